@@ -23,6 +23,52 @@ const (
 	CookieAuthScopes = "cookieAuth.Scopes"
 )
 
+// Defines values for BlockEventReason.
+const (
+	BlockEventReasonErrors BlockEventReason = "errors"
+	BlockEventReasonManual BlockEventReason = "manual"
+	BlockEventReasonRate   BlockEventReason = "rate"
+	BlockEventReasonScan   BlockEventReason = "scan"
+)
+
+// Defines values for CertificateStatusStatus.
+const (
+	Expired  CertificateStatusStatus = "expired"
+	Failed   CertificateStatusStatus = "failed"
+	Pending  CertificateStatusStatus = "pending"
+	Renewing CertificateStatusStatus = "renewing"
+	Valid    CertificateStatusStatus = "valid"
+)
+
+// Defines values for CircuitBreakerStatState.
+const (
+	Closed   CircuitBreakerStatState = "closed"
+	HalfOpen CircuitBreakerStatState = "half-open"
+	Open     CircuitBreakerStatState = "open"
+)
+
+// Defines values for UpstreamPoolStatus.
+const (
+	UpstreamPoolStatusDegraded  UpstreamPoolStatus = "degraded"
+	UpstreamPoolStatusHealthy   UpstreamPoolStatus = "healthy"
+	UpstreamPoolStatusUnhealthy UpstreamPoolStatus = "unhealthy"
+)
+
+// Defines values for UpstreamPoolSummaryStatus.
+const (
+	UpstreamPoolSummaryStatusDegraded  UpstreamPoolSummaryStatus = "degraded"
+	UpstreamPoolSummaryStatusHealthy   UpstreamPoolSummaryStatus = "healthy"
+	UpstreamPoolSummaryStatusUnhealthy UpstreamPoolSummaryStatus = "unhealthy"
+)
+
+// Defines values for ListRateLimiterBlocksParamsReason.
+const (
+	ListRateLimiterBlocksParamsReasonErrors ListRateLimiterBlocksParamsReason = "errors"
+	ListRateLimiterBlocksParamsReasonManual ListRateLimiterBlocksParamsReason = "manual"
+	ListRateLimiterBlocksParamsReasonRate   ListRateLimiterBlocksParamsReason = "rate"
+	ListRateLimiterBlocksParamsReasonScan   ListRateLimiterBlocksParamsReason = "scan"
+)
+
 // AllUserCountersResponse defines model for AllUserCountersResponse.
 type AllUserCountersResponse struct {
 	// CounterId ID of the counter type
@@ -41,12 +87,223 @@ type AllUserCountersResponse struct {
 	Users []UserCountersResponse `json:"users"`
 }
 
+// AllowlistEntries defines model for AllowlistEntries.
+type AllowlistEntries = []AllowlistEntry
+
+// AllowlistEntry defines model for AllowlistEntry.
+type AllowlistEntry struct {
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy *string   `json:"createdBy,omitempty"`
+	Id        string    `json:"id"`
+
+	// Network IP address or CIDR network
+	Network string  `json:"network"`
+	Note    *string `json:"note,omitempty"`
+}
+
+// AllowlistRequest defines model for AllowlistRequest.
+type AllowlistRequest struct {
+	// Network IP address or CIDR network that bypasses the rate limiter
+	Network string  `json:"network"`
+	Note    *string `json:"note,omitempty"`
+}
+
 // AvailableCountersResponse defines model for AvailableCountersResponse.
 type AvailableCountersResponse struct {
 	// Counters List of available counter type IDs
 	Counters []string `json:"counters"`
 }
 
+// BlockEvent defines model for BlockEvent.
+type BlockEvent struct {
+	// Active Whether the block applies now
+	Active      bool    `json:"active"`
+	City        *string `json:"city,omitempty"`
+	Country     *string `json:"country,omitempty"`
+	CountryCode *string `json:"countryCode,omitempty"`
+
+	// CreatedBy Administrator of a manual block
+	CreatedBy *string `json:"createdBy,omitempty"`
+
+	// EndsAt When the block ends, null for a permanent block
+	EndsAt *time.Time `json:"endsAt"`
+	Id     string     `json:"id"`
+
+	// Ja4h JA4H fingerprint of the request
+	Ja4h      *string  `json:"ja4h,omitempty"`
+	Latitude  *float32 `json:"latitude,omitempty"`
+	Longitude *float32 `json:"longitude,omitempty"`
+
+	// Network Blocked IP address, or CIDR network of a manual block
+	Network string `json:"network"`
+
+	// Note Why an administrator blocked it
+	Note *string `json:"note,omitempty"`
+
+	// Path Path of the request that caused the block
+	Path *string `json:"path,omitempty"`
+
+	// Reason Over requestsPerMinute, too many 401/404 responses, a vulnerability scan, or blocked by an administrator
+	Reason    BlockEventReason `json:"reason"`
+	StartedAt time.Time        `json:"startedAt"`
+
+	// UnblockedAt When an administrator ended the block early
+	UnblockedAt *time.Time `json:"unblockedAt"`
+	UnblockedBy *string    `json:"unblockedBy,omitempty"`
+	UserAgent   *string    `json:"userAgent,omitempty"`
+}
+
+// BlockEventReason Over requestsPerMinute, too many 401/404 responses, a vulnerability scan, or blocked by an administrator
+type BlockEventReason string
+
+// BlockEventsResponse defines model for BlockEventsResponse.
+type BlockEventsResponse struct {
+	Events []BlockEvent `json:"events"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+
+	// TotalCount Total number of blocks of the filter (for pagination)
+	TotalCount int `json:"totalCount"`
+}
+
+// BlockRequest defines model for BlockRequest.
+type BlockRequest struct {
+	// DurationMinutes How long the block lasts; permanent when missing
+	DurationMinutes *int `json:"durationMinutes"`
+
+	// Network IP address or CIDR network to block
+	Network string `json:"network"`
+
+	// Note Why it is blocked
+	Note *string `json:"note,omitempty"`
+}
+
+// CacheRouteStats defines model for CacheRouteStats.
+type CacheRouteStats struct {
+	// Bypasses Requests the cache does not handle, such as unsafe methods or no-store
+	Bypasses int64 `json:"bypasses"`
+
+	// Hits Fresh responses served from the cache
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+
+	// Revalidations Stored responses confirmed by the upstream with 304 Not Modified
+	Revalidations int64  `json:"revalidations"`
+	Route         string `json:"route"`
+
+	// StaleHits Stale responses served while refreshed or because the upstream failed
+	StaleHits int64 `json:"staleHits"`
+	Stores    int64 `json:"stores"`
+}
+
+// CacheStats defines model for CacheStats.
+type CacheStats struct {
+	// Bypasses Requests the cache does not handle, such as unsafe methods or no-store
+	Bypasses int64 `json:"bypasses"`
+
+	// Disk Disk tier, absent when not configured
+	Disk *CacheTierStats `json:"disk"`
+
+	// Enabled Whether a route uses the cache
+	Enabled bool `json:"enabled"`
+
+	// HitRatio Share of the cached requests served without a full upstream response
+	HitRatio float64 `json:"hitRatio"`
+
+	// Hits Fresh responses served from the cache
+	Hits   int64          `json:"hits"`
+	Memory CacheTierStats `json:"memory"`
+	Misses int64          `json:"misses"`
+
+	// Revalidations Stored responses confirmed by the upstream with 304 Not Modified
+	Revalidations int64             `json:"revalidations"`
+	Routes        []CacheRouteStats `json:"routes"`
+
+	// StaleHits Stale responses served while refreshed or because the upstream failed
+	StaleHits int64 `json:"staleHits"`
+	Stores    int64 `json:"stores"`
+}
+
+// CacheTierStats defines model for CacheTierStats.
+type CacheTierStats struct {
+	Entries      int   `json:"entries"`
+	Evictions    int64 `json:"evictions"`
+	MaxSizeBytes int64 `json:"maxSizeBytes"`
+	SizeBytes    int64 `json:"sizeBytes"`
+}
+
+// CertificateStatus defines model for CertificateStatus.
+type CertificateStatus struct {
+	Host string `json:"host"`
+
+	// Issuer Common name of the issuing certificate authority
+	Issuer *string `json:"issuer"`
+
+	// LastCheck Time the certificate was last requested
+	LastCheck *time.Time `json:"lastCheck"`
+
+	// LastError Error of the last attempt to obtain the certificate
+	LastError *string `json:"lastError"`
+
+	// NotAfter Expiry of the certificate
+	NotAfter  *time.Time `json:"notAfter"`
+	NotBefore *time.Time `json:"notBefore"`
+
+	// Status pending: not obtained yet; renewing: due for renewal; failed: could not be obtained
+	Status CertificateStatusStatus `json:"status"`
+}
+
+// CertificateStatusStatus pending: not obtained yet; renewing: due for renewal; failed: could not be obtained
+type CertificateStatusStatus string
+
+// CertificateStatuses defines model for CertificateStatuses.
+type CertificateStatuses = []CertificateStatus
+
+// CircuitBreakerStat defines model for CircuitBreakerStat.
+type CircuitBreakerStat struct {
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+
+	// Failures Failures in the failure ratio window
+	Failures       int        `json:"failures"`
+	LastTransition *time.Time `json:"lastTransition"`
+	OpenedAt       *time.Time `json:"openedAt"`
+
+	// Requests Requests in the failure ratio window
+	Requests int `json:"requests"`
+
+	// RetryAt When an open breaker lets probe requests through
+	RetryAt *time.Time              `json:"retryAt"`
+	Route   string                  `json:"route"`
+	State   CircuitBreakerStatState `json:"state"`
+
+	// Transitions Number of state changes since the gateway started
+	Transitions int `json:"transitions"`
+}
+
+// CircuitBreakerStatState defines model for CircuitBreakerStat.State.
+type CircuitBreakerStatState string
+
+// CircuitBreakerStats defines model for CircuitBreakerStats.
+type CircuitBreakerStats = []CircuitBreakerStat
+
+// ConfigReloadResponse defines model for ConfigReloadResponse.
+type ConfigReloadResponse struct {
+	// Added Routes only in the new configuration
+	Added []string `json:"added"`
+
+	// Changed Routes with different settings
+	Changed []string `json:"changed"`
+
+	// Removed Routes only in the old configuration
+	Removed []string `json:"removed"`
+
+	// RestartRequired Changed settings that only take effect after a restart, like server
+	RestartRequired []string `json:"restartRequired"`
+
+	// Unchanged Number of routes with the same settings
+	Unchanged int `json:"unchanged"`
+}
+
 // CounterAdjustmentRequest defines model for CounterAdjustmentRequest.
 type CounterAdjustmentRequest struct {
 	// Amount Amount to add (positive) or deduct (negative)
@@ -115,14 +372,21 @@ type Error struct {
 
 // HealthResponse defines model for HealthResponse.
 type HealthResponse struct {
-	Database struct {
+	// Certificates Expiry and renewal status of the ACME certificates
+	Certificates *[]CertificateStatus `json:"certificates,omitempty"`
+	Database     struct {
 		// OpenConnections Number of open database connections
 		OpenConnections *int   `json:"open_connections,omitempty"`
 		Status          string `json:"status"`
 	} `json:"database"`
+
+	// Status ok, degraded, unavailable or draining
 	Status    string    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 
+	// Upstreams Health summary of the upstream targets of each proxy route
+	Upstreams *[]UpstreamPoolSummary `json:"upstreams,omitempty"`
+
 	// Uptime Server uptime duration
 	Uptime string `json:"uptime"`
 }
@@ -153,14 +417,19 @@ type RateLimiterStats = []RateLimiterStat
 
 // RequestDetail defines model for RequestDetail.
 type RequestDetail struct {
-	Browser string `json:"browser"`
+	// Attempts Number of upstream attempts, including retries
+	Attempts *int   `json:"attempts"`
+	Browser  string `json:"browser"`
 
 	// BrowserVersion Version of the browser
 	BrowserVersion string `json:"browser_version"`
 	City           string `json:"city"`
-	Country        string `json:"country"`
-	DeviceType     string `json:"device_type"`
-	Id             string `json:"id"`
+
+	// Coalesced Whether the response was shared from an identical request in flight
+	Coalesced  *bool  `json:"coalesced"`
+	Country    string `json:"country"`
+	DeviceType string `json:"device_type"`
+	Id         string `json:"id"`
 
 	// Latitude GPS latitude coordinate
 	Latitude *float32 `json:"latitude"`
@@ -173,11 +442,17 @@ type RequestDetail struct {
 	Platform string `json:"platform"`
 
 	// PlatformVersion Version of the operating system/platform
-	PlatformVersion string    `json:"platform_version"`
-	ResponseSize    float32   `json:"response_size"`
-	ResponseTime    float32   `json:"response_time"`
-	StatusCode      int       `json:"status_code"`
-	Timestamp       time.Time `json:"timestamp"`
+	PlatformVersion string  `json:"platform_version"`
+	ResponseSize    float32 `json:"response_size"`
+	ResponseTime    float32 `json:"response_time"`
+
+	// RouteName Name of the proxy route that handled the request
+	RouteName  *string   `json:"route_name"`
+	StatusCode int       `json:"status_code"`
+	Timestamp  time.Time `json:"timestamp"`
+
+	// Upstream Upstream target URL the request was sent to
+	Upstream *string `json:"upstream"`
 
 	// UserId ID of the authenticated user making the request
 	UserId *string `json:"user_id"`
@@ -199,6 +474,9 @@ type RequestStatistics struct {
 	// AverageResponseTime Average response time in milliseconds
 	AverageResponseTime float32 `json:"averageResponseTime"`
 
+	// CoalescedRequests Requests answered with the response of an identical request in flight
+	CoalescedRequests int64 `json:"coalescedRequests"`
+
 	// RequestsByBrowser Number of requests grouped by browser
 	RequestsByBrowser map[string]int `json:"requestsByBrowser"`
 
@@ -273,6 +551,51 @@ type TokenResponse struct {
 	UsageCount int `json:"usage_count"`
 }
 
+// UpstreamPool defines model for UpstreamPool.
+type UpstreamPool struct {
+	// ActiveHealthCheck Targets are probed periodically
+	ActiveHealthCheck bool `json:"activeHealthCheck"`
+
+	// PassiveHealthCheck Targets are marked down after consecutive failed requests
+	PassiveHealthCheck bool                   `json:"passiveHealthCheck"`
+	Route              string                 `json:"route"`
+	Status             UpstreamPoolStatus     `json:"status"`
+	Strategy           string                 `json:"strategy"`
+	Targets            []UpstreamTargetHealth `json:"targets"`
+}
+
+// UpstreamPoolStatus defines model for UpstreamPool.Status.
+type UpstreamPoolStatus string
+
+// UpstreamPoolSummary defines model for UpstreamPoolSummary.
+type UpstreamPoolSummary struct {
+	HealthyTargets int                       `json:"healthyTargets"`
+	Route          string                    `json:"route"`
+	Status         UpstreamPoolSummaryStatus `json:"status"`
+	TotalTargets   int                       `json:"totalTargets"`
+}
+
+// UpstreamPoolSummaryStatus defines model for UpstreamPoolSummary.Status.
+type UpstreamPoolSummaryStatus string
+
+// UpstreamPools defines model for UpstreamPools.
+type UpstreamPools = []UpstreamPool
+
+// UpstreamTargetHealth defines model for UpstreamTargetHealth.
+type UpstreamTargetHealth struct {
+	ActiveRequests      int64 `json:"activeRequests"`
+	ConsecutiveFailures int   `json:"consecutiveFailures"`
+	Healthy             bool  `json:"healthy"`
+
+	// LastCheck Time of the last active probe
+	LastCheck *time.Time `json:"lastCheck"`
+
+	// LastError Error of the last failed probe or proxied request
+	LastError        *string    `json:"lastError"`
+	LastStatusChange *time.Time `json:"lastStatusChange"`
+	Url              string     `json:"url"`
+}
+
 // UserCountersResponse defines model for UserCountersResponse.
 type UserCountersResponse struct {
 	// Balance Current counter balance
@@ -332,6 +655,27 @@ type GetUserCounterHistoryParams struct {
 	Offset *int `form:"offset,omitempty" json:"offset,omitempty"`
 }
 
+// ListRateLimiterBlocksParams defines parameters for ListRateLimiterBlocks.
+type ListRateLimiterBlocksParams struct {
+	// Network Only the blocks of this IP address or CIDR network
+	Network *string `form:"network,omitempty" json:"network,omitempty"`
+
+	// Reason Only the blocks of this reason
+	Reason *ListRateLimiterBlocksParamsReason `form:"reason,omitempty" json:"reason,omitempty"`
+
+	// Active Only the blocks that apply now
+	Active *bool `form:"active,omitempty" json:"active,omitempty"`
+
+	// Limit Maximum number of blocks to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Offset Number of blocks to skip
+	Offset *int `form:"offset,omitempty" json:"offset,omitempty"`
+}
+
+// ListRateLimiterBlocksParamsReason defines parameters for ListRateLimiterBlocks.
+type ListRateLimiterBlocksParamsReason string
+
 // GetRequestStatisticsParams defines parameters for GetRequestStatistics.
 type GetRequestStatisticsParams struct {
 	// StartDate Start date for filtering results (ISO 8601 format)
@@ -362,6 +706,12 @@ type LogoutUserParams struct {
 // AdjustUserCountersJSONRequestBody defines body for AdjustUserCounters for application/json ContentType.
 type AdjustUserCountersJSONRequestBody = CounterAdjustmentRequest
 
+// CreateRateLimiterAllowlistEntryJSONRequestBody defines body for CreateRateLimiterAllowlistEntry for application/json ContentType.
+type CreateRateLimiterAllowlistEntryJSONRequestBody = AllowlistRequest
+
+// CreateRateLimiterBlockJSONRequestBody defines body for CreateRateLimiterBlock for application/json ContentType.
+type CreateRateLimiterBlockJSONRequestBody = BlockRequest
+
 // CreateUserJSONRequestBody defines body for CreateUser for application/json ContentType.
 type CreateUserJSONRequestBody = UserCreateRequest
 
@@ -376,9 +726,15 @@ type ServerInterface interface {
 	// Get all users' counter balances (admin only)
 	// (GET /api/admin/counters/{counterId})
 	GetAllUserCounters(w http.ResponseWriter, r *http.Request, counterId string, params GetAllUserCountersParams)
+	// Get the expiry and renewal status of the ACME certificates
+	// (GET /api/certificates)
+	GetCertificates(w http.ResponseWriter, r *http.Request)
 	// Get current rate limiter configuration
 	// (GET /api/config/rate-limiter)
 	GetRateLimiterConfig(w http.ResponseWriter, r *http.Request)
+	// Reload the configuration file without restart (admin only)
+	// (POST /api/config/reload)
+	ReloadConfig(w http.ResponseWriter, r *http.Request)
 	// Get user's current counter balance
 	// (GET /api/counters/{counterId}/{userId})
 	GetUserCounters(w http.ResponseWriter, r *http.Request, counterId string, userId string)
@@ -388,6 +744,30 @@ type ServerInterface interface {
 	// Get user's counter transaction history
 	// (GET /api/counters/{counterId}/{userId}/history)
 	GetUserCounterHistory(w http.ResponseWriter, r *http.Request, counterId string, userId string, params GetUserCounterHistoryParams)
+	// List the networks the rate limiter never limits (admin only)
+	// (GET /api/rate-limiter/allowlist)
+	ListRateLimiterAllowlist(w http.ResponseWriter, r *http.Request)
+	// Add an IP address or CIDR network to the allowlist (admin only)
+	// (POST /api/rate-limiter/allowlist)
+	CreateRateLimiterAllowlistEntry(w http.ResponseWriter, r *http.Request)
+	// Remove an entry of the allowlist (admin only)
+	// (DELETE /api/rate-limiter/allowlist/{entryId})
+	DeleteRateLimiterAllowlistEntry(w http.ResponseWriter, r *http.Request, entryId string)
+	// List the blocks of the rate limiter, newest first (admin only)
+	// (GET /api/rate-limiter/blocks)
+	ListRateLimiterBlocks(w http.ResponseWriter, r *http.Request, params ListRateLimiterBlocksParams)
+	// Block an IP address or CIDR network (admin only)
+	// (POST /api/rate-limiter/blocks)
+	CreateRateLimiterBlock(w http.ResponseWriter, r *http.Request)
+	// End a block early (admin only)
+	// (DELETE /api/rate-limiter/blocks/{blockId})
+	DeleteRateLimiterBlock(w http.ResponseWriter, r *http.Request, blockId string)
+	// Get the hit and miss counters of the shared response cache
+	// (GET /api/statistics/cache)
+	GetCacheStats(w http.ResponseWriter, r *http.Request)
+	// Get the state of the circuit breakers of the proxy routes
+	// (GET /api/statistics/circuit-breakers)
+	GetCircuitBreakerStats(w http.ResponseWriter, r *http.Request)
 	// Get current rate limiter statistics
 	// (GET /api/statistics/rate-limiter)
 	GetRateLimiterStats(w http.ResponseWriter, r *http.Request)
@@ -403,6 +783,9 @@ type ServerInterface interface {
 	// Get token details (admin only)
 	// (GET /api/tokens/{tokenId})
 	GetToken(w http.ResponseWriter, r *http.Request, tokenId string)
+	// Get the health of the upstream targets of every proxy route
+	// (GET /api/upstreams)
+	GetUpstreams(w http.ResponseWriter, r *http.Request)
 	// List all users
 	// (GET /api/users)
 	ListUsers(w http.ResponseWriter, r *http.Request)
@@ -511,6 +894,26 @@ func (siw *ServerInterfaceWrapper) GetAllUserCounters(w http.ResponseWriter, r *
 	handler.ServeHTTP(w, r)
 }
 
+// GetCertificates operation middleware
+func (siw *ServerInterfaceWrapper) GetCertificates(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetCertificates(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // GetRateLimiterConfig operation middleware
 func (siw *ServerInterfaceWrapper) GetRateLimiterConfig(w http.ResponseWriter, r *http.Request) {
 
@@ -531,6 +934,26 @@ func (siw *ServerInterfaceWrapper) GetRateLimiterConfig(w http.ResponseWriter, r
 	handler.ServeHTTP(w, r)
 }
 
+// ReloadConfig operation middleware
+func (siw *ServerInterfaceWrapper) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ReloadConfig(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // GetUserCounters operation middleware
 func (siw *ServerInterfaceWrapper) GetUserCounters(w http.ResponseWriter, r *http.Request) {
 
@@ -670,8 +1093,8 @@ func (siw *ServerInterfaceWrapper) GetUserCounterHistory(w http.ResponseWriter,
 	handler.ServeHTTP(w, r)
 }
 
-// GetRateLimiterStats operation middleware
-func (siw *ServerInterfaceWrapper) GetRateLimiterStats(w http.ResponseWriter, r *http.Request) {
+// ListRateLimiterAllowlist operation middleware
+func (siw *ServerInterfaceWrapper) ListRateLimiterAllowlist(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
@@ -680,7 +1103,7 @@ func (siw *ServerInterfaceWrapper) GetRateLimiterStats(w http.ResponseWriter, r
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetRateLimiterStats(w, r)
+		siw.Handler.ListRateLimiterAllowlist(w, r)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -690,10 +1113,8 @@ func (siw *ServerInterfaceWrapper) GetRateLimiterStats(w http.ResponseWriter, r
 	handler.ServeHTTP(w, r)
 }
 
-// GetRequestStatistics operation middleware
-func (siw *ServerInterfaceWrapper) GetRequestStatistics(w http.ResponseWriter, r *http.Request) {
-
-	var err error
+// CreateRateLimiterAllowlistEntry operation middleware
+func (siw *ServerInterfaceWrapper) CreateRateLimiterAllowlistEntry(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
@@ -701,27 +1122,39 @@ func (siw *ServerInterfaceWrapper) GetRequestStatistics(w http.ResponseWriter, r
 
 	r = r.WithContext(ctx)
 
-	// Parameter object where we will unmarshal all parameters from the context
-	var params GetRequestStatisticsParams
-
-	// ------------- Optional query parameter "start_date" -------------
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.CreateRateLimiterAllowlistEntry(w, r)
+	}))
 
-	err = runtime.BindQueryParameter("form", true, false, "start_date", r.URL.Query(), &params.StartDate)
-	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "start_date", Err: err})
-		return
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
 	}
 
-	// ------------- Optional query parameter "end_date" -------------
+	handler.ServeHTTP(w, r)
+}
 
-	err = runtime.BindQueryParameter("form", true, false, "end_date", r.URL.Query(), &params.EndDate)
+// DeleteRateLimiterAllowlistEntry operation middleware
+func (siw *ServerInterfaceWrapper) DeleteRateLimiterAllowlistEntry(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "entryId" -------------
+	var entryId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "entryId", r.PathValue("entryId"), &entryId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "end_date", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "entryId", Err: err})
 		return
 	}
 
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetRequestStatistics(w, r, params)
+		siw.Handler.DeleteRateLimiterAllowlistEntry(w, r, entryId)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -731,8 +1164,8 @@ func (siw *ServerInterfaceWrapper) GetRequestStatistics(w http.ResponseWriter, r
 	handler.ServeHTTP(w, r)
 }
 
-// GetRequestDetails operation middleware
-func (siw *ServerInterfaceWrapper) GetRequestDetails(w http.ResponseWriter, r *http.Request) {
+// ListRateLimiterBlocks operation middleware
+func (siw *ServerInterfaceWrapper) ListRateLimiterBlocks(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
@@ -743,26 +1176,50 @@ func (siw *ServerInterfaceWrapper) GetRequestDetails(w http.ResponseWriter, r *h
 	r = r.WithContext(ctx)
 
 	// Parameter object where we will unmarshal all parameters from the context
-	var params GetRequestDetailsParams
+	var params ListRateLimiterBlocksParams
 
-	// ------------- Optional query parameter "start_date" -------------
+	// ------------- Optional query parameter "network" -------------
 
-	err = runtime.BindQueryParameter("form", true, false, "start_date", r.URL.Query(), &params.StartDate)
+	err = runtime.BindQueryParameter("form", true, false, "network", r.URL.Query(), &params.Network)
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "start_date", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "network", Err: err})
 		return
 	}
 
-	// ------------- Optional query parameter "end_date" -------------
+	// ------------- Optional query parameter "reason" -------------
 
-	err = runtime.BindQueryParameter("form", true, false, "end_date", r.URL.Query(), &params.EndDate)
+	err = runtime.BindQueryParameter("form", true, false, "reason", r.URL.Query(), &params.Reason)
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "end_date", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "reason", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "active" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "active", r.URL.Query(), &params.Active)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "active", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", r.URL.Query(), &params.Limit)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "limit", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "offset" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "offset", r.URL.Query(), &params.Offset)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "offset", Err: err})
 		return
 	}
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetRequestDetails(w, r, params)
+		siw.Handler.ListRateLimiterBlocks(w, r, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -772,30 +1229,17 @@ func (siw *ServerInterfaceWrapper) GetRequestDetails(w http.ResponseWriter, r *h
 	handler.ServeHTTP(w, r)
 }
 
-// DeleteToken operation middleware
-func (siw *ServerInterfaceWrapper) DeleteToken(w http.ResponseWriter, r *http.Request) {
-
-	var err error
-
-	// ------------- Path parameter "tokenId" -------------
-	var tokenId string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "tokenId", r.PathValue("tokenId"), &tokenId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tokenId", Err: err})
-		return
-	}
+// CreateRateLimiterBlock operation middleware
+func (siw *ServerInterfaceWrapper) CreateRateLimiterBlock(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
 	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
 
-	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
-
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.DeleteToken(w, r, tokenId)
+		siw.Handler.CreateRateLimiterBlock(w, r)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -805,17 +1249,17 @@ func (siw *ServerInterfaceWrapper) DeleteToken(w http.ResponseWriter, r *http.Re
 	handler.ServeHTTP(w, r)
 }
 
-// GetToken operation middleware
-func (siw *ServerInterfaceWrapper) GetToken(w http.ResponseWriter, r *http.Request) {
+// DeleteRateLimiterBlock operation middleware
+func (siw *ServerInterfaceWrapper) DeleteRateLimiterBlock(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
-	// ------------- Path parameter "tokenId" -------------
-	var tokenId string
+	// ------------- Path parameter "blockId" -------------
+	var blockId string
 
-	err = runtime.BindStyledParameterWithOptions("simple", "tokenId", r.PathValue("tokenId"), &tokenId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	err = runtime.BindStyledParameterWithOptions("simple", "blockId", r.PathValue("blockId"), &blockId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tokenId", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "blockId", Err: err})
 		return
 	}
 
@@ -823,12 +1267,10 @@ func (siw *ServerInterfaceWrapper) GetToken(w http.ResponseWriter, r *http.Reque
 
 	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
 
-	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
-
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetToken(w, r, tokenId)
+		siw.Handler.DeleteRateLimiterBlock(w, r, blockId)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -838,8 +1280,8 @@ func (siw *ServerInterfaceWrapper) GetToken(w http.ResponseWriter, r *http.Reque
 	handler.ServeHTTP(w, r)
 }
 
-// ListUsers operation middleware
-func (siw *ServerInterfaceWrapper) ListUsers(w http.ResponseWriter, r *http.Request) {
+// GetCacheStats operation middleware
+func (siw *ServerInterfaceWrapper) GetCacheStats(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
@@ -848,7 +1290,7 @@ func (siw *ServerInterfaceWrapper) ListUsers(w http.ResponseWriter, r *http.Requ
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.ListUsers(w, r)
+		siw.Handler.GetCacheStats(w, r)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -858,11 +1300,17 @@ func (siw *ServerInterfaceWrapper) ListUsers(w http.ResponseWriter, r *http.Requ
 	handler.ServeHTTP(w, r)
 }
 
-// CreateUser operation middleware
-func (siw *ServerInterfaceWrapper) CreateUser(w http.ResponseWriter, r *http.Request) {
+// GetCircuitBreakerStats operation middleware
+func (siw *ServerInterfaceWrapper) GetCircuitBreakerStats(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.CreateUser(w, r)
+		siw.Handler.GetCircuitBreakerStats(w, r)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -872,15 +1320,237 @@ func (siw *ServerInterfaceWrapper) CreateUser(w http.ResponseWriter, r *http.Req
 	handler.ServeHTTP(w, r)
 }
 
-// GetUserById operation middleware
-func (siw *ServerInterfaceWrapper) GetUserById(w http.ResponseWriter, r *http.Request) {
+// GetRateLimiterStats operation middleware
+func (siw *ServerInterfaceWrapper) GetRateLimiterStats(w http.ResponseWriter, r *http.Request) {
 
-	var err error
+	ctx := r.Context()
 
-	// ------------- Path parameter "userId" -------------
-	var userId string
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
 
-	err = runtime.BindStyledParameterWithOptions("simple", "userId", r.PathValue("userId"), &userId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetRateLimiterStats(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetRequestStatistics operation middleware
+func (siw *ServerInterfaceWrapper) GetRequestStatistics(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetRequestStatisticsParams
+
+	// ------------- Optional query parameter "start_date" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "start_date", r.URL.Query(), &params.StartDate)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "start_date", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "end_date" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "end_date", r.URL.Query(), &params.EndDate)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "end_date", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetRequestStatistics(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetRequestDetails operation middleware
+func (siw *ServerInterfaceWrapper) GetRequestDetails(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetRequestDetailsParams
+
+	// ------------- Optional query parameter "start_date" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "start_date", r.URL.Query(), &params.StartDate)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "start_date", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "end_date" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "end_date", r.URL.Query(), &params.EndDate)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "end_date", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetRequestDetails(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// DeleteToken operation middleware
+func (siw *ServerInterfaceWrapper) DeleteToken(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "tokenId" -------------
+	var tokenId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "tokenId", r.PathValue("tokenId"), &tokenId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tokenId", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteToken(w, r, tokenId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetToken operation middleware
+func (siw *ServerInterfaceWrapper) GetToken(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "tokenId" -------------
+	var tokenId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "tokenId", r.PathValue("tokenId"), &tokenId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tokenId", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetToken(w, r, tokenId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetUpstreams operation middleware
+func (siw *ServerInterfaceWrapper) GetUpstreams(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetUpstreams(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// ListUsers operation middleware
+func (siw *ServerInterfaceWrapper) ListUsers(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, CookieAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ListUsers(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// CreateUser operation middleware
+func (siw *ServerInterfaceWrapper) CreateUser(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.CreateUser(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetUserById operation middleware
+func (siw *ServerInterfaceWrapper) GetUserById(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "userId" -------------
+	var userId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "userId", r.PathValue("userId"), &userId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
 	if err != nil {
 		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "userId", Err: err})
 		return
@@ -1187,15 +1857,26 @@ func HandlerWithOptions(si ServerInterface, options StdHTTPServerOptions) http.H
 
 	m.HandleFunc("GET "+options.BaseURL+"/api/admin/counters", wrapper.GetAvailableCounters)
 	m.HandleFunc("GET "+options.BaseURL+"/api/admin/counters/{counterId}", wrapper.GetAllUserCounters)
+	m.HandleFunc("GET "+options.BaseURL+"/api/certificates", wrapper.GetCertificates)
 	m.HandleFunc("GET "+options.BaseURL+"/api/config/rate-limiter", wrapper.GetRateLimiterConfig)
+	m.HandleFunc("POST "+options.BaseURL+"/api/config/reload", wrapper.ReloadConfig)
 	m.HandleFunc("GET "+options.BaseURL+"/api/counters/{counterId}/{userId}", wrapper.GetUserCounters)
 	m.HandleFunc("POST "+options.BaseURL+"/api/counters/{counterId}/{userId}", wrapper.AdjustUserCounters)
 	m.HandleFunc("GET "+options.BaseURL+"/api/counters/{counterId}/{userId}/history", wrapper.GetUserCounterHistory)
+	m.HandleFunc("GET "+options.BaseURL+"/api/rate-limiter/allowlist", wrapper.ListRateLimiterAllowlist)
+	m.HandleFunc("POST "+options.BaseURL+"/api/rate-limiter/allowlist", wrapper.CreateRateLimiterAllowlistEntry)
+	m.HandleFunc("DELETE "+options.BaseURL+"/api/rate-limiter/allowlist/{entryId}", wrapper.DeleteRateLimiterAllowlistEntry)
+	m.HandleFunc("GET "+options.BaseURL+"/api/rate-limiter/blocks", wrapper.ListRateLimiterBlocks)
+	m.HandleFunc("POST "+options.BaseURL+"/api/rate-limiter/blocks", wrapper.CreateRateLimiterBlock)
+	m.HandleFunc("DELETE "+options.BaseURL+"/api/rate-limiter/blocks/{blockId}", wrapper.DeleteRateLimiterBlock)
+	m.HandleFunc("GET "+options.BaseURL+"/api/statistics/cache", wrapper.GetCacheStats)
+	m.HandleFunc("GET "+options.BaseURL+"/api/statistics/circuit-breakers", wrapper.GetCircuitBreakerStats)
 	m.HandleFunc("GET "+options.BaseURL+"/api/statistics/rate-limiter", wrapper.GetRateLimiterStats)
 	m.HandleFunc("GET "+options.BaseURL+"/api/statistics/requests", wrapper.GetRequestStatistics)
 	m.HandleFunc("GET "+options.BaseURL+"/api/statistics/requests/details", wrapper.GetRequestDetails)
 	m.HandleFunc("DELETE "+options.BaseURL+"/api/tokens/{tokenId}", wrapper.DeleteToken)
 	m.HandleFunc("GET "+options.BaseURL+"/api/tokens/{tokenId}", wrapper.GetToken)
+	m.HandleFunc("GET "+options.BaseURL+"/api/upstreams", wrapper.GetUpstreams)
 	m.HandleFunc("GET "+options.BaseURL+"/api/users", wrapper.ListUsers)
 	m.HandleFunc("POST "+options.BaseURL+"/api/users", wrapper.CreateUser)
 	m.HandleFunc("GET "+options.BaseURL+"/api/users/{userId}", wrapper.GetUserById)
@@ -1306,6 +1987,31 @@ func (response GetAllUserCounters500JSONResponse) VisitGetAllUserCountersRespons
 	return json.NewEncoder(w).Encode(response)
 }
 
+type GetCertificatesRequestObject struct {
+}
+
+type GetCertificatesResponseObject interface {
+	VisitGetCertificatesResponse(w http.ResponseWriter) error
+}
+
+type GetCertificates200JSONResponse CertificateStatuses
+
+func (response GetCertificates200JSONResponse) VisitGetCertificatesResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type GetCertificates401JSONResponse Error
+
+func (response GetCertificates401JSONResponse) VisitGetCertificatesResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
 type GetRateLimiterConfigRequestObject struct {
 }
 
@@ -1340,6 +2046,49 @@ func (response GetRateLimiterConfig500JSONResponse) VisitGetRateLimiterConfigRes
 	return json.NewEncoder(w).Encode(response)
 }
 
+type ReloadConfigRequestObject struct {
+}
+
+type ReloadConfigResponseObject interface {
+	VisitReloadConfigResponse(w http.ResponseWriter) error
+}
+
+type ReloadConfig200JSONResponse ConfigReloadResponse
+
+func (response ReloadConfig200JSONResponse) VisitReloadConfigResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ReloadConfig400JSONResponse Error
+
+func (response ReloadConfig400JSONResponse) VisitReloadConfigResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ReloadConfig401JSONResponse Error
+
+func (response ReloadConfig401JSONResponse) VisitReloadConfigResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ReloadConfig403JSONResponse Error
+
+func (response ReloadConfig403JSONResponse) VisitReloadConfigResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(403)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
 type GetUserCountersRequestObject struct {
 	CounterId string `json:"counterId"`
 	UserId    string `json:"userId"`
@@ -1440,75 +2189,369 @@ func (response AdjustUserCounters403JSONResponse) VisitAdjustUserCountersRespons
 	return json.NewEncoder(w).Encode(response)
 }
 
-type AdjustUserCounters404JSONResponse Error
+type AdjustUserCounters404JSONResponse Error
+
+func (response AdjustUserCounters404JSONResponse) VisitAdjustUserCountersResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type AdjustUserCounters500JSONResponse Error
+
+func (response AdjustUserCounters500JSONResponse) VisitAdjustUserCountersResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type GetUserCounterHistoryRequestObject struct {
+	CounterId string `json:"counterId"`
+	UserId    string `json:"userId"`
+	Params    GetUserCounterHistoryParams
+}
+
+type GetUserCounterHistoryResponseObject interface {
+	VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error
+}
+
+type GetUserCounterHistory200JSONResponse CounterHistoryResponse
+
+func (response GetUserCounterHistory200JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type GetUserCounterHistory401JSONResponse Error
+
+func (response GetUserCounterHistory401JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type GetUserCounterHistory403JSONResponse Error
+
+func (response GetUserCounterHistory403JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(403)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type GetUserCounterHistory404JSONResponse Error
+
+func (response GetUserCounterHistory404JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type GetUserCounterHistory500JSONResponse Error
+
+func (response GetUserCounterHistory500JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ListRateLimiterAllowlistRequestObject struct {
+}
+
+type ListRateLimiterAllowlistResponseObject interface {
+	VisitListRateLimiterAllowlistResponse(w http.ResponseWriter) error
+}
+
+type ListRateLimiterAllowlist200JSONResponse AllowlistEntries
+
+func (response ListRateLimiterAllowlist200JSONResponse) VisitListRateLimiterAllowlistResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ListRateLimiterAllowlist401JSONResponse Error
+
+func (response ListRateLimiterAllowlist401JSONResponse) VisitListRateLimiterAllowlistResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ListRateLimiterAllowlist500JSONResponse Error
+
+func (response ListRateLimiterAllowlist500JSONResponse) VisitListRateLimiterAllowlistResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterAllowlistEntryRequestObject struct {
+	Body *CreateRateLimiterAllowlistEntryJSONRequestBody
+}
+
+type CreateRateLimiterAllowlistEntryResponseObject interface {
+	VisitCreateRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error
+}
+
+type CreateRateLimiterAllowlistEntry201JSONResponse AllowlistEntry
+
+func (response CreateRateLimiterAllowlistEntry201JSONResponse) VisitCreateRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterAllowlistEntry400JSONResponse Error
+
+func (response CreateRateLimiterAllowlistEntry400JSONResponse) VisitCreateRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterAllowlistEntry401JSONResponse Error
+
+func (response CreateRateLimiterAllowlistEntry401JSONResponse) VisitCreateRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterAllowlistEntry500JSONResponse Error
+
+func (response CreateRateLimiterAllowlistEntry500JSONResponse) VisitCreateRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type DeleteRateLimiterAllowlistEntryRequestObject struct {
+	EntryId string `json:"entryId"`
+}
+
+type DeleteRateLimiterAllowlistEntryResponseObject interface {
+	VisitDeleteRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error
+}
+
+type DeleteRateLimiterAllowlistEntry204Response struct {
+}
+
+func (response DeleteRateLimiterAllowlistEntry204Response) VisitDeleteRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.WriteHeader(204)
+	return nil
+}
+
+type DeleteRateLimiterAllowlistEntry401JSONResponse Error
+
+func (response DeleteRateLimiterAllowlistEntry401JSONResponse) VisitDeleteRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type DeleteRateLimiterAllowlistEntry404JSONResponse Error
+
+func (response DeleteRateLimiterAllowlistEntry404JSONResponse) VisitDeleteRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type DeleteRateLimiterAllowlistEntry500JSONResponse Error
+
+func (response DeleteRateLimiterAllowlistEntry500JSONResponse) VisitDeleteRateLimiterAllowlistEntryResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ListRateLimiterBlocksRequestObject struct {
+	Params ListRateLimiterBlocksParams
+}
+
+type ListRateLimiterBlocksResponseObject interface {
+	VisitListRateLimiterBlocksResponse(w http.ResponseWriter) error
+}
+
+type ListRateLimiterBlocks200JSONResponse BlockEventsResponse
+
+func (response ListRateLimiterBlocks200JSONResponse) VisitListRateLimiterBlocksResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ListRateLimiterBlocks401JSONResponse Error
+
+func (response ListRateLimiterBlocks401JSONResponse) VisitListRateLimiterBlocksResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type ListRateLimiterBlocks500JSONResponse Error
+
+func (response ListRateLimiterBlocks500JSONResponse) VisitListRateLimiterBlocksResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterBlockRequestObject struct {
+	Body *CreateRateLimiterBlockJSONRequestBody
+}
+
+type CreateRateLimiterBlockResponseObject interface {
+	VisitCreateRateLimiterBlockResponse(w http.ResponseWriter) error
+}
+
+type CreateRateLimiterBlock201JSONResponse BlockEvent
+
+func (response CreateRateLimiterBlock201JSONResponse) VisitCreateRateLimiterBlockResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterBlock400JSONResponse Error
+
+func (response CreateRateLimiterBlock400JSONResponse) VisitCreateRateLimiterBlockResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterBlock401JSONResponse Error
+
+func (response CreateRateLimiterBlock401JSONResponse) VisitCreateRateLimiterBlockResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type CreateRateLimiterBlock500JSONResponse Error
+
+func (response CreateRateLimiterBlock500JSONResponse) VisitCreateRateLimiterBlockResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type DeleteRateLimiterBlockRequestObject struct {
+	BlockId string `json:"blockId"`
+}
+
+type DeleteRateLimiterBlockResponseObject interface {
+	VisitDeleteRateLimiterBlockResponse(w http.ResponseWriter) error
+}
+
+type DeleteRateLimiterBlock200JSONResponse BlockEvent
+
+func (response DeleteRateLimiterBlock200JSONResponse) VisitDeleteRateLimiterBlockResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type DeleteRateLimiterBlock401JSONResponse Error
+
+func (response DeleteRateLimiterBlock401JSONResponse) VisitDeleteRateLimiterBlockResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type DeleteRateLimiterBlock404JSONResponse Error
 
-func (response AdjustUserCounters404JSONResponse) VisitAdjustUserCountersResponse(w http.ResponseWriter) error {
+func (response DeleteRateLimiterBlock404JSONResponse) VisitDeleteRateLimiterBlockResponse(w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(404)
 
 	return json.NewEncoder(w).Encode(response)
 }
 
-type AdjustUserCounters500JSONResponse Error
+type DeleteRateLimiterBlock500JSONResponse Error
 
-func (response AdjustUserCounters500JSONResponse) VisitAdjustUserCountersResponse(w http.ResponseWriter) error {
+func (response DeleteRateLimiterBlock500JSONResponse) VisitDeleteRateLimiterBlockResponse(w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(500)
 
 	return json.NewEncoder(w).Encode(response)
 }
 
-type GetUserCounterHistoryRequestObject struct {
-	CounterId string `json:"counterId"`
-	UserId    string `json:"userId"`
-	Params    GetUserCounterHistoryParams
+type GetCacheStatsRequestObject struct {
 }
 
-type GetUserCounterHistoryResponseObject interface {
-	VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error
+type GetCacheStatsResponseObject interface {
+	VisitGetCacheStatsResponse(w http.ResponseWriter) error
 }
 
-type GetUserCounterHistory200JSONResponse CounterHistoryResponse
+type GetCacheStats200JSONResponse CacheStats
 
-func (response GetUserCounterHistory200JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+func (response GetCacheStats200JSONResponse) VisitGetCacheStatsResponse(w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 
 	return json.NewEncoder(w).Encode(response)
 }
 
-type GetUserCounterHistory401JSONResponse Error
+type GetCacheStats401JSONResponse Error
 
-func (response GetUserCounterHistory401JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+func (response GetCacheStats401JSONResponse) VisitGetCacheStatsResponse(w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(401)
 
 	return json.NewEncoder(w).Encode(response)
 }
 
-type GetUserCounterHistory403JSONResponse Error
-
-func (response GetUserCounterHistory403JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(403)
+type GetCircuitBreakerStatsRequestObject struct {
+}
 
-	return json.NewEncoder(w).Encode(response)
+type GetCircuitBreakerStatsResponseObject interface {
+	VisitGetCircuitBreakerStatsResponse(w http.ResponseWriter) error
 }
 
-type GetUserCounterHistory404JSONResponse Error
+type GetCircuitBreakerStats200JSONResponse CircuitBreakerStats
 
-func (response GetUserCounterHistory404JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+func (response GetCircuitBreakerStats200JSONResponse) VisitGetCircuitBreakerStatsResponse(w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(404)
+	w.WriteHeader(200)
 
 	return json.NewEncoder(w).Encode(response)
 }
 
-type GetUserCounterHistory500JSONResponse Error
+type GetCircuitBreakerStats401JSONResponse Error
 
-func (response GetUserCounterHistory500JSONResponse) VisitGetUserCounterHistoryResponse(w http.ResponseWriter) error {
+func (response GetCircuitBreakerStats401JSONResponse) VisitGetCircuitBreakerStatsResponse(w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(500)
+	w.WriteHeader(401)
 
 	return json.NewEncoder(w).Encode(response)
 }
@@ -1695,6 +2738,31 @@ func (response GetToken500JSONResponse) VisitGetTokenResponse(w http.ResponseWri
 	return json.NewEncoder(w).Encode(response)
 }
 
+type GetUpstreamsRequestObject struct {
+}
+
+type GetUpstreamsResponseObject interface {
+	VisitGetUpstreamsResponse(w http.ResponseWriter) error
+}
+
+type GetUpstreams200JSONResponse UpstreamPools
+
+func (response GetUpstreams200JSONResponse) VisitGetUpstreamsResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type GetUpstreams401JSONResponse Error
+
+func (response GetUpstreams401JSONResponse) VisitGetUpstreamsResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
 type ListUsersRequestObject struct {
 }
 
@@ -1940,6 +3008,15 @@ func (response HealthCheck200JSONResponse) VisitHealthCheckResponse(w http.Respo
 	return json.NewEncoder(w).Encode(response)
 }
 
+type HealthCheck503JSONResponse HealthResponse
+
+func (response HealthCheck503JSONResponse) VisitHealthCheckResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(503)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
 type LogoutUserRequestObject struct {
 	Params LogoutUserParams
 }
@@ -2036,9 +3113,15 @@ type StrictServerInterface interface {
 	// Get all users' counter balances (admin only)
 	// (GET /api/admin/counters/{counterId})
 	GetAllUserCounters(ctx context.Context, request GetAllUserCountersRequestObject) (GetAllUserCountersResponseObject, error)
+	// Get the expiry and renewal status of the ACME certificates
+	// (GET /api/certificates)
+	GetCertificates(ctx context.Context, request GetCertificatesRequestObject) (GetCertificatesResponseObject, error)
 	// Get current rate limiter configuration
 	// (GET /api/config/rate-limiter)
 	GetRateLimiterConfig(ctx context.Context, request GetRateLimiterConfigRequestObject) (GetRateLimiterConfigResponseObject, error)
+	// Reload the configuration file without restart (admin only)
+	// (POST /api/config/reload)
+	ReloadConfig(ctx context.Context, request ReloadConfigRequestObject) (ReloadConfigResponseObject, error)
 	// Get user's current counter balance
 	// (GET /api/counters/{counterId}/{userId})
 	GetUserCounters(ctx context.Context, request GetUserCountersRequestObject) (GetUserCountersResponseObject, error)
@@ -2048,6 +3131,30 @@ type StrictServerInterface interface {
 	// Get user's counter transaction history
 	// (GET /api/counters/{counterId}/{userId}/history)
 	GetUserCounterHistory(ctx context.Context, request GetUserCounterHistoryRequestObject) (GetUserCounterHistoryResponseObject, error)
+	// List the networks the rate limiter never limits (admin only)
+	// (GET /api/rate-limiter/allowlist)
+	ListRateLimiterAllowlist(ctx context.Context, request ListRateLimiterAllowlistRequestObject) (ListRateLimiterAllowlistResponseObject, error)
+	// Add an IP address or CIDR network to the allowlist (admin only)
+	// (POST /api/rate-limiter/allowlist)
+	CreateRateLimiterAllowlistEntry(ctx context.Context, request CreateRateLimiterAllowlistEntryRequestObject) (CreateRateLimiterAllowlistEntryResponseObject, error)
+	// Remove an entry of the allowlist (admin only)
+	// (DELETE /api/rate-limiter/allowlist/{entryId})
+	DeleteRateLimiterAllowlistEntry(ctx context.Context, request DeleteRateLimiterAllowlistEntryRequestObject) (DeleteRateLimiterAllowlistEntryResponseObject, error)
+	// List the blocks of the rate limiter, newest first (admin only)
+	// (GET /api/rate-limiter/blocks)
+	ListRateLimiterBlocks(ctx context.Context, request ListRateLimiterBlocksRequestObject) (ListRateLimiterBlocksResponseObject, error)
+	// Block an IP address or CIDR network (admin only)
+	// (POST /api/rate-limiter/blocks)
+	CreateRateLimiterBlock(ctx context.Context, request CreateRateLimiterBlockRequestObject) (CreateRateLimiterBlockResponseObject, error)
+	// End a block early (admin only)
+	// (DELETE /api/rate-limiter/blocks/{blockId})
+	DeleteRateLimiterBlock(ctx context.Context, request DeleteRateLimiterBlockRequestObject) (DeleteRateLimiterBlockResponseObject, error)
+	// Get the hit and miss counters of the shared response cache
+	// (GET /api/statistics/cache)
+	GetCacheStats(ctx context.Context, request GetCacheStatsRequestObject) (GetCacheStatsResponseObject, error)
+	// Get the state of the circuit breakers of the proxy routes
+	// (GET /api/statistics/circuit-breakers)
+	GetCircuitBreakerStats(ctx context.Context, request GetCircuitBreakerStatsRequestObject) (GetCircuitBreakerStatsResponseObject, error)
 	// Get current rate limiter statistics
 	// (GET /api/statistics/rate-limiter)
 	GetRateLimiterStats(ctx context.Context, request GetRateLimiterStatsRequestObject) (GetRateLimiterStatsResponseObject, error)
@@ -2063,6 +3170,9 @@ type StrictServerInterface interface {
 	// Get token details (admin only)
 	// (GET /api/tokens/{tokenId})
 	GetToken(ctx context.Context, request GetTokenRequestObject) (GetTokenResponseObject, error)
+	// Get the health of the upstream targets of every proxy route
+	// (GET /api/upstreams)
+	GetUpstreams(ctx context.Context, request GetUpstreamsRequestObject) (GetUpstreamsResponseObject, error)
 	// List all users
 	// (GET /api/users)
 	ListUsers(ctx context.Context, request ListUsersRequestObject) (ListUsersResponseObject, error)
@@ -2172,6 +3282,30 @@ func (sh *strictHandler) GetAllUserCounters(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// GetCertificates operation middleware
+func (sh *strictHandler) GetCertificates(w http.ResponseWriter, r *http.Request) {
+	var request GetCertificatesRequestObject
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.GetCertificates(ctx, request.(GetCertificatesRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "GetCertificates")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(GetCertificatesResponseObject); ok {
+		if err := validResponse.VisitGetCertificatesResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
 // GetRateLimiterConfig operation middleware
 func (sh *strictHandler) GetRateLimiterConfig(w http.ResponseWriter, r *http.Request) {
 	var request GetRateLimiterConfigRequestObject
@@ -2196,6 +3330,30 @@ func (sh *strictHandler) GetRateLimiterConfig(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// ReloadConfig operation middleware
+func (sh *strictHandler) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	var request ReloadConfigRequestObject
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.ReloadConfig(ctx, request.(ReloadConfigRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "ReloadConfig")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(ReloadConfigResponseObject); ok {
+		if err := validResponse.VisitReloadConfigResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
 // GetUserCounters operation middleware
 func (sh *strictHandler) GetUserCounters(w http.ResponseWriter, r *http.Request, counterId string, userId string) {
 	var request GetUserCountersRequestObject
@@ -2285,6 +3443,218 @@ func (sh *strictHandler) GetUserCounterHistory(w http.ResponseWriter, r *http.Re
 	}
 }
 
+// ListRateLimiterAllowlist operation middleware
+func (sh *strictHandler) ListRateLimiterAllowlist(w http.ResponseWriter, r *http.Request) {
+	var request ListRateLimiterAllowlistRequestObject
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.ListRateLimiterAllowlist(ctx, request.(ListRateLimiterAllowlistRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "ListRateLimiterAllowlist")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(ListRateLimiterAllowlistResponseObject); ok {
+		if err := validResponse.VisitListRateLimiterAllowlistResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
+// CreateRateLimiterAllowlistEntry operation middleware
+func (sh *strictHandler) CreateRateLimiterAllowlistEntry(w http.ResponseWriter, r *http.Request) {
+	var request CreateRateLimiterAllowlistEntryRequestObject
+
+	var body CreateRateLimiterAllowlistEntryJSONRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		sh.options.RequestErrorHandlerFunc(w, r, fmt.Errorf("can't decode JSON body: %w", err))
+		return
+	}
+	request.Body = &body
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.CreateRateLimiterAllowlistEntry(ctx, request.(CreateRateLimiterAllowlistEntryRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "CreateRateLimiterAllowlistEntry")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(CreateRateLimiterAllowlistEntryResponseObject); ok {
+		if err := validResponse.VisitCreateRateLimiterAllowlistEntryResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
+// DeleteRateLimiterAllowlistEntry operation middleware
+func (sh *strictHandler) DeleteRateLimiterAllowlistEntry(w http.ResponseWriter, r *http.Request, entryId string) {
+	var request DeleteRateLimiterAllowlistEntryRequestObject
+
+	request.EntryId = entryId
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.DeleteRateLimiterAllowlistEntry(ctx, request.(DeleteRateLimiterAllowlistEntryRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "DeleteRateLimiterAllowlistEntry")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(DeleteRateLimiterAllowlistEntryResponseObject); ok {
+		if err := validResponse.VisitDeleteRateLimiterAllowlistEntryResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
+// ListRateLimiterBlocks operation middleware
+func (sh *strictHandler) ListRateLimiterBlocks(w http.ResponseWriter, r *http.Request, params ListRateLimiterBlocksParams) {
+	var request ListRateLimiterBlocksRequestObject
+
+	request.Params = params
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.ListRateLimiterBlocks(ctx, request.(ListRateLimiterBlocksRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "ListRateLimiterBlocks")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(ListRateLimiterBlocksResponseObject); ok {
+		if err := validResponse.VisitListRateLimiterBlocksResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
+// CreateRateLimiterBlock operation middleware
+func (sh *strictHandler) CreateRateLimiterBlock(w http.ResponseWriter, r *http.Request) {
+	var request CreateRateLimiterBlockRequestObject
+
+	var body CreateRateLimiterBlockJSONRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		sh.options.RequestErrorHandlerFunc(w, r, fmt.Errorf("can't decode JSON body: %w", err))
+		return
+	}
+	request.Body = &body
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.CreateRateLimiterBlock(ctx, request.(CreateRateLimiterBlockRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "CreateRateLimiterBlock")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(CreateRateLimiterBlockResponseObject); ok {
+		if err := validResponse.VisitCreateRateLimiterBlockResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
+// DeleteRateLimiterBlock operation middleware
+func (sh *strictHandler) DeleteRateLimiterBlock(w http.ResponseWriter, r *http.Request, blockId string) {
+	var request DeleteRateLimiterBlockRequestObject
+
+	request.BlockId = blockId
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.DeleteRateLimiterBlock(ctx, request.(DeleteRateLimiterBlockRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "DeleteRateLimiterBlock")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(DeleteRateLimiterBlockResponseObject); ok {
+		if err := validResponse.VisitDeleteRateLimiterBlockResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
+// GetCacheStats operation middleware
+func (sh *strictHandler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	var request GetCacheStatsRequestObject
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.GetCacheStats(ctx, request.(GetCacheStatsRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "GetCacheStats")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(GetCacheStatsResponseObject); ok {
+		if err := validResponse.VisitGetCacheStatsResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
+// GetCircuitBreakerStats operation middleware
+func (sh *strictHandler) GetCircuitBreakerStats(w http.ResponseWriter, r *http.Request) {
+	var request GetCircuitBreakerStatsRequestObject
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.GetCircuitBreakerStats(ctx, request.(GetCircuitBreakerStatsRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "GetCircuitBreakerStats")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(GetCircuitBreakerStatsResponseObject); ok {
+		if err := validResponse.VisitGetCircuitBreakerStatsResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
 // GetRateLimiterStats operation middleware
 func (sh *strictHandler) GetRateLimiterStats(w http.ResponseWriter, r *http.Request) {
 	var request GetRateLimiterStatsRequestObject
@@ -2413,6 +3783,30 @@ func (sh *strictHandler) GetToken(w http.ResponseWriter, r *http.Request, tokenI
 	}
 }
 
+// GetUpstreams operation middleware
+func (sh *strictHandler) GetUpstreams(w http.ResponseWriter, r *http.Request) {
+	var request GetUpstreamsRequestObject
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, request interface{}) (interface{}, error) {
+		return sh.ssi.GetUpstreams(ctx, request.(GetUpstreamsRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "GetUpstreams")
+	}
+
+	response, err := handler(r.Context(), w, r, request)
+
+	if err != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, err)
+	} else if validResponse, ok := response.(GetUpstreamsResponseObject); ok {
+		if err := validResponse.VisitGetUpstreamsResponse(w); err != nil {
+			sh.options.ResponseErrorHandlerFunc(w, r, err)
+		}
+	} else if response != nil {
+		sh.options.ResponseErrorHandlerFunc(w, r, fmt.Errorf("unexpected response type: %T", response))
+	}
+}
+
 // ListUsers operation middleware
 func (sh *strictHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	var request ListUsersRequestObject
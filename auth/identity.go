@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // SHA-384 and SHA-512 for HS384/HS512 and RS384/RS512
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+)
+
+// maxCachedIdentityTokens bounds the number of sessions whose token is kept for reuse.
+const maxCachedIdentityTokens = 4096
+
+// IdentityClaims are the claims of the identity token sent to upstreams.
+type IdentityClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+	Username  string `json:"preferred_username,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Admin     bool   `json:"admin"`
+	Provider  string `json:"provider,omitempty"`
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSet is the document served by the JWKS endpoint.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// IdentitySigner signs the short-lived JWTs that carry the identity of the session user
+// to the upstreams of authenticated routes.
+type IdentitySigner struct {
+	cfg    config.IdentityJWTConfig
+	alg    string
+	hash   crypto.Hash
+	secret []byte
+	key    *rsa.PrivateKey
+	keyID  string
+	now    func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]cachedIdentityToken // by session token
+}
+
+type cachedIdentityToken struct {
+	token     string
+	refreshAt time.Time
+}
+
+// NewIdentitySigner loads the key of the configuration. The configuration is expected to
+// be validated by config.LoadConfig.
+func NewIdentitySigner(cfg config.IdentityJWTConfig) (*IdentitySigner, error) {
+	s := &IdentitySigner{
+		cfg:    cfg,
+		alg:    cfg.Algorithm,
+		keyID:  cfg.KeyID,
+		now:    time.Now,
+		tokens: make(map[string]cachedIdentityToken),
+	}
+	if cfg.IsHMAC() {
+		if s.alg == "" {
+			s.alg = "HS256"
+		}
+		s.secret = []byte(cfg.Secret)
+	} else {
+		if s.alg == "" {
+			s.alg = "RS256"
+		}
+		keyPEM := []byte(cfg.PrivateKey)
+		if cfg.PrivateKeyFile != "" {
+			data, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read identity private key: %w", err)
+			}
+			keyPEM = data
+		}
+		key, err := parseRSAPrivateKey(keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+		if s.keyID == "" {
+			s.keyID = rsaThumbprint(&key.PublicKey)
+		}
+	}
+	switch s.alg {
+	case "HS256", "RS256":
+		s.hash = crypto.SHA256
+	case "HS384", "RS384":
+		s.hash = crypto.SHA384
+	case "HS512", "RS512":
+		s.hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported identity token algorithm '%s'", s.alg)
+	}
+	return s, nil
+}
+
+// Header returns the request header the token is sent in.
+func (s *IdentitySigner) Header() string {
+	return s.cfg.HeaderName()
+}
+
+// Token returns a token for the session. Tokens are reused until half of their lifetime
+// has passed, so upstreams still get at least half of the TTL to use them.
+func (s *IdentitySigner) Token(sess *db.Session) (string, error) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.tokens[sess.Token]; ok && sess.Token != "" && now.Before(cached.refreshAt) {
+		return cached.token, nil
+	}
+
+	ttl := s.cfg.TTL()
+	token, err := s.Sign(sess, now)
+	if err != nil {
+		return "", err
+	}
+	if sess.Token != "" {
+		if len(s.tokens) >= maxCachedIdentityTokens {
+			s.pruneLocked(now)
+		}
+		s.tokens[sess.Token] = cachedIdentityToken{token: token, refreshAt: now.Add(ttl / 2)}
+	}
+	return token, nil
+}
+
+// pruneLocked drops the tokens that would be renewed anyway, or all of them when that
+// does not free any room.
+func (s *IdentitySigner) pruneLocked(now time.Time) {
+	for key, cached := range s.tokens {
+		if !now.Before(cached.refreshAt) {
+			delete(s.tokens, key)
+		}
+	}
+	if len(s.tokens) >= maxCachedIdentityTokens {
+		clear(s.tokens)
+	}
+}
+
+// Sign creates a new token for the session, issued at now.
+func (s *IdentitySigner) Sign(sess *db.Session, now time.Time) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	claims := IdentityClaims{
+		Issuer:    s.cfg.IssuerName(),
+		Subject:   sess.UserID,
+		Audience:  s.cfg.Audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.cfg.TTL()).Unix(),
+		ID:        hex.EncodeToString(jti),
+		Username:  sess.Username,
+		Email:     sess.Email,
+		Admin:     sess.IsAdmin,
+		Provider:  sess.Provider,
+	}
+	header := map[string]string{"alg": s.alg, "typ": "JWT"}
+	if s.keyID != "" {
+		header["kid"] = s.keyID
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	var signature []byte
+	if s.key != nil {
+		h := s.hash.New()
+		h.Write([]byte(signingInput))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, s.key, s.hash, h.Sum(nil))
+		if err != nil {
+			return "", fmt.Errorf("failed to sign identity token: %w", err)
+		}
+	} else {
+		mac := hmac.New(s.hash.New, s.secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// JWKS returns the public keys upstreams use to verify the tokens. HMAC secrets are
+// shared out of band and are never published, so the set is empty for HS algorithms.
+func (s *IdentitySigner) JWKS() JWKSet {
+	if s.key == nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+	return JWKSet{Keys: []JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: s.alg,
+		Kid: s.keyID,
+		N:   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+	}}}
+}
+
+// parseRSAPrivateKey reads a PKCS#1 or PKCS#8 RSA private key in PEM format.
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(string(data))))
+	if block == nil {
+		return nil, errors.New("identity private key is not in PEM format")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity private key is a %T, an RSA key is required", parsed)
+	}
+	return key, nil
+}
+
+// rsaThumbprint returns the RFC 7638 thumbprint of a public key, used as its key ID.
+func rsaThumbprint(key *rsa.PublicKey) string {
+	jwk := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()))
+	sum := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
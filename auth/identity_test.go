@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeJWT splits a token and returns its header, claims, signing input and signature.
+func decodeJWT(t *testing.T, token string) (map[string]string, IdentityClaims, string, []byte) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	var header map[string]string
+	var claims IdentityClaims
+	for i, target := range []any{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, target))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	return header, claims, parts[0] + "." + parts[1], signature
+}
+
+func TestIdentitySigner_HMAC(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+	signer, err := NewIdentitySigner(config.IdentityJWTConfig{Enabled: true, Secret: secret, Audience: "orders", TTLSeconds: 30})
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	token, err := signer.Sign(&db.Session{UserID: "user-1", Email: "ana@example.com", IsAdmin: true, Provider: "github"}, now)
+	require.NoError(t, err)
+
+	header, claims, signingInput, signature := decodeJWT(t, token)
+	assert.Equal(t, map[string]string{"alg": "HS256", "typ": "JWT"}, header)
+	assert.Equal(t, IdentityClaims{
+		Issuer: "taronja-gateway", Subject: "user-1", Audience: "orders",
+		IssuedAt: now.Unix(), ExpiresAt: now.Unix() + 30, ID: claims.ID,
+		Email: "ana@example.com", Admin: true, Provider: "github",
+	}, claims)
+	assert.Len(t, claims.ID, 32)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	assert.Equal(t, mac.Sum(nil), signature)
+	assert.Empty(t, signer.JWKS().Keys, "HMAC secrets are not published")
+}
+
+func TestIdentitySigner_RSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	signer, err := NewIdentitySigner(config.IdentityJWTConfig{Enabled: true, PrivateKey: keyPEM, Algorithm: "RS384"})
+	require.NoError(t, err)
+	token, err := signer.Sign(&db.Session{UserID: "user-1"}, time.Now())
+	require.NoError(t, err)
+	header, _, signingInput, signature := decodeJWT(t, token)
+
+	// Verify the token with the published key only
+	jwks := signer.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	jwk := jwks.Keys[0]
+	assert.Equal(t, header["kid"], jwk.Kid)
+	assert.Equal(t, "RS384", jwk.Alg)
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	require.NoError(t, err)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	h := crypto.SHA384.New()
+	h.Write([]byte(signingInput))
+	assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA384, h.Sum(nil), signature))
+}
+
+func TestIdentitySigner_TokenIsReused(t *testing.T) {
+	signer, err := NewIdentitySigner(config.IdentityJWTConfig{Enabled: true, Secret: strings.Repeat("s", 32), TTLSeconds: 60})
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	signer.now = func() time.Time { return now }
+
+	sess := &db.Session{Token: "session-1", UserID: "user-1"}
+	first, err := signer.Token(sess)
+	require.NoError(t, err)
+	now = now.Add(29 * time.Second)
+	second, err := signer.Token(sess)
+	require.NoError(t, err)
+	assert.Equal(t, first, second, "the token is reused during the first half of its lifetime")
+
+	now = now.Add(time.Second)
+	third, err := signer.Token(sess)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third)
+
+	other, err := signer.Token(&db.Session{Token: "session-2", UserID: "user-2"})
+	require.NoError(t, err)
+	assert.NotEqual(t, third, other)
+}
+
+func TestNewIdentitySigner_InvalidKey(t *testing.T) {
+	_, err := NewIdentitySigner(config.IdentityJWTConfig{Enabled: true, PrivateKey: "not a key"})
+	assert.Error(t, err)
+	_, err = NewIdentitySigner(config.IdentityJWTConfig{Enabled: true, PrivateKeyFile: "does-not-exist.pem"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,555 @@
+// Package cache implements the shared HTTP response cache of the proxy routes, following
+// the semantics of a shared cache in RFC 9111 and the stale-while-revalidate and
+// stale-if-error extensions of RFC 5861.
+package cache
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// StatusHeader tells clients how the cache handled a request.
+const StatusHeader = "X-Cache"
+
+// Values of StatusHeader.
+const (
+	StatusHit         = "HIT"         // fresh stored response
+	StatusStale       = "STALE"       // stale stored response, served while it is refreshed or because the upstream failed
+	StatusRevalidated = "REVALIDATED" // stored response confirmed by the upstream with 304 Not Modified
+	StatusMiss        = "MISS"        // response from the upstream
+	StatusBypass      = "BYPASS"      // request the cache does not handle
+)
+
+// diskQueueSize bounds the entries waiting to be written to the disk tier; entries are
+// only kept in memory when the disk falls behind.
+const diskQueueSize = 256
+
+// Stats are the counters of the cache since the gateway started.
+type Stats struct {
+	Hits          int64
+	StaleHits     int64
+	Revalidations int64
+	Misses        int64
+	Bypasses      int64
+	Stores        int64
+	Memory        TierStats
+	Disk          *TierStats // nil without a disk tier
+	Routes        []RouteStats
+}
+
+// HitRatio returns the share of the requests handled by the cache that were answered
+// without a full response from the upstream.
+func (s Stats) HitRatio() float64 {
+	served := s.Hits + s.StaleHits + s.Revalidations
+	if served+s.Misses == 0 {
+		return 0
+	}
+	return float64(served) / float64(served+s.Misses)
+}
+
+// RouteStats are the counters of one route.
+type RouteStats struct {
+	Route         string
+	Hits          int64
+	StaleHits     int64
+	Revalidations int64
+	Misses        int64
+	Bypasses      int64
+	Stores        int64
+}
+
+type routeCounters struct {
+	route         string
+	hits          atomic.Int64
+	staleHits     atomic.Int64
+	revalidations atomic.Int64
+	misses        atomic.Int64
+	bypasses      atomic.Int64
+	stores        atomic.Int64
+}
+
+// Cache is the response cache shared by all the routes with a cache block. Responses are
+// kept in memory and, when a directory is configured, also written to disk.
+type Cache struct {
+	memory   *memoryStore
+	disk     *diskStore
+	maxEntry int64
+	now      func() time.Time
+
+	diskQueue chan *Entry
+	diskDone  chan struct{}
+
+	mu           sync.Mutex
+	diskClosed   bool // diskQueue is closed, entries are only kept in memory
+	routes       []*routeCounters
+	revalidating map[string]bool // keys being refreshed in the background
+}
+
+// New creates the cache with the sizes of the configuration.
+func New(cfg config.CacheConfig) (*Cache, error) {
+	c := &Cache{
+		memory:       newMemoryStore(cfg.MaxMemoryBytes()),
+		maxEntry:     min(cfg.MaxEntryBytes(), cfg.MaxMemoryBytes()),
+		now:          time.Now,
+		revalidating: make(map[string]bool),
+	}
+	if cfg.Disk.Path != "" {
+		disk, err := newDiskStore(cfg.Disk.Path, cfg.Disk.MaxSizeBytes())
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+		c.diskQueue = make(chan *Entry, diskQueueSize)
+		c.diskDone = make(chan struct{})
+		go c.writeToDisk()
+	}
+	return c, nil
+}
+
+// Close waits for the pending disk writes. The entries stored afterwards are only kept
+// in memory.
+func (c *Cache) Close() error {
+	if c.diskQueue == nil {
+		return nil
+	}
+	c.mu.Lock()
+	if !c.diskClosed {
+		c.diskClosed = true
+		close(c.diskQueue)
+	}
+	c.mu.Unlock()
+	<-c.diskDone
+	return nil
+}
+
+func (c *Cache) writeToDisk() {
+	defer close(c.diskDone)
+	for e := range c.diskQueue {
+		if err := c.disk.set(e); err != nil {
+			log.Printf("Cache: %v", err)
+		}
+	}
+}
+
+// get returns the entry of a key, promoting entries found on disk to memory.
+func (c *Cache) get(key string) *Entry {
+	if e, ok := c.memory.get(key); ok {
+		return e
+	}
+	if c.disk == nil {
+		return nil
+	}
+	e, ok := c.disk.get(key)
+	if !ok {
+		return nil
+	}
+	c.memory.set(e)
+	return e
+}
+
+func (c *Cache) set(e *Entry) {
+	c.memory.set(e)
+	if c.diskQueue == nil {
+		return
+	}
+	// The queue is only closed with the lock held, and sending never blocks
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.diskClosed {
+		return
+	}
+	select {
+	case c.diskQueue <- e:
+	default:
+		log.Printf("Cache: disk writes are falling behind, %s is only kept in memory", e.Key)
+	}
+}
+
+func (c *Cache) delete(key string) {
+	c.memory.delete(key)
+	if c.disk != nil {
+		c.disk.delete(key)
+	}
+}
+
+// Stats returns the counters of the cache and of every route using it.
+func (c *Cache) Stats() Stats {
+	stats := Stats{Memory: c.memory.stats()}
+	if c.disk != nil {
+		disk := c.disk.stats()
+		stats.Disk = &disk
+	}
+	c.mu.Lock()
+	routes := slices.Clone(c.routes)
+	c.mu.Unlock()
+	for _, rc := range routes {
+		rs := RouteStats{
+			Route:         rc.route,
+			Hits:          rc.hits.Load(),
+			StaleHits:     rc.staleHits.Load(),
+			Revalidations: rc.revalidations.Load(),
+			Misses:        rc.misses.Load(),
+			Bypasses:      rc.bypasses.Load(),
+			Stores:        rc.stores.Load(),
+		}
+		stats.Hits += rs.Hits
+		stats.StaleHits += rs.StaleHits
+		stats.Revalidations += rs.Revalidations
+		stats.Misses += rs.Misses
+		stats.Bypasses += rs.Bypasses
+		stats.Stores += rs.Stores
+		stats.Routes = append(stats.Routes, rs)
+	}
+	return stats
+}
+
+// route holds what the middleware of one route needs.
+type route struct {
+	name                 string
+	authenticated        bool
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	counters             *routeCounters
+}
+
+// Middleware serves the requests of a route from the cache and stores the responses of
+// its upstream.
+func (c *Cache) Middleware(routeConfig config.RouteConfig) func(http.Handler) http.Handler {
+	rt := &route{
+		name:                 routeConfig.Name,
+		authenticated:        routeConfig.Authentication.Enabled || routeConfig.ClientCert != nil,
+		staleWhileRevalidate: routeConfig.Cache.StaleWhileRevalidate(),
+		staleIfError:         routeConfig.Cache.StaleIfError(),
+		counters:             c.routeCounters(routeConfig.Name),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.serve(w, r, next, rt)
+		})
+	}
+}
+
+// routeCounters returns the counters of a route, the same ones for every configuration
+// reload as the cache is kept.
+func (c *Cache) routeCounters(name string) *routeCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rc := range c.routes {
+		if rc.route == name {
+			return rc
+		}
+	}
+	rc := &routeCounters{route: name}
+	c.routes = append(c.routes, rc)
+	return rc
+}
+
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, next http.Handler, rt *route) {
+	key := primaryKey(rt.name, r)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rt.counters.bypasses.Add(1)
+		next.ServeHTTP(w, r)
+		if !isSafeMethod(r.Method) {
+			// Unsafe requests invalidate the stored responses of their URL (RFC 9111 §4.4)
+			c.delete(key)
+		}
+		return
+	}
+	reqCC := parseDirectives(r.Header)
+	if reqCC.has("no-store") || r.Header.Get("Upgrade") != "" {
+		rt.counters.bypasses.Add(1)
+		w.Header().Set(StatusHeader, StatusBypass)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	entry := c.lookup(key, r)
+	now := c.now()
+	if entry != nil && !reqCC.has("no-cache") {
+		switch c.freshness(entry, reqCC, rt, now) {
+		case fresh:
+			rt.counters.hits.Add(1)
+			serveEntry(w, r, entry, now, StatusHit)
+			return
+		case staleRevalidate:
+			rt.counters.staleHits.Add(1)
+			serveEntry(w, r, entry, now, StatusStale)
+			c.revalidateInBackground(r, next, rt, entry)
+			return
+		}
+	}
+	if entry == nil && reqCC.has("only-if-cached") {
+		rt.counters.misses.Add(1)
+		http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if r.Method == http.MethodHead {
+		// Responses to HEAD have no body to store or to refresh a stored response with
+		rt.counters.misses.Add(1)
+		w.Header().Set(StatusHeader, StatusMiss)
+		next.ServeHTTP(w, r)
+		return
+	}
+	c.fetch(w, r, next, rt, key, entry, false)
+}
+
+type freshnessState int
+
+const (
+	stale           freshnessState = iota // must be revalidated before use
+	fresh                                 // can be served
+	staleRevalidate                       // can be served while it is refreshed
+)
+
+// freshness decides how a stored response may be used for a request (RFC 9111 §4.2 and
+// RFC 5861 §3).
+func (c *Cache) freshness(e *Entry, reqCC directives, rt *route, now time.Time) freshnessState {
+	resCC := parseDirectives(e.Header)
+	if resCC.has("no-cache") {
+		return stale
+	}
+	age := e.age(now)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return stale
+	}
+	lifetime := e.freshnessLifetime(resCC)
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	if age < lifetime {
+		return fresh
+	}
+	if mustRevalidate(resCC) {
+		return stale
+	}
+	staleness := age - lifetime
+	if value, ok := reqCC["max-stale"]; ok {
+		if maxStale, _ := reqCC.seconds("max-stale"); value == "" || staleness <= maxStale {
+			return staleRevalidate
+		}
+	}
+	swr, ok := resCC.seconds("stale-while-revalidate")
+	if !ok {
+		swr = rt.staleWhileRevalidate
+	}
+	if staleness <= swr {
+		return staleRevalidate
+	}
+	return stale
+}
+
+// canServeStaleOnError reports whether a stored response may replace an error of the
+// upstream (RFC 5861 §4).
+func (c *Cache) canServeStaleOnError(e *Entry, reqCC directives, rt *route, now time.Time) bool {
+	resCC := parseDirectives(e.Header)
+	if mustRevalidate(resCC) {
+		return false
+	}
+	sie, ok := reqCC.seconds("stale-if-error")
+	if !ok {
+		sie, ok = resCC.seconds("stale-if-error")
+	}
+	if !ok {
+		sie = rt.staleIfError
+	}
+	return e.age(now)-e.freshnessLifetime(resCC) <= sie
+}
+
+func mustRevalidate(cc directives) bool {
+	return cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage")
+}
+
+// fetch sends the request to the upstream, revalidating entry when there is one, and
+// stores the response when it may be stored. Background fetches only count the stores.
+func (c *Cache) fetch(w http.ResponseWriter, r *http.Request, next http.Handler, rt *route, key string, entry *Entry, background bool) {
+	outreq := r
+	if entry != nil && entry.hasValidator() {
+		// Replace the validators of the client with the ones of the stored response; the
+		// client's are checked against the refreshed response
+		outreq = new(http.Request)
+		*outreq = *r
+		outreq.Header = r.Header.Clone()
+		outreq.Header.Del("If-None-Match")
+		outreq.Header.Del("If-Modified-Since")
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			outreq.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			outreq.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	reqCC := parseDirectives(r.Header)
+	cw := &captureWriter{
+		ResponseWriter: w,
+		header:         make(http.Header),
+		requestTime:    c.now(),
+		now:            c.now,
+		limit:          c.maxEntry,
+		decide: func(status int, header http.Header, now time.Time) (swallow, store bool) {
+			if entry != nil {
+				if status == http.StatusNotModified && outreq != r {
+					return true, false
+				}
+				if status >= http.StatusInternalServerError && c.canServeStaleOnError(entry, reqCC, rt, now) {
+					return true, false
+				}
+			}
+			return false, c.storable(r, status, header, rt)
+		},
+	}
+	// The reverse proxy aborts the handler with a panic when the upstream breaks in the
+	// middle of the body, so partial responses are never stored
+	next.ServeHTTP(cw, outreq)
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	count := func(counter *atomic.Int64) {
+		if !background {
+			counter.Add(1)
+		}
+	}
+	switch {
+	case cw.swallowed && cw.status == http.StatusNotModified:
+		count(&rt.counters.revalidations)
+		updated := entry.refreshed(cw.header, cw.requestTime, cw.responseTime)
+		c.set(updated)
+		serveEntry(w, r, updated, c.now(), StatusRevalidated)
+	case cw.swallowed:
+		log.Printf("Cache [%s]: upstream answered %d, serving stale response for %s", rt.name, cw.status, r.URL.Path)
+		count(&rt.counters.staleHits)
+		serveEntry(w, r, entry, c.now(), StatusStale)
+	default:
+		count(&rt.counters.misses)
+		if cw.store && !cw.overflow && cw.complete() {
+			rt.counters.stores.Add(1)
+			c.store(key, r, &Entry{
+				Status:       cw.status,
+				Header:       cw.header.Clone(),
+				Body:         cw.body,
+				RequestTime:  cw.requestTime,
+				ResponseTime: cw.responseTime,
+			})
+		} else if entry != nil && !cw.store && cw.status != http.StatusNotModified && cw.status < http.StatusInternalServerError {
+			// The upstream no longer allows storing the response
+			c.delete(entry.Key)
+		}
+	}
+}
+
+// revalidateInBackground refreshes a stale entry that was served, unless the same entry
+// is already being refreshed.
+func (c *Cache) revalidateInBackground(r *http.Request, next http.Handler, rt *route, entry *Entry) {
+	c.mu.Lock()
+	if c.revalidating[entry.Key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[entry.Key] = true
+	c.mu.Unlock()
+
+	// The client may be gone before the upstream answers
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	req.Header.Del("Cache-Control")
+	req.Header.Del("Pragma")
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, entry.Key)
+			c.mu.Unlock()
+			if err := recover(); err != nil && err != http.ErrAbortHandler {
+				log.Printf("Cache [%s]: background revalidation of %s failed: %v", rt.name, req.URL.Path, err)
+			}
+		}()
+		c.fetch(discardWriter{header: make(http.Header)}, req, next, rt, primaryKey(rt.name, req), entry, true)
+	}()
+}
+
+// storable reports whether a shared cache may store the response (RFC 9111 §3).
+func (c *Cache) storable(r *http.Request, status int, header http.Header, rt *route) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	cc := parseDirectives(header)
+	if cc.has("no-store") || cc.has("private") || len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	explicit := hasExplicitExpiration(header, cc)
+	if !heuristicallyCacheable(status) && !(explicit && (status == http.StatusFound || status == http.StatusTemporaryRedirect)) {
+		return false
+	}
+	if slices.Contains(varyNames(header), "*") {
+		return false
+	}
+	// Responses to authenticated requests are only shared when the upstream says so; the
+	// upstream may tell users apart by their cookies too (RFC 9111 §3.5)
+	if (rt.authenticated || r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "") &&
+		!cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+	e := &Entry{Status: status, Header: header, ResponseTime: c.now()}
+	return e.freshnessLifetime(cc) > 0 || e.hasValidator() || cc.has("stale-while-revalidate") || cc.has("stale-if-error")
+}
+
+// lookup returns the stored response for the request, following the Vary marker of the
+// primary key to the variant of the request.
+func (c *Cache) lookup(key string, r *http.Request) *Entry {
+	e := c.get(key)
+	if e == nil || len(e.Vary) == 0 {
+		return e
+	}
+	return c.get(variantKey(key, e.Vary, r))
+}
+
+// store saves a response under the primary key, or under the key of its variant with a
+// marker under the primary key when it has a Vary header.
+func (c *Cache) store(key string, r *http.Request, e *Entry) {
+	e.Header.Del(StatusHeader)
+	e.Key = key
+	if names := varyNames(e.Header); len(names) > 0 {
+		c.set(&Entry{Key: key, Vary: names})
+		e.Key = variantKey(key, names, r)
+	}
+	c.set(e)
+}
+
+// primaryKey identifies the stored responses of a URL on a route.
+func primaryKey(route string, r *http.Request) string {
+	return route + "\x00" + strings.ToLower(r.Host) + r.URL.RequestURI()
+}
+
+// variantKey identifies the variant of a response selected by the Vary headers.
+func variantKey(key string, names []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range names {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		for i, v := range r.Header.Values(name) {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(strings.Join(strings.Fields(v), " "))
+		}
+	}
+	return b.String()
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,418 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a clock the tests move forward by hand.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (tc *testClock) Now() time.Time {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.now
+}
+
+func (tc *testClock) Advance(d time.Duration) {
+	tc.mu.Lock()
+	tc.now = tc.now.Add(d)
+	tc.mu.Unlock()
+}
+
+// upstream answers with the headers and body returned by respond and counts its calls.
+type upstream struct {
+	calls   atomic.Int32
+	respond func(r *http.Request, call int) (status int, header http.Header, body string)
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := int(u.calls.Add(1))
+	status, header, body := u.respond(r, call)
+	for name, values := range header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// newTestCache returns a cache with a test clock and the handler of a route using it.
+func newTestCache(t *testing.T, route config.RouteConfig, up *upstream) (*Cache, *testClock, http.Handler) {
+	t.Helper()
+	c, err := New(config.CacheConfig{})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	clock := &testClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c.now = clock.Now
+	if route.Name == "" {
+		route.Name = "test"
+	}
+	if route.Cache == nil {
+		route.Cache = &config.RouteCacheConfig{}
+	}
+	return c, clock, c.Middleware(route)(up)
+}
+
+func get(h http.Handler, target string, header http.Header) *httptest.ResponseRecorder {
+	return do(h, http.MethodGet, target, header)
+}
+
+func do(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func cacheControl(value string) http.Header {
+	return http.Header{"Cache-Control": {value}}
+}
+
+func TestCacheServesFreshResponses(t *testing.T) {
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		return http.StatusOK, cacheControl("max-age=60"), "v" + strconv.Itoa(call)
+	}}
+	c, clock, h := newTestCache(t, config.RouteConfig{}, up)
+
+	rec := get(h, "/items", nil)
+	assert.Equal(t, StatusMiss, rec.Header().Get(StatusHeader))
+	assert.Equal(t, "v1", rec.Body.String())
+
+	clock.Advance(10 * time.Second)
+	rec = get(h, "/items", nil)
+	assert.Equal(t, StatusHit, rec.Header().Get(StatusHeader))
+	assert.Equal(t, "v1", rec.Body.String())
+	assert.Equal(t, "10", rec.Header().Get("Age"))
+	assert.Equal(t, "2", rec.Header().Get("Content-Length"))
+
+	assert.Equal(t, StatusMiss, get(h, "/items?page=2", nil).Header().Get(StatusHeader), "the query string is part of the key")
+
+	clock.Advance(60 * time.Second)
+	rec = get(h, "/items", nil)
+	assert.Equal(t, StatusMiss, rec.Header().Get(StatusHeader), "expired responses without validators are fetched again")
+	assert.Equal(t, "v3", rec.Body.String())
+
+	assert.Equal(t, StatusHit, do(h, http.MethodHead, "/items", nil).Header().Get(StatusHeader), "HEAD is answered from the stored GET response")
+
+	stats := c.Stats()
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, int64(3), stats.Misses)
+	assert.Equal(t, int64(3), stats.Stores)
+	assert.Equal(t, 2, stats.Memory.Entries)
+	require.Len(t, stats.Routes, 1)
+	assert.Equal(t, "test", stats.Routes[0].Route)
+}
+
+func TestCacheDoesNotStorePrivateResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"no-store", cacheControl("no-store, max-age=60")},
+		{"private", cacheControl("private, max-age=60")},
+		{"set-cookie", http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}},
+		{"vary star", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}},
+		{"no lifetime or validator", http.Header{}},
+		{"not cacheable status", cacheControl("max-age=60")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := http.StatusOK
+			if tt.name == "not cacheable status" {
+				status = http.StatusInternalServerError
+			}
+			up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+				return status, tt.header, "body"
+			}}
+			_, _, h := newTestCache(t, config.RouteConfig{}, up)
+			get(h, "/", nil)
+			get(h, "/", nil)
+			assert.Equal(t, int32(2), up.calls.Load())
+		})
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-language"}}, r.Header.Get("Accept-Language")
+	}}
+	_, _, h := newTestCache(t, config.RouteConfig{}, up)
+
+	get(h, "/", http.Header{"Accept-Language": {"en"}})
+	get(h, "/", http.Header{"Accept-Language": {"fr"}})
+	en := get(h, "/", http.Header{"Accept-Language": {"en"}})
+	fr := get(h, "/", http.Header{"Accept-Language": {"fr"}})
+	assert.Equal(t, StatusHit, en.Header().Get(StatusHeader))
+	assert.Equal(t, "en", en.Body.String())
+	assert.Equal(t, StatusHit, fr.Header().Get(StatusHeader))
+	assert.Equal(t, "fr", fr.Body.String())
+	assert.Equal(t, int32(2), up.calls.Load())
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			return http.StatusNotModified, http.Header{"Cache-Control": {"max-age=30"}, "ETag": {`"v1"`}}, ""
+		}
+		return http.StatusOK, http.Header{"Cache-Control": {"no-cache"}, "ETag": {`"v1"`}}, "body"
+	}}
+	c, _, h := newTestCache(t, config.RouteConfig{}, up)
+
+	assert.Equal(t, StatusMiss, get(h, "/doc", nil).Header().Get(StatusHeader))
+	rec := get(h, "/doc", nil)
+	assert.Equal(t, StatusRevalidated, rec.Header().Get(StatusHeader), "no-cache responses are revalidated on every use")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "body", rec.Body.String())
+	assert.Equal(t, "max-age=30", rec.Header().Get("Cache-Control"), "the headers of the 304 replace the stored ones")
+
+	rec = get(h, "/doc", http.Header{"If-None-Match": {`"v1"`}})
+	assert.Equal(t, StatusHit, rec.Header().Get(StatusHeader))
+	assert.Equal(t, http.StatusNotModified, rec.Code, "client validators are checked against the stored response")
+	assert.Empty(t, rec.Body.String())
+
+	assert.Equal(t, int32(2), up.calls.Load())
+	assert.Equal(t, int64(1), c.Stats().Revalidations)
+}
+
+func TestCacheRevalidationWithLastModified(t *testing.T) {
+	lastModified := time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			return http.StatusNotModified, nil, ""
+		}
+		return http.StatusOK, http.Header{"Cache-Control": {"max-age=10"}, "Last-Modified": {lastModified}}, "body"
+	}}
+	_, clock, h := newTestCache(t, config.RouteConfig{}, up)
+
+	get(h, "/doc", nil)
+	clock.Advance(20 * time.Second)
+	rec := get(h, "/doc", nil)
+	assert.Equal(t, StatusRevalidated, rec.Header().Get(StatusHeader))
+	assert.Equal(t, "0", rec.Header().Get("Age"), "a revalidated response is fresh again")
+	assert.Equal(t, StatusHit, get(h, "/doc", nil).Header().Get(StatusHeader))
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	refreshed := make(chan struct{})
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		if call == 2 {
+			defer close(refreshed)
+		}
+		return http.StatusOK, cacheControl("max-age=10, stale-while-revalidate=30"), "v" + strconv.Itoa(call)
+	}}
+	c, clock, h := newTestCache(t, config.RouteConfig{}, up)
+
+	get(h, "/feed", nil)
+	clock.Advance(15 * time.Second)
+	rec := get(h, "/feed", nil)
+	assert.Equal(t, StatusStale, rec.Header().Get(StatusHeader))
+	assert.Equal(t, "v1", rec.Body.String(), "the stale response is served at once")
+
+	select {
+	case <-refreshed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the response was not refreshed in the background")
+	}
+	require.Eventually(t, func() bool { return get(h, "/feed", nil).Body.String() == "v2" }, time.Second, 10*time.Millisecond)
+
+	clock.Advance(60 * time.Second)
+	assert.Equal(t, StatusMiss, get(h, "/feed", nil).Header().Get(StatusHeader), "past the window the response is fetched again")
+
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.StaleHits)
+	assert.Equal(t, int64(2), stats.Misses, "background refreshes are not counted as misses")
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	var failing atomic.Bool
+	respond := func(cc string) func(r *http.Request, call int) (int, http.Header, string) {
+		return func(r *http.Request, call int) (int, http.Header, string) {
+			if failing.Load() {
+				return http.StatusBadGateway, nil, "Bad Gateway"
+			}
+			return http.StatusOK, cacheControl(cc), "good"
+		}
+	}
+
+	t.Run("route default", func(t *testing.T) {
+		failing.Store(false)
+		up := &upstream{respond: respond("max-age=10")}
+		_, clock, h := newTestCache(t, config.RouteConfig{Cache: &config.RouteCacheConfig{StaleIfErrorSeconds: 60}}, up)
+		get(h, "/", nil)
+		failing.Store(true)
+		clock.Advance(30 * time.Second)
+		rec := get(h, "/", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, StatusStale, rec.Header().Get(StatusHeader))
+		assert.Equal(t, "good", rec.Body.String())
+
+		clock.Advance(60 * time.Second)
+		assert.Equal(t, http.StatusBadGateway, get(h, "/", nil).Code, "past the window the error is returned")
+	})
+
+	t.Run("must-revalidate", func(t *testing.T) {
+		failing.Store(false)
+		up := &upstream{respond: respond("max-age=10, must-revalidate, stale-if-error=60")}
+		_, clock, h := newTestCache(t, config.RouteConfig{}, up)
+		get(h, "/", nil)
+		failing.Store(true)
+		clock.Advance(30 * time.Second)
+		assert.Equal(t, http.StatusBadGateway, get(h, "/", nil).Code)
+	})
+}
+
+func TestCacheRequestDirectives(t *testing.T) {
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		return http.StatusOK, cacheControl("max-age=60"), "v" + strconv.Itoa(call)
+	}}
+	_, clock, h := newTestCache(t, config.RouteConfig{}, up)
+
+	assert.Equal(t, http.StatusGatewayTimeout, get(h, "/", cacheControl("only-if-cached")).Code)
+	get(h, "/", nil)
+	clock.Advance(20 * time.Second)
+
+	assert.Equal(t, StatusHit, get(h, "/", cacheControl("max-age=30")).Header().Get(StatusHeader))
+	assert.Equal(t, StatusMiss, get(h, "/", cacheControl("max-age=10")).Header().Get(StatusHeader))
+	assert.Equal(t, StatusMiss, get(h, "/", cacheControl("no-cache")).Header().Get(StatusHeader))
+	assert.Equal(t, StatusMiss, get(h, "/", http.Header{"Pragma": {"no-cache"}}).Header().Get(StatusHeader))
+	assert.Equal(t, StatusBypass, get(h, "/", cacheControl("no-store")).Header().Get(StatusHeader))
+
+	clock.Advance(70 * time.Second)
+	rec := get(h, "/", cacheControl("max-stale=60"))
+	assert.Equal(t, StatusStale, rec.Header().Get(StatusHeader), "clients may accept stale responses")
+}
+
+func TestCacheUnsafeMethodsInvalidate(t *testing.T) {
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		return http.StatusOK, cacheControl("max-age=60"), "v" + strconv.Itoa(call)
+	}}
+	_, _, h := newTestCache(t, config.RouteConfig{}, up)
+
+	get(h, "/orders/1", nil)
+	assert.Equal(t, StatusHit, get(h, "/orders/1", nil).Header().Get(StatusHeader))
+	do(h, http.MethodPut, "/orders/1", nil)
+	rec := get(h, "/orders/1", nil)
+	assert.Equal(t, StatusMiss, rec.Header().Get(StatusHeader))
+	assert.Equal(t, "v3", rec.Body.String())
+}
+
+func TestCacheAuthenticatedResponses(t *testing.T) {
+	for cc, shared := range map[string]bool{"max-age=60": false, "public, max-age=60": true, "s-maxage=60": true} {
+		t.Run(cc, func(t *testing.T) {
+			up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+				return http.StatusOK, cacheControl(cc), "body"
+			}}
+			_, _, h := newTestCache(t, config.RouteConfig{Authentication: config.AuthenticationConfig{Enabled: true}}, up)
+			get(h, "/", nil)
+			assert.Equal(t, shared, get(h, "/", nil).Header().Get(StatusHeader) == StatusHit)
+
+			_, _, h = newTestCache(t, config.RouteConfig{ClientCert: &config.RouteClientCertConfig{}}, up)
+			get(h, "/", nil)
+			assert.Equal(t, shared, get(h, "/", nil).Header().Get(StatusHeader) == StatusHit, "client certificate route")
+
+			_, _, h = newTestCache(t, config.RouteConfig{}, up)
+			cookie := http.Header{"Cookie": {"session=ana"}}
+			get(h, "/", cookie)
+			assert.Equal(t, shared, get(h, "/", cookie).Header().Get(StatusHeader) == StatusHit, "request with cookies")
+		})
+	}
+}
+
+func TestCacheSkipsLargeResponses(t *testing.T) {
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		return http.StatusOK, cacheControl("max-age=60"), strings.Repeat("x", 2048)
+	}}
+	c, err := New(config.CacheConfig{MaxEntryKB: 1})
+	require.NoError(t, err)
+	h := c.Middleware(config.RouteConfig{Name: "big", Cache: &config.RouteCacheConfig{}})(up)
+
+	assert.Len(t, get(h, "/", nil).Body.String(), 2048)
+	assert.Equal(t, StatusMiss, get(h, "/", nil).Header().Get(StatusHeader))
+	assert.Equal(t, int64(0), c.Stats().Stores)
+}
+
+func TestCacheDiskTierSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		return http.StatusOK, http.Header{"Cache-Control": {"max-age=3600"}, "Content-Type": {"text/plain"}}, "from disk"
+	}}
+	route := config.RouteConfig{Name: "disk", Cache: &config.RouteCacheConfig{}}
+
+	first, err := New(config.CacheConfig{Disk: config.CacheDiskConfig{Path: dir}})
+	require.NoError(t, err)
+	get(first.Middleware(route)(up), "/file", nil)
+	require.NoError(t, first.Close())
+
+	second, err := New(config.CacheConfig{Disk: config.CacheDiskConfig{Path: dir}})
+	require.NoError(t, err)
+	defer second.Close()
+	assert.Equal(t, 1, second.Stats().Disk.Entries)
+	rec := get(second.Middleware(route)(up), "/file", nil)
+	assert.Equal(t, StatusHit, rec.Header().Get(StatusHeader))
+	assert.Equal(t, "from disk", rec.Body.String())
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Equal(t, int32(1), up.calls.Load())
+	assert.Equal(t, 1, second.Stats().Memory.Entries, "disk hits are promoted to memory")
+}
+
+func TestCacheCloseWhileStoring(t *testing.T) {
+	up := &upstream{respond: func(r *http.Request, call int) (int, http.Header, string) {
+		return http.StatusOK, http.Header{"Cache-Control": {"max-age=3600"}}, "ok"
+	}}
+	c, err := New(config.CacheConfig{Disk: config.CacheDiskConfig{Path: t.TempDir()}})
+	require.NoError(t, err)
+	h := c.Middleware(config.RouteConfig{Name: "disk", Cache: &config.RouteCacheConfig{}})(up)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			for j := range 50 {
+				get(h, fmt.Sprintf("/file/%d/%d", i, j), nil)
+			}
+		})
+	}
+	require.NoError(t, c.Close())
+	wg.Wait()
+	require.NoError(t, c.Close())
+
+	rec := get(h, "/after-close", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, StatusHit, get(h, "/after-close", nil).Header().Get(StatusHeader), "kept in memory")
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	entry := func(key string) *Entry { return &Entry{Key: key, Body: make([]byte, 100)} }
+	m := newMemoryStore(3 * entry("a").size())
+	m.set(entry("a"))
+	m.set(entry("b"))
+	m.set(entry("c"))
+	_, _ = m.get("a")
+	m.set(entry("d"))
+
+	_, ok := m.get("b")
+	assert.False(t, ok, "the least recently used entry is evicted")
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok := m.get(key)
+		assert.True(t, ok, key)
+	}
+	assert.Equal(t, int64(1), m.stats().Evictions)
+}
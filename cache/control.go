@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHeuristicLifetime caps the freshness lifetime guessed from Last-Modified.
+const maxHeuristicLifetime = 24 * time.Hour
+
+// directives are the Cache-Control directives of a request or a response. Names are
+// lowercased; directives without an argument have an empty value.
+type directives map[string]string
+
+// parseDirectives reads the Cache-Control header. A request without one that sends
+// "Pragma: no-cache" is treated as "Cache-Control: no-cache" (RFC 9111 §5.4).
+func parseDirectives(h http.Header) directives {
+	d := directives{}
+	for _, line := range h.Values("Cache-Control") {
+		for part := range strings.SplitSeq(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := d[name]; !ok {
+				d[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	if len(d) == 0 && strings.EqualFold(strings.TrimSpace(h.Get("Pragma")), "no-cache") {
+		d["no-cache"] = ""
+	}
+	return d
+}
+
+func (d directives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of a directive. An invalid argument counts as
+// zero, which makes the response stale (RFC 9111 §4.2.1).
+func (d directives) seconds(name string) (time.Duration, bool) {
+	value, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	return time.Duration(min(n, 1<<31)) * time.Second, true
+}
+
+// heuristicallyCacheable reports whether responses with the status may be stored without
+// an explicit expiration time (RFC 9110 §15.1).
+func heuristicallyCacheable(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// hasExplicitExpiration reports whether the response says how long it is fresh.
+func hasExplicitExpiration(h http.Header, cc directives) bool {
+	return cc.has("s-maxage") || cc.has("max-age") || h.Get("Expires") != ""
+}
+
+// varyNames returns the request headers listed in Vary, canonicalized and sorted.
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, line := range h.Values("Vary") {
+		for name := range strings.SplitSeq(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskFileSuffix is the extension of the files of the disk tier.
+const diskFileSuffix = ".cache"
+
+// diskStore keeps entries as files in a directory, one gob-encoded entry per file named
+// after the hash of its key. The index of the files is kept in memory and rebuilt from the
+// directory on start, so entries survive restarts.
+type diskStore struct {
+	dir string
+
+	mu        sync.Mutex
+	maxSize   int64
+	size      int64
+	evictions int64
+	items     map[string]*list.Element // by file name
+	lru       *list.List               // front is the most recently used
+}
+
+type diskItem struct {
+	name string
+	size int64
+}
+
+// newDiskStore opens the directory, creating it if needed, and indexes the files in it
+// from the most to the least recently written.
+func newDiskStore(dir string, maxSize int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	d := &diskStore{dir: dir, maxSize: maxSize, items: make(map[string]*list.Element), lru: list.New()}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, de := range dirEntries {
+		if de.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(de.Name(), ".tmp-") {
+			os.Remove(filepath.Join(dir, de.Name())) // left over by an interrupted write
+			continue
+		}
+		if !strings.HasSuffix(de.Name(), diskFileSuffix) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{de.Name(), info.Size(), info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	for _, f := range files {
+		d.items[f.name] = d.lru.PushFront(&diskItem{name: f.name, size: f.size})
+		d.size += f.size
+	}
+	d.mu.Lock()
+	d.evictLocked()
+	d.mu.Unlock()
+	return d, nil
+}
+
+func diskFileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskFileSuffix
+}
+
+func (d *diskStore) get(key string) (*Entry, bool) {
+	name := diskFileName(key)
+	d.mu.Lock()
+	el, ok := d.items[name]
+	if ok {
+		d.lru.MoveToFront(el)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(d.dir, name))
+	if err != nil {
+		d.delete(key)
+		return nil, false
+	}
+	var e Entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil || e.Key != key {
+		log.Printf("Cache: dropping unreadable disk entry %s: %v", name, err)
+		d.delete(key)
+		return nil, false
+	}
+	return &e, true
+}
+
+// set writes the entry to a temporary file and renames it, so readers never see a
+// partial entry.
+func (d *diskStore) set(e *Entry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+	size := int64(buf.Len())
+	if size > d.maxSize {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	name := diskFileName(e.Key)
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[name]; ok {
+		d.size -= el.Value.(*diskItem).size
+		d.lru.Remove(el)
+	}
+	d.items[name] = d.lru.PushFront(&diskItem{name: name, size: size})
+	d.size += size
+	d.evictLocked()
+	return nil
+}
+
+func (d *diskStore) delete(key string) {
+	name := diskFileName(key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[name]; ok {
+		d.removeLocked(el)
+	}
+}
+
+func (d *diskStore) evictLocked() {
+	for d.size > d.maxSize {
+		d.removeLocked(d.lru.Back())
+		d.evictions++
+	}
+}
+
+func (d *diskStore) removeLocked(el *list.Element) {
+	item := el.Value.(*diskItem)
+	d.size -= item.size
+	d.lru.Remove(el)
+	delete(d.items, item.name)
+	os.Remove(filepath.Join(d.dir, item.name))
+}
+
+func (d *diskStore) stats() TierStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return TierStats{Entries: len(d.items), SizeBytes: d.size, MaxSizeBytes: d.maxSize, Evictions: d.evictions}
+}
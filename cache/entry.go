@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a stored response. Entries are never changed once stored; a revalidated
+// response is stored as a new entry.
+type Entry struct {
+	Key          string
+	Status       int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time // when the request that produced the response was sent
+	ResponseTime time.Time // when the response headers were received
+
+	// Vary is only set on the marker stored under the primary key of a response with a
+	// Vary header: the request headers that select the variant.
+	Vary []string
+}
+
+// size estimates the memory used by the entry.
+func (e *Entry) size() int64 {
+	n := int64(len(e.Key) + len(e.Body) + 128)
+	for name, values := range e.Header {
+		n += int64(len(name))
+		for _, v := range values {
+			n += int64(len(v) + 16)
+		}
+	}
+	for _, name := range e.Vary {
+		n += int64(len(name))
+	}
+	return n
+}
+
+// date returns the Date of the response, or the time it was received.
+func (e *Entry) date() time.Time {
+	if t, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return t
+	}
+	return e.ResponseTime
+}
+
+// age returns the current age of the response (RFC 9111 §4.2.3).
+func (e *Entry) age(now time.Time) time.Duration {
+	apparentAge := max(0, e.ResponseTime.Sub(e.date()))
+	var ageValue time.Duration
+	if n, err := strconv.ParseInt(strings.TrimSpace(e.Header.Get("Age")), 10, 64); err == nil && n > 0 {
+		ageValue = time.Duration(n) * time.Second
+	}
+	correctedAgeValue := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	return max(apparentAge, correctedAgeValue) + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime returns how long the response is fresh in a shared cache
+// (RFC 9111 §4.2.1), guessing 10% of its age since Last-Modified when it has no explicit
+// expiration time.
+func (e *Entry) freshnessLifetime(cc directives) time.Duration {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0 // invalid dates mean "already expired"
+		}
+		return max(0, t.Sub(e.date()))
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicallyCacheable(e.Status) {
+		return min(max(0, e.date().Sub(lastModified)/10), maxHeuristicLifetime)
+	}
+	return 0
+}
+
+// hasValidator reports whether the response can be revalidated with a conditional request.
+func (e *Entry) hasValidator() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// refreshed returns a copy of the entry updated with the headers of a 304 response to a
+// revalidation (RFC 9111 §4.3.4).
+func (e *Entry) refreshed(header http.Header, requestTime, responseTime time.Time) *Entry {
+	updated := *e
+	updated.Header = e.Header.Clone()
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding":
+			continue // describe the empty 304 body, not the stored one
+		}
+		updated.Header[name] = values
+	}
+	updated.RequestTime = requestTime
+	updated.ResponseTime = responseTime
+	return &updated
+}
+
+// matchesConditional reports whether a client request with If-None-Match or
+// If-Modified-Since can be answered with 304 Not Modified (RFC 9110 §13.1).
+func (e *Entry) matchesConditional(r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.Header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for candidate := range strings.SplitSeq(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		lastModified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+		return err == nil && !lastModified.After(since)
+	}
+	return false
+}
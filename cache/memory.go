@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// TierStats describes the content of one tier of the cache.
+type TierStats struct {
+	Entries      int
+	SizeBytes    int64
+	MaxSizeBytes int64
+	Evictions    int64
+}
+
+// memoryStore keeps entries in memory up to a total size, evicting the least recently
+// used entries first.
+type memoryStore struct {
+	mu        sync.Mutex
+	maxSize   int64
+	size      int64
+	evictions int64
+	items     map[string]*list.Element
+	lru       *list.List // front is the most recently used
+}
+
+type memoryItem struct {
+	entry *Entry
+	size  int64
+}
+
+func newMemoryStore(maxSize int64) *memoryStore {
+	return &memoryStore{maxSize: maxSize, items: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (m *memoryStore) get(key string) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	m.lru.MoveToFront(el)
+	return el.Value.(*memoryItem).entry, true
+}
+
+// set stores the entry under its key, replacing any previous one. Entries larger than the
+// whole store are dropped.
+func (m *memoryStore) set(e *Entry) {
+	size := e.size()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeLocked(e.Key)
+	if size > m.maxSize {
+		return
+	}
+	m.items[e.Key] = m.lru.PushFront(&memoryItem{entry: e, size: size})
+	m.size += size
+	for m.size > m.maxSize {
+		oldest := m.lru.Back()
+		m.removeLocked(oldest.Value.(*memoryItem).entry.Key)
+		m.evictions++
+	}
+}
+
+func (m *memoryStore) delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeLocked(key)
+}
+
+func (m *memoryStore) removeLocked(key string) {
+	if el, ok := m.items[key]; ok {
+		m.size -= el.Value.(*memoryItem).size
+		m.lru.Remove(el)
+		delete(m.items, key)
+	}
+}
+
+func (m *memoryStore) stats() TierStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return TierStats{Entries: len(m.items), SizeBytes: m.size, MaxSizeBytes: m.maxSize, Evictions: m.evictions}
+}
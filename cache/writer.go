@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"bufio"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// captureWriter sends the upstream response to the client while keeping a copy of its
+// body to store. Whether the response reaches the client is decided when its headers are
+// written, so a 304 to a revalidation or an upstream error can be replaced with the stored
+// response.
+type captureWriter struct {
+	http.ResponseWriter
+	header http.Header
+	now    func() time.Time
+	limit  int64
+	decide func(status int, header http.Header, now time.Time) (swallow, store bool)
+
+	requestTime  time.Time
+	responseTime time.Time
+	status       int
+	swallowed    bool
+	store        bool
+	overflow     bool // the body is larger than the limit
+	body         []byte
+}
+
+func (cw *captureWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.status != 0 {
+		return
+	}
+	dst := cw.ResponseWriter.Header()
+	if code < http.StatusOK {
+		// Informational responses go straight to the client, without the headers of the
+		// final response
+		saved := dst.Clone()
+		maps.Copy(dst, cw.header)
+		cw.ResponseWriter.WriteHeader(code)
+		clear(dst)
+		maps.Copy(dst, saved)
+		return
+	}
+
+	cw.status = code
+	cw.responseTime = cw.now()
+	cw.swallowed, cw.store = cw.decide(code, cw.header, cw.responseTime)
+	if cw.swallowed {
+		return
+	}
+	for name, values := range cw.header {
+		dst[name] = append(dst[name], values...)
+	}
+	dst.Set(StatusHeader, StatusMiss)
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.store && !cw.overflow {
+		if int64(len(cw.body)+len(p)) > cw.limit {
+			cw.overflow = true
+			cw.body = nil
+		} else {
+			cw.body = append(cw.body, p...)
+		}
+	}
+	if cw.swallowed {
+		return len(p), nil
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client, for streamed responses
+func (cw *captureWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.swallowed {
+		http.NewResponseController(cw.ResponseWriter).Flush()
+	}
+}
+
+// Hijack hands the connection over for upgraded requests
+func (cw *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// complete reports whether the whole body announced by Content-Length was received.
+func (cw *captureWriter) complete() bool {
+	cl := cw.header.Get("Content-Length")
+	if cl == "" {
+		return true
+	}
+	n, err := strconv.Atoi(cl)
+	return err == nil && n == len(cw.body)
+}
+
+// discardWriter receives the responses of background revalidations.
+type discardWriter struct {
+	header http.Header
+}
+
+func (d discardWriter) Header() http.Header         { return d.header }
+func (d discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d discardWriter) WriteHeader(int)             {}
+
+// serveEntry writes a stored response, or 304 Not Modified when the validators of the
+// client match it.
+func serveEntry(w http.ResponseWriter, r *http.Request, e *Entry, now time.Time, status string) {
+	h := w.Header()
+	for name, values := range e.Header {
+		h[name] = append(h[name], values...)
+	}
+	h.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	h.Set(StatusHeader, status)
+	if e.Status == http.StatusOK && e.matchesConditional(r) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if e.Status != http.StatusNoContent {
+		h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	}
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		w.Write(e.Body)
+	}
+}
@@ -0,0 +1,243 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// checkInterval is how often the certificates of all hostnames are checked, and obtained
+// when missing or expired
+var checkInterval = time.Hour
+
+// Status values of an ACME certificate.
+const (
+	StatusPending  = "pending"  // not obtained yet
+	StatusValid    = "valid"    // obtained, not due for renewal
+	StatusRenewing = "renewing" // due for renewal, renewed in the background
+	StatusExpired  = "expired"  // expired and not renewed
+	StatusFailed   = "failed"   // never obtained, see the last error
+)
+
+// CertificateStatus reports the ACME certificate of a hostname.
+type CertificateStatus struct {
+	Host      string
+	Status    string
+	Issuer    string    // common name of the issuing CA, empty when pending
+	NotBefore time.Time // zero when pending
+	NotAfter  time.Time // expiry, zero when pending
+	LastCheck time.Time // last time the certificate was requested, zero before the first check
+	LastError string    // error of the last request, empty when it succeeded
+}
+
+// Manager obtains the certificates of the configured hostnames from an ACME certificate
+// authority and renews them before they expire. The domains are validated with tls-alpn-01
+// through GetCertificate, and with http-01 through HTTPHandler when it is used.
+type Manager struct {
+	autocert    *autocert.Manager
+	hosts       []string
+	renewBefore time.Duration
+	mu          sync.Mutex
+	certs       map[string]*hostCertificate // by hostname
+	ctx         context.Context
+	cancel      context.CancelFunc
+	startOnce   sync.Once
+}
+
+// hostCertificate is the last known certificate of a hostname
+type hostCertificate struct {
+	leaf      *x509.Certificate
+	lastCheck time.Time
+	lastError string
+}
+
+// NewManager creates the ACME manager of the configuration. The certificates and account
+// key are stored in the cache folder when configured, otherwise in the repository.
+func NewManager(acmeConfig *config.ACMEConfig, repo db.CertificateCacheRepository) (*Manager, error) {
+	var cache autocert.Cache
+	switch {
+	case acmeConfig.CacheDir != "":
+		cache = autocert.DirCache(acmeConfig.CacheDir)
+	case repo != nil:
+		cache = &dbCache{repo: repo}
+	default:
+		return nil, fmt.Errorf("acme requires a cacheDir or a database to store the certificates")
+	}
+
+	client := &acme.Client{DirectoryURL: acmeConfig.Directory()}
+	if acmeConfig.CAFile != "" {
+		pem, err := os.ReadFile(acmeConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ACME CA file '%s' has no PEM certificates", acmeConfig.CAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		autocert: &autocert.Manager{
+			Prompt:      autocert.AcceptTOS,
+			Cache:       cache,
+			HostPolicy:  autocert.HostWhitelist(acmeConfig.Hosts...),
+			RenewBefore: acmeConfig.RenewBefore(),
+			Client:      client,
+			Email:       acmeConfig.Email,
+		},
+		hosts:       acmeConfig.Hosts,
+		renewBefore: acmeConfig.RenewBefore(),
+		certs:       make(map[string]*hostCertificate, len(acmeConfig.Hosts)),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	for _, host := range acmeConfig.Hosts {
+		m.certs[host] = &hostCertificate{}
+	}
+	return m, nil
+}
+
+// Manages reports whether the certificate of the hostname comes from ACME
+func (m *Manager) Manages(serverName string) bool {
+	return slices.Contains(m.hosts, normalizeHost(serverName))
+}
+
+// IsChallenge reports whether the handshake validates a domain with tls-alpn-01
+func IsChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// GetCertificate returns the certificate of the handshake: the tls-alpn-01 challenge
+// certificate, or the certificate of the hostname, obtained first when there is none.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := m.autocert.GetCertificate(hello)
+	if !IsChallenge(hello) {
+		m.record(hello.ServerName, cert, err)
+	}
+	return cert, err
+}
+
+// HTTPHandler answers the http-01 challenges and passes the other requests to fallback
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.autocert.HTTPHandler(fallback)
+}
+
+// record keeps the certificate served for the hostname, or the error to obtain it
+func (m *Manager) record(serverName string, cert *tls.Certificate, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	host, ok := m.certs[normalizeHost(serverName)]
+	if !ok {
+		return
+	}
+	host.lastCheck = time.Now()
+	host.lastError = ""
+	if err != nil {
+		host.lastError = err.Error()
+	} else if cert != nil && cert.Leaf != nil {
+		host.leaf = cert.Leaf
+	}
+}
+
+// Start obtains the missing certificates in the background, and checks them again every
+// interval so the expired ones are replaced. The server must be listening, as the
+// certificate authority connects to it to validate the domains.
+func (m *Manager) Start() {
+	m.startOnce.Do(func() {
+		go m.run()
+	})
+}
+
+func (m *Manager) run() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		for _, host := range m.hosts {
+			if m.ctx.Err() != nil {
+				return
+			}
+			m.check(host)
+		}
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check requests the ECDSA certificate of the hostname, the one modern clients get
+func (m *Manager) check(host string) {
+	_, err := m.GetCertificate(&tls.ClientHelloInfo{
+		ServerName:       host,
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	})
+	if err != nil {
+		log.Printf("ACME: failed to obtain the certificate of '%s': %v", host, err)
+	}
+}
+
+// Close stops the background checks
+func (m *Manager) Close() {
+	m.cancel()
+}
+
+// Statuses returns the certificate status of every hostname, in configuration order
+func (m *Manager) Statuses() []CertificateStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	statuses := make([]CertificateStatus, 0, len(m.hosts))
+	for _, name := range m.hosts {
+		host := m.certs[name]
+		status := CertificateStatus{
+			Host:      name,
+			LastCheck: host.lastCheck,
+			LastError: host.lastError,
+		}
+		switch leaf := host.leaf; {
+		case leaf == nil && host.lastError != "":
+			status.Status = StatusFailed
+		case leaf == nil:
+			status.Status = StatusPending
+		default:
+			status.Issuer = leaf.Issuer.CommonName
+			status.NotBefore = leaf.NotBefore
+			status.NotAfter = leaf.NotAfter
+			switch {
+			case now.After(leaf.NotAfter):
+				status.Status = StatusExpired
+			case leaf.NotAfter.Sub(now) < m.renewBefore:
+				status.Status = StatusRenewing
+			default:
+				status.Status = StatusValid
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// normalizeHost lowers the case of the SNI hostname and removes its trailing dot
+func normalizeHost(serverName string) string {
+	return strings.ToLower(strings.TrimSuffix(serverName, "."))
+}
@@ -0,0 +1,214 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// cachedCertificate encodes a certificate of the host the way autocert caches it: the PEM
+// private key followed by the PEM certificate chain
+func cachedCertificate(t *testing.T, host string, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		Issuer:       pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	var data bytes.Buffer
+	require.NoError(t, pem.Encode(&data, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	require.NoError(t, pem.Encode(&data, &pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return data.Bytes()
+}
+
+// unavailableDirectory is an ACME directory URL that answers 404; server errors would be
+// retried by the ACME client
+func unavailableDirectory(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	return server.URL + "/directory"
+}
+
+func newTestRepository(t *testing.T) db.CertificateCacheRepository {
+	t.Helper()
+	db.SetupTestDB(fmt.Sprintf("%s_%d", t.Name(), time.Now().UnixNano()))
+	return db.NewCertificateCacheRepositoryDB(db.GetConnection())
+}
+
+func TestManagerStatuses(t *testing.T) {
+	repo := newTestRepository(t)
+	require.NoError(t, repo.PutCertificateData("api.example.test", cachedCertificate(t, "api.example.test", time.Now().Add(60*24*time.Hour))))
+	require.NoError(t, repo.PutCertificateData("www.example.test", cachedCertificate(t, "www.example.test", time.Now().Add(10*24*time.Hour))))
+
+	m, err := NewManager(&config.ACMEConfig{
+		AcceptTOS:    true,
+		DirectoryURL: unavailableDirectory(t),
+		Hosts:        []string{"api.example.test", "www.example.test", "new.example.test"},
+	}, repo)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for _, status := range m.Statuses() {
+		assert.Equal(t, StatusPending, status.Status, status.Host)
+	}
+
+	m.check("api.example.test")
+	m.check("www.example.test")
+	m.check("new.example.test")
+	statuses := m.Statuses()
+	require.Len(t, statuses, 3)
+
+	assert.Equal(t, "api.example.test", statuses[0].Host)
+	assert.Equal(t, StatusValid, statuses[0].Status, "served from the database")
+	assert.Equal(t, "api.example.test", statuses[0].Issuer)
+	assert.WithinDuration(t, time.Now().Add(60*24*time.Hour), statuses[0].NotAfter, time.Minute)
+	assert.False(t, statuses[0].LastCheck.IsZero())
+	assert.Empty(t, statuses[0].LastError)
+
+	assert.Equal(t, StatusRenewing, statuses[1].Status, "within the 30 days before expiry")
+
+	assert.Equal(t, "new.example.test", statuses[2].Host)
+	assert.Equal(t, StatusFailed, statuses[2].Status, "the directory is unavailable")
+	assert.NotEmpty(t, statuses[2].LastError)
+	assert.True(t, statuses[2].NotAfter.IsZero())
+
+	// An expired certificate that was not renewed
+	expired := &x509.Certificate{NotBefore: time.Now().Add(-48 * time.Hour), NotAfter: time.Now().Add(-time.Hour)}
+	m.record("API.example.test.", &tls.Certificate{Leaf: expired}, nil)
+	assert.Equal(t, StatusExpired, m.Statuses()[0].Status)
+
+	// A failed renewal keeps the certificate
+	m.record("www.example.test", nil, errors.New("rate limited"))
+	assert.Equal(t, StatusRenewing, m.Statuses()[1].Status)
+	assert.Equal(t, "rate limited", m.Statuses()[1].LastError)
+}
+
+func TestManagerHosts(t *testing.T) {
+	m, err := NewManager(&config.ACMEConfig{AcceptTOS: true, Hosts: []string{"api.example.test"}, CacheDir: t.TempDir()}, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	assert.True(t, m.Manages("API.example.test."))
+	assert.False(t, m.Manages("www.example.test"))
+	assert.True(t, IsChallenge(&tls.ClientHelloInfo{SupportedProtos: []string{acme.ALPNProto}}))
+	assert.False(t, IsChallenge(&tls.ClientHelloInfo{SupportedProtos: []string{"h2", acme.ALPNProto}}))
+
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.test"})
+	assert.Error(t, err, "only the configured hosts get certificates")
+
+	// http-01 challenges are answered, the other requests go to the fallback
+	handler := m.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api.example.test/.well-known/acme-challenge/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api.example.test/orders", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+
+	_, err = NewManager(&config.ACMEConfig{AcceptTOS: true, Hosts: []string{"api.example.test"}}, nil)
+	assert.Error(t, err, "no storage")
+	_, err = NewManager(&config.ACMEConfig{AcceptTOS: true, Hosts: []string{"api.example.test"}, CacheDir: t.TempDir(), CAFile: "missing.pem"}, nil)
+	assert.Error(t, err)
+}
+
+func TestDBCache(t *testing.T) {
+	cache := &dbCache{repo: newTestRepository(t)}
+	ctx := t.Context()
+
+	_, err := cache.Get(ctx, "acme_account+key")
+	assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+
+	require.NoError(t, cache.Put(ctx, "acme_account+key", []byte("key")))
+	data, err := cache.Get(ctx, "acme_account+key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key"), data)
+
+	require.NoError(t, cache.Delete(ctx, "acme_account+key"))
+	_, err = cache.Get(ctx, "acme_account+key")
+	assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+}
+
+// TestPebble obtains a certificate from a local Pebble server. It runs when
+// ACME_TEST_DIRECTORY is set, for instance:
+//
+//	pebble -config test/config/pebble-config.json &
+//	ACME_TEST_DIRECTORY=https://localhost:14000/dir ACME_TEST_CA_FILE=test/certs/pebble.minica.pem \
+//	ACME_TEST_HOST=gateway.localhost go test ./certs -run TestPebble
+//
+// Pebble validates the domain on the httpPort (5002) and tlsPort (5001) of its configuration,
+// so ACME_TEST_HOST must resolve to this machine.
+func TestPebble(t *testing.T) {
+	directory := os.Getenv("ACME_TEST_DIRECTORY")
+	if directory == "" {
+		t.Skip("ACME_TEST_DIRECTORY is not set")
+	}
+	host := os.Getenv("ACME_TEST_HOST")
+	if host == "" {
+		host = "gateway.localhost"
+	}
+
+	m, err := NewManager(&config.ACMEConfig{
+		AcceptTOS:    true,
+		Email:        "admin@example.com",
+		DirectoryURL: directory,
+		CAFile:       os.Getenv("ACME_TEST_CA_FILE"),
+		Hosts:        []string{host},
+	}, newTestRepository(t))
+	require.NoError(t, err)
+	defer m.Close()
+
+	tlsListener, err := tls.Listen("tcp", ":5001", &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+	})
+	require.NoError(t, err)
+	defer tlsListener.Close()
+	go http.Serve(tlsListener, http.NotFoundHandler())
+
+	httpListener, err := net.Listen("tcp", ":5002")
+	require.NoError(t, err)
+	defer httpListener.Close()
+	go http.Serve(httpListener, m.HTTPHandler(nil))
+
+	m.check(host)
+	status := m.Statuses()[0]
+	require.Equal(t, StatusValid, status.Status, status.LastError)
+	assert.True(t, status.NotAfter.After(time.Now()))
+	assert.NotEmpty(t, status.Issuer)
+}
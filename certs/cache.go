@@ -0,0 +1,35 @@
+package certs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jmaister/taronja-gateway/db"
+	"golang.org/x/crypto/acme/autocert"
+	"gorm.io/gorm"
+)
+
+// dbCache stores the certificates and account key of the ACME client in the database, so
+// they survive restarts and are shared by the gateways that use the same database.
+type dbCache struct {
+	repo db.CertificateCacheRepository
+}
+
+// Get returns the data of the key, autocert.ErrCacheMiss when there is none
+func (c *dbCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.repo.GetCertificateData(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put stores the data of the key
+func (c *dbCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.repo.PutCertificateData(key, data)
+}
+
+// Delete removes the data of the key
+func (c *dbCache) Delete(ctx context.Context, key string) error {
+	return c.repo.DeleteCertificateData(key)
+}
@@ -4,8 +4,14 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,9 +24,38 @@ import (
 // ServerConfig defines the gateway server's network configuration.
 // All fields are required.
 type ServerConfig struct {
-	Host string `yaml:"host"` // Server bind address (e.g., "127.0.0.1" for localhost only, "0.0.0.0" for all interfaces)
-	Port int    `yaml:"port"` // Server port number (e.g., 8080). Required.
-	URL  string `yaml:"url"`  // Full external URL for OAuth redirects (e.g., "https://example.com" or "http://localhost:8080")
+	Host            string               `yaml:"host"`            // Server bind address (e.g., "127.0.0.1" for localhost only, "0.0.0.0" for all interfaces)
+	Port            int                  `yaml:"port"`            // Server port number (e.g., 8080). Required.
+	URL             string               `yaml:"url"`             // Full external URL for OAuth redirects (e.g., "https://example.com" or "http://localhost:8080")
+	Timeouts        ServerTimeoutsConfig `yaml:"timeouts"`        // HTTP server timeouts. Optional.
+	Shutdown        ServerShutdownConfig `yaml:"shutdown"`        // Connection draining on SIGINT/SIGTERM. Optional.
+	TLS             *TLSConfig           `yaml:"tls"`             // HTTPS with SNI certificates and client certificates. Optional; plain HTTP when not set.
+	TrustedProxies  []string             `yaml:"trustedProxies"`  // Addresses or CIDRs of the proxies in front of the gateway (e.g., "10.0.0.0/8"); only their forwarding headers are read. Default: none, the peer is the client
+	ForwardedHeader string               `yaml:"forwardedHeader"` // Header with the client address that the trusted proxies set: "X-Forwarded-For", "Forwarded", "X-Real-IP" or "X-Client-IP"; the others are ignored. Default: "X-Forwarded-For"
+	ProxyProtocol   *ProxyProtocolConfig `yaml:"proxyProtocol"`   // PROXY protocol headers of TCP load balancers. Optional.
+}
+
+// ProxyProtocolConfig reads the PROXY protocol header (v1 or v2) that TCP load balancers such as
+// HAProxy or AWS NLB send at the start of each connection, with the address of the client.
+type ProxyProtocolConfig struct {
+	AllowedSources []string `yaml:"allowedSources"` // Addresses or CIDRs of the load balancers; the connections of other peers are read as they are. Required.
+	Required       bool     `yaml:"required"`       // Close the connections of the allowed sources that send no header. Default: false, the header is optional
+}
+
+// ServerTimeoutsConfig sets the timeouts of the HTTP server for every route.
+// Routes with a request timeout replace the read and write timeouts for their own requests.
+type ServerTimeoutsConfig struct {
+	ReadHeaderSeconds int `yaml:"readHeaderSeconds"` // Time to read the request headers. Default: 10
+	ReadSeconds       int `yaml:"readSeconds"`       // Time to read the whole request, body included. Default: 15, -1 = no limit
+	WriteSeconds      int `yaml:"writeSeconds"`      // Time to write the response. Default: 15, -1 = no limit
+	IdleSeconds       int `yaml:"idleSeconds"`       // Time an idle keep-alive connection stays open. Default: 120, -1 = no limit
+}
+
+// ServerShutdownConfig controls how the gateway stops. The health check reports "draining"
+// first, then the listener closes and the requests in flight get the drain timeout to finish.
+type ServerShutdownConfig struct {
+	DelaySeconds int `yaml:"delaySeconds"` // Time the health check reports draining before the listener closes, for load balancers to notice. Default: 0
+	DrainSeconds int `yaml:"drainSeconds"` // Time the requests in flight, WebSockets included, have to finish before they are cut off. Default: 30
 }
 
 // AuthenticationConfig controls whether authentication is required for a specific route.
@@ -30,22 +65,225 @@ type AuthenticationConfig struct {
 
 // RouteOptions contains additional optional configuration for individual routes.
 type RouteOptions struct {
-	CacheControlSeconds *int `yaml:"cacheControlSeconds,omitempty"` // Cache control in seconds. Optional. nil = no cache header, 0 = "no-cache", >0 = "max-age=N"
+	CacheControlSeconds *int  `yaml:"cacheControlSeconds,omitempty"` // Cache control in seconds. Optional. nil = no cache header, 0 = "no-cache", >0 = "max-age=N"
+	ImmutableAssets     *bool `yaml:"immutableAssets,omitempty"`     // Fingerprinted static files (e.g. app.3f9a1c.js) are cached for a year as immutable. Default: true
+	DirectoryListing    *bool `yaml:"directoryListing,omitempty"`    // List the files of static folders without index.html. Default: true
+}
+
+// Load-balancing strategies supported by LoadBalancingConfig.Strategy.
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyWeighted         = "weighted"
+	StrategyLeastConnections = "least-connections"
+	StrategyRandomTwoChoices = "random-two-choices"
+	StrategyConsistentHash   = "consistent-hash"
+)
+
+// UpstreamTarget defines one backend server of a load-balanced proxy route.
+type UpstreamTarget struct {
+	URL    string `yaml:"url"`    // Backend base URL (e.g., "http://10.0.0.1:8080"). Required.
+	Weight int    `yaml:"weight"` // Relative weight used by the "weighted" and "consistent-hash" strategies. Default: 1
+}
+
+// LoadBalancingConfig selects how requests are spread over the targets of a proxy route.
+type LoadBalancingConfig struct {
+	Strategy string `yaml:"strategy"` // "round-robin", "weighted", "least-connections", "random-two-choices" or "consistent-hash". Default: "round-robin"
+	HashOn   string `yaml:"hashOn"`   // Key used by "consistent-hash": "ip", "header" or "cookie". Default: "ip"
+	HashKey  string `yaml:"hashKey"`  // Header or cookie name when hashOn is "header" or "cookie".
+}
+
+// HealthCheckConfig defines how the targets of a proxy route are checked.
+// Active checks probe every target periodically; passive checks watch live traffic.
+type HealthCheckConfig struct {
+	Path               string                   `yaml:"path"`               // Probe path requested on every target (e.g., "/healthz"). Empty disables active checks.
+	IntervalSeconds    int                      `yaml:"intervalSeconds"`    // Seconds between probes. Default: 10
+	TimeoutSeconds     int                      `yaml:"timeoutSeconds"`     // Seconds to wait for a probe response. Default: 2
+	ExpectedStatus     int                      `yaml:"expectedStatus"`     // Status code of a healthy probe response. Default: any 2xx
+	HealthyThreshold   int                      `yaml:"healthyThreshold"`   // Consecutive successful probes to mark a target healthy. Default: 2
+	UnhealthyThreshold int                      `yaml:"unhealthyThreshold"` // Consecutive failed probes to mark a target unhealthy. Default: 3
+	Passive            PassiveHealthCheckConfig `yaml:"passive"`            // Passive checks on proxied traffic. Optional.
+}
+
+// PassiveHealthCheckConfig marks targets unhealthy from the responses of proxied requests.
+type PassiveHealthCheckConfig struct {
+	MaxFailures     int `yaml:"maxFailures"`     // Consecutive 5xx responses or connection errors before a target is marked unhealthy. 0 = disabled.
+	CooldownSeconds int `yaml:"cooldownSeconds"` // Seconds a passively failed target stays out of rotation when no probe path is set. Default: 30
+}
+
+// CircuitBreakerConfig stops forwarding requests to the upstream of a proxy route while it is failing.
+// A 5xx response or a connection error counts as a failure. At least one trip condition must be set.
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int                    `yaml:"consecutiveFailures"` // Open after N consecutive failures. 0 = disabled.
+	FailureRatio        float64                `yaml:"failureRatio"`        // Open when the failure ratio in the window reaches this value (0 to 1). 0 = disabled.
+	MinRequests         int                    `yaml:"minRequests"`         // Requests needed in the window before failureRatio applies. Default: 20
+	WindowSeconds       int                    `yaml:"windowSeconds"`       // Window used to compute failureRatio. Default: 60
+	OpenSeconds         int                    `yaml:"openSeconds"`         // Seconds the breaker stays open before letting probe requests through. Default: 30
+	HalfOpenRequests    int                    `yaml:"halfOpenRequests"`    // Probe requests allowed while half-open; all must succeed to close. Default: 1
+	Fallback            CircuitBreakerFallback `yaml:"fallback"`            // Response served while the breaker is open. Optional.
+}
+
+// CircuitBreakerFallback defines what is served instead of the upstream while a breaker is open.
+type CircuitBreakerFallback struct {
+	Route       string `yaml:"route"`       // Name of another route that serves the request instead. Optional.
+	StatusCode  int    `yaml:"statusCode"`  // Status code of the fallback response. Default: 503
+	Body        string `yaml:"body"`        // Body of the fallback response. Default: "Service Unavailable"
+	ContentType string `yaml:"contentType"` // Content type of the fallback body. Default: "text/plain; charset=utf-8"
+}
+
+// Retry error kinds that can trigger a retry.
+const (
+	RetryOnConnectFailure = "connect-failure" // the connection to the target could not be established
+	RetryOnReset          = "reset"           // the target closed or reset the connection before responding
+	RetryOnTimeout        = "timeout"         // the attempt exceeded the per-try timeout
+)
+
+// RetryConfig retries failed upstream calls of a proxy route. Only requests with a retryable
+// method are retried, and each retry goes to a different target when the route has several.
+type RetryConfig struct {
+	Attempts        int      `yaml:"attempts"`        // Total attempts, including the first one. Default: 3
+	Methods         []string `yaml:"methods"`         // Methods that may be retried. Default: GET, HEAD, OPTIONS, PUT, DELETE
+	StatusCodes     []int    `yaml:"statusCodes"`     // Upstream status codes that trigger a retry. Default: 502, 503, 504
+	Errors          []string `yaml:"errors"`          // Error kinds that trigger a retry: connect-failure, reset, timeout. Default: all
+	BackoffMs       int      `yaml:"backoffMs"`       // Base delay before the first retry, doubled on every retry, with jitter. Default: 50
+	MaxBackoffMs    int      `yaml:"maxBackoffMs"`    // Upper bound of the delay between retries. Default: 1000
+	PerTryTimeoutMs int      `yaml:"perTryTimeoutMs"` // Time an attempt may wait for the response headers. 0 = no limit.
+	MaxBodyBytes    int64    `yaml:"maxBodyBytes"`    // Largest request body buffered for retries; larger requests are sent once. Default: 1 MiB
+}
+
+// RouteCacheConfig stores the responses of a proxy route in the shared cache. What is stored
+// and for how long follows the Cache-Control, Expires and Vary headers of the upstream
+// (RFC 9111); these settings only apply when the response does not say otherwise.
+type RouteCacheConfig struct {
+	StaleWhileRevalidateSeconds int `yaml:"staleWhileRevalidateSeconds"` // Serve a stale response while it is refreshed in the background, unless the response has its own stale-while-revalidate. Default: 0
+	StaleIfErrorSeconds         int `yaml:"staleIfErrorSeconds"`         // Serve a stale response when the upstream fails, unless the response has its own stale-if-error. Default: 0
+}
+
+// CollapseConfig shares one upstream request between concurrent identical requests of a
+// proxy route, so a burst of requests for the same uncached URL reaches the upstream once.
+type CollapseConfig struct {
+	Headers   []string `yaml:"headers"`   // Request headers that must also match, e.g. Accept-Language. Default: none
+	PerUser   bool     `yaml:"perUser"`   // Only share responses between requests of the same user. Default: false, true on routes with authentication
+	MaxWaitMs int      `yaml:"maxWaitMs"` // Longest wait for the shared response before a request is sent on its own. Default: 5000
+	MaxBodyKB int      `yaml:"maxBodyKB"` // Largest response body copied to the waiting requests. Default: 1024
+}
+
+// RouteCompressionConfig overrides the global compression settings for one route. Any
+// setting left out is taken from the global block.
+type RouteCompressionConfig struct {
+	Enabled      *bool    `yaml:"enabled"`      // Compress the responses of this route. Default: true when the block is present
+	Algorithms   []string `yaml:"algorithms"`   // Encodings offered, in order of preference. Optional.
+	MinSizeBytes int      `yaml:"minSizeBytes"` // Smaller responses are sent uncompressed. Optional.
+	ContentTypes []string `yaml:"contentTypes"` // Media types that are compressed. Optional.
+}
+
+// RouteTimeoutsConfig bounds the time spent on the requests of a route, so routes with
+// generous limits can share the listener with strict ones.
+type RouteTimeoutsConfig struct {
+	DialMs            int `yaml:"dialMs"`            // Time to connect to an upstream target. Default: 30000
+	ResponseHeaderMs  int `yaml:"responseHeaderMs"`  // Time the upstream has to send the response headers. 0 = no limit
+	RequestSeconds    int `yaml:"requestSeconds"`    // Total time for a request, response body included. Replaces the server read/write timeouts. 0 = server timeouts apply
+	StreamIdleSeconds int `yaml:"streamIdleSeconds"` // Time a WebSocket connection or streamed response (SSE, chunked) may stay idle before it is closed. Default: 300
+}
+
+// RouteMatchConfig holds the request conditions a route needs besides its path, host and method.
+// All conditions must hold for the route to match.
+type RouteMatchConfig struct {
+	Headers []ValueMatch `yaml:"headers"` // Request header conditions. Optional.
+	Query   []ValueMatch `yaml:"query"`   // Query string parameter conditions. Optional.
+}
+
+// ValueMatch is a condition on a request header or query parameter. With neither Value nor
+// Regex set, the header or parameter only has to be present.
+type ValueMatch struct {
+	Name  string `yaml:"name"`  // Header or query parameter name. Required.
+	Value string `yaml:"value"` // Exact value. Mutually exclusive with Regex.
+	Regex string `yaml:"regex"` // Regular expression the value must match (e.g., "^v[23]$"). Mutually exclusive with Value.
+}
+
+// TemplatePlaceholder matches the "{name}" placeholders of rewrite and header templates.
+var TemplatePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// RewriteConfig changes the path and query string of a request before it is proxied or
+// looked up in a static folder. Templates can use the route wildcards as {w0}, {w1}, ... in
+// the order of the "*" segments of 'from' (a trailing "*" captures the rest of the path),
+// the groups of Regex as {1} or {name}, and the original path as {path}.
+// In YAML, a plain string is a shorthand for Path.
+type RewriteConfig struct {
+	Path  string             `yaml:"path"`  // Path template, optionally with query parameters to set (e.g., "/v2/certificates/{w1}?box={w0}"). Default: the request path
+	Regex string             `yaml:"regex"` // Regular expression matched against the request path; requests that do not match are left unchanged. Optional.
+	Query RewriteQueryConfig `yaml:"query"` // Query string changes. Optional.
+}
+
+// RewriteQueryConfig changes the query parameters of a request. Set and Add values are templates.
+type RewriteQueryConfig struct {
+	Set    map[string]string `yaml:"set"`    // Parameters to set, replacing the values sent by the client.
+	Add    map[string]string `yaml:"add"`    // Parameters to append to the values sent by the client.
+	Remove []string          `yaml:"remove"` // Parameters to drop.
+}
+
+// UnmarshalYAML accepts either a path template string or the full rewrite block.
+func (rc *RewriteConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		rc.Path = value.Value
+		return nil
+	}
+	type plain RewriteConfig
+	return value.Decode((*plain)(rc))
+}
+
+// IdentityHeaders are the request headers the gateway sets with the identity of the session
+// or client certificate on authenticated routes.
+var IdentityHeaders = []string{"X-User-Id", "X-User-Data", "X-Client-Cert-Subject", "X-Client-Cert-Fingerprint"}
+
+// Values available to header templates.
+var HeaderTemplateValues = []string{
+	"user.id", "user.email", "user.username", "user.admin", // session of the request, empty when anonymous
+	"client.ip", "client.country", "client.ja4h", // client information
+	"route",      // route name
+	"request.id", // X-Request-Id sent by the client, or a generated ID
+}
+
+// HeadersConfig changes the headers of the requests or responses of a route. Headers are
+// removed first, then set, then added. Set and Add values are templates that can use the
+// HeaderTemplateValues, e.g. "{user.id}".
+type HeadersConfig struct {
+	Set    map[string]string `yaml:"set"`    // Headers to set, replacing existing values.
+	Add    map[string]string `yaml:"add"`    // Headers to append to existing values.
+	Remove []string          `yaml:"remove"` // Headers to drop.
 }
 
 // RouteConfig defines a single routing rule for the gateway.
 // Routes can proxy to remote servers or serve static files.
 type RouteConfig struct {
-	Name           string               `yaml:"name"`              // Human-readable route name for logging. Required.
-	From           string               `yaml:"from"`              // Incoming request path pattern (e.g., "/api/*", "/"). Must start with "/". Required.
-	To             string               `yaml:"to"`                // Target URL for proxying (e.g., "https://api.example.com"). Required for proxy routes.
-	ToFolder       string               `yaml:"toFolder"`          // Local folder path for static content. Mutually exclusive with ToFile. Required if Static=true and ToFile not set.
-	ToFile         string               `yaml:"toFile"`            // Specific file path for static content. Mutually exclusive with ToFolder. Optional.
-	Static         bool                 `yaml:"static"`            // Enable static file serving. Default: false
-	IsSPA          bool                 `yaml:"isSPA"`             // Enable SPA mode. For static routes: serves index.html on 404. For proxy routes: re-requests the upstream base URL on 404. Default: false
-	RemoveFromPath string               `yaml:"removeFromPath"`    // Path prefix to remove before proxying (e.g., "/api/v1/"). Optional.
-	Authentication AuthenticationConfig `yaml:"authentication"`    // Authentication requirements for this route
-	Options        *RouteOptions        `yaml:"options,omitempty"` // Additional route options (cache control, etc.). Optional.
+	Name            string                  `yaml:"name"`              // Human-readable route name for logging. Required.
+	From            string                  `yaml:"from"`              // Incoming request path pattern (e.g., "/api/*", "/"). Must start with "/". Required.
+	Hosts           []string                `yaml:"hosts,omitempty"`   // Host names the route answers (e.g., "api.example.com", "*.example.com"). Default: any host
+	Methods         []string                `yaml:"methods,omitempty"` // HTTP methods the route answers (e.g., GET, POST). Default: any method
+	Match           *RouteMatchConfig       `yaml:"match"`             // Header and query string conditions. Optional.
+	To              string                  `yaml:"to"`                // Target URL for proxying (e.g., "https://api.example.com"). Required for proxy routes unless Targets is set.
+	Targets         []UpstreamTarget        `yaml:"targets,omitempty"` // Several upstream targets for load-balanced proxying. Mutually exclusive with To.
+	LoadBalancing   LoadBalancingConfig     `yaml:"loadBalancing"`     // Load-balancing strategy when several targets are configured. Optional.
+	HealthCheck     *HealthCheckConfig      `yaml:"healthCheck"`       // Upstream health checking for proxy routes. Optional.
+	CircuitBreaker  *CircuitBreakerConfig   `yaml:"circuitBreaker"`    // Circuit breaker for proxy routes. Optional.
+	Retry           *RetryConfig            `yaml:"retry"`             // Retry policy for proxy routes. Optional.
+	Timeouts        *RouteTimeoutsConfig    `yaml:"timeouts"`          // Per-route timeouts. Optional.
+	Cache           *RouteCacheConfig       `yaml:"cache"`             // Shared response cache for proxy routes. Optional.
+	Collapse        *CollapseConfig         `yaml:"collapse"`          // Share one upstream request between concurrent identical requests. Optional.
+	RateLimit       []string                `yaml:"rateLimit"`         // Names of the management.rateLimiter.policies that limit this route. Optional.
+	Compression     *RouteCompressionConfig `yaml:"compression"`       // Response compression settings of this route. Optional.
+	ErrorPages      map[string]string       `yaml:"errorPages"`        // HTML templates of the error pages of this route by status ("404") or class ("5xx"), over the global ones. Optional.
+	ToFolder        string                  `yaml:"toFolder"`          // Local folder path for static content. Mutually exclusive with ToFile. Required if Static=true and ToFile not set.
+	ToFile          string                  `yaml:"toFile"`            // Specific file path for static content. Mutually exclusive with ToFolder. With ToArchive, a path inside the archive. Optional.
+	ToArchive       string                  `yaml:"toArchive"`         // Zip or tar.gz archive served as the folder of static content, reloaded when it changes. Mutually exclusive with ToFolder. Optional.
+	Static          bool                    `yaml:"static"`            // Enable static file serving. Default: false
+	IsSPA           bool                    `yaml:"isSPA"`             // Enable SPA mode. For static routes: serves index.html on 404. For proxy routes: re-requests the upstream base URL on 404. Default: false
+	RemoveFromPath  string                  `yaml:"removeFromPath"`    // Path prefix to remove before proxying (e.g., "/api/v1/"). Optional.
+	Rewrite         *RewriteConfig          `yaml:"rewrite"`           // Path and query rewrite using the wildcards of 'from' or regex captures. Mutually exclusive with RemoveFromPath. Optional.
+	RequestHeaders  *HeadersConfig          `yaml:"requestHeaders"`    // Changes to the request headers before they reach the proxy or file server. Optional.
+	ResponseHeaders *HeadersConfig          `yaml:"responseHeaders"`   // Changes to the response headers sent to the client. Optional.
+	Authentication  AuthenticationConfig    `yaml:"authentication"`    // Authentication requirements for this route
+	ClientCert      *RouteClientCertConfig  `yaml:"clientCertificate"` // Client certificate (mutual TLS) requirement of this route. Needs server.tls.clientAuth. Optional.
+	Options         *RouteOptions           `yaml:"options,omitempty"` // Additional route options (cache control, etc.). Optional.
 }
 
 // AuthProviderCredentials contains OAuth2 provider credentials.
@@ -129,6 +367,14 @@ type ManagementConfig struct {
 	Admin       AdminConfig       `yaml:"admin"`       // Admin dashboard access configuration
 	Session     SessionConfig     `yaml:"session"`     // Session lifetime configuration for authenticated users
 	RateLimiter RateLimiterConfig `yaml:"rateLimiter"` // Rate limiter settings. Optional; zero values disable.
+	Reload      ReloadConfig      `yaml:"reload"`      // Reload of the configuration file without restart. Optional.
+}
+
+// ReloadConfig controls how the configuration file is reloaded while the gateway runs.
+// SIGHUP and the management API always reload it; the file can also be watched.
+type ReloadConfig struct {
+	Watch                bool `yaml:"watch"`                // Reload when the configuration file changes. Default: false
+	WatchIntervalSeconds int  `yaml:"watchIntervalSeconds"` // How often the file is checked for changes. Default: 2
 }
 
 // RateLimiterConfig contains simple in-memory rate limiting settings.
@@ -150,17 +396,101 @@ type VulnerabilityScanConfig struct {
 // A single configuration block keeps the gateway easy to configure.
 // The middleware applies limits per client IP address.
 type RateLimiterConfig struct {
-	RequestsPerMinute int `yaml:"requestsPerMinute"` // Max requests per IP per 60s window. 0 = disabled.
+	RequestsPerMinute int `yaml:"requestsPerMinute"` // Max requests per IP per minute, refilled continuously. 0 = disabled.
+	Burst             int `yaml:"burst"`             // Requests an IP can make at once within requestsPerMinute. Default: requestsPerMinute
 	MaxErrors         int `yaml:"maxErrors"`         // Max number of 401 or 404 responses before blocking. 0 = disabled.
 	BlockMinutes      int `yaml:"blockMinutes"`      // Duration (in minutes) to block offending IPs. 0 = no blocking.
 
 	VulnerabilityScan VulnerabilityScanConfig `yaml:"vulnerabilityScan"` // Optional scanner detector
+
+	Policies map[string]RateLimitPolicyConfig `yaml:"policies"` // Named limits, attached to routes with rateLimit or to paths. Optional.
+
+	Storage *RateLimitStorageConfig `yaml:"storage"` // Where the counters and blocks are kept, shared by the gateway instances. Optional; in memory when not set.
+}
+
+// Storage types of the rate limiter.
+const (
+	RateLimitStorageMemory   = "memory"   // counters and blocks of this instance only
+	RateLimitStorageDatabase = "database" // counters and blocks in the database, shared by the instances using it
+)
+
+// RateLimitStorageConfig shares the rate limiter between gateway instances behind a load balancer,
+// so the limits apply to the requests of all of them and a blocked IP is blocked by every instance.
+type RateLimitStorageConfig struct {
+	Type        string `yaml:"type"`        // memory or database. Default: memory
+	SyncSeconds int    `yaml:"syncSeconds"` // How often the counts and blocks of the other instances are read. Default: 5
+	Batch       bool   `yaml:"batch"`       // Count the requests locally and write them on every sync, instead of on every request. Default: false
+	FailClosed  bool   `yaml:"failClosed"`  // Without batch, answer 503 when a request cannot be counted in the database, instead of letting it through. Default: false
+}
+
+// Keys of the rate limit policies: what the requests are counted by.
+const (
+	RateLimitKeyIP     = "ip"     // client IP address
+	RateLimitKeyUser   = "user"   // ID of the authenticated user, the IP for anonymous requests
+	RateLimitKeyToken  = "token"  // ID of the API token of the request, the IP without one
+	RateLimitKeyJA4H   = "ja4h"   // JA4H fingerprint of the request
+	RateLimitKeyHeader = "header" // value of a header, written "header:X-Api-Key"; the IP without it
+)
+
+// RateLimitPolicyConfig is a token bucket: it holds burst requests and refills at the rate
+// of requests per period. A request that finds it empty gets 429 Too Many Requests, with
+// the time until the next request is allowed in Retry-After.
+type RateLimitPolicyConfig struct {
+	Requests      int      `yaml:"requests"`      // Requests allowed per period. Required.
+	PeriodSeconds int      `yaml:"periodSeconds"` // Length of the period (e.g., 1 for "per second"). Default: 60
+	Burst         int      `yaml:"burst"`         // Requests allowed at once. Default: requests
+	Key           string   `yaml:"key"`           // What requests are counted by: ip, user, token, ja4h or header:<name>. Default: ip
+	Paths         []string `yaml:"paths"`         // Request paths limited on every route, management ones included (e.g., "/_/login"). Supports wildcards. Optional.
 }
 
 // IsEnabled reports whether any rate-limiting or vulnerability-scan feature is active.
 func (r RateLimiterConfig) IsEnabled() bool {
 	return r.RequestsPerMinute > 0 || r.MaxErrors > 0 ||
-		r.VulnerabilityScan.Max404 > 0 || len(r.VulnerabilityScan.URLs) > 0
+		r.VulnerabilityScan.Max404 > 0 || len(r.VulnerabilityScan.URLs) > 0 || len(r.Policies) > 0
+}
+
+// GlobalBurst returns the burst of the requestsPerMinute limit.
+func (r RateLimiterConfig) GlobalBurst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.RequestsPerMinute
+}
+
+// Encodings supported by the response compression.
+const (
+	CompressionBrotli = "br"
+	CompressionZstd   = "zstd"
+	CompressionGzip   = "gzip"
+)
+
+// DefaultCompressionContentTypes are the media types compressed when no list is configured.
+// A type ending in /* matches all its subtypes.
+var DefaultCompressionContentTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/ld+json",
+	"application/manifest+json",
+	"application/wasm",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/x-javascript",
+	"image/svg+xml",
+	"image/x-icon",
+	"font/otf",
+	"font/ttf",
+}
+
+// CompressionConfig compresses the responses of the user routes, negotiated with the
+// Accept-Encoding header of the client.
+type CompressionConfig struct {
+	Enabled      bool     `yaml:"enabled"`      // Compress the responses of all the routes. Default: false
+	Algorithms   []string `yaml:"algorithms"`   // Encodings offered, in order of preference: br, zstd, gzip. Default: all, in that order
+	MinSizeBytes int      `yaml:"minSizeBytes"` // Smaller responses are sent uncompressed. Default: 1024
+	ContentTypes []string `yaml:"contentTypes"` // Media types that are compressed. Default: DefaultCompressionContentTypes
 }
 
 // GeolocationConfig defines IP geolocation service settings.
@@ -169,6 +499,127 @@ type GeolocationConfig struct {
 	IPLocateAPIKey string `yaml:"iplocateApiKey"` // API key for iplocate.io service. Optional. Can use environment variables (e.g., ${IPLOCATE_IO_API_KEY}). Without this, geolocation features are disabled.
 }
 
+// CacheConfig sizes the shared response cache used by the routes with a cache block.
+type CacheConfig struct {
+	MaxMemoryMB int             `yaml:"maxMemoryMB"` // Memory for cached responses; the least recently used are evicted first. Default: 64
+	MaxEntryKB  int             `yaml:"maxEntryKB"`  // Largest response body that is stored. Default: 1024
+	Disk        CacheDiskConfig `yaml:"disk"`        // Second tier on disk, kept across restarts. Optional.
+}
+
+// CacheDiskConfig configures the disk tier of the response cache.
+type CacheDiskConfig struct {
+	Path      string `yaml:"path"`      // Directory of the cached responses. Empty disables the disk tier.
+	MaxSizeMB int    `yaml:"maxSizeMB"` // Disk space for cached responses. Default: 1024
+}
+
+// MaxMemoryBytes returns the memory limit of the cache, applying the default.
+func (c CacheConfig) MaxMemoryBytes() int64 {
+	if c.MaxMemoryMB <= 0 {
+		return 64 << 20
+	}
+	return int64(c.MaxMemoryMB) << 20
+}
+
+// MaxEntryBytes returns the largest body that is stored, applying the default.
+func (c CacheConfig) MaxEntryBytes() int64 {
+	if c.MaxEntryKB <= 0 {
+		return 1 << 20
+	}
+	return int64(c.MaxEntryKB) << 10
+}
+
+// MaxSizeBytes returns the disk limit of the cache, applying the default.
+func (c CacheDiskConfig) MaxSizeBytes() int64 {
+	if c.MaxSizeMB <= 0 {
+		return 1 << 30
+	}
+	return int64(c.MaxSizeMB) << 20
+}
+
+// DefaultIdentityJWTHeader is the request header that carries the signed identity token.
+const DefaultIdentityJWTHeader = "X-User-Jwt"
+
+// IdentityConfig controls how the identity of the session user is passed to the upstreams
+// of authenticated proxy routes, on top of the X-User-Id and X-User-Data headers.
+type IdentityConfig struct {
+	JWT IdentityJWTConfig `yaml:"jwt"` // Signed identity token. Optional.
+}
+
+// IdentityJWTConfig configures the short-lived JWT sent to upstreams. Either secret (HMAC) or
+// privateKey/privateKeyFile (RSA) must be set; the RSA public key is published as a JWKS
+// under the management prefix so upstreams can verify the tokens.
+type IdentityJWTConfig struct {
+	Enabled        bool   `yaml:"enabled"`        // Send the signed token. Default: false
+	Header         string `yaml:"header"`         // Request header of the token. Default: "X-User-Jwt"
+	Algorithm      string `yaml:"algorithm"`      // HS256, HS384, HS512, RS256, RS384 or RS512. Default: HS256 with a secret, RS256 with a key
+	Secret         string `yaml:"secret"`         // HMAC secret, at least 32 bytes. Can use environment variables.
+	PrivateKey     string `yaml:"privateKey"`     // RSA private key in PEM format (PKCS#1 or PKCS#8). Can use environment variables.
+	PrivateKeyFile string `yaml:"privateKeyFile"` // File with the RSA private key in PEM format
+	KeyID          string `yaml:"keyId"`          // "kid" of the key. Default: the RFC 7638 thumbprint for RSA keys
+	Issuer         string `yaml:"issuer"`         // "iss" claim. Default: "taronja-gateway"
+	Audience       string `yaml:"audience"`       // "aud" claim. Optional.
+	TTLSeconds     int    `yaml:"ttlSeconds"`     // Token lifetime. Default: 60
+}
+
+// HeaderName returns the request header of the token, applying the default.
+func (c IdentityJWTConfig) HeaderName() string {
+	if c.Header == "" {
+		return DefaultIdentityJWTHeader
+	}
+	return c.Header
+}
+
+// IssuerName returns the "iss" claim, applying the default.
+func (c IdentityJWTConfig) IssuerName() string {
+	if c.Issuer == "" {
+		return "taronja-gateway"
+	}
+	return c.Issuer
+}
+
+// TTL returns the lifetime of a token, applying the default.
+func (c IdentityJWTConfig) TTL() time.Duration {
+	if c.TTLSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// IsHMAC reports whether tokens are signed with the shared secret.
+func (c IdentityJWTConfig) IsHMAC() bool {
+	if c.Algorithm != "" {
+		return strings.HasPrefix(c.Algorithm, "HS")
+	}
+	return c.Secret != ""
+}
+
+// validate checks that the algorithm and the key material agree.
+func (c IdentityJWTConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Algorithm != "" && !slices.Contains([]string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512"}, c.Algorithm) {
+		return fmt.Errorf("identity.jwt.algorithm '%s' is not supported, use HS256, HS384, HS512, RS256, RS384 or RS512", c.Algorithm)
+	}
+	hasKey := c.PrivateKey != "" || c.PrivateKeyFile != ""
+	switch {
+	case c.Secret != "" && hasKey:
+		return fmt.Errorf("identity.jwt cannot have both 'secret' and a private key")
+	case c.PrivateKey != "" && c.PrivateKeyFile != "":
+		return fmt.Errorf("identity.jwt cannot have both 'privateKey' and 'privateKeyFile'")
+	case c.IsHMAC() && len(c.Secret) < 32:
+		return fmt.Errorf("identity.jwt.secret must be at least 32 bytes long")
+	case !c.IsHMAC() && !hasKey:
+		return fmt.Errorf("identity.jwt.algorithm '%s' requires 'privateKey' or 'privateKeyFile'", c.Algorithm)
+	case c.TTLSeconds < 0:
+		return fmt.Errorf("identity.jwt.ttlSeconds cannot be negative")
+	}
+	if strings.ContainsAny(c.HeaderName(), " :\t\r\n") {
+		return fmt.Errorf("identity.jwt.header '%s' is not a valid header name", c.Header)
+	}
+	return nil
+}
+
 // GatewayConfig is the root configuration structure for Taronja Gateway.
 // It contains all settings needed to run the gateway including server, routing, authentication, and management.
 // Configuration is loaded from a YAML file and supports environment variable expansion (${VAR_NAME}).
@@ -181,6 +632,10 @@ type GatewayConfig struct {
 	Branding                BrandingConfig          `yaml:"branding,omitempty"`      // UI branding customization. Optional.
 	Geolocation             GeolocationConfig       `yaml:"geolocation"`             // IP geolocation service settings. Optional.
 	Notification            NotificationConfig      `yaml:"notification"`            // Notification system settings. Optional.
+	Identity                IdentityConfig          `yaml:"identity"`                // Identity passed to authenticated proxy routes. Optional.
+	Cache                   CacheConfig             `yaml:"cache"`                   // Shared response cache sizes. Optional.
+	Compression             CompressionConfig       `yaml:"compression"`             // Response compression of the user routes. Optional.
+	ErrorPages              map[string]string       `yaml:"errorPages"`              // HTML templates of the error pages by status ("404") or class ("5xx"). Optional.
 }
 
 // LoadConfig reads, parses, and validates the YAML configuration file.
@@ -252,6 +707,55 @@ func LoadConfig(filename string) (*GatewayConfig, error) {
 		log.Printf("Admin access is disabled")
 	}
 
+	if err := config.Identity.JWT.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Management.RateLimiter.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.Server.Shutdown.DelaySeconds < 0 || config.Server.Shutdown.DrainSeconds < 0 {
+		return nil, fmt.Errorf("server shutdown times cannot be negative")
+	}
+
+	for _, entry := range config.Server.TrustedProxies {
+		if _, err := parseProxyPrefix(entry); err != nil {
+			return nil, fmt.Errorf("server.trustedProxies '%s' is not an IP address or CIDR", entry)
+		}
+	}
+
+	switch http.CanonicalHeaderKey(config.Server.ForwardedHeader) {
+	case "", "X-Forwarded-For", "Forwarded", "X-Real-Ip", "X-Client-Ip":
+	default:
+		return nil, fmt.Errorf("server.forwardedHeader '%s' must be X-Forwarded-For, Forwarded, X-Real-IP or X-Client-IP", config.Server.ForwardedHeader)
+	}
+
+	if proxyProtocol := config.Server.ProxyProtocol; proxyProtocol != nil {
+		if len(proxyProtocol.AllowedSources) == 0 {
+			return nil, fmt.Errorf("server.proxyProtocol requires allowedSources")
+		}
+		for _, entry := range proxyProtocol.AllowedSources {
+			if _, err := parseProxyPrefix(entry); err != nil {
+				return nil, fmt.Errorf("server.proxyProtocol.allowedSources '%s' is not an IP address or CIDR", entry)
+			}
+		}
+	}
+
+	if tlsConfig := config.Server.TLS; tlsConfig != nil {
+		if err := tlsConfig.validate(config.Server.Port); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateCompressionAlgorithms("compression", config.Compression.Algorithms); err != nil {
+		return nil, err
+	}
+
+	if err := validateErrorPages("errorPages", config.ErrorPages); err != nil {
+		return nil, err
+	}
+
 	// Validate authentication providers
 	if !config.HasAnyAuthentication() {
 		log.Printf("WARNING: No authentication providers are configured. Consider enabling at least one authentication method:")
@@ -267,15 +771,37 @@ func LoadConfig(filename string) (*GatewayConfig, error) {
 	for i := range config.Routes {
 		route := &config.Routes[i]
 
+		if route.ClientCert != nil && (config.Server.TLS == nil || config.Server.TLS.ClientAuth == nil) {
+			return nil, fmt.Errorf("route '%s' clientCertificate requires server.tls.clientAuth", route.Name)
+		}
+
+		if to := route.Timeouts; to != nil && (to.DialMs < 0 || to.ResponseHeaderMs < 0 || to.RequestSeconds < 0 || to.StreamIdleSeconds < 0) {
+			return nil, fmt.Errorf("route '%s' timeouts cannot be negative", route.Name)
+		}
+
 		if route.Static {
 			// Validate that ToFolder and ToFile are mutually exclusive
 			if route.ToFolder != "" && route.ToFile != "" {
 				return nil, fmt.Errorf("route '%s' cannot have both 'toFolder' and 'toFile' specified, they are mutually exclusive", route.Name)
 			}
+			if route.ToFolder != "" && route.ToArchive != "" {
+				return nil, fmt.Errorf("route '%s' cannot have both 'toFolder' and 'toArchive' specified, they are mutually exclusive", route.Name)
+			}
+
+			// Validate that at least one of ToFolder, ToFile or ToArchive is specified
+			if route.ToFolder == "" && route.ToFile == "" && route.ToArchive == "" {
+				return nil, fmt.Errorf("route '%s' is marked as static but neither 'toFolder', 'toFile' nor 'toArchive' is specified", route.Name)
+			}
 
-			// Validate that at least one of ToFolder or ToFile is specified
-			if route.ToFolder == "" && route.ToFile == "" {
-				return nil, fmt.Errorf("route '%s' is marked as static but neither 'toFolder' nor 'toFile' is specified", route.Name)
+			// Resolve archive path; its 'toFile' is a path inside the archive
+			if route.ToArchive != "" {
+				if !IsArchivePath(route.ToArchive) {
+					return nil, fmt.Errorf("route '%s' toArchive '%s' must be a .zip, .tar.gz or .tgz file", route.Name, route.ToArchive)
+				}
+				if !filepath.IsAbs(route.ToArchive) {
+					route.ToArchive = filepath.Join(currentDir, route.ToArchive)
+				}
+				route.ToArchive = filepath.Clean(route.ToArchive)
 			}
 
 			// Resolve folder path
@@ -294,7 +820,7 @@ func LoadConfig(filename string) (*GatewayConfig, error) {
 			}
 
 			// Resolve file path
-			if route.ToFile != "" {
+			if route.ToFile != "" && route.ToArchive == "" {
 				originalPath := route.ToFile
 				resolvedPath := originalPath
 				if !filepath.IsAbs(originalPath) {
@@ -309,6 +835,46 @@ func LoadConfig(filename string) (*GatewayConfig, error) {
 			}
 		}
 
+		if !route.Static {
+			if err := route.validateUpstreams(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := route.validateCache(); err != nil {
+			return nil, err
+		}
+
+		if err := route.validateCollapse(); err != nil {
+			return nil, err
+		}
+
+		if err := route.validateRateLimit(config.Management.RateLimiter.Policies); err != nil {
+			return nil, err
+		}
+
+		if route.Compression != nil {
+			if err := validateCompressionAlgorithms(fmt.Sprintf("route '%s' compression", route.Name), route.Compression.Algorithms); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := validateErrorPages(fmt.Sprintf("route '%s' errorPages", route.Name), route.ErrorPages); err != nil {
+			return nil, err
+		}
+
+		if err := route.validateMatching(); err != nil {
+			return nil, err
+		}
+
+		if err := route.validateRewrite(); err != nil {
+			return nil, err
+		}
+
+		if err := route.validateHeaders(); err != nil {
+			return nil, err
+		}
+
 		// Validate route 'From' path? Ensure it starts with '/'?
 		if !strings.HasPrefix(route.From, "/") {
 			log.Printf("Warning: Route '%s' From path '%s' does not start with '/'. Adding prefix.", route.Name, route.From)
@@ -316,9 +882,35 @@ func LoadConfig(filename string) (*GatewayConfig, error) {
 		}
 	}
 
+	if tlsConfig := config.Server.TLS; tlsConfig != nil && tlsConfig.ACME != nil {
+		if err := tlsConfig.ACME.resolveHosts(config.Routes); err != nil {
+			return nil, err
+		}
+	}
+
+	// Circuit breaker fallbacks must point to an existing route
+	for _, route := range config.Routes {
+		if route.CircuitBreaker == nil || route.CircuitBreaker.Fallback.Route == "" {
+			continue
+		}
+		if config.findRoute(route.CircuitBreaker.Fallback.Route) == nil {
+			return nil, fmt.Errorf("route '%s' circuitBreaker.fallback.route '%s' does not exist", route.Name, route.CircuitBreaker.Fallback.Route)
+		}
+	}
+
 	return config, nil
 }
 
+// findRoute returns the route with the given name, or nil.
+func (c *GatewayConfig) findRoute(name string) *RouteConfig {
+	for i := range c.Routes {
+		if c.Routes[i].Name == name {
+			return &c.Routes[i]
+		}
+	}
+	return nil
+}
+
 // --- Helper Functions ---
 
 // HasAuthentication checks if any authentication is enabled in the config.
@@ -360,6 +952,696 @@ func NewLoginPageData(redirectURL string, gatewayConfig *GatewayConfig) loginPag
 	return data
 }
 
+// --- Upstream Helper Methods ---
+
+// UpstreamTargets returns the proxy targets of the route. A plain 'to' URL is
+// returned as a single target with weight 1.
+func (route *RouteConfig) UpstreamTargets() []UpstreamTarget {
+	if len(route.Targets) > 0 {
+		return route.Targets
+	}
+	if route.To == "" {
+		return nil
+	}
+	return []UpstreamTarget{{URL: route.To, Weight: 1}}
+}
+
+// UpstreamDescription returns a short human-readable list of the route targets for logging.
+func (route *RouteConfig) UpstreamDescription() string {
+	targets := route.UpstreamTargets()
+	urls := make([]string, 0, len(targets))
+	for _, t := range targets {
+		urls = append(urls, t.URL)
+	}
+	return strings.Join(urls, ", ")
+}
+
+// validateUpstreams checks the proxy targets and load-balancing settings of a route.
+func (route *RouteConfig) validateUpstreams() error {
+	if route.To != "" && len(route.Targets) > 0 {
+		return fmt.Errorf("route '%s' cannot have both 'to' and 'targets' specified, they are mutually exclusive", route.Name)
+	}
+	for i, target := range route.Targets {
+		if target.URL == "" {
+			return fmt.Errorf("route '%s' target %d has an empty 'url'", route.Name, i)
+		}
+		if target.Weight < 0 {
+			return fmt.Errorf("route '%s' target '%s' has a negative weight", route.Name, target.URL)
+		}
+	}
+	if hc := route.HealthCheck; hc != nil {
+		if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+			return fmt.Errorf("route '%s' healthCheck.path '%s' must start with '/'", route.Name, hc.Path)
+		}
+		if hc.IntervalSeconds < 0 || hc.TimeoutSeconds < 0 || hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 ||
+			hc.Passive.MaxFailures < 0 || hc.Passive.CooldownSeconds < 0 {
+			return fmt.Errorf("route '%s' healthCheck values cannot be negative", route.Name)
+		}
+	}
+	if cb := route.CircuitBreaker; cb != nil {
+		if cb.ConsecutiveFailures <= 0 && cb.FailureRatio <= 0 {
+			return fmt.Errorf("route '%s' circuitBreaker needs consecutiveFailures or failureRatio", route.Name)
+		}
+		if cb.FailureRatio < 0 || cb.FailureRatio > 1 {
+			return fmt.Errorf("route '%s' circuitBreaker.failureRatio must be between 0 and 1", route.Name)
+		}
+		if cb.ConsecutiveFailures < 0 || cb.MinRequests < 0 || cb.WindowSeconds < 0 || cb.OpenSeconds < 0 || cb.HalfOpenRequests < 0 {
+			return fmt.Errorf("route '%s' circuitBreaker values cannot be negative", route.Name)
+		}
+		if cb.Fallback.Route == route.Name {
+			return fmt.Errorf("route '%s' circuitBreaker.fallback.route cannot point to itself", route.Name)
+		}
+		if cb.Fallback.StatusCode != 0 && (cb.Fallback.StatusCode < 100 || cb.Fallback.StatusCode > 599) {
+			return fmt.Errorf("route '%s' circuitBreaker.fallback.statusCode %d is not a valid HTTP status", route.Name, cb.Fallback.StatusCode)
+		}
+	}
+	if rc := route.Retry; rc != nil {
+		if rc.Attempts < 0 || rc.BackoffMs < 0 || rc.MaxBackoffMs < 0 || rc.PerTryTimeoutMs < 0 || rc.MaxBodyBytes < 0 {
+			return fmt.Errorf("route '%s' retry values cannot be negative", route.Name)
+		}
+		for _, code := range rc.StatusCodes {
+			if code < 100 || code > 599 {
+				return fmt.Errorf("route '%s' retry.statusCodes %d is not a valid HTTP status", route.Name, code)
+			}
+		}
+		for _, kind := range rc.Errors {
+			switch kind {
+			case RetryOnConnectFailure, RetryOnReset, RetryOnTimeout:
+			default:
+				return fmt.Errorf("route '%s' has unknown retry.errors kind '%s'", route.Name, kind)
+			}
+		}
+	}
+	switch route.LoadBalancing.Strategy {
+	case "", StrategyRoundRobin, StrategyWeighted, StrategyLeastConnections, StrategyRandomTwoChoices:
+	case StrategyConsistentHash:
+		switch route.LoadBalancing.HashOn {
+		case "", "ip":
+		case "header", "cookie":
+			if route.LoadBalancing.HashKey == "" {
+				return fmt.Errorf("route '%s' uses consistent-hash on %s but 'hashKey' is empty", route.Name, route.LoadBalancing.HashOn)
+			}
+		default:
+			return fmt.Errorf("route '%s' has unknown loadBalancing.hashOn '%s'", route.Name, route.LoadBalancing.HashOn)
+		}
+	default:
+		return fmt.Errorf("route '%s' has unknown loadBalancing.strategy '%s'", route.Name, route.LoadBalancing.Strategy)
+	}
+	return nil
+}
+
+// validateMatching checks the host, method, header and query conditions of a route and
+// normalizes hosts to lower case and methods to upper case.
+func (route *RouteConfig) validateMatching() error {
+	for i, host := range route.Hosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, "*:/ ") {
+			return fmt.Errorf("route '%s' has invalid host '%s', expected a host name without port such as 'api.example.com' or '*.example.com'", route.Name, route.Hosts[i])
+		}
+		route.Hosts[i] = host
+	}
+	for i, method := range route.Methods {
+		method = strings.ToUpper(method)
+		if method == "" || strings.IndexFunc(method, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			return fmt.Errorf("route '%s' has invalid method '%s'", route.Name, route.Methods[i])
+		}
+		route.Methods[i] = method
+	}
+	if route.Match == nil {
+		return nil
+	}
+	for _, group := range []struct {
+		field   string
+		matches []ValueMatch
+	}{{"match.headers", route.Match.Headers}, {"match.query", route.Match.Query}} {
+		for _, m := range group.matches {
+			if m.Name == "" {
+				return fmt.Errorf("route '%s' has a %s condition without 'name'", route.Name, group.field)
+			}
+			if m.Value != "" && m.Regex != "" {
+				return fmt.Errorf("route '%s' %s condition '%s' cannot have both 'value' and 'regex'", route.Name, group.field, m.Name)
+			}
+			if _, err := regexp.Compile(m.Regex); err != nil {
+				return fmt.Errorf("route '%s' %s condition '%s' has an invalid regex: %w", route.Name, group.field, m.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRewrite checks that a rewrite only uses placeholders the route can fill.
+func (route *RouteConfig) validateRewrite() error {
+	rw := route.Rewrite
+	if rw == nil {
+		return nil
+	}
+	if route.RemoveFromPath != "" {
+		return fmt.Errorf("route '%s' cannot have both 'rewrite' and 'removeFromPath' specified, they are mutually exclusive", route.Name)
+	}
+	if route.Static && route.ToFolder == "" {
+		return fmt.Errorf("route '%s' uses 'rewrite' on a static route without 'toFolder'", route.Name)
+	}
+	if rw.Path != "" && !strings.HasPrefix(rw.Path, "/") && !strings.HasPrefix(rw.Path, "{") {
+		return fmt.Errorf("route '%s' rewrite.path '%s' must start with '/'", route.Name, rw.Path)
+	}
+
+	known := map[string]bool{"path": true}
+	for i := range route.WildcardCount() {
+		known[fmt.Sprintf("w%d", i)] = true
+	}
+	if rw.Regex != "" {
+		re, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return fmt.Errorf("route '%s' rewrite.regex is invalid: %w", route.Name, err)
+		}
+		for i, name := range re.SubexpNames() {
+			known[strconv.Itoa(i)] = true
+			if name != "" {
+				known[name] = true
+			}
+		}
+	}
+
+	templates := []string{rw.Path}
+	for _, v := range rw.Query.Set {
+		templates = append(templates, v)
+	}
+	for _, v := range rw.Query.Add {
+		templates = append(templates, v)
+	}
+	for _, tmpl := range templates {
+		for _, m := range TemplatePlaceholder.FindAllStringSubmatch(tmpl, -1) {
+			if !known[m[1]] {
+				return fmt.Errorf("route '%s' rewrite uses unknown placeholder '%s'", route.Name, m[0])
+			}
+		}
+	}
+	return nil
+}
+
+// validateCache checks the cache block of a route.
+func (route *RouteConfig) validateCache() error {
+	if route.Cache == nil {
+		return nil
+	}
+	if route.Static {
+		return fmt.Errorf("route '%s' cache is only supported on proxy routes", route.Name)
+	}
+	if route.Cache.StaleWhileRevalidateSeconds < 0 || route.Cache.StaleIfErrorSeconds < 0 {
+		return fmt.Errorf("route '%s' cache stale times cannot be negative", route.Name)
+	}
+	return nil
+}
+
+// validateCollapse checks the collapse block of a route.
+func (route *RouteConfig) validateCollapse() error {
+	if route.Collapse == nil {
+		return nil
+	}
+	if route.Static {
+		return fmt.Errorf("route '%s' collapse is only supported on proxy routes", route.Name)
+	}
+	if route.Collapse.MaxWaitMs < 0 || route.Collapse.MaxBodyKB < 0 {
+		return fmt.Errorf("route '%s' collapse limits cannot be negative", route.Name)
+	}
+	for _, name := range route.Collapse.Headers {
+		if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+			return fmt.Errorf("route '%s' collapse has invalid header name '%s'", route.Name, name)
+		}
+	}
+	return nil
+}
+
+// validateCompressionAlgorithms checks the encodings of a compression block.
+func validateCompressionAlgorithms(field string, algorithms []string) error {
+	for _, algorithm := range algorithms {
+		switch algorithm {
+		case CompressionBrotli, CompressionZstd, CompressionGzip:
+		default:
+			return fmt.Errorf("%s has unsupported algorithm '%s', available: br, zstd, gzip", field, algorithm)
+		}
+	}
+	return nil
+}
+
+// validateErrorPages checks the keys of an errorPages block: an error status from 400 to
+// 599 or a class, 4xx or 5xx, each with a template file.
+func validateErrorPages(field string, pages map[string]string) error {
+	for key, file := range pages {
+		if key != "4xx" && key != "5xx" {
+			status, err := strconv.Atoi(key)
+			if err != nil || status < 400 || status > 599 {
+				return fmt.Errorf("%s has invalid status '%s', use an error status (e.g. 404) or a class (4xx, 5xx)", field, key)
+			}
+		}
+		if file == "" {
+			return fmt.Errorf("%s '%s' has no template file", field, key)
+		}
+	}
+	return nil
+}
+
+// validateHeaders checks the header names and templates of the header blocks of a route.
+func (route *RouteConfig) validateHeaders() error {
+	for _, block := range []struct {
+		field string
+		hc    *HeadersConfig
+	}{{"requestHeaders", route.RequestHeaders}, {"responseHeaders", route.ResponseHeaders}} {
+		field, hc := block.field, block.hc
+		if hc == nil {
+			continue
+		}
+		names := slices.Clone(hc.Remove)
+		for name, tmpl := range hc.Set {
+			names = append(names, name)
+			if err := validateHeaderTemplate(tmpl); err != nil {
+				return fmt.Errorf("route '%s' %s.set '%s': %w", route.Name, field, name, err)
+			}
+		}
+		for name, tmpl := range hc.Add {
+			names = append(names, name)
+			if err := validateHeaderTemplate(tmpl); err != nil {
+				return fmt.Errorf("route '%s' %s.add '%s': %w", route.Name, field, name, err)
+			}
+		}
+		for _, name := range names {
+			if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+				return fmt.Errorf("route '%s' %s has invalid header name '%s'", route.Name, field, name)
+			}
+		}
+	}
+	// the identity of authenticated routes replaces whatever the rules set
+	if hc := route.RequestHeaders; hc != nil && (route.Authentication.Enabled || route.ClientCert != nil) {
+		for _, names := range [][]string{slices.Collect(maps.Keys(hc.Set)), slices.Collect(maps.Keys(hc.Add))} {
+			for _, name := range names {
+				if slices.Contains(IdentityHeaders, http.CanonicalHeaderKey(name)) {
+					return fmt.Errorf("route '%s' requestHeaders cannot set '%s', the gateway sets it with the identity of the request", route.Name, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateHeaderTemplate checks that a header template only uses known values.
+func validateHeaderTemplate(tmpl string) error {
+	for _, m := range TemplatePlaceholder.FindAllStringSubmatch(tmpl, -1) {
+		if !slices.Contains(HeaderTemplateValues, m[1]) {
+			return fmt.Errorf("unknown placeholder '%s', available: %s", m[0], strings.Join(HeaderTemplateValues, ", "))
+		}
+	}
+	return nil
+}
+
+// WildcardCount returns the number of "*" segments in the 'from' path of the route.
+func (route *RouteConfig) WildcardCount() int {
+	count := 0
+	for _, seg := range strings.Split(route.From, "/") {
+		if seg == "*" {
+			count++
+		}
+	}
+	return count
+}
+
+// --- HealthCheck Helper Methods ---
+
+// Interval returns the time between active probes.
+func (hc *HealthCheckConfig) Interval() time.Duration {
+	if hc.IntervalSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(hc.IntervalSeconds) * time.Second
+}
+
+// Timeout returns the timeout of a single active probe.
+func (hc *HealthCheckConfig) Timeout() time.Duration {
+	if hc.TimeoutSeconds <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(hc.TimeoutSeconds) * time.Second
+}
+
+// IsExpectedStatus reports whether a probe response status means the target is healthy.
+func (hc *HealthCheckConfig) IsExpectedStatus(status int) bool {
+	if hc.ExpectedStatus == 0 {
+		return status >= 200 && status < 300
+	}
+	return status == hc.ExpectedStatus
+}
+
+// Thresholds returns the healthy and unhealthy thresholds, applying defaults.
+func (hc *HealthCheckConfig) Thresholds() (healthy, unhealthy int) {
+	healthy, unhealthy = hc.HealthyThreshold, hc.UnhealthyThreshold
+	if healthy <= 0 {
+		healthy = 2
+	}
+	if unhealthy <= 0 {
+		unhealthy = 3
+	}
+	return healthy, unhealthy
+}
+
+// Cooldown returns how long a passively failed target stays unhealthy when there is no active probe.
+func (p *PassiveHealthCheckConfig) Cooldown() time.Duration {
+	if p.CooldownSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(p.CooldownSeconds) * time.Second
+}
+
+// --- CircuitBreaker Helper Methods ---
+
+// Window returns the window used to compute the failure ratio.
+func (cb *CircuitBreakerConfig) Window() time.Duration {
+	if cb.WindowSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(cb.WindowSeconds) * time.Second
+}
+
+// OpenDuration returns how long the breaker stays open before probing the upstream again.
+func (cb *CircuitBreakerConfig) OpenDuration() time.Duration {
+	if cb.OpenSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(cb.OpenSeconds) * time.Second
+}
+
+// MinimumRequests returns the number of requests needed before the failure ratio is evaluated.
+func (cb *CircuitBreakerConfig) MinimumRequests() int {
+	if cb.MinRequests <= 0 {
+		return 20
+	}
+	return cb.MinRequests
+}
+
+// ProbeRequests returns the number of probe requests allowed while half-open.
+func (cb *CircuitBreakerConfig) ProbeRequests() int {
+	if cb.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return cb.HalfOpenRequests
+}
+
+// --- Retry Helper Methods ---
+
+// MaxAttempts returns the total number of attempts, including the first one.
+func (rc *RetryConfig) MaxAttempts() int {
+	if rc.Attempts <= 0 {
+		return 3
+	}
+	return rc.Attempts
+}
+
+// IsRetryableMethod reports whether requests with the given method may be retried.
+func (rc *RetryConfig) IsRetryableMethod(method string) bool {
+	methods := rc.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryableStatus reports whether an upstream response with the given status triggers a retry.
+func (rc *RetryConfig) IsRetryableStatus(status int) bool {
+	if len(rc.StatusCodes) == 0 {
+		return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	}
+	return slices.Contains(rc.StatusCodes, status)
+}
+
+// RetriesOn reports whether errors of the given kind (RetryOnConnectFailure, ...) trigger a retry.
+func (rc *RetryConfig) RetriesOn(kind string) bool {
+	return len(rc.Errors) == 0 || slices.Contains(rc.Errors, kind)
+}
+
+// Backoff returns the base and maximum delay between retries.
+func (rc *RetryConfig) Backoff() (base, limit time.Duration) {
+	base, limit = 50*time.Millisecond, time.Second
+	if rc.BackoffMs > 0 {
+		base = time.Duration(rc.BackoffMs) * time.Millisecond
+	}
+	if rc.MaxBackoffMs > 0 {
+		limit = time.Duration(rc.MaxBackoffMs) * time.Millisecond
+	}
+	return base, limit
+}
+
+// PerTryTimeout returns how long an attempt may wait for response headers, or 0 for no limit.
+func (rc *RetryConfig) PerTryTimeout() time.Duration {
+	return time.Duration(rc.PerTryTimeoutMs) * time.Millisecond
+}
+
+// BodyLimit returns the largest request body that is buffered so the request can be retried.
+func (rc *RetryConfig) BodyLimit() int64 {
+	if rc.MaxBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return rc.MaxBodyBytes
+}
+
+// --- Trusted Proxy Helper Methods ---
+
+// TrustedProxyPrefixes returns the trusted proxies as prefixes, a single address being a
+// prefix of its full length. The entries are expected to be validated.
+func (c *ServerConfig) TrustedProxyPrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, entry := range c.TrustedProxies {
+		if prefix, err := parseProxyPrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// GetForwardedHeader returns the header with the client address set by the trusted
+// proxies, defaulting to X-Forwarded-For
+func (c *ServerConfig) GetForwardedHeader() string {
+	if c.ForwardedHeader == "" {
+		return "X-Forwarded-For"
+	}
+	return c.ForwardedHeader
+}
+
+// SourcePrefixes returns the allowed sources as prefixes, like TrustedProxyPrefixes
+func (c *ProxyProtocolConfig) SourcePrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(c.AllowedSources))
+	for _, entry := range c.AllowedSources {
+		if prefix, err := parseProxyPrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// parseProxyPrefix parses an address ("10.0.0.1") or a CIDR ("10.0.0.0/8")
+func parseProxyPrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// --- Rate Limit Helper Methods ---
+
+// Period returns the period the requests of the policy are counted in.
+func (p RateLimitPolicyConfig) Period() time.Duration {
+	if p.PeriodSeconds > 0 {
+		return time.Duration(p.PeriodSeconds) * time.Second
+	}
+	return time.Minute
+}
+
+// BurstSize returns the requests the policy allows at once.
+func (p RateLimitPolicyConfig) BurstSize() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Requests
+}
+
+// KeyType returns the key of the policy without the header name: ip, user, token, ja4h or header.
+func (p RateLimitPolicyConfig) KeyType() string {
+	if p.Key == "" {
+		return RateLimitKeyIP
+	}
+	keyType, _, _ := strings.Cut(p.Key, ":")
+	return keyType
+}
+
+// HeaderName returns the header of a "header:<name>" key, empty for the other keys.
+func (p RateLimitPolicyConfig) HeaderName() string {
+	keyType, name, _ := strings.Cut(p.Key, ":")
+	if keyType != RateLimitKeyHeader {
+		return ""
+	}
+	return http.CanonicalHeaderKey(strings.TrimSpace(name))
+}
+
+// StorageType returns where the counters and blocks are kept: memory or database.
+func (r RateLimiterConfig) StorageType() string {
+	if r.Storage == nil || r.Storage.Type == "" {
+		return RateLimitStorageMemory
+	}
+	return r.Storage.Type
+}
+
+// IsShared reports whether the counters and blocks are kept in the database, shared by the instances.
+func (r RateLimiterConfig) IsShared() bool {
+	return r.StorageType() == RateLimitStorageDatabase
+}
+
+// SyncInterval returns how often a shared storage reads the counts and blocks of the other instances.
+func (s *RateLimitStorageConfig) SyncInterval() time.Duration {
+	if s == nil || s.SyncSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(s.SyncSeconds) * time.Second
+}
+
+// validate checks the policies and the storage of the rate limiter.
+func (r RateLimiterConfig) validate() error {
+	if r.Burst < 0 {
+		return fmt.Errorf("management.rateLimiter.burst cannot be negative")
+	}
+	if storage := r.Storage; storage != nil {
+		if storage.Type != "" && storage.Type != RateLimitStorageMemory && storage.Type != RateLimitStorageDatabase {
+			return fmt.Errorf("management.rateLimiter.storage.type '%s' is not supported, available: memory, database", storage.Type)
+		}
+		if storage.SyncSeconds < 0 {
+			return fmt.Errorf("management.rateLimiter.storage.syncSeconds cannot be negative")
+		}
+		if storage.FailClosed && storage.Batch {
+			return fmt.Errorf("management.rateLimiter.storage.failClosed cannot be combined with batch, which counts the requests without the database")
+		}
+	}
+	for name, policy := range r.Policies {
+		if name == "" {
+			return fmt.Errorf("management.rateLimiter.policies cannot have an empty name")
+		}
+		if policy.Requests <= 0 {
+			return fmt.Errorf("rate limit policy '%s' requires requests", name)
+		}
+		if policy.PeriodSeconds < 0 || policy.Burst < 0 {
+			return fmt.Errorf("rate limit policy '%s' periodSeconds and burst cannot be negative", name)
+		}
+		switch {
+		case policy.KeyType() == RateLimitKeyHeader:
+			if header := policy.HeaderName(); header == "" || strings.ContainsAny(header, " :\t\r\n") {
+				return fmt.Errorf("rate limit policy '%s' has an invalid header in key '%s'", name, policy.Key)
+			}
+		case policy.Key == "", policy.Key == RateLimitKeyIP, policy.Key == RateLimitKeyUser,
+			policy.Key == RateLimitKeyToken, policy.Key == RateLimitKeyJA4H:
+		default:
+			return fmt.Errorf("rate limit policy '%s' has unsupported key '%s', available: ip, user, token, ja4h, header:<name>", name, policy.Key)
+		}
+		for _, path := range policy.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("rate limit policy '%s' path '%s' must start with '/'", name, path)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRateLimit checks that the policies of a route exist.
+func (route *RouteConfig) validateRateLimit(policies map[string]RateLimitPolicyConfig) error {
+	for _, name := range route.RateLimit {
+		if _, ok := policies[name]; !ok {
+			return fmt.Errorf("route '%s' rateLimit policy '%s' does not exist", route.Name, name)
+		}
+	}
+	return nil
+}
+
+// --- Timeout Helper Methods ---
+
+// timeoutSeconds converts a timeout setting to a duration: 0 takes the default, negative disables it.
+func timeoutSeconds(seconds int, def time.Duration) time.Duration {
+	switch {
+	case seconds < 0:
+		return 0
+	case seconds == 0:
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ReadHeader returns the time allowed to read the request headers.
+func (t ServerTimeoutsConfig) ReadHeader() time.Duration {
+	return timeoutSeconds(t.ReadHeaderSeconds, 10*time.Second)
+}
+
+// Read returns the time allowed to read a whole request, or 0 for no limit.
+func (t ServerTimeoutsConfig) Read() time.Duration {
+	return timeoutSeconds(t.ReadSeconds, 15*time.Second)
+}
+
+// Write returns the time allowed to write a response, or 0 for no limit.
+func (t ServerTimeoutsConfig) Write() time.Duration {
+	return timeoutSeconds(t.WriteSeconds, 15*time.Second)
+}
+
+// Idle returns how long an idle keep-alive connection stays open, or 0 for no limit.
+func (t ServerTimeoutsConfig) Idle() time.Duration {
+	return timeoutSeconds(t.IdleSeconds, 120*time.Second)
+}
+
+// Delay returns how long the health check reports draining before the listener closes.
+func (s ServerShutdownConfig) Delay() time.Duration {
+	return time.Duration(s.DelaySeconds) * time.Second
+}
+
+// Drain returns the time the requests in flight have to finish on shutdown.
+func (s ServerShutdownConfig) Drain() time.Duration {
+	return timeoutSeconds(s.DrainSeconds, 30*time.Second)
+}
+
+// Dial returns the upstream connect timeout of the route, or 0 to keep the default.
+func (t *RouteTimeoutsConfig) Dial() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(t.DialMs) * time.Millisecond
+}
+
+// ResponseHeader returns the time the upstream has to send response headers, or 0 for no limit.
+func (t *RouteTimeoutsConfig) ResponseHeader() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(t.ResponseHeaderMs) * time.Millisecond
+}
+
+// Request returns the total deadline of a request on the route, or 0 when the server timeouts apply.
+func (t *RouteTimeoutsConfig) Request() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(t.RequestSeconds) * time.Second
+}
+
+// StreamIdle returns how long a WebSocket connection or streamed response may stay idle.
+func (t *RouteTimeoutsConfig) StreamIdle() time.Duration {
+	if t == nil || t.StreamIdleSeconds <= 0 {
+		return 300 * time.Second
+	}
+	return time.Duration(t.StreamIdleSeconds) * time.Second
+}
+
 // --- RouteOptions Helper Methods ---
 
 // getCacheControlHeader returns the appropriate Cache-Control header value based on the configuration.
@@ -392,3 +1674,109 @@ func (route *RouteConfig) GetCacheControlHeader() string {
 func (route *RouteConfig) ShouldSetCacheHeader() bool {
 	return route.Options != nil && route.Options.CacheControlSeconds != nil && *route.Options.CacheControlSeconds >= 0
 }
+
+// IsArchivePath reports whether the file name has the extension of a supported archive:
+// .zip, .tar.gz or .tgz.
+func IsArchivePath(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// ImmutableAssetsEnabled reports whether fingerprinted static files are cached as immutable.
+func (route *RouteConfig) ImmutableAssetsEnabled() bool {
+	return route.Options == nil || route.Options.ImmutableAssets == nil || *route.Options.ImmutableAssets
+}
+
+// DirectoryListingEnabled reports whether static folders without index.html list their files.
+func (route *RouteConfig) DirectoryListingEnabled() bool {
+	return route.Options == nil || route.Options.DirectoryListing == nil || *route.Options.DirectoryListing
+}
+
+// --- Error Page Helper Methods ---
+
+// ErrorPagesForRoute returns the error page templates of a route: the route ones over the
+// global ones.
+func (c *GatewayConfig) ErrorPagesForRoute(route *RouteConfig) map[string]string {
+	pages := maps.Clone(c.ErrorPages)
+	if pages == nil {
+		pages = make(map[string]string)
+	}
+	maps.Copy(pages, route.ErrorPages)
+	return pages
+}
+
+// --- Cache Helper Methods ---
+
+// StaleWhileRevalidate returns how long a stale response may be served while it is refreshed.
+func (rc *RouteCacheConfig) StaleWhileRevalidate() time.Duration {
+	if rc == nil {
+		return 0
+	}
+	return time.Duration(rc.StaleWhileRevalidateSeconds) * time.Second
+}
+
+// StaleIfError returns how long a stale response may be served when the upstream fails.
+func (rc *RouteCacheConfig) StaleIfError() time.Duration {
+	if rc == nil {
+		return 0
+	}
+	return time.Duration(rc.StaleIfErrorSeconds) * time.Second
+}
+
+// --- Collapse Helper Methods ---
+
+// MaxWait returns how long a request waits for the response of an identical request.
+func (cc *CollapseConfig) MaxWait() time.Duration {
+	if cc.MaxWaitMs <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(cc.MaxWaitMs) * time.Millisecond
+}
+
+// MaxBodyBytes returns the largest response body shared with waiting requests.
+func (cc *CollapseConfig) MaxBodyBytes() int64 {
+	if cc.MaxBodyKB <= 0 {
+		return 1 << 20
+	}
+	return int64(cc.MaxBodyKB) << 10
+}
+
+// --- Compression Helper Methods ---
+
+// ForRoute returns the compression settings of a route: the route block over the global
+// one, with the defaults applied.
+func (c CompressionConfig) ForRoute(rc *RouteCompressionConfig) CompressionConfig {
+	settings := c
+	if rc != nil {
+		settings.Enabled = rc.Enabled == nil || *rc.Enabled
+		if len(rc.Algorithms) > 0 {
+			settings.Algorithms = rc.Algorithms
+		}
+		if rc.MinSizeBytes > 0 {
+			settings.MinSizeBytes = rc.MinSizeBytes
+		}
+		if len(rc.ContentTypes) > 0 {
+			settings.ContentTypes = rc.ContentTypes
+		}
+	}
+	if len(settings.Algorithms) == 0 {
+		settings.Algorithms = []string{CompressionBrotli, CompressionZstd, CompressionGzip}
+	}
+	if settings.MinSizeBytes <= 0 {
+		settings.MinSizeBytes = 1024
+	}
+	if len(settings.ContentTypes) == 0 {
+		settings.ContentTypes = DefaultCompressionContentTypes
+	}
+	return settings
+}
+
+// --- Reload Helper Methods ---
+
+// WatchInterval returns how often the configuration file is checked for changes.
+func (rc ReloadConfig) WatchInterval() time.Duration {
+	if rc.WatchIntervalSeconds <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(rc.WatchIntervalSeconds) * time.Second
+}
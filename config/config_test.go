@@ -1,9 +1,14 @@
 package config
 
 import (
+	"crypto/tls"
+	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v3"
 )
 
 func TestRouteOptions_GetCacheControlHeader(t *testing.T) {
@@ -141,6 +146,658 @@ func TestRouteConfig_CacheControlMethods(t *testing.T) {
 	assert.True(t, route3.ShouldSetCacheHeader())
 }
 
+func TestRouteConfig_UpstreamTargets(t *testing.T) {
+	single := &RouteConfig{Name: "single", To: "http://a:1"}
+	assert.Equal(t, []UpstreamTarget{{URL: "http://a:1", Weight: 1}}, single.UpstreamTargets())
+	assert.Equal(t, "http://a:1", single.UpstreamDescription())
+
+	multi := &RouteConfig{Name: "multi", Targets: []UpstreamTarget{{URL: "http://a:1", Weight: 2}, {URL: "http://b:1"}}}
+	assert.Len(t, multi.UpstreamTargets(), 2)
+	assert.Equal(t, "http://a:1, http://b:1", multi.UpstreamDescription())
+
+	assert.Empty(t, (&RouteConfig{Name: "none"}).UpstreamTargets())
+}
+
+func TestRouteConfig_ValidateUpstreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   RouteConfig
+		wantErr bool
+	}{
+		{
+			name:  "single to",
+			route: RouteConfig{Name: "r", To: "http://a:1"},
+		},
+		{
+			name:  "weighted targets",
+			route: RouteConfig{Name: "r", Targets: []UpstreamTarget{{URL: "http://a:1", Weight: 3}, {URL: "http://b:1"}}, LoadBalancing: LoadBalancingConfig{Strategy: StrategyWeighted}},
+		},
+		{
+			name:    "to and targets together",
+			route:   RouteConfig{Name: "r", To: "http://a:1", Targets: []UpstreamTarget{{URL: "http://b:1"}}},
+			wantErr: true,
+		},
+		{
+			name:    "target without url",
+			route:   RouteConfig{Name: "r", Targets: []UpstreamTarget{{Weight: 1}}},
+			wantErr: true,
+		},
+		{
+			name:    "negative weight",
+			route:   RouteConfig{Name: "r", Targets: []UpstreamTarget{{URL: "http://a:1", Weight: -1}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown strategy",
+			route:   RouteConfig{Name: "r", To: "http://a:1", LoadBalancing: LoadBalancingConfig{Strategy: "fastest"}},
+			wantErr: true,
+		},
+		{
+			name:    "header hash without key",
+			route:   RouteConfig{Name: "r", To: "http://a:1", LoadBalancing: LoadBalancingConfig{Strategy: StrategyConsistentHash, HashOn: "header"}},
+			wantErr: true,
+		},
+		{
+			name:  "health check",
+			route: RouteConfig{Name: "r", To: "http://a:1", HealthCheck: &HealthCheckConfig{Path: "/healthz", Passive: PassiveHealthCheckConfig{MaxFailures: 5}}},
+		},
+		{
+			name:    "health check path without slash",
+			route:   RouteConfig{Name: "r", To: "http://a:1", HealthCheck: &HealthCheckConfig{Path: "healthz"}},
+			wantErr: true,
+		},
+		{
+			name:    "negative health check interval",
+			route:   RouteConfig{Name: "r", To: "http://a:1", HealthCheck: &HealthCheckConfig{IntervalSeconds: -1}},
+			wantErr: true,
+		},
+		{
+			name:  "circuit breaker",
+			route: RouteConfig{Name: "r", To: "http://a:1", CircuitBreaker: &CircuitBreakerConfig{ConsecutiveFailures: 5, Fallback: CircuitBreakerFallback{Route: "other"}}},
+		},
+		{
+			name:    "circuit breaker without trip condition",
+			route:   RouteConfig{Name: "r", To: "http://a:1", CircuitBreaker: &CircuitBreakerConfig{OpenSeconds: 10}},
+			wantErr: true,
+		},
+		{
+			name:    "circuit breaker ratio above one",
+			route:   RouteConfig{Name: "r", To: "http://a:1", CircuitBreaker: &CircuitBreakerConfig{FailureRatio: 1.5}},
+			wantErr: true,
+		},
+		{
+			name:    "circuit breaker falling back to itself",
+			route:   RouteConfig{Name: "r", To: "http://a:1", CircuitBreaker: &CircuitBreakerConfig{ConsecutiveFailures: 1, Fallback: CircuitBreakerFallback{Route: "r"}}},
+			wantErr: true,
+		},
+		{
+			name:  "retry policy",
+			route: RouteConfig{Name: "r", To: "http://a:1", Retry: &RetryConfig{Attempts: 3, StatusCodes: []int{503}, Errors: []string{RetryOnReset, RetryOnTimeout}}},
+		},
+		{
+			name:    "retry with unknown error kind",
+			route:   RouteConfig{Name: "r", To: "http://a:1", Retry: &RetryConfig{Errors: []string{"refused"}}},
+			wantErr: true,
+		},
+		{
+			name:    "retry with invalid status code",
+			route:   RouteConfig{Name: "r", To: "http://a:1", Retry: &RetryConfig{StatusCodes: []int{1000}}},
+			wantErr: true,
+		},
+		{
+			name:    "retry with negative per-try timeout",
+			route:   RouteConfig{Name: "r", To: "http://a:1", Retry: &RetryConfig{PerTryTimeoutMs: -1}},
+			wantErr: true,
+		},
+		{
+			name:  "cookie hash with key",
+			route: RouteConfig{Name: "r", To: "http://a:1", LoadBalancing: LoadBalancingConfig{Strategy: StrategyConsistentHash, HashOn: "cookie", HashKey: "sid"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.route.validateUpstreams()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRouteConfig_ValidateMatching(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   RouteConfig
+		wantErr bool
+	}{
+		{
+			name:  "no conditions",
+			route: RouteConfig{Name: "r"},
+		},
+		{
+			name:  "exact and wildcard hosts",
+			route: RouteConfig{Name: "r", Hosts: []string{"api.example.com", "*.example.com"}},
+		},
+		{
+			name:    "host with port",
+			route:   RouteConfig{Name: "r", Hosts: []string{"api.example.com:8080"}},
+			wantErr: true,
+		},
+		{
+			name:    "wildcard in the middle of a host",
+			route:   RouteConfig{Name: "r", Hosts: []string{"api.*.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid method",
+			route:   RouteConfig{Name: "r", Methods: []string{"GET POST"}},
+			wantErr: true,
+		},
+		{
+			name:  "header and query conditions",
+			route: RouteConfig{Name: "r", Match: &RouteMatchConfig{Headers: []ValueMatch{{Name: "X-Version", Regex: "^v[23]$"}}, Query: []ValueMatch{{Name: "beta"}}}},
+		},
+		{
+			name:    "condition without name",
+			route:   RouteConfig{Name: "r", Match: &RouteMatchConfig{Query: []ValueMatch{{Value: "1"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "condition with value and regex",
+			route:   RouteConfig{Name: "r", Match: &RouteMatchConfig{Headers: []ValueMatch{{Name: "X-Version", Value: "v2", Regex: "v2"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid regex",
+			route:   RouteConfig{Name: "r", Match: &RouteMatchConfig{Headers: []ValueMatch{{Name: "X-Version", Regex: "v[2"}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.route.validateMatching()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRouteConfig_ValidateMatchingNormalizes(t *testing.T) {
+	route := RouteConfig{Name: "r", Hosts: []string{"API.Example.com."}, Methods: []string{"get", "Post"}}
+	assert.NoError(t, route.validateMatching())
+	assert.Equal(t, []string{"api.example.com"}, route.Hosts)
+	assert.Equal(t, []string{"GET", "POST"}, route.Methods)
+}
+
+func TestRouteConfig_ValidateRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   RouteConfig
+		wantErr bool
+	}{
+		{
+			name:  "wildcards",
+			route: RouteConfig{Name: "r", From: "/api/boxes/*/certs/*", Rewrite: &RewriteConfig{Path: "/v2/certificates/{w1}?box={w0}"}},
+		},
+		{
+			name:    "wildcard the route does not have",
+			route:   RouteConfig{Name: "r", From: "/api/*", Rewrite: &RewriteConfig{Path: "/v2/{w1}"}},
+			wantErr: true,
+		},
+		{
+			name:  "regex groups",
+			route: RouteConfig{Name: "r", From: "/users/*", Rewrite: &RewriteConfig{Path: "/people/{id}/{2}", Regex: `^/users/(?P<id>\d+)/(.*)$`}},
+		},
+		{
+			name:    "invalid regex",
+			route:   RouteConfig{Name: "r", From: "/users/*", Rewrite: &RewriteConfig{Regex: "(unclosed"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown placeholder in query",
+			route:   RouteConfig{Name: "r", From: "/search/*", Rewrite: &RewriteConfig{Query: RewriteQueryConfig{Set: map[string]string{"q": "{term}"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "path without leading slash",
+			route:   RouteConfig{Name: "r", From: "/api/*", Rewrite: &RewriteConfig{Path: "v2/{w0}"}},
+			wantErr: true,
+		},
+		{
+			name:    "together with removeFromPath",
+			route:   RouteConfig{Name: "r", From: "/api/*", RemoveFromPath: "/api", Rewrite: &RewriteConfig{Path: "/{w0}"}},
+			wantErr: true,
+		},
+		{
+			name:    "static file route",
+			route:   RouteConfig{Name: "r", From: "/file", Static: true, ToFile: "a.txt", Rewrite: &RewriteConfig{Path: "/b.txt"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.route.validateRewrite()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRouteConfig_ValidateHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   RouteConfig
+		wantErr bool
+	}{
+		{
+			name: "templates",
+			route: RouteConfig{Name: "r",
+				RequestHeaders:  &HeadersConfig{Set: map[string]string{"X-User": "{user.id} ({user.email})"}, Remove: []string{"Cookie"}},
+				ResponseHeaders: &HeadersConfig{Add: map[string]string{"X-Request-Id": "{request.id}"}},
+			},
+		},
+		{
+			name:    "unknown placeholder",
+			route:   RouteConfig{Name: "r", RequestHeaders: &HeadersConfig{Set: map[string]string{"X-User": "{user.name}"}}},
+			wantErr: true,
+		},
+		{
+			name:    "header name with a space",
+			route:   RouteConfig{Name: "r", ResponseHeaders: &HeadersConfig{Set: map[string]string{"X Frame": "DENY"}}},
+			wantErr: true,
+		},
+		{
+			name:    "header name with a colon",
+			route:   RouteConfig{Name: "r", RequestHeaders: &HeadersConfig{Remove: []string{"X-Debug:"}}},
+			wantErr: true,
+		},
+		{
+			name:  "identity header without authentication",
+			route: RouteConfig{Name: "r", RequestHeaders: &HeadersConfig{Set: map[string]string{"X-User-Id": "tenant-1"}}},
+		},
+		{
+			name: "identity header with authentication",
+			route: RouteConfig{Name: "r", Authentication: AuthenticationConfig{Enabled: true},
+				RequestHeaders: &HeadersConfig{Add: map[string]string{"x-user-data": "{user.email}"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.route.validateHeaders()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestIdentityJWTConfig_Validate(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+	tests := []struct {
+		name    string
+		cfg     IdentityJWTConfig
+		wantErr bool
+	}{
+		{name: "disabled", cfg: IdentityJWTConfig{}},
+		{name: "hmac", cfg: IdentityJWTConfig{Enabled: true, Secret: secret}},
+		{name: "rsa", cfg: IdentityJWTConfig{Enabled: true, Algorithm: "RS512", PrivateKeyFile: "key.pem"}},
+		{name: "short secret", cfg: IdentityJWTConfig{Enabled: true, Secret: "short"}, wantErr: true},
+		{name: "no key", cfg: IdentityJWTConfig{Enabled: true}, wantErr: true},
+		{name: "rsa algorithm with a secret", cfg: IdentityJWTConfig{Enabled: true, Algorithm: "RS256", Secret: secret}, wantErr: true},
+		{name: "secret and key", cfg: IdentityJWTConfig{Enabled: true, Secret: secret, PrivateKeyFile: "key.pem"}, wantErr: true},
+		{name: "unsupported algorithm", cfg: IdentityJWTConfig{Enabled: true, Algorithm: "none", Secret: secret}, wantErr: true},
+		{name: "invalid header", cfg: IdentityJWTConfig{Enabled: true, Secret: secret, Header: "X User"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRewriteConfig_UnmarshalYAML(t *testing.T) {
+	var routes []RouteConfig
+	err := yaml.Unmarshal([]byte(`
+- name: short
+  rewrite: /v2/{w0}
+- name: full
+  rewrite:
+    regex: ^/a/(.*)$
+    query:
+      remove: [debug]
+`), &routes)
+	assert.NoError(t, err)
+	assert.Equal(t, "/v2/{w0}", routes[0].Rewrite.Path)
+	assert.Equal(t, "^/a/(.*)$", routes[1].Rewrite.Regex)
+	assert.Equal(t, []string{"debug"}, routes[1].Rewrite.Query.Remove)
+}
+
+func TestRetryConfig_Defaults(t *testing.T) {
+	rc := &RetryConfig{}
+	assert.Equal(t, 3, rc.MaxAttempts())
+	assert.True(t, rc.IsRetryableMethod("get"))
+	assert.True(t, rc.IsRetryableMethod("PUT"))
+	assert.False(t, rc.IsRetryableMethod("POST"))
+	assert.True(t, rc.IsRetryableStatus(502))
+	assert.False(t, rc.IsRetryableStatus(500))
+	assert.True(t, rc.RetriesOn(RetryOnConnectFailure))
+	assert.Equal(t, int64(1<<20), rc.BodyLimit())
+	assert.Equal(t, time.Duration(0), rc.PerTryTimeout())
+
+	rc = &RetryConfig{Methods: []string{"POST"}, StatusCodes: []int{500}, Errors: []string{RetryOnTimeout}}
+	assert.True(t, rc.IsRetryableMethod("POST"))
+	assert.False(t, rc.IsRetryableMethod("GET"))
+	assert.True(t, rc.IsRetryableStatus(500))
+	assert.False(t, rc.IsRetryableStatus(502))
+	assert.False(t, rc.RetriesOn(RetryOnReset))
+}
+
+func TestTimeoutsConfig(t *testing.T) {
+	server := ServerTimeoutsConfig{}
+	assert.Equal(t, 10*time.Second, server.ReadHeader())
+	assert.Equal(t, 15*time.Second, server.Read())
+	assert.Equal(t, 15*time.Second, server.Write())
+	assert.Equal(t, 120*time.Second, server.Idle())
+
+	server = ServerTimeoutsConfig{ReadSeconds: 30, WriteSeconds: -1}
+	assert.Equal(t, 30*time.Second, server.Read())
+	assert.Equal(t, time.Duration(0), server.Write(), "-1 disables the timeout")
+
+	var route *RouteTimeoutsConfig
+	assert.Equal(t, time.Duration(0), route.Request(), "routes without timeouts use the server ones")
+
+	route = &RouteTimeoutsConfig{DialMs: 500, ResponseHeaderMs: 2000, RequestSeconds: 600}
+	assert.Equal(t, 500*time.Millisecond, route.Dial())
+	assert.Equal(t, 2*time.Second, route.ResponseHeader())
+	assert.Equal(t, 10*time.Minute, route.Request())
+
+	shutdown := ServerShutdownConfig{}
+	assert.Equal(t, time.Duration(0), shutdown.Delay())
+	assert.Equal(t, 30*time.Second, shutdown.Drain())
+	shutdown = ServerShutdownConfig{DelaySeconds: 5, DrainSeconds: 60}
+	assert.Equal(t, 5*time.Second, shutdown.Delay())
+	assert.Equal(t, time.Minute, shutdown.Drain())
+}
+
+func TestTrustedProxiesConfig(t *testing.T) {
+	server := ServerConfig{TrustedProxies: []string{"10.1.2.3/8", "192.168.1.10", "::ffff:172.16.0.1", "fd00::/8"}}
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.10/32"),
+		netip.MustParsePrefix("172.16.0.1/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}, server.TrustedProxyPrefixes())
+	assert.Equal(t, "X-Forwarded-For", server.GetForwardedHeader())
+	server.ForwardedHeader = "Forwarded"
+	assert.Equal(t, "Forwarded", server.GetForwardedHeader())
+
+	for _, entry := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0.1:8080", ""} {
+		_, err := parseProxyPrefix(entry)
+		assert.Error(t, err, entry)
+	}
+
+	proxyProtocol := &ProxyProtocolConfig{AllowedSources: []string{"10.0.0.0/8", "2001:db8::1"}}
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+	}, proxyProtocol.SourcePrefixes())
+}
+
+func TestRateLimitPolicyConfig(t *testing.T) {
+	policy := RateLimitPolicyConfig{Requests: 10}
+	assert.Equal(t, time.Minute, policy.Period())
+	assert.Equal(t, 10, policy.BurstSize())
+	assert.Equal(t, RateLimitKeyIP, policy.KeyType())
+
+	policy = RateLimitPolicyConfig{Requests: 100, PeriodSeconds: 1, Burst: 20, Key: "header:x-api-key"}
+	assert.Equal(t, time.Second, policy.Period())
+	assert.Equal(t, 20, policy.BurstSize())
+	assert.Equal(t, RateLimitKeyHeader, policy.KeyType())
+	assert.Equal(t, "X-Api-Key", policy.HeaderName())
+	assert.Empty(t, RateLimitPolicyConfig{Key: "user"}.HeaderName())
+
+	assert.Equal(t, 30, RateLimiterConfig{RequestsPerMinute: 30}.GlobalBurst())
+	assert.Equal(t, 5, RateLimiterConfig{RequestsPerMinute: 30, Burst: 5}.GlobalBurst())
+	assert.True(t, RateLimiterConfig{Policies: map[string]RateLimitPolicyConfig{"search": policy}}.IsEnabled())
+
+	valid := map[string]RateLimitPolicyConfig{"search": policy, "login": {Requests: 10, Key: "user", Paths: []string{"/_/login"}}}
+	assert.NoError(t, RateLimiterConfig{Policies: valid}.validate())
+	for name, invalid := range map[string]RateLimitPolicyConfig{
+		"no requests": {},
+		"negative":    {Requests: 1, Burst: -1},
+		"key":         {Requests: 1, Key: "session"},
+		"ip suffix":   {Requests: 1, Key: "ip:x"},
+		"header":      {Requests: 1, Key: "header:"},
+		"path":        {Requests: 1, Paths: []string{"login"}},
+	} {
+		assert.Error(t, RateLimiterConfig{Policies: map[string]RateLimitPolicyConfig{"p": invalid}}.validate(), name)
+	}
+
+	route := RouteConfig{Name: "Search", RateLimit: []string{"search"}}
+	assert.NoError(t, route.validateRateLimit(valid))
+	route.RateLimit = []string{"missing"}
+	assert.EqualError(t, route.validateRateLimit(valid), "route 'Search' rateLimit policy 'missing' does not exist")
+}
+
+func TestRateLimitStorageConfig(t *testing.T) {
+	assert.Equal(t, RateLimitStorageMemory, RateLimiterConfig{}.StorageType())
+	var storage *RateLimitStorageConfig
+	assert.Equal(t, 5*time.Second, storage.SyncInterval())
+
+	storage = &RateLimitStorageConfig{Type: RateLimitStorageDatabase, SyncSeconds: 2, Batch: true}
+	cfg := RateLimiterConfig{Storage: storage}
+	assert.Equal(t, RateLimitStorageDatabase, cfg.StorageType())
+	assert.True(t, cfg.IsShared())
+	assert.False(t, RateLimiterConfig{}.IsShared())
+	assert.Equal(t, 2*time.Second, storage.SyncInterval())
+	assert.NoError(t, cfg.validate())
+
+	assert.EqualError(t, RateLimiterConfig{Storage: &RateLimitStorageConfig{Type: "redis"}}.validate(),
+		"management.rateLimiter.storage.type 'redis' is not supported, available: memory, database")
+	assert.Error(t, RateLimiterConfig{Storage: &RateLimitStorageConfig{SyncSeconds: -1}}.validate())
+	assert.Error(t, RateLimiterConfig{Storage: &RateLimitStorageConfig{Type: RateLimitStorageDatabase, Batch: true, FailClosed: true}}.validate())
+	assert.NoError(t, RateLimiterConfig{Storage: &RateLimitStorageConfig{Type: RateLimitStorageDatabase, FailClosed: true}}.validate())
+}
+
+func TestCacheConfig(t *testing.T) {
+	cfg := CacheConfig{}
+	assert.Equal(t, int64(64<<20), cfg.MaxMemoryBytes())
+	assert.Equal(t, int64(1<<20), cfg.MaxEntryBytes())
+	assert.Equal(t, int64(1<<30), cfg.Disk.MaxSizeBytes())
+
+	var route *RouteCacheConfig
+	assert.Equal(t, time.Duration(0), route.StaleIfError())
+	route = &RouteCacheConfig{StaleWhileRevalidateSeconds: 30, StaleIfErrorSeconds: 600}
+	assert.Equal(t, 30*time.Second, route.StaleWhileRevalidate())
+	assert.Equal(t, 10*time.Minute, route.StaleIfError())
+
+	assert.NoError(t, (&RouteConfig{Name: "r", Cache: route}).validateCache())
+	assert.Error(t, (&RouteConfig{Name: "r", Static: true, Cache: route}).validateCache(), "static routes are not cached")
+	assert.Error(t, (&RouteConfig{Name: "r", Cache: &RouteCacheConfig{StaleIfErrorSeconds: -1}}).validateCache())
+}
+
+func TestCollapseConfig(t *testing.T) {
+	cc := &CollapseConfig{}
+	assert.Equal(t, 5*time.Second, cc.MaxWait())
+	assert.Equal(t, int64(1<<20), cc.MaxBodyBytes())
+	cc = &CollapseConfig{MaxWaitMs: 250, MaxBodyKB: 64, Headers: []string{"Accept-Language"}}
+	assert.Equal(t, 250*time.Millisecond, cc.MaxWait())
+	assert.Equal(t, int64(64<<10), cc.MaxBodyBytes())
+
+	assert.NoError(t, (&RouteConfig{Name: "r", Collapse: cc}).validateCollapse())
+	assert.Error(t, (&RouteConfig{Name: "r", Static: true, Collapse: cc}).validateCollapse(), "static routes are not collapsed")
+	assert.Error(t, (&RouteConfig{Name: "r", Collapse: &CollapseConfig{MaxWaitMs: -1}}).validateCollapse())
+	assert.Error(t, (&RouteConfig{Name: "r", Collapse: &CollapseConfig{Headers: []string{"Accept Language"}}}).validateCollapse())
+}
+
+func TestCompressionConfig(t *testing.T) {
+	settings := CompressionConfig{}.ForRoute(nil)
+	assert.False(t, settings.Enabled)
+	assert.Equal(t, []string{CompressionBrotli, CompressionZstd, CompressionGzip}, settings.Algorithms)
+	assert.Equal(t, 1024, settings.MinSizeBytes)
+	assert.Equal(t, DefaultCompressionContentTypes, settings.ContentTypes)
+
+	global := CompressionConfig{Enabled: true, Algorithms: []string{CompressionGzip}, MinSizeBytes: 512}
+	settings = global.ForRoute(&RouteCompressionConfig{ContentTypes: []string{"application/json"}})
+	assert.True(t, settings.Enabled)
+	assert.Equal(t, []string{CompressionGzip}, settings.Algorithms)
+	assert.Equal(t, 512, settings.MinSizeBytes)
+	assert.Equal(t, []string{"application/json"}, settings.ContentTypes)
+
+	disabled := false
+	assert.False(t, global.ForRoute(&RouteCompressionConfig{Enabled: &disabled}).Enabled)
+	assert.True(t, CompressionConfig{}.ForRoute(&RouteCompressionConfig{}).Enabled, "a route block enables compression")
+
+	assert.NoError(t, validateCompressionAlgorithms("compression", []string{"br", "zstd", "gzip"}))
+	assert.Error(t, validateCompressionAlgorithms("compression", []string{"deflate"}))
+}
+
+func TestErrorPagesConfig(t *testing.T) {
+	assert.NoError(t, validateErrorPages("errorPages", map[string]string{"404": "404.html", "429": "busy.html", "5xx": "5xx.html", "4xx": "4xx.html"}))
+	assert.Error(t, validateErrorPages("errorPages", map[string]string{"200": "ok.html"}))
+	assert.Error(t, validateErrorPages("errorPages", map[string]string{"3xx": "redirect.html"}))
+	assert.Error(t, validateErrorPages("errorPages", map[string]string{"404": ""}))
+
+	gc := &GatewayConfig{ErrorPages: map[string]string{"404": "global-404.html", "5xx": "global-5xx.html"}}
+	route := &RouteConfig{Name: "r", ErrorPages: map[string]string{"404": "route-404.html"}}
+	assert.Equal(t, map[string]string{"404": "route-404.html", "5xx": "global-5xx.html"}, gc.ErrorPagesForRoute(route))
+	assert.Equal(t, "global-404.html", gc.ErrorPages["404"], "the global pages are not changed")
+	assert.Empty(t, (&GatewayConfig{}).ErrorPagesForRoute(&RouteConfig{}))
+}
+
+func TestStaticRouteOptions(t *testing.T) {
+	route := &RouteConfig{}
+	assert.True(t, route.ImmutableAssetsEnabled())
+	assert.True(t, route.DirectoryListingEnabled())
+
+	disabled := false
+	route.Options = &RouteOptions{ImmutableAssets: &disabled, DirectoryListing: &disabled}
+	assert.False(t, route.ImmutableAssetsEnabled())
+	assert.False(t, route.DirectoryListingEnabled())
+}
+
+func TestIsArchivePath(t *testing.T) {
+	for _, name := range []string{"site.zip", "/srv/site.tar.gz", "dist.TGZ"} {
+		assert.True(t, IsArchivePath(name), name)
+	}
+	for _, name := range []string{"site.tar", "site.gz", "site.zip.bak", "dist"} {
+		assert.False(t, IsArchivePath(name), name)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := &GatewayConfig{
+		Server: ServerConfig{Host: "0.0.0.0", Port: 8080},
+		Routes: []RouteConfig{
+			{Name: "Orders", From: "/orders/*", To: "http://orders"},
+			{Name: "Legacy", From: "/legacy/*", To: "http://legacy"},
+			{Name: "Site", From: "/*", Static: true, ToFolder: "/srv/site"},
+		},
+	}
+	new := &GatewayConfig{
+		Server: ServerConfig{Host: "0.0.0.0", Port: 9090},
+		Routes: []RouteConfig{
+			{Name: "Site", From: "/*", Static: true, ToFolder: "/srv/site"},
+			{Name: "Orders", From: "/orders/*", To: "http://orders-v2"},
+			{Name: "Users", From: "/users/*", To: "http://users"},
+		},
+	}
+
+	diff := DiffConfig(old, new)
+	assert.Equal(t, []string{"Users"}, diff.Added)
+	assert.Equal(t, []string{"Legacy"}, diff.Removed)
+	assert.Equal(t, []string{"Orders"}, diff.Changed)
+	assert.Equal(t, 1, diff.Unchanged, "the order of the routes does not count as a change")
+	assert.Equal(t, []string{"server"}, diff.RestartRequired)
+	assert.True(t, diff.HasChanges())
+
+	assert.False(t, DiffConfig(old, old).HasChanges())
+
+	withTLS := *old
+	withTLS.Server.TLS = &TLSConfig{Certificates: []TLSCertificateConfig{{CertFile: "server.crt", KeyFile: "server.key"}}}
+	assert.Equal(t, []string{"server"}, DiffConfig(old, &withTLS).RestartRequired)
+
+	shared := *old
+	shared.Management.RateLimiter.Storage = &RateLimitStorageConfig{Type: RateLimitStorageDatabase}
+	assert.Equal(t, []string{"management.rateLimiter.storage"}, DiffConfig(old, &shared).RestartRequired)
+}
+
+func TestTLSConfig(t *testing.T) {
+	pair := TLSCertificateConfig{CertFile: "server.crt", KeyFile: "server.key"}
+	valid := TLSConfig{Certificates: []TLSCertificateConfig{pair}}
+	assert.NoError(t, valid.validate(8443))
+	assert.Equal(t, uint16(tls.VersionTLS12), valid.MinTLSVersion())
+	assert.Nil(t, valid.CipherSuiteIDs(), "Go's default suites")
+
+	valid = TLSConfig{
+		Certificates:     []TLSCertificateConfig{pair},
+		MinVersion:       "1.3",
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		HTTPRedirectPort: 80,
+		ClientAuth:       &ClientAuthConfig{CAFile: "ca.pem", Mode: "require"},
+	}
+	assert.NoError(t, valid.validate(443))
+	assert.Equal(t, uint16(tls.VersionTLS13), valid.MinTLSVersion())
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, valid.CipherSuiteIDs())
+
+	invalid := map[string]TLSConfig{
+		"no certificates":     {},
+		"missing key":         {Certificates: []TLSCertificateConfig{{CertFile: "server.crt"}}},
+		"old version":         {Certificates: []TLSCertificateConfig{pair}, MinVersion: "1.0"},
+		"insecure cipher":     {Certificates: []TLSCertificateConfig{pair}, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		"redirect on port":    {Certificates: []TLSCertificateConfig{pair}, HTTPRedirectPort: 443},
+		"client CA missing":   {Certificates: []TLSCertificateConfig{pair}, ClientAuth: &ClientAuthConfig{}},
+		"unknown client mode": {Certificates: []TLSCertificateConfig{pair}, ClientAuth: &ClientAuthConfig{CAFile: "ca.pem", Mode: "always"}},
+		"acme terms":          {ACME: &ACMEConfig{Hosts: []string{"api.example.com"}}},
+		"acme wildcard":       {ACME: &ACMEConfig{AcceptTOS: true, Hosts: []string{"*.example.com"}}},
+		"acme renew":          {ACME: &ACMEConfig{AcceptTOS: true, RenewBeforeDays: -1}},
+	}
+	for name, tlsConfig := range invalid {
+		assert.Error(t, tlsConfig.validate(443), name)
+	}
+
+	acme := &ACMEConfig{AcceptTOS: true, Hosts: []string{"API.example.com."}}
+	assert.NoError(t, (&TLSConfig{ACME: acme}).validate(443), "certificates are optional with acme")
+	assert.Equal(t, []string{"api.example.com"}, acme.Hosts)
+	assert.Equal(t, DefaultACMEDirectoryURL, acme.Directory())
+	assert.Equal(t, 30*24*time.Hour, acme.RenewBefore())
+
+	acme = &ACMEConfig{AcceptTOS: true}
+	routes := []RouteConfig{{Hosts: []string{"api.example.com", "*.apps.example.com"}}, {Hosts: []string{"api.example.com", "www.example.com"}}, {}}
+	assert.NoError(t, acme.resolveHosts(routes))
+	assert.Equal(t, []string{"api.example.com", "www.example.com"}, acme.Hosts, "route hosts without wildcards")
+	assert.Error(t, (&ACMEConfig{AcceptTOS: true}).resolveHosts(nil), "no hosts")
+
+	route := &RouteClientCertConfig{}
+	assert.True(t, route.AllowsSubject("anyone"))
+	route.AllowedSubjects = []string{"billing", "*.services.example.com"}
+	assert.True(t, route.AllowsSubject("billing"))
+	assert.True(t, route.AllowsSubject("orders.services.example.com"))
+	assert.False(t, route.AllowsSubject("reports"))
+}
+
 // Helper function to create int pointers
 func intPtr(i int) *int {
 	return &i
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+)
+
+// ConfigDiff lists what changed between two configurations, for the reload logs and API
+type ConfigDiff struct {
+	Added     []string // routes only in the new configuration, by name
+	Removed   []string // routes only in the old configuration, by name
+	Changed   []string // routes in both with different settings, by name
+	Unchanged int      // number of routes with the same settings
+	// Settings that changed but only take effect after a restart, like "server"
+	RestartRequired []string
+}
+
+// HasChanges reports whether anything changed between the configurations
+func (d ConfigDiff) HasChanges() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Changed) > 0 || len(d.RestartRequired) > 0
+}
+
+// DiffConfig compares the routes of two configurations by name, and lists the settings
+// that changed but cannot be applied while the gateway runs.
+func DiffConfig(old, new *GatewayConfig) ConfigDiff {
+	var diff ConfigDiff
+
+	oldRoutes := make(map[string]RouteConfig, len(old.Routes))
+	for _, route := range old.Routes {
+		oldRoutes[route.Name] = route
+	}
+	seen := make(map[string]bool, len(new.Routes))
+	for _, route := range new.Routes {
+		seen[route.Name] = true
+		previous, ok := oldRoutes[route.Name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, route.Name)
+		case !reflect.DeepEqual(previous, route):
+			diff.Changed = append(diff.Changed, route.Name)
+		default:
+			diff.Unchanged++
+		}
+	}
+	for _, route := range old.Routes {
+		if !seen[route.Name] {
+			diff.Removed = append(diff.Removed, route.Name)
+		}
+	}
+
+	// The listener with its TLS and PROXY protocol settings, the sizes of the cache, the file
+	// watch and the storage of the rate limiter are set when the gateway starts
+	if old.Server.Host != new.Server.Host || old.Server.Port != new.Server.Port || old.Server.Timeouts != new.Server.Timeouts ||
+		!reflect.DeepEqual(old.Server.TLS, new.Server.TLS) || !reflect.DeepEqual(old.Server.ProxyProtocol, new.Server.ProxyProtocol) {
+		diff.RestartRequired = append(diff.RestartRequired, "server")
+	}
+	if old.Cache != new.Cache {
+		diff.RestartRequired = append(diff.RestartRequired, "cache")
+	}
+	if old.Management.Reload != new.Management.Reload {
+		diff.RestartRequired = append(diff.RestartRequired, "management.reload")
+	}
+	if !reflect.DeepEqual(old.Management.RateLimiter.Storage, new.Management.RateLimiter.Storage) {
+		diff.RestartRequired = append(diff.RestartRequired, "management.rateLimiter.storage")
+	}
+	if old.Geolocation != new.Geolocation {
+		diff.RestartRequired = append(diff.RestartRequired, "geolocation")
+	}
+	slices.Sort(diff.RestartRequired)
+	return diff
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TLSConfig enables HTTPS on the server port. The certificate of each connection is chosen
+// by the SNI hostname, and the files are loaded again when they change.
+type TLSConfig struct {
+	Certificates     []TLSCertificateConfig `yaml:"certificates"`     // Certificate/key pairs; the first one is served when no hostname matches. Required unless acme is set.
+	MinVersion       string                 `yaml:"minVersion"`       // Oldest TLS version accepted: "1.2" or "1.3". Default: "1.2"
+	CipherSuites     []string               `yaml:"cipherSuites"`     // TLS 1.2 cipher suites by name (e.g., "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"). TLS 1.3 suites are not configurable. Default: Go's secure suites
+	HTTPRedirectPort int                    `yaml:"httpRedirectPort"` // Port of a plain HTTP listener that redirects to HTTPS (e.g., 80). Also answers the ACME http-01 challenges. Optional.
+	ClientAuth       *ClientAuthConfig      `yaml:"clientAuth"`       // Client certificate (mutual TLS) verification. Optional.
+	ACME             *ACMEConfig            `yaml:"acme"`             // Certificates obtained and renewed automatically with ACME (e.g., Let's Encrypt). Optional.
+}
+
+// TLSCertificateConfig is a certificate/key pair and the hostnames it is served for.
+type TLSCertificateConfig struct {
+	Hosts    []string `yaml:"hosts"`    // SNI hostnames (e.g., "api.example.com", "*.example.com"). Default: the DNS names of the certificate
+	CertFile string   `yaml:"certFile"` // PEM certificate chain, leaf first. Required.
+	KeyFile  string   `yaml:"keyFile"`  // PEM private key. Required.
+}
+
+// ClientAuthConfig verifies the client certificates sent on the TLS handshake.
+type ClientAuthConfig struct {
+	CAFile string `yaml:"caFile"` // PEM certificates of the CAs that sign the client certificates. Required.
+	Mode   string `yaml:"mode"`   // "optional": verified when sent, routes decide; "require": every connection needs one. Default: "optional"
+}
+
+// Client certificate modes of ClientAuthConfig.
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// ACMEConfig obtains and renews the certificates of the hostnames with an ACME certificate
+// authority. The domain is validated with the tls-alpn-01 challenge on the server port, and
+// with http-01 on the httpRedirectPort when set. The certificate files of 'certificates'
+// take precedence for the hostnames they cover.
+type ACMEConfig struct {
+	AcceptTOS       bool     `yaml:"acceptTermsOfService"` // Agree to the terms of service of the certificate authority. Required: true
+	Email           string   `yaml:"email"`                // Contact of the ACME account, for expiry notices. Optional.
+	DirectoryURL    string   `yaml:"directoryUrl"`         // ACME directory (e.g., "https://localhost:14000/dir" for Pebble). Default: Let's Encrypt production
+	CAFile          string   `yaml:"caFile"`               // PEM certificates trusted for the directory connection, for test servers such as Pebble. Optional.
+	Hosts           []string `yaml:"hosts"`                // Hostnames to obtain certificates for; wildcards are not supported. Default: the hosts of the routes without wildcards
+	CacheDir        string   `yaml:"cacheDir"`             // Folder of the certificates and account key. Default: stored in the database
+	RenewBeforeDays int      `yaml:"renewBeforeDays"`      // Renew the certificates this many days before they expire. Default: 30
+}
+
+// DefaultACMEDirectoryURL is the production directory of Let's Encrypt
+const DefaultACMEDirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// RouteClientCertConfig requires a verified client certificate on a route, and forwards
+// its identity to the upstream.
+type RouteClientCertConfig struct {
+	Required        bool     `yaml:"required"`        // Reject requests without a verified client certificate with 403. Default: false (forwarded when sent)
+	AllowedSubjects []string `yaml:"allowedSubjects"` // Common names allowed (e.g., "billing", "*.services.example.com"). Default: any certificate of the CA
+}
+
+// --- TLS Helper Methods ---
+
+// MinTLSVersion returns the oldest TLS version accepted, applying the default.
+func (c *TLSConfig) MinTLSVersion() uint16 {
+	if c.MinVersion == "1.3" {
+		return tls.VersionTLS13
+	}
+	return tls.VersionTLS12
+}
+
+// CipherSuiteIDs returns the configured cipher suites, or nil for Go's defaults.
+// The names are expected to be validated.
+func (c *TLSConfig) CipherSuiteIDs() []uint16 {
+	var ids []uint16
+	for _, name := range c.CipherSuites {
+		if suite := secureCipherSuite(name); suite != nil {
+			ids = append(ids, suite.ID)
+		}
+	}
+	return ids
+}
+
+// ClientAuthMode returns the client certificate mode, applying the default.
+func (c *ClientAuthConfig) ClientAuthMode() string {
+	if c.Mode == "" {
+		return ClientAuthOptional
+	}
+	return c.Mode
+}
+
+// Directory returns the ACME directory URL, applying the default.
+func (c *ACMEConfig) Directory() string {
+	if c.DirectoryURL == "" {
+		return DefaultACMEDirectoryURL
+	}
+	return c.DirectoryURL
+}
+
+// RenewBefore returns how long before expiry the certificates are renewed, applying the default.
+func (c *ACMEConfig) RenewBefore() time.Duration {
+	if c.RenewBeforeDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(c.RenewBeforeDays) * 24 * time.Hour
+}
+
+// AllowsSubject reports whether a client certificate with the common name may use the route.
+func (c *RouteClientCertConfig) AllowsSubject(commonName string) bool {
+	if len(c.AllowedSubjects) == 0 {
+		return true
+	}
+	for _, pattern := range c.AllowedSubjects {
+		if matched, _ := path.Match(pattern, commonName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// secureCipherSuite returns the cipher suite with the name, nil when unknown or insecure.
+func secureCipherSuite(name string) *tls.CipherSuite {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite
+		}
+	}
+	return nil
+}
+
+// validate checks the TLS settings; the certificate files are read when the server starts.
+func (c *TLSConfig) validate(serverPort int) error {
+	if len(c.Certificates) == 0 && c.ACME == nil {
+		return fmt.Errorf("server.tls requires at least one certificate or acme")
+	}
+	for i, cert := range c.Certificates {
+		if cert.CertFile == "" || cert.KeyFile == "" {
+			return fmt.Errorf("server.tls.certificates[%d] requires 'certFile' and 'keyFile'", i)
+		}
+	}
+	if c.MinVersion != "" && c.MinVersion != "1.2" && c.MinVersion != "1.3" {
+		return fmt.Errorf("server.tls.minVersion '%s' is not supported, use 1.2 or 1.3", c.MinVersion)
+	}
+	for _, name := range c.CipherSuites {
+		if secureCipherSuite(name) == nil {
+			return fmt.Errorf("server.tls.cipherSuites '%s' is unknown or insecure", name)
+		}
+	}
+	if c.HTTPRedirectPort < 0 || (c.HTTPRedirectPort != 0 && c.HTTPRedirectPort == serverPort) {
+		return fmt.Errorf("server.tls.httpRedirectPort %d must differ from the server port", c.HTTPRedirectPort)
+	}
+	if ca := c.ClientAuth; ca != nil {
+		if ca.CAFile == "" {
+			return fmt.Errorf("server.tls.clientAuth requires 'caFile'")
+		}
+		if !slices.Contains([]string{ClientAuthOptional, ClientAuthRequire}, ca.ClientAuthMode()) {
+			return fmt.Errorf("server.tls.clientAuth.mode '%s' is not supported, use optional or require", ca.Mode)
+		}
+	}
+	if acme := c.ACME; acme != nil {
+		if !acme.AcceptTOS {
+			return fmt.Errorf("server.tls.acme requires 'acceptTermsOfService: true'")
+		}
+		if acme.RenewBeforeDays < 0 {
+			return fmt.Errorf("server.tls.acme.renewBeforeDays cannot be negative")
+		}
+		for i, host := range acme.Hosts {
+			host = strings.ToLower(strings.TrimSuffix(host, "."))
+			if !strings.Contains(host, ".") || strings.ContainsAny(host, "*:/ ") {
+				return fmt.Errorf("server.tls.acme.hosts has invalid host '%s', expected a host name such as 'api.example.com'", acme.Hosts[i])
+			}
+			acme.Hosts[i] = host
+		}
+	}
+	return nil
+}
+
+// resolveHosts defaults the ACME hostnames to the hosts of the routes that are not
+// wildcards, which ACME cannot validate with http-01 or tls-alpn-01. The route hosts are
+// expected to be normalized.
+func (c *ACMEConfig) resolveHosts(routes []RouteConfig) error {
+	if len(c.Hosts) == 0 {
+		for _, route := range routes {
+			for _, host := range route.Hosts {
+				if !strings.HasPrefix(host, "*") && strings.Contains(host, ".") && !slices.Contains(c.Hosts, host) {
+					c.Hosts = append(c.Hosts, host)
+				}
+			}
+		}
+	}
+	if len(c.Hosts) == 0 {
+		return fmt.Errorf("server.tls.acme has no hosts, set 'hosts' or the hosts of the routes")
+	}
+	return nil
+}
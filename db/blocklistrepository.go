@@ -0,0 +1,127 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BlockEventFilter selects block events; the empty fields select them all
+type BlockEventFilter struct {
+	Network  string     // blocked IP address or network
+	Reason   string     // rate, errors, scan or manual
+	ActiveAt *time.Time // only the blocks that apply at this time
+}
+
+// BlockListRepository stores the blocks of the rate limiter and its allowlist
+type BlockListRepository interface {
+	// CreateBlockEvent stores a new block
+	CreateBlockEvent(event *BlockEvent) error
+	// GetBlockEvent returns a block by ID, gorm.ErrRecordNotFound when there is none
+	GetBlockEvent(id string) (*BlockEvent, error)
+	// ListBlockEvents returns a page of the blocks, newest first, and the total count.
+	// A limit of 0 returns them all.
+	ListBlockEvents(filter BlockEventFilter, limit, offset int) ([]BlockEvent, int64, error)
+	// EndBlockEvent ends a block early; gorm.ErrRecordNotFound when it is missing or already ended
+	EndBlockEvent(id string, at time.Time, by string) error
+
+	// ListAllowlistEntries returns the allowlist, oldest first
+	ListAllowlistEntries() ([]AllowlistEntry, error)
+	// CreateAllowlistEntry adds an entry to the allowlist
+	CreateAllowlistEntry(entry *AllowlistEntry) error
+	// DeleteAllowlistEntry removes an entry; gorm.ErrRecordNotFound when it is missing
+	DeleteAllowlistEntry(id string) error
+}
+
+// BlockListRepositoryDB is a database implementation of BlockListRepository
+type BlockListRepositoryDB struct {
+	db *gorm.DB
+}
+
+// NewBlockListRepositoryDB creates a new database block list repository
+func NewBlockListRepositoryDB(db *gorm.DB) *BlockListRepositoryDB {
+	return &BlockListRepositoryDB{db: db}
+}
+
+// CreateBlockEvent stores a new block
+func (r *BlockListRepositoryDB) CreateBlockEvent(event *BlockEvent) error {
+	return r.db.Create(event).Error
+}
+
+// GetBlockEvent returns the block with the ID
+func (r *BlockListRepositoryDB) GetBlockEvent(id string) (*BlockEvent, error) {
+	var event BlockEvent
+	if err := r.db.Where("id = ?", id).First(&event).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// ListBlockEvents returns the blocks of the filter, newest first
+func (r *BlockListRepositoryDB) ListBlockEvents(filter BlockEventFilter, limit, offset int) ([]BlockEvent, int64, error) {
+	query := r.db.Model(&BlockEvent{})
+	if filter.Network != "" {
+		query = query.Where("network = ?", filter.Network)
+	}
+	if filter.Reason != "" {
+		query = query.Where("reason = ?", filter.Reason)
+	}
+	if filter.ActiveAt != nil {
+		query = query.Where("unblocked_at IS NULL AND started_at <= ? AND (ends_at IS NULL OR ends_at > ?)", *filter.ActiveAt, *filter.ActiveAt)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []BlockEvent
+	query = query.Order("started_at DESC").Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// EndBlockEvent sets the unblock time of an active block
+func (r *BlockListRepositoryDB) EndBlockEvent(id string, at time.Time, by string) error {
+	result := r.db.Model(&BlockEvent{}).
+		Where("id = ? AND unblocked_at IS NULL", id).
+		Updates(map[string]interface{}{"unblocked_at": at, "unblocked_by": by})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListAllowlistEntries returns every entry of the allowlist
+func (r *BlockListRepositoryDB) ListAllowlistEntries() ([]AllowlistEntry, error) {
+	var entries []AllowlistEntry
+	if err := r.db.Order("created_at ASC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CreateAllowlistEntry stores a new entry of the allowlist
+func (r *BlockListRepositoryDB) CreateAllowlistEntry(entry *AllowlistEntry) error {
+	return r.db.Create(entry).Error
+}
+
+// DeleteAllowlistEntry removes the entry with the ID
+func (r *BlockListRepositoryDB) DeleteAllowlistEntry(id string) error {
+	result := r.db.Where("id = ?", id).Delete(&AllowlistEntry{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
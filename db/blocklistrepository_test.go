@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestBlockListRepositoryBlockEvents(t *testing.T) {
+	testName := fmt.Sprintf("blocklistrepository_events_test_%d", time.Now().UnixNano())
+	SetupTestDB(testName)
+	repo := NewBlockListRepositoryDB(GetConnection())
+
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	later := now.Add(time.Hour)
+	old := &BlockEvent{Network: "203.0.113.7", Reason: BlockReasonRate, Path: "/api", StartedAt: now.Add(-time.Hour), EndsAt: &expired}
+	scan := &BlockEvent{Network: "203.0.113.7", Reason: BlockReasonScan, Path: "/.env", StartedAt: now.Add(-time.Second), EndsAt: &later}
+	manual := &BlockEvent{Network: "198.51.100.0/24", Reason: BlockReasonManual, Note: "abuse", CreatedBy: "admin", StartedAt: now}
+	for _, event := range []*BlockEvent{old, scan, manual} {
+		require.NoError(t, repo.CreateBlockEvent(event))
+		assert.NotEmpty(t, event.ID)
+	}
+
+	events, total, err := repo.ListBlockEvents(BlockEventFilter{}, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, events, 3)
+	assert.Equal(t, manual.ID, events[0].ID, "newest first")
+	assert.Equal(t, old.ID, events[2].ID)
+
+	events, total, err = repo.ListBlockEvents(BlockEventFilter{Network: "203.0.113.7"}, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, events, 1)
+	assert.Equal(t, old.ID, events[0].ID)
+
+	events, _, err = repo.ListBlockEvents(BlockEventFilter{Reason: BlockReasonManual}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Nil(t, events[0].EndsAt, "permanent block")
+
+	events, total, err = repo.ListBlockEvents(BlockEventFilter{ActiveAt: &now}, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total, "the expired block is not active")
+
+	require.NoError(t, repo.EndBlockEvent(scan.ID, now, "admin"))
+	ended, err := repo.GetBlockEvent(scan.ID)
+	require.NoError(t, err)
+	require.NotNil(t, ended.UnblockedAt)
+	assert.Equal(t, "admin", ended.UnblockedBy)
+	assert.False(t, ended.IsActive(now))
+	assert.ErrorIs(t, repo.EndBlockEvent(scan.ID, now, "admin"), gorm.ErrRecordNotFound, "already ended")
+	assert.ErrorIs(t, repo.EndBlockEvent("missing", now, "admin"), gorm.ErrRecordNotFound)
+
+	events, _, err = repo.ListBlockEvents(BlockEventFilter{ActiveAt: &now}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, manual.ID, events[0].ID)
+
+	_, err = repo.GetBlockEvent("missing")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestBlockListRepositoryAllowlist(t *testing.T) {
+	testName := fmt.Sprintf("blocklistrepository_allowlist_test_%d", time.Now().UnixNano())
+	SetupTestDB(testName)
+	repo := NewBlockListRepositoryDB(GetConnection())
+
+	office := &AllowlistEntry{Network: "192.0.2.0/24", Note: "office", CreatedBy: "admin"}
+	require.NoError(t, repo.CreateAllowlistEntry(office))
+	assert.NotEmpty(t, office.ID)
+	monitor := &AllowlistEntry{Network: "198.51.100.10", Note: "uptime monitor"}
+	require.NoError(t, repo.CreateAllowlistEntry(monitor))
+	assert.Error(t, repo.CreateAllowlistEntry(&AllowlistEntry{Network: "192.0.2.0/24"}), "networks are unique")
+
+	entries, err := repo.ListAllowlistEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "192.0.2.0/24", entries[0].Network)
+	assert.Equal(t, "office", entries[0].Note)
+
+	require.NoError(t, repo.DeleteAllowlistEntry(office.ID))
+	assert.ErrorIs(t, repo.DeleteAllowlistEntry(office.ID), gorm.ErrRecordNotFound)
+	entries, err = repo.ListAllowlistEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, monitor.ID, entries[0].ID)
+}
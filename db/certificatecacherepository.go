@@ -0,0 +1,49 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CertificateCacheRepository stores the certificates and account key of the ACME client
+type CertificateCacheRepository interface {
+	// GetCertificateData returns the data of the key, gorm.ErrRecordNotFound when there is none
+	GetCertificateData(key string) ([]byte, error)
+	// PutCertificateData creates or replaces the data of the key
+	PutCertificateData(key string, data []byte) error
+	// DeleteCertificateData removes the key; removing a missing key is not an error
+	DeleteCertificateData(key string) error
+}
+
+// CertificateCacheRepositoryDB is a database implementation of CertificateCacheRepository
+type CertificateCacheRepositoryDB struct {
+	db *gorm.DB
+}
+
+// NewCertificateCacheRepositoryDB creates a new database certificate cache repository
+func NewCertificateCacheRepositoryDB(db *gorm.DB) *CertificateCacheRepositoryDB {
+	return &CertificateCacheRepositoryDB{db: db}
+}
+
+// GetCertificateData returns the data stored under the key
+func (r *CertificateCacheRepositoryDB) GetCertificateData(key string) ([]byte, error) {
+	var entry CertificateCacheEntry
+	if err := r.db.Where("key = ?", key).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return entry.Data, nil
+}
+
+// PutCertificateData stores the data under the key, replacing the previous data
+func (r *CertificateCacheRepositoryDB) PutCertificateData(key string, data []byte) error {
+	entry := &CertificateCacheEntry{Key: key, Data: data}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
+	}).Create(entry).Error
+}
+
+// DeleteCertificateData removes the data stored under the key
+func (r *CertificateCacheRepositoryDB) DeleteCertificateData(key string) error {
+	return r.db.Where("key = ?", key).Delete(&CertificateCacheEntry{}).Error
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestCertificateCacheRepository(t *testing.T) {
+	testName := fmt.Sprintf("certificatecacherepository_test_%d", time.Now().UnixNano())
+	SetupTestDB(testName)
+	repo := NewCertificateCacheRepositoryDB(GetConnection())
+
+	_, err := repo.GetCertificateData("api.example.com")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	require.NoError(t, repo.PutCertificateData("api.example.com", []byte("first")))
+	data, err := repo.GetCertificateData("api.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), data)
+
+	require.NoError(t, repo.PutCertificateData("api.example.com", []byte("renewed")))
+	data, err = repo.GetCertificateData("api.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("renewed"), data, "put replaces the data")
+
+	require.NoError(t, repo.DeleteCertificateData("api.example.com"))
+	_, err = repo.GetCertificateData("api.example.com")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.NoError(t, repo.DeleteCertificateData("api.example.com"), "deleting a missing key")
+}
@@ -45,7 +45,7 @@ func Init() {
 	sqlDB.SetConnMaxLifetime(0) // No limit for SQLite
 
 	// Migrate the schema
-	err2 := db.AutoMigrate(&User{}, &Session{}, &TrafficMetric{}, &Token{}, &Counter{})
+	err2 := db.AutoMigrate(&User{}, &Session{}, &TrafficMetric{}, &Token{}, &Counter{}, &CertificateCacheEntry{}, &BlockEvent{}, &AllowlistEntry{}, &RateLimitCounter{}, &RateLimitBucket{}, &RateLimitBlock{})
 	if err2 != nil {
 		panic("Failed to migration DB: " + err2.Error())
 	}
@@ -93,6 +93,12 @@ func SetupTestDB(testName string) {
 		&TrafficMetric{},
 		&Token{},
 		&Counter{},
+		&CertificateCacheEntry{},
+		&BlockEvent{},
+		&AllowlistEntry{},
+		&RateLimitCounter{},
+		&RateLimitBucket{},
+		&RateLimitBlock{},
 	)
 	if err != nil {
 		panic("Failed to migrate test database: " + err.Error())
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimitStoreRepository stores the counters and blocks that gateway instances share for
+// their rate limiters
+type RateLimitStoreRepository interface {
+	// IncrementCounter adds n to the count of the key in the window, creating the counter, and
+	// returns the new count
+	IncrementCounter(key string, windowStart, n int64, expiresAt time.Time) (int64, error)
+	// AddCounts adds the counts to the counters of their key and window, creating them
+	AddCounts(counters []RateLimitCounter) error
+	// ListCounters returns the counters that have not expired at the time
+	ListCounters(at time.Time) ([]RateLimitCounter, error)
+	// DeleteCounters removes every window of the keys
+	DeleteCounters(keys ...string) error
+
+	// TakeBucket counts a request made at now against the token bucket of the key, of burst
+	// requests refilled one per interval. It returns the TAT of the bucket, moved forward when
+	// the request is allowed and as it was when it is denied.
+	TakeBucket(key string, now time.Time, interval time.Duration, burst int) (tat time.Time, allowed bool, err error)
+	// AddTakes moves the buckets forward by the requests taken, as if they were made at now
+	AddTakes(takes []RateLimitTake, now time.Time) error
+	// ListBuckets returns the buckets that are not full at the time
+	ListBuckets(at time.Time) ([]RateLimitBucket, error)
+	// DeleteBuckets removes the buckets of the keys
+	DeleteBuckets(keys ...string) error
+
+	// SetBlock creates or replaces the block of the key
+	SetBlock(key string, until time.Time) error
+	// DeleteBlock removes the block of the key; removing a missing block is not an error
+	DeleteBlock(key string) error
+	// ListBlocks returns the blocks that have not ended at the time
+	ListBlocks(at time.Time) ([]RateLimitBlock, error)
+
+	// DeleteExpired removes the counters, full buckets and blocks that expired at the time
+	DeleteExpired(at time.Time) error
+}
+
+// RateLimitTake is a number of requests taken from the token bucket of a key
+type RateLimitTake struct {
+	Key      string
+	Requests int64
+	Interval time.Duration // time to refill one request
+}
+
+// RateLimitStoreRepositoryDB is a database implementation of RateLimitStoreRepository
+type RateLimitStoreRepositoryDB struct {
+	db *gorm.DB
+}
+
+// NewRateLimitStoreRepositoryDB creates a new database rate limit store repository
+func NewRateLimitStoreRepositoryDB(db *gorm.DB) *RateLimitStoreRepositoryDB {
+	return &RateLimitStoreRepositoryDB{db: db}
+}
+
+// addCounts is the upsert that adds to the count of an existing counter, so concurrent
+// instances never overwrite each other
+var addCounts = clause.OnConflict{
+	Columns: []clause.Column{{Name: "key"}, {Name: "window_start"}},
+	DoUpdates: clause.Assignments(map[string]interface{}{
+		"count":      gorm.Expr("rate_limit_counters.count + excluded.count"),
+		"expires_at": gorm.Expr("excluded.expires_at"),
+	}),
+}
+
+// IncrementCounter adds n to the counter and returns the new count with the same upsert
+func (r *RateLimitStoreRepositoryDB) IncrementCounter(key string, windowStart, n int64, expiresAt time.Time) (int64, error) {
+	counter := &RateLimitCounter{Key: key, WindowStart: windowStart, Count: n, ExpiresAt: expiresAt}
+	err := r.db.Clauses(addCounts, clause.Returning{Columns: []clause.Column{{Name: "count"}}}).Create(counter).Error
+	return counter.Count, err
+}
+
+// AddCounts adds the counts of several counters in one transaction
+func (r *RateLimitStoreRepositoryDB) AddCounts(counters []RateLimitCounter) error {
+	if len(counters) == 0 {
+		return nil
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, counter := range counters {
+			if err := tx.Clauses(addCounts).Create(&counter).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListCounters returns the counters that expire after the time
+func (r *RateLimitStoreRepositoryDB) ListCounters(at time.Time) ([]RateLimitCounter, error) {
+	var counters []RateLimitCounter
+	if err := r.db.Where("expires_at > ?", at).Find(&counters).Error; err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
+
+// DeleteCounters removes the counters of the keys
+func (r *RateLimitStoreRepositoryDB) DeleteCounters(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.db.Where("key IN ?", keys).Delete(&RateLimitCounter{}).Error
+}
+
+// TakeBucket moves the TAT of the bucket forward by the interval with one upsert: an empty
+// bucket starts at now, and the update only happens when the bucket has room for the
+// request, so no row comes back for a denied one.
+func (r *RateLimitStoreRepositoryDB) TakeBucket(key string, now time.Time, interval time.Duration, burst int) (time.Time, bool, error) {
+	from := now.UnixNano()
+	bucket := &RateLimitBucket{Key: key, TAT: from + int64(interval)}
+	result := r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"tat": gorm.Expr("CASE WHEN rate_limit_buckets.tat > ? THEN rate_limit_buckets.tat + ? ELSE excluded.tat END", from, int64(interval)),
+		}),
+		// the request is allowed while max(tat, now) + interval is within burst intervals of now
+		Where: clause.Where{Exprs: []clause.Expression{
+			gorm.Expr("rate_limit_buckets.tat <= ?", from+int64(burst-1)*int64(interval)),
+		}},
+	}, clause.Returning{Columns: []clause.Column{{Name: "tat"}}}).Create(bucket)
+	if result.Error != nil {
+		return time.Time{}, false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return time.Unix(0, bucket.TAT), true, nil
+	}
+	var tat int64
+	if err := r.db.Model(&RateLimitBucket{}).Where("key = ?", key).Pluck("tat", &tat).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(0, tat), false, nil
+}
+
+// AddTakes moves the buckets of the takes forward in one transaction
+func (r *RateLimitStoreRepositoryDB) AddTakes(takes []RateLimitTake, now time.Time) error {
+	if len(takes) == 0 {
+		return nil
+	}
+	from := now.UnixNano()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, take := range takes {
+			add := take.Requests * int64(take.Interval)
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "key"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"tat": gorm.Expr("CASE WHEN rate_limit_buckets.tat > ? THEN rate_limit_buckets.tat + ? ELSE excluded.tat END", from, add),
+				}),
+			}).Create(&RateLimitBucket{Key: take.Key, TAT: from + add}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListBuckets returns the buckets whose TAT is after the time
+func (r *RateLimitStoreRepositoryDB) ListBuckets(at time.Time) ([]RateLimitBucket, error) {
+	var buckets []RateLimitBucket
+	if err := r.db.Where("tat > ?", at.UnixNano()).Find(&buckets).Error; err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// DeleteBuckets removes the buckets of the keys
+func (r *RateLimitStoreRepositoryDB) DeleteBuckets(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.db.Where("key IN ?", keys).Delete(&RateLimitBucket{}).Error
+}
+
+// SetBlock stores the block of the key, replacing the previous one
+func (r *RateLimitStoreRepositoryDB) SetBlock(key string, until time.Time) error {
+	block := &RateLimitBlock{Key: key, BlockedUntil: until}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"blocked_until"}),
+	}).Create(block).Error
+}
+
+// DeleteBlock removes the block of the key
+func (r *RateLimitStoreRepositoryDB) DeleteBlock(key string) error {
+	return r.db.Where("key = ?", key).Delete(&RateLimitBlock{}).Error
+}
+
+// ListBlocks returns the blocks that end after the time
+func (r *RateLimitStoreRepositoryDB) ListBlocks(at time.Time) ([]RateLimitBlock, error) {
+	var blocks []RateLimitBlock
+	if err := r.db.Where("blocked_until > ?", at).Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// DeleteExpired removes the counters and blocks that are no longer used
+func (r *RateLimitStoreRepositoryDB) DeleteExpired(at time.Time) error {
+	if err := r.db.Where("expires_at <= ?", at).Delete(&RateLimitCounter{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.Where("tat <= ?", at.UnixNano()).Delete(&RateLimitBucket{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("blocked_until <= ?", at).Delete(&RateLimitBlock{}).Error
+}
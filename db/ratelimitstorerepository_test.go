@@ -0,0 +1,156 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStoreRepositoryCounters(t *testing.T) {
+	testName := fmt.Sprintf("ratelimitstorerepository_counters_test_%d", time.Now().UnixNano())
+	SetupTestDB(testName)
+	repo := NewRateLimitStoreRepositoryDB(GetConnection())
+
+	now := time.Now()
+	expires := now.Add(2 * time.Minute)
+	count, err := repo.IncrementCounter("rate|203.0.113.7", 60, 1, expires)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = repo.IncrementCounter("rate|203.0.113.7", 60, 2, expires)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Concurrent increments are all counted
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.IncrementCounter("rate|203.0.113.7", 120, 1, expires)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, repo.AddCounts([]RateLimitCounter{
+		{Key: "rate|203.0.113.7", WindowStart: 120, Count: 5, ExpiresAt: expires},
+		{Key: "errors|203.0.113.7", WindowStart: 0, Count: 1, ExpiresAt: now.Add(-time.Second)},
+	}))
+	counters, err := repo.ListCounters(now)
+	require.NoError(t, err)
+	counts := make(map[int64]int64)
+	for _, counter := range counters {
+		counts[counter.WindowStart] = counter.Count
+	}
+	assert.Equal(t, map[int64]int64{60: 3, 120: 15}, counts, "the expired counter is not listed")
+
+	require.NoError(t, repo.DeleteExpired(now))
+	require.NoError(t, repo.DeleteCounters("errors|203.0.113.7"))
+	require.NoError(t, repo.DeleteCounters("rate|203.0.113.7"))
+	counters, err = repo.ListCounters(time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, counters)
+}
+
+func TestRateLimitStoreRepositoryBlocks(t *testing.T) {
+	testName := fmt.Sprintf("ratelimitstorerepository_blocks_test_%d", time.Now().UnixNano())
+	SetupTestDB(testName)
+	repo := NewRateLimitStoreRepositoryDB(GetConnection())
+
+	now := time.Now()
+	require.NoError(t, repo.SetBlock("203.0.113.7", now.Add(time.Minute)))
+	require.NoError(t, repo.SetBlock("203.0.113.7", now.Add(time.Hour)))
+	require.NoError(t, repo.SetBlock("198.51.100.1", now.Add(-time.Minute)))
+
+	blocks, err := repo.ListBlocks(now)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	assert.Equal(t, "203.0.113.7", blocks[0].Key)
+	assert.WithinDuration(t, now.Add(time.Hour), blocks[0].BlockedUntil, time.Second, "replaced")
+
+	require.NoError(t, repo.DeleteExpired(now))
+	blocks, err = repo.ListBlocks(time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, blocks, 1)
+
+	require.NoError(t, repo.DeleteBlock("203.0.113.7"))
+	require.NoError(t, repo.DeleteBlock("203.0.113.7"))
+	blocks, err = repo.ListBlocks(now)
+	require.NoError(t, err)
+	assert.Empty(t, blocks)
+}
+
+func TestRateLimitStoreRepositoryBuckets(t *testing.T) {
+	testName := fmt.Sprintf("ratelimitstorerepository_buckets_test_%d", time.Now().UnixNano())
+	SetupTestDB(testName)
+	repo := NewRateLimitStoreRepositoryDB(GetConnection())
+
+	// A burst of 3 requests refilled one per second
+	now := time.Unix(1000, 0)
+	for i := 1; i <= 3; i++ {
+		tat, allowed, err := repo.TakeBucket("policy|login|203.0.113.7", now, time.Second, 3)
+		require.NoError(t, err)
+		assert.True(t, allowed, "request %d", i)
+		assert.Equal(t, now.Add(time.Duration(i)*time.Second), tat)
+	}
+	tat, allowed, err := repo.TakeBucket("policy|login|203.0.113.7", now, time.Second, 3)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, now.Add(3*time.Second), tat, "a denied request does not move the TAT")
+
+	// One request refills every second; a full bucket starts again from now
+	tat, allowed, err = repo.TakeBucket("policy|login|203.0.113.7", now.Add(time.Second), time.Second, 3)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, now.Add(4*time.Second), tat)
+	tat, allowed, err = repo.TakeBucket("policy|login|203.0.113.7", now.Add(time.Minute), time.Second, 3)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, now.Add(time.Minute+time.Second), tat)
+
+	// Concurrent requests never take more than the burst
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for range 10 {
+		wg.Go(func() {
+			_, allowed, err := repo.TakeBucket("rate|198.51.100.1", now, time.Second, 4)
+			assert.NoError(t, err)
+			if allowed {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, 4, taken)
+
+	// Batched requests move the buckets as if they were made at once
+	require.NoError(t, repo.AddTakes([]RateLimitTake{
+		{Key: "rate|198.51.100.1", Requests: 2, Interval: time.Second},
+		{Key: "rate|192.0.2.1", Requests: 5, Interval: time.Second},
+	}, now))
+	buckets, err := repo.ListBuckets(now)
+	require.NoError(t, err)
+	tats := make(map[string]time.Time)
+	for _, bucket := range buckets {
+		tats[bucket.Key] = time.Unix(0, bucket.TAT)
+	}
+	assert.Equal(t, map[string]time.Time{
+		"policy|login|203.0.113.7": now.Add(time.Minute + time.Second),
+		"rate|198.51.100.1":        now.Add(6 * time.Second),
+		"rate|192.0.2.1":           now.Add(5 * time.Second),
+	}, tats)
+
+	require.NoError(t, repo.DeleteBuckets("rate|192.0.2.1"))
+	require.NoError(t, repo.DeleteExpired(now.Add(10*time.Second)))
+	buckets, err = repo.ListBuckets(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, buckets, 1, "the full and removed buckets are gone")
+	assert.Equal(t, "policy|login|203.0.113.7", buckets[0].Key)
+}
@@ -111,15 +111,24 @@ type Session struct {
 // This struct is used to store HTTP traffic metrics and analytics data
 type TrafficMetric struct {
 	gorm.Model
-	HttpMethod     string    `gorm:"type:varchar(10);not null"`  // HTTP method (GET, POST, etc.)
-	Path           string    `gorm:"type:varchar(500);not null"` // URL path of the request
-	HttpStatus     int       `gorm:"not null"`                   // HTTP status code of the response
-	ResponseTimeNs int64     `gorm:"not null"`                   // Time taken to process the request in nanoseconds
-	Timestamp      time.Time `gorm:"not null"`                   // Time when the request was received
-	ResponseSize   int64     `gorm:"default:0"`                  // Size of the response in bytes
-	Error          string    `gorm:"type:text"`                  // Any error message if the request failed
-	UserID         string    `gorm:"type:varchar(255)"`          // ID of the user making the request, if authenticated
-	SessionID      string    `gorm:"type:varchar(255)"`          // ID of the session, if applicable
+	HttpMethod        string    `gorm:"type:varchar(10);not null"`  // HTTP method (GET, POST, etc.)
+	Path              string    `gorm:"type:varchar(500);not null"` // URL path of the request
+	HttpStatus        int       `gorm:"not null"`                   // HTTP status code of the response
+	ResponseTimeNs    int64     `gorm:"not null"`                   // Time taken to process the request in nanoseconds
+	Timestamp         time.Time `gorm:"not null"`                   // Time when the request was received
+	ResponseSize      int64     `gorm:"default:0"`                  // Size of the response in bytes
+	Error             string    `gorm:"type:text"`                  // Any error message if the request failed
+	UserID            string    `gorm:"type:varchar(255)"`          // ID of the user making the request, if authenticated
+	SessionID         string    `gorm:"type:varchar(255)"`          // ID of the session, if applicable
+	RouteName         string    `gorm:"type:varchar(255);index"`    // Name of the proxy route that handled the request, if any
+	Upstream          string    `gorm:"type:varchar(500)"`          // Upstream target URL the request was sent to, if proxied
+	CircuitState      string    `gorm:"type:varchar(20)"`           // State of the route circuit breaker when the request was handled, if any
+	CircuitTransition string    `gorm:"type:varchar(40)"`           // Circuit breaker transition caused by the request (e.g. "closed->open"), if any
+	Attempts          int       `gorm:"default:0"`                  // Number of upstream attempts, including retries. 0 if not proxied
+	StreamType        string    `gorm:"type:varchar(20)"`           // "websocket", "sse" or "chunked" when the response was streamed
+	StreamBytesIn     int64     `gorm:"default:0"`                  // Bytes received from the client over an upgraded connection
+	StreamBytesOut    int64     `gorm:"default:0"`                  // Bytes sent to the client over an upgraded connection
+	Coalesced         bool      `gorm:"default:false"`              // Whether the response was shared from an identical request in flight
 	// Embed common client and geographical information
 	ClientInfo
 }
@@ -185,3 +194,93 @@ func (c *Counter) BeforeCreate(tx *gorm.DB) error {
 	c.ID = newId
 	return nil
 }
+
+// CertificateCacheEntry stores an ACME certificate, account key or challenge token by key
+type CertificateCacheEntry struct {
+	Key       string    `gorm:"primaryKey;column:key;type:varchar(255);not null"` // Hostname, "<hostname>+rsa", "<hostname>+token" or "acme_account+key"
+	Data      []byte    `gorm:"not null"`                                         // PEM encoded private key and certificate chain
+	UpdatedAt time.Time // When the entry was last written
+}
+
+// Reasons of a BlockEvent
+const (
+	BlockReasonRate   = "rate"   // over requestsPerMinute
+	BlockReasonErrors = "errors" // too many 401 and 404 responses
+	BlockReasonScan   = "scan"   // too many 404 responses on the vulnerability scan paths
+	BlockReasonManual = "manual" // blocked by an administrator
+)
+
+// BlockEvent records an IP address or network blocked by the rate limiter, with the request
+// that caused it. The active blocks are restored when the gateway starts.
+type BlockEvent struct {
+	ID          string     `gorm:"primaryKey;column:id;type:varchar(255);not null"`
+	Network     string     `gorm:"type:varchar(50);not null;index"` // Blocked IP address, or CIDR network of a manual block
+	Reason      string     `gorm:"type:varchar(20);not null;index"` // rate, errors, scan or manual
+	Path        string     `gorm:"type:varchar(500)"`               // Path of the request that caused the block, empty for manual blocks
+	Note        string     `gorm:"type:text"`                       // Why an administrator blocked it
+	CreatedBy   string     `gorm:"type:varchar(255)"`               // Administrator of a manual block, empty for automatic blocks
+	StartedAt   time.Time  `gorm:"not null;index"`                  // When the block started
+	EndsAt      *time.Time `gorm:"index"`                           // When the block ends (nullable for a permanent block)
+	UnblockedAt *time.Time // When an administrator ended the block early
+	UnblockedBy string     `gorm:"type:varchar(255)"` // Administrator who ended the block early
+
+	// Client information of the request that caused the block
+	ClientInfo
+}
+
+// BeforeCreate will set a CUID rather than numeric ID.
+func (b *BlockEvent) BeforeCreate(tx *gorm.DB) error {
+	newId, err := cuid.NewCrypto(rand.Reader)
+	if err != nil {
+		return err
+	}
+	b.ID = newId
+	return nil
+}
+
+// IsActive reports whether the block applies at the given time
+func (b *BlockEvent) IsActive(at time.Time) bool {
+	return b.UnblockedAt == nil && !at.Before(b.StartedAt) && (b.EndsAt == nil || at.Before(*b.EndsAt))
+}
+
+// AllowlistEntry is an IP address or network that the rate limiter never limits nor blocks
+type AllowlistEntry struct {
+	ID        string    `gorm:"primaryKey;column:id;type:varchar(255);not null"`
+	Network   string    `gorm:"type:varchar(50);not null;uniqueIndex"` // IP address or CIDR network
+	Note      string    `gorm:"type:text"`                             // Why it is allowed
+	CreatedBy string    `gorm:"type:varchar(255)"`                     // Administrator who added it
+	CreatedAt time.Time `gorm:"autoCreateTime"`                        // When it was added
+}
+
+// BeforeCreate will set a CUID rather than numeric ID.
+func (a *AllowlistEntry) BeforeCreate(tx *gorm.DB) error {
+	newId, err := cuid.NewCrypto(rand.Reader)
+	if err != nil {
+		return err
+	}
+	a.ID = newId
+	return nil
+}
+
+// RateLimitCounter counts the events of a rate limiter key in a window of time, for the gateway
+// instances that share their rate limiter. Every instance adds its events to the same row.
+type RateLimitCounter struct {
+	Key         string    `gorm:"primaryKey;column:key;type:varchar(255);not null"` // What is counted, like "rate|203.0.113.7"
+	WindowStart int64     `gorm:"primaryKey;autoIncrement:false"`                   // Start of the window, in Unix seconds
+	Count       int64     `gorm:"not null"`                                         // Events in the window
+	ExpiresAt   time.Time `gorm:"not null;index"`                                   // When the window no longer counts
+}
+
+// RateLimitBucket is the token bucket of a rate limiter key, for the gateway instances that
+// share their rate limiter. Like the buckets in memory, it keeps the theoretical arrival time
+// (TAT) of the next request, which every request moves forward.
+type RateLimitBucket struct {
+	Key string `gorm:"primaryKey;column:key;type:varchar(255);not null"` // What is limited, like "rate|203.0.113.7"
+	TAT int64  `gorm:"column:tat;not null;index"`                        // Theoretical arrival time, in Unix nanoseconds; the bucket is full after it
+}
+
+// RateLimitBlock is an IP address blocked by a gateway instance that shares its rate limiter
+type RateLimitBlock struct {
+	Key          string    `gorm:"primaryKey;column:key;type:varchar(255);not null"` // Blocked IP address
+	BlockedUntil time.Time `gorm:"not null;index"`                                   // When the block ends
+}
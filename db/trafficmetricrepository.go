@@ -16,6 +16,7 @@ type TrafficMetricRepository interface {
 	GetRequestCountByStatus(startDate, endDate time.Time) (map[int]int, error)
 	GetTotalRequestCount(startDate, endDate time.Time) (int64, error)
 	GetAverageResponseSize(startDate, endDate time.Time) (float64, error)
+	GetCoalescedRequestCount(startDate, endDate time.Time) (int64, error)
 	GetRequestCountByCountry(startDate, endDate time.Time) (map[string]int, error)
 	GetRequestCountByDeviceType(startDate, endDate time.Time) (map[string]int, error)
 	GetRequestCountByPlatform(startDate, endDate time.Time) (map[string]int, error)
@@ -145,6 +146,22 @@ func (r *TrafficMetricRepositoryDB) GetAverageResponseSize(startDate, endDate ti
 	return result.Average, nil
 }
 
+// GetCoalescedRequestCount returns the number of requests answered with the response of an
+// identical request in flight within a date range.
+func (r *TrafficMetricRepositoryDB) GetCoalescedRequestCount(startDate, endDate time.Time) (int64, error) {
+	var count int64
+	err := r.DB.Model(&TrafficMetric{}).
+		Where("timestamp BETWEEN ? AND ? AND coalesced = ?", startDate, endDate, true).
+		Count(&count).Error
+
+	if err != nil {
+		log.Printf("Error getting coalesced request count: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
 // GetRequestCountByCountry returns request counts grouped by country within a date range.
 func (r *TrafficMetricRepositoryDB) GetRequestCountByCountry(startDate, endDate time.Time) (map[string]int, error) {
 	var results []struct {
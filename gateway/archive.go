@@ -0,0 +1,389 @@
+package gateway
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// archiveCheckInterval is how often the archive of a static route is checked for changes
+var archiveCheckInterval = time.Second
+
+// The archive is held in memory uncompressed, so its files are bounded: an archive that
+// expands to more is rejected rather than filling the memory of the gateway.
+var (
+	maxArchiveBytes     int64 = 512 << 20 // all the files of an archive
+	maxArchiveFileBytes int64 = 128 << 20 // one file of an archive
+)
+
+// archiveFileSystem serves the files of a .zip or .tar.gz archive of a static route. The
+// archive is loaded in memory and loaded again when the file changes: the new content is
+// swapped in at once, and the requests being served keep the files they opened.
+type archiveFileSystem struct {
+	path      string
+	current   atomic.Pointer[archiveSnapshot]
+	checked   atomic.Int64 // last check for changes, in Unix nanoseconds
+	reloading sync.Mutex
+}
+
+// archiveSnapshot is the content of the archive at a point in time
+type archiveSnapshot struct {
+	files   http.FileSystem
+	size    int64
+	modTime time.Time
+}
+
+// newArchiveFileSystem loads the archive at path
+func newArchiveFileSystem(archivePath string) (*archiveFileSystem, error) {
+	a := &archiveFileSystem{path: archivePath}
+	snapshot, err := loadArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	a.current.Store(snapshot)
+	a.checked.Store(time.Now().UnixNano())
+	return a, nil
+}
+
+func (a *archiveFileSystem) Open(name string) (http.File, error) {
+	a.refresh()
+	return a.current.Load().files.Open(name)
+}
+
+// refresh loads the archive again when it changed since it was loaded. Only one request
+// per interval checks it; if the new archive cannot be read, the old content is kept.
+func (a *archiveFileSystem) refresh() {
+	now := time.Now().UnixNano()
+	last := a.checked.Load()
+	if now-last < int64(archiveCheckInterval) || !a.checked.CompareAndSwap(last, now) {
+		return
+	}
+	if !a.reloading.TryLock() {
+		return
+	}
+	defer a.reloading.Unlock()
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		// The archive may be in the middle of a replacement
+		return
+	}
+	current := a.current.Load()
+	if info.Size() == current.size && info.ModTime().Equal(current.modTime) {
+		return
+	}
+	snapshot, err := loadArchive(a.path)
+	if err != nil {
+		log.Printf("Warning: Static archive '%s' changed but could not be loaded, serving the previous content: %v", a.path, err)
+		return
+	}
+	a.current.Store(snapshot)
+	log.Printf("Static archive '%s' reloaded", a.path)
+}
+
+// loadArchive reads the archive at path into memory. The format is chosen by the extension.
+func loadArchive(archivePath string) (*archiveSnapshot, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	builder := &archiveBuilder{modTime: info.ModTime()}
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		err = builder.readZip(file, info.Size())
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		err = builder.readTarGz(file)
+	default:
+		err = errors.New("unsupported archive format, expected .zip, .tar.gz or .tgz")
+	}
+	if err == nil {
+		err = builder.checkNames()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive '%s': %w", archivePath, err)
+	}
+	return &archiveSnapshot{files: http.FS(builder.build()), size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// archiveBuilder collects the files of an archive
+type archiveBuilder struct {
+	modTime time.Time // modification time of the archive, given to all its files
+	files   map[string][]byte
+	dirs    []string
+	size    int64 // bytes of all the files read so far
+}
+
+func (b *archiveBuilder) readZip(r io.ReaderAt, size int64) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range reader.File {
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			b.addDir(f.Name)
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			content, err := b.readFile(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			b.addFile(f.Name, content)
+		}
+	}
+	return nil
+}
+
+func (b *archiveBuilder) readTarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			b.addDir(header.Name)
+		case tar.TypeReg:
+			content, err := b.readFile(header.Name, reader)
+			if err != nil {
+				return err
+			}
+			b.addFile(header.Name, content)
+		}
+		// Links and special files are not served
+	}
+}
+
+// readFile reads the content of a file of the archive, up to the size limits
+func (b *archiveBuilder) readFile(name string, r io.Reader) ([]byte, error) {
+	limit := min(maxArchiveFileBytes, maxArchiveBytes-b.size)
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if int64(len(content)) > limit {
+		if limit == maxArchiveFileBytes {
+			return nil, fmt.Errorf("%s: file is larger than the limit of %d bytes", name, maxArchiveFileBytes)
+		}
+		return nil, fmt.Errorf("archive files are larger than the limit of %d bytes in total", maxArchiveBytes)
+	}
+	b.size += int64(len(content))
+	return content, nil
+}
+
+// checkNames reports the files whose name is also a folder of the archive, like a file
+// "docs" next to "docs/index.html": only one of them could be served.
+func (b *archiveBuilder) checkNames() error {
+	folders := make(map[string]bool, len(b.dirs))
+	for _, name := range b.dirs {
+		folders[name] = true
+	}
+	for name := range b.files {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			folders[dir] = true
+		}
+	}
+	for name := range b.files {
+		if folders[name] {
+			return fmt.Errorf("%s: is both a file and a folder", name)
+		}
+	}
+	return nil
+}
+
+func (b *archiveBuilder) addFile(name string, content []byte) {
+	if name, ok := archiveName(name); ok {
+		if b.files == nil {
+			b.files = make(map[string][]byte)
+		}
+		b.files[name] = content
+	}
+}
+
+func (b *archiveBuilder) addDir(name string) {
+	if name, ok := archiveName(name); ok {
+		b.dirs = append(b.dirs, name)
+	}
+}
+
+// archiveName returns the name of an archive entry as an fs.FS name, and false for the
+// entries outside the archive root, like ../etc/passwd
+func archiveName(name string) (string, bool) {
+	name = strings.TrimPrefix(strings.ReplaceAll(name, `\`, "/"), "./")
+	name = strings.Trim(name, "/")
+	if name == "" || !fs.ValidPath(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// build returns the file system of the collected files. Archives that keep everything in
+// one folder, like dist/ or build/, are served from that folder.
+func (b *archiveBuilder) build() archiveFS {
+	prefix := b.commonFolder()
+	fsys := archiveFS{".": &archiveEntry{name: ".", dir: true, modTime: b.modTime}}
+	for _, name := range b.dirs {
+		if name = strings.TrimPrefix(name, prefix); name != "" && name+"/" != prefix {
+			fsys.dir(name, b.modTime)
+		}
+	}
+	for name, content := range b.files {
+		name = strings.TrimPrefix(name, prefix)
+		parent := fsys.dir(path.Dir(name), b.modTime)
+		entry := &archiveEntry{name: path.Base(name), data: content, modTime: b.modTime}
+		fsys[name] = entry
+		parent.children = append(parent.children, entry)
+	}
+	for _, entry := range fsys {
+		slices.SortFunc(entry.children, func(x, y *archiveEntry) int { return strings.Compare(x.name, y.name) })
+	}
+	return fsys
+}
+
+// commonFolder returns the folder, with a trailing slash, that holds all the files of the
+// archive, or "" when there are files at the root or several folders
+func (b *archiveBuilder) commonFolder() string {
+	folder := ""
+	for name := range b.files {
+		first, _, ok := strings.Cut(name, "/")
+		if !ok || (folder != "" && folder != first) {
+			return ""
+		}
+		folder = first
+	}
+	for _, name := range b.dirs {
+		if first, _, _ := strings.Cut(name, "/"); folder != "" && first != folder {
+			return ""
+		}
+	}
+	if folder == "" {
+		return ""
+	}
+	return folder + "/"
+}
+
+// archiveFS is an in-memory fs.FS with the files of an archive, by name
+type archiveFS map[string]*archiveEntry
+
+// dir returns the folder with the name, adding it and its parents when missing
+func (fsys archiveFS) dir(name string, modTime time.Time) *archiveEntry {
+	if entry, ok := fsys[name]; ok {
+		return entry
+	}
+	parent := fsys.dir(path.Dir(name), modTime)
+	entry := &archiveEntry{name: path.Base(name), dir: true, modTime: modTime}
+	fsys[name] = entry
+	parent.children = append(parent.children, entry)
+	return entry
+}
+
+func (fsys archiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := fsys[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.dir {
+		return &archiveDir{entry: entry}, nil
+	}
+	return &archiveFile{entry: entry, Reader: bytes.NewReader(entry.data)}, nil
+}
+
+// archiveEntry is a file or folder of an archive. It is its own fs.FileInfo and fs.DirEntry.
+type archiveEntry struct {
+	name     string
+	dir      bool
+	data     []byte
+	modTime  time.Time
+	children []*archiveEntry // folders only, sorted by name
+}
+
+func (e *archiveEntry) Name() string       { return e.name }
+func (e *archiveEntry) Size() int64        { return int64(len(e.data)) }
+func (e *archiveEntry) ModTime() time.Time { return e.modTime }
+func (e *archiveEntry) IsDir() bool        { return e.dir }
+func (e *archiveEntry) Sys() any           { return nil }
+
+func (e *archiveEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+func (e *archiveEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *archiveEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// archiveFile is an open file of an archive, seekable for http.ServeContent
+type archiveFile struct {
+	*bytes.Reader
+	entry *archiveEntry
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *archiveFile) Close() error               { return nil }
+
+// archiveDir is an open folder of an archive
+type archiveDir struct {
+	entry  *archiveEntry
+	offset int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *archiveDir) Close() error               { return nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entry.children[d.offset:]
+	if n > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(remaining) {
+		remaining = remaining[:n]
+	}
+	d.offset += len(remaining)
+	entries := make([]fs.DirEntry, len(remaining))
+	for i, entry := range remaining {
+		entries[i] = entry
+	}
+	return entries, nil
+}
@@ -0,0 +1,246 @@
+package gateway
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeArchive writes the files to a .zip or .tar.gz archive, chosen by the extension. Names
+// ending in / are folders.
+func writeArchive(t *testing.T, archivePath string, files map[string]string) {
+	t.Helper()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	if filepath.Ext(archivePath) == ".zip" {
+		zw := zip.NewWriter(&buf)
+		for _, name := range names {
+			w, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = io.WriteString(w, files[name])
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+	} else {
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, name := range names {
+			header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+			if name[len(name)-1] == '/' {
+				header = &tar.Header{Name: name, Mode: 0o755, Typeflag: tar.TypeDir}
+			}
+			require.NoError(t, tw.WriteHeader(header))
+			_, err := io.WriteString(tw, files[name])
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+	}
+
+	// Replaced with a rename, as in a deployment
+	tmp := archivePath + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, buf.Bytes(), 0o644))
+	require.NoError(t, os.Rename(tmp, archivePath))
+}
+
+func newArchiveGateway(t *testing.T, routes ...config.RouteConfig) *Gateway {
+	t.Helper()
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes:     routes,
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName(t.Name()))
+	require.NoError(t, err)
+	return gateway
+}
+
+func serveArchiveRequest(gateway *Gateway, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestStaticArchive(t *testing.T) {
+	site := map[string]string{
+		"index.html":      "<h1>Home</h1>",
+		"docs/":           "",
+		"docs/guide.html": "<h1>Guide</h1>",
+		"assets/app.js":   "console.log('app');",
+	}
+
+	for _, name := range []string{"site.zip", "site.tar.gz", "site.tgz"} {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), name)
+			writeArchive(t, archive, site)
+			gateway := newArchiveGateway(t, config.RouteConfig{Name: "Site", From: "/site/*", Static: true, ToArchive: archive})
+
+			rec := serveArchiveRequest(gateway, "/site/")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "<h1>Home</h1>", rec.Body.String())
+
+			rec = serveArchiveRequest(gateway, "/site/assets/app.js")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "console.log('app');", rec.Body.String())
+			assert.Contains(t, rec.Header().Get("Content-Type"), "javascript")
+			assert.NotEmpty(t, rec.Header().Get("ETag"))
+
+			rec = serveArchiveRequest(gateway, "/site/docs/")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), "guide.html", "folders are listed")
+
+			assert.Equal(t, http.StatusNotFound, serveArchiveRequest(gateway, "/site/missing.html").Code)
+		})
+	}
+}
+
+func TestStaticArchiveSPA(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "app.zip")
+	writeArchive(t, archive, map[string]string{
+		"dist/index.html":      "<div id=app></div>",
+		"dist/assets/index.js": "render();",
+	})
+	gateway := newArchiveGateway(t, config.RouteConfig{Name: "App", From: "/*", Static: true, IsSPA: true, ToArchive: archive})
+
+	rec := serveArchiveRequest(gateway, "/assets/index.js")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "render();", rec.Body.String(), "the single top-level folder is the root")
+
+	rec = serveArchiveRequest(gateway, "/orders/42")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "<div id=app></div>", rec.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/42", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	rec = httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "<div", rec.Body.String())
+}
+
+func TestStaticArchiveSingleFile(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "docs.tar.gz")
+	writeArchive(t, archive, map[string]string{"robots.txt": "User-agent: *", "other.txt": "other"})
+	gateway := newArchiveGateway(t,
+		config.RouteConfig{Name: "Robots", From: "/robots.txt", Static: true, ToArchive: archive, ToFile: "robots.txt"},
+		config.RouteConfig{Name: "Missing", From: "/missing.txt", Static: true, ToArchive: archive, ToFile: "missing.txt"},
+	)
+
+	rec := serveArchiveRequest(gateway, "/robots.txt")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "User-agent: *", rec.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	assert.Equal(t, http.StatusNotFound, serveArchiveRequest(gateway, "/missing.txt").Code)
+}
+
+func TestStaticArchiveHotSwap(t *testing.T) {
+	interval := archiveCheckInterval
+	archiveCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { archiveCheckInterval = interval })
+
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	writeArchive(t, archive, map[string]string{"index.html": "v1", "old.html": "old"})
+	gateway := newArchiveGateway(t, config.RouteConfig{Name: "Site", From: "/*", Static: true, ToArchive: archive})
+	require.Equal(t, "v1", serveArchiveRequest(gateway, "/").Body.String())
+
+	// A new version, with a later modification time so the change is seen
+	writeArchive(t, archive, map[string]string{"index.html": "v2", "new.html": "new"})
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(archive, later, later))
+	require.Eventually(t, func() bool {
+		return serveArchiveRequest(gateway, "/").Body.String() == "v2"
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, http.StatusOK, serveArchiveRequest(gateway, "/new.html").Code)
+	assert.Equal(t, http.StatusNotFound, serveArchiveRequest(gateway, "/old.html").Code)
+
+	// A broken archive keeps the content being served
+	require.NoError(t, os.WriteFile(archive, []byte("not a zip"), 0o644))
+	time.Sleep(3 * archiveCheckInterval)
+	assert.Equal(t, "v2", serveArchiveRequest(gateway, "/").Body.String())
+}
+
+func TestArchiveFS(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "unsafe.zip")
+	writeArchive(t, archive, map[string]string{
+		"../outside.txt":  "outside",
+		"/absolute.txt":   "absolute",
+		"./inside.txt":    "inside",
+		"nested/deep.txt": "deep",
+	})
+	files, err := newArchiveFileSystem(archive)
+	require.NoError(t, err)
+
+	for name, content := range map[string]string{"/absolute.txt": "absolute", "/inside.txt": "inside", "/nested/deep.txt": "deep"} {
+		f, err := files.Open(name)
+		require.NoError(t, err, name)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+		f.Close()
+	}
+	_, err = files.Open("/outside.txt")
+	assert.Error(t, err, "entries outside the root are skipped")
+
+	dir, err := files.Open("/")
+	require.NoError(t, err)
+	entries, err := dir.Readdir(-1)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"absolute.txt", "inside.txt", "nested"}, names)
+
+	_, err = newArchiveFileSystem(filepath.Join(t.TempDir(), "missing.zip"))
+	assert.Error(t, err)
+}
+
+func TestArchiveLimits(t *testing.T) {
+	total, file := maxArchiveBytes, maxArchiveFileBytes
+	maxArchiveBytes, maxArchiveFileBytes = 100, 60
+	t.Cleanup(func() { maxArchiveBytes, maxArchiveFileBytes = total, file })
+
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		dir := t.TempDir()
+		fits := filepath.Join(dir, "fits"+ext)
+		writeArchive(t, fits, map[string]string{"a.txt": strings.Repeat("a", 50), "b.txt": strings.Repeat("b", 50)})
+		_, err := newArchiveFileSystem(fits)
+		assert.NoError(t, err, ext)
+
+		large := filepath.Join(dir, "large"+ext)
+		writeArchive(t, large, map[string]string{"a.txt": strings.Repeat("a", 61)})
+		_, err = newArchiveFileSystem(large)
+		assert.ErrorContains(t, err, "a.txt: file is larger than the limit of 60 bytes", ext)
+
+		many := filepath.Join(dir, "many"+ext)
+		writeArchive(t, many, map[string]string{"a.txt": strings.Repeat("a", 50), "b.txt": strings.Repeat("b", 50), "c.txt": "c"})
+		_, err = newArchiveFileSystem(many)
+		assert.ErrorContains(t, err, "larger than the limit of 100 bytes in total", ext)
+
+		conflict := filepath.Join(dir, "conflict"+ext)
+		writeArchive(t, conflict, map[string]string{"docs": "file", "docs/index.html": "page"})
+		_, err = newArchiveFileSystem(conflict)
+		assert.ErrorContains(t, err, "docs: is both a file and a folder", ext)
+	}
+}
@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// immutableCacheControl is sent with the fingerprinted static files: their name changes
+// with their content, so they can be cached for good.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// staticAssetHandler sets the caching headers of a static file before the file server
+// sends it: a strong ETag from its content and, for fingerprinted names, a Cache-Control
+// that keeps it for a year. Folders without index.html answer 404 when their listing is
+// disabled.
+type staticAssetHandler struct {
+	files     http.FileSystem
+	next      http.Handler
+	etags     *etagCache
+	file      string // file served for every request, "" to use the request path
+	immutable bool   // fingerprinted files are cached as immutable
+	listing   bool   // folders without index.html list their files
+}
+
+func (h *staticAssetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	name, ok := staticFileName(r.URL.Path, h.file)
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	f, err := h.files.Open(name)
+	if err != nil {
+		// The file server lists the folder when it has no index.html
+		if !h.listing && errors.Is(err, fs.ErrNotExist) && strings.HasSuffix(name, "/index.html") {
+			if dir, err := h.files.Open(path.Dir(name)); err == nil {
+				dir.Close()
+				http.NotFound(w, r)
+				return
+			}
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if etag, err := h.etags.get(name, f, info); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	if h.immutable && fingerprinted(name) {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// staticFileName returns the name of the file the file server sends for a request path, or
+// false when it answers with a redirect. file is the file of single file routes.
+func staticFileName(urlPath, file string) (string, bool) {
+	if file != "" {
+		return file, true
+	}
+	name := urlPath
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	// The file server redirects the requests of index.html to the folder
+	if strings.HasSuffix(name, "/index.html") {
+		return "", false
+	}
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	return path.Clean(name), true
+}
+
+// fingerprinted reports whether the file name has a content hash, like app.3f9a1c.js
+// (webpack) or index-BXk3n9a2.js (Vite), in a part after the first one.
+func fingerprinted(name string) bool {
+	base := path.Base(name)
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	parts := strings.FieldsFunc(stem, func(r rune) bool { return r == '.' || r == '-' })
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts[1:] {
+		if isContentHash(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// isContentHash reports whether the part looks like a hash: at least 6 hex characters of
+// one case with letters and digits, or at least 8 base64url characters mixing upper and
+// lower case letters and digits. Words like "decade", dates like 20240101 and words like
+// the "summary" of Q3summary2024 rule out names that only look random: a year-long
+// immutable response for a file that changes is worse than a missed one.
+func isContentHash(part string) bool {
+	if len(part) < 6 {
+		return false
+	}
+	var digit, lower, upper, other bool
+	hexLower, hexUpper := true, true
+	run, longestRun := 0, 0 // lowercase letters in a row
+	for _, c := range part {
+		run++
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+			run = 0
+		case c >= 'a' && c <= 'z':
+			lower = true
+			hexUpper = false
+			hexLower = hexLower && c <= 'f'
+		case c >= 'A' && c <= 'Z':
+			upper = true
+			hexLower = false
+			hexUpper = hexUpper && c <= 'F'
+			run = 0
+		case c == '_':
+			other = true
+			run = 0
+		default:
+			return false
+		}
+		longestRun = max(longestRun, run)
+	}
+	if !other && digit && (lower && hexLower || upper && hexUpper) {
+		return true
+	}
+	return len(part) >= 8 && digit && lower && upper && longestRun < 6
+}
+
+// etagCache keeps the ETag of the static files of a route, so each file is hashed once
+// per change
+type etagCache struct {
+	mu    sync.Mutex
+	etags map[string]cachedETag // by file name
+}
+
+type cachedETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func newETagCache() *etagCache {
+	return &etagCache{etags: make(map[string]cachedETag)}
+}
+
+// get returns the strong ETag of the open file f, hashing it when it is new or changed.
+// f is read to the end and rewound.
+func (c *etagCache) get(name string, f http.File, info fs.FileInfo) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.etags[name]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)[:18]) + `"`
+
+	c.mu.Lock()
+	c.etags[name] = cachedETag{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	c.mu.Unlock()
+	return etag, nil
+}
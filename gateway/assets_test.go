@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprinted(t *testing.T) {
+	tests := map[string]bool{
+		"/app.3f9a1c8e.js":                      true,
+		"/app.3F9A1C8E.js":                      true,
+		"/app.3f9a1c.js":                        true,
+		"/app.c0ffee.js":                        true,
+		"/app.facade.js":                        false,
+		"/app.a1B2c3.js":                        false,
+		"/assets/index-BXk3n9a2.js":             true,
+		"/chunk-vendors.2b1a4f8e.css":           true,
+		"/assets/vendor-react-Dk_3x9Qa.js":      true,
+		"/app.js":                               false,
+		"/index.html":                           false,
+		"/jquery-3.7.1.min.js":                  false,
+		"/bootstrap-datepicker.js":              false,
+		"/app.decade.js":                        false,
+		"/polyfill-es2015.js":                   false,
+		"/3f9a1c8e.js":                          false,
+		"/fonts/roboto-v30-latin-regular.woff2": false,
+		"/photo-IMG20240101.jpg":                false,
+		"/report-Q3summary2024.pdf":             false,
+		"/report-Q3summary.pdf":                 false,
+		"/backup-20240101.js":                   false,
+	}
+	for name, want := range tests {
+		assert.Equal(t, want, fingerprinted(name), name)
+	}
+}
+
+func TestStaticAssets(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":          "<!DOCTYPE html><title>Taronja</title>",
+		"app.3f9a1c.js":       "console.log('fingerprinted');",
+		"app.js":              "console.log('plain');",
+		"assets/logo.svg":     "<svg></svg>",
+		"assets/logo.svg.gz":  "gzip bytes",
+		"downloads/notes.txt": "notes",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	oneHour := 3600
+	noListing, noImmutable := false, false
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes: []config.RouteConfig{
+			{Name: "Plain", From: "/plain/*", Static: true, ToFolder: dir, Options: &config.RouteOptions{ImmutableAssets: &noImmutable}},
+			{Name: "Private", From: "/private/*", Static: true, ToFolder: dir, Options: &config.RouteOptions{DirectoryListing: &noListing}},
+			{Name: "Site", From: "/*", Static: true, ToFolder: dir, Options: &config.RouteOptions{CacheControlSeconds: &oneHour},
+				Compression: &config.RouteCompressionConfig{}},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestStaticAssets"))
+	require.NoError(t, err)
+
+	serve := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		gateway.Mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("strong ETags", func(t *testing.T) {
+		rec := serve("/app.js", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		etag := rec.Header().Get("ETag")
+		assert.Regexp(t, `^"[A-Za-z0-9_-]{24}"$`, etag)
+		assert.Equal(t, etag, serve("/app.js", nil).Header().Get("ETag"), "the ETag is stable")
+		assert.NotEqual(t, etag, serve("/app.3f9a1c.js", nil).Header().Get("ETag"))
+
+		rec = serve("/app.js", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		rec = serve("/", nil)
+		assert.NotEmpty(t, rec.Header().Get("ETag"), "folders get the ETag of their index.html")
+
+		// The file changes, and so does its ETag
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log('changed');"), 0o644))
+		assert.NotEqual(t, etag, serve("/app.js", nil).Header().Get("ETag"))
+	})
+
+	t.Run("precompressed siblings have their own ETag", func(t *testing.T) {
+		plain := serve("/assets/logo.svg", nil)
+		gzipped := serve("/assets/logo.svg", map[string]string{"Accept-Encoding": "gzip"})
+		require.Equal(t, "gzip", gzipped.Header().Get("Content-Encoding"))
+		assert.NotEmpty(t, gzipped.Header().Get("ETag"))
+		assert.NotEqual(t, plain.Header().Get("ETag"), gzipped.Header().Get("ETag"))
+	})
+
+	t.Run("immutable fingerprinted files", func(t *testing.T) {
+		assert.Equal(t, "public, max-age=31536000, immutable", serve("/app.3f9a1c.js", nil).Header().Get("Cache-Control"))
+		assert.Equal(t, "max-age=3600", serve("/app.js", nil).Header().Get("Cache-Control"), "other files keep cacheControlSeconds")
+		assert.Empty(t, serve("/plain/app.3f9a1c.js", nil).Header().Get("Cache-Control"), "immutableAssets disabled")
+	})
+
+	t.Run("directory listing", func(t *testing.T) {
+		rec := serve("/downloads/", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "notes.txt")
+
+		rec = serve("/private/downloads/", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "notes.txt")
+		assert.Equal(t, http.StatusOK, serve("/private/downloads/notes.txt", nil).Code)
+		assert.Equal(t, http.StatusOK, serve("/private/", nil).Code, "folders with index.html are served")
+	})
+}
+
+func TestGatewayErrorPages(t *testing.T) {
+	pagesDir := t.TempDir()
+	notFound := filepath.Join(pagesDir, "404.html")
+	badGateway := filepath.Join(pagesDir, "5xx.html")
+	unauthorized := filepath.Join(pagesDir, "401.html")
+	require.NoError(t, os.WriteFile(notFound, []byte("<h1>Lost: {{.Path}}</h1>"), 0o644))
+	require.NoError(t, os.WriteFile(badGateway, []byte("<h1>{{.Route}} is down ({{.Status}})</h1>"), 0o644))
+	require.NoError(t, os.WriteFile(unauthorized, []byte("<h1>Please log in</h1>"), 0o644))
+
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		ErrorPages: map[string]string{"404": notFound, "5xx": badGateway},
+		Routes: []config.RouteConfig{
+			{Name: "Orders", From: "/orders/*", To: "http://127.0.0.1:1"},
+			{Name: "Account", From: "/account/*", To: "http://127.0.0.1:1", Authentication: config.AuthenticationConfig{Enabled: true},
+				ErrorPages: map[string]string{"401": unauthorized}},
+			{Name: "Site", From: "/site/*", Static: true, ToFolder: t.TempDir()},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayErrorPages"))
+	require.NoError(t, err)
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		gateway.Server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/site/missing.png", "text/html")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "<h1>Lost: /site/missing.png</h1>", rec.Body.String())
+
+	rec = get("/nowhere", "text/html")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "<h1>Lost: /nowhere</h1>", rec.Body.String(), "requests outside the routes get the global pages")
+
+	rec = get("/orders/1", "text/html")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "<h1>Orders is down (502)</h1>", rec.Body.String())
+
+	rec = get("/account/profile", "text/html")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "<h1>Please log in</h1>", rec.Body.String())
+
+	rec = get("/orders/1", "application/json")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"), "API clients get the plain error")
+}
@@ -15,11 +15,14 @@ type Dependencies struct {
 	DB *gorm.DB
 
 	// Repositories
-	UserRepo          db.UserRepository
-	SessionRepo       db.SessionRepository
-	TrafficMetricRepo db.TrafficMetricRepository
-	TokenRepo         db.TokenRepository
-	CountersRepo      db.CountersRepository
+	UserRepo           db.UserRepository
+	SessionRepo        db.SessionRepository
+	TrafficMetricRepo  db.TrafficMetricRepository
+	TokenRepo          db.TokenRepository
+	CountersRepo       db.CountersRepository
+	CertificateRepo    db.CertificateCacheRepository
+	BlockListRepo      db.BlockListRepository
+	RateLimitStoreRepo db.RateLimitStoreRepository
 
 	// Services
 	SessionStore session.SessionStore
@@ -41,6 +44,9 @@ func NewProduction() *Dependencies {
 	trafficMetricRepo := db.NewTrafficMetricRepository(gormDB)
 	tokenRepo := db.NewTokenRepositoryDB(gormDB)
 	countersRepo := db.NewDBCountersRepository(gormDB)
+	certificateRepo := db.NewCertificateCacheRepositoryDB(gormDB)
+	blockListRepo := db.NewBlockListRepositoryDB(gormDB)
+	rateLimitStoreRepo := db.NewRateLimitStoreRepositoryDB(gormDB)
 
 	// Create session store with 24 hour duration
 	sessionStore := session.NewSessionStore(sessionRepo, 24*time.Hour)
@@ -49,18 +55,34 @@ func NewProduction() *Dependencies {
 	tokenService := auth.NewTokenService(tokenRepo, userRepo)
 
 	return &Dependencies{
-		DB:                gormDB,
-		UserRepo:          userRepo,
-		SessionRepo:       sessionRepo,
-		TrafficMetricRepo: trafficMetricRepo,
-		TokenRepo:         tokenRepo,
-		CountersRepo:      countersRepo,
-		SessionStore:      sessionStore,
-		TokenService:      tokenService,
-		StartTime:         time.Now(),
+		DB:                 gormDB,
+		UserRepo:           userRepo,
+		SessionRepo:        sessionRepo,
+		TrafficMetricRepo:  trafficMetricRepo,
+		TokenRepo:          tokenRepo,
+		CountersRepo:       countersRepo,
+		CertificateRepo:    certificateRepo,
+		BlockListRepo:      blockListRepo,
+		RateLimitStoreRepo: rateLimitStoreRepo,
+		SessionStore:       sessionStore,
+		TokenService:       tokenService,
+		StartTime:          time.Now(),
 	}
 }
 
+// Close closes the database connection. Call it once the requests and metrics that use the
+// repositories are done.
+func (d *Dependencies) Close() error {
+	if d.DB == nil {
+		return nil
+	}
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
 // NewTest creates dependencies configured for testing with a test database
 func NewTest() *Dependencies {
 	return NewTestWithName("test-dependencies")
@@ -78,6 +100,9 @@ func NewTestWithName(testName string) *Dependencies {
 	trafficMetricRepo := db.NewTrafficMetricRepository(gormDB)
 	tokenRepo := db.NewTokenRepositoryDB(gormDB)
 	countersRepo := db.NewDBCountersRepository(gormDB)
+	certificateRepo := db.NewCertificateCacheRepositoryDB(gormDB)
+	blockListRepo := db.NewBlockListRepositoryDB(gormDB)
+	rateLimitStoreRepo := db.NewRateLimitStoreRepositoryDB(gormDB)
 
 	// Create session store with 1 hour duration for tests
 	sessionStore := session.NewSessionStore(sessionRepo, 1*time.Hour)
@@ -86,14 +111,17 @@ func NewTestWithName(testName string) *Dependencies {
 	tokenService := auth.NewTokenService(tokenRepo, userRepo)
 
 	return &Dependencies{
-		DB:                gormDB,
-		UserRepo:          userRepo,
-		SessionRepo:       sessionRepo,
-		TrafficMetricRepo: trafficMetricRepo,
-		TokenRepo:         tokenRepo,
-		CountersRepo:      countersRepo,
-		SessionStore:      sessionStore,
-		TokenService:      tokenService,
-		StartTime:         time.Now(),
+		DB:                 gormDB,
+		UserRepo:           userRepo,
+		SessionRepo:        sessionRepo,
+		TrafficMetricRepo:  trafficMetricRepo,
+		TokenRepo:          tokenRepo,
+		CountersRepo:       countersRepo,
+		CertificateRepo:    certificateRepo,
+		BlockListRepo:      blockListRepo,
+		RateLimitStoreRepo: rateLimitStoreRepo,
+		SessionStore:       sessionStore,
+		TokenService:       tokenService,
+		StartTime:          time.Now(),
 	}
 }
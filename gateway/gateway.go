@@ -1,22 +1,29 @@
 package gateway
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template" // Added for template parsing
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
+	"path"
 	"path/filepath" // Still needed for user-defined static routes from OS filesystem
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/auth"
+	"github.com/jmaister/taronja-gateway/cache"
+	"github.com/jmaister/taronja-gateway/certs"
 	"github.com/jmaister/taronja-gateway/config"
 	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/gateway/deps"
@@ -25,6 +32,7 @@ import (
 	"github.com/jmaister/taronja-gateway/providers"
 	"github.com/jmaister/taronja-gateway/session"
 	"github.com/jmaister/taronja-gateway/static"
+	"github.com/jmaister/taronja-gateway/upstream"
 )
 
 // --- Gateway Struct ---
@@ -34,12 +42,35 @@ type Gateway struct {
 	Mux           *http.ServeMux
 	Dependencies  *deps.Dependencies
 	// Middleware components (created during gateway initialization)
-	AuthMiddleware      *middleware.AuthMiddleware
-	HttpCacheMiddleware *middleware.HttpCacheMiddleware
-	RouteChainBuilder   *middleware.RouteChainBuilder
-	// Rate limiter instance (for stats/config APIs)
-	RateLimiter   *middleware.RateLimiter
+	AuthMiddleware        *middleware.AuthMiddleware
+	HttpCacheMiddleware   *middleware.HttpCacheMiddleware
+	CompressionMiddleware *middleware.CompressionMiddleware
+	RouteChainBuilder     *middleware.RouteChainBuilder
+	// Plain HTTP listener that redirects to HTTPS, nil when not configured
+	RedirectServer *http.Server
+	// Certificates obtained with ACME (for status APIs), nil when not configured
+	Certificates *certs.Manager
+	// Rate limiter instance (for stats/config APIs), kept across configuration reloads
+	RateLimiter *middleware.RateLimiter
+	// Upstream pools of the proxy routes (for health checks and status APIs)
+	Upstreams *upstream.Registry
+	// Shared response cache of the proxy routes (for stats APIs), nil when no route uses it.
+	// Kept across configuration reloads.
+	ResponseCache *cache.Cache
+	// File the configuration is reloaded from, empty when it cannot be reloaded
+	ConfigPath    string
 	templates     map[string]*template.Template
+	routeHandlers map[string]http.HandlerFunc // final handler of each user route by name, used by circuit breaker fallbacks
+	router        *router                     // selects the user route of a request
+	identity      *auth.IdentitySigner        // signs the identity token of authenticated proxy routes, nil when disabled
+	handler       http.Handler                // global middleware chain around Mux
+	reloadConfig  handlers.ConfigReloader     // reloads the configuration of the gateway serving these routes
+	current       atomic.Pointer[Gateway]     // gateway built from the configuration being served
+	reloadMu      sync.Mutex                  // one configuration reload at a time
+	streams       *streamTracker              // upgraded connections, shared by the configurations
+	inflight      atomic.Int64                // requests being served, upgraded connections included
+	draining      atomic.Bool                 // set when the gateway starts shutting down
+	isDraining    func() bool                 // reports whether the gateway serving these routes shuts down
 	WebappEmbedFS *embed.FS
 	StartTime     time.Time
 }
@@ -48,23 +79,79 @@ type Gateway struct {
 
 // NewGatewayWithDependencies creates a new gateway instance with pre-initialized dependencies
 func NewGatewayWithDependencies(config *config.GatewayConfig, webappEmbedFS *embed.FS, deps *deps.Dependencies) (*Gateway, error) {
-	// Create middleware components from the core dependencies
-	authMiddleware := middleware.NewAuthMiddleware(deps.SessionStore, deps.TokenService, config.Management.Prefix)
-	cacheMiddleware := middleware.NewHttpCacheMiddleware()
-	routeChainBuilder := middleware.NewRouteChainBuilder(authMiddleware, cacheMiddleware)
+	// The state that outlives a configuration: the rate limiter entries, the response
+	// cache and the sessions of the dependencies
+	gateway := &Gateway{
+		Dependencies:  deps,
+		RateLimiter:   middleware.NewRateLimiter(config.Management.RateLimiter),
+		WebappEmbedFS: webappEmbedFS,
+		StartTime:     time.Now(),
+		streams:       newStreamTracker(),
+	}
+
+	// The instances sharing the database share the counters and blocks of their rate limiters
+	if config.Management.RateLimiter.IsShared() && deps.RateLimitStoreRepo != nil {
+		if err := gateway.RateLimiter.UseSharedStore(deps.RateLimitStoreRepo); err != nil {
+			return nil, err
+		}
+	}
+
+	// The blocks of the rate limiter and its allowlist are stored, and restored on startup
+	if deps.BlockListRepo != nil {
+		if err := gateway.RateLimiter.UseBlockList(deps.BlockListRepo); err != nil {
+			return nil, fmt.Errorf("failed to load the rate limiter block list: %w", err)
+		}
+	}
+
+	// The ACME certificates are reported by the management API of every configuration
+	if tlsConfig := config.Server.TLS; tlsConfig != nil && tlsConfig.ACME != nil {
+		certificates, err := certs.NewManager(tlsConfig.ACME, deps.CertificateRepo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure ACME: %w", err)
+		}
+		gateway.Certificates = certificates
+	}
+
+	// Build the routes and middleware of the configuration
+	next, err := gateway.build(config)
+	if err != nil {
+		return nil, err
+	}
+	gateway.Server = createHTTPServer(config, gateway)
+	if config.Server.TLS != nil {
+		if gateway.Server.TLSConfig, err = newServerTLSConfig(config.Server.TLS, gateway.Certificates); err != nil {
+			next.Upstreams.Close()
+			return nil, fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		gateway.RedirectServer = newRedirectServer(config, gateway.Certificates)
+	}
+	gateway.adopt(next)
+
+	// Ensure admin user exists if configured
+	if err := ensureAdminUser(config, deps.UserRepo); err != nil {
+		return nil, fmt.Errorf("failed to ensure admin user: %w", err)
+	}
+
+	return gateway, nil
+}
+
+// build creates the middleware and routes of a configuration on a new gateway that shares
+// the dependencies, rate limiter and response cache of g. Nothing of g is changed, so a
+// configuration that fails here never replaces the one being served.
+func (g *Gateway) build(config *config.GatewayConfig) (*Gateway, error) {
+	deps := g.Dependencies
 
 	// Validate middleware dependencies
 	if err := middleware.ValidateAllMiddleware(deps, config); err != nil {
 		return nil, fmt.Errorf("middleware validation failed: %w", err)
 	}
 
-	// Log middleware status
-	middleware.LogMiddlewareStatus(config)
-
-	// Create HTTP server with middleware chain (also returns limiter)
-	server, mux, rl, err := createHTTPServer(config, deps)
+	// Create middleware components from the core dependencies
+	authMiddleware := middleware.NewAuthMiddleware(deps.SessionStore, deps.TokenService, config.Management.Prefix)
+	compressionMiddleware := middleware.NewCompressionMiddleware(config.Compression)
+	errorPages, err := middleware.NewErrorPages(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP server: %w", err)
+		return nil, fmt.Errorf("failed to load error pages: %w", err)
 	}
 
 	// Initialize templates
@@ -73,57 +160,123 @@ func NewGatewayWithDependencies(config *config.GatewayConfig, webappEmbedFS *emb
 		return nil, fmt.Errorf("failed to parse templates: %w", err)
 	}
 
+	// Load the key of the identity token sent to upstreams
+	var identity *auth.IdentitySigner
+	if config.Identity.JWT.Enabled {
+		identity, err = auth.NewIdentitySigner(config.Identity.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure identity token: %w", err)
+		}
+	}
+
+	// Create the shared response cache when a route uses it; an existing one is kept
+	responseCache := g.ResponseCache
+	if responseCache == nil {
+		for _, route := range config.Routes {
+			if route.Cache != nil {
+				if responseCache, err = cache.New(config.Cache); err != nil {
+					return nil, fmt.Errorf("failed to create response cache: %w", err)
+				}
+				break
+			}
+		}
+	}
+	cacheMiddleware := middleware.NewHttpCacheMiddleware(responseCache)
+	routeChainBuilder := middleware.NewRouteChainBuilder(authMiddleware, g.RateLimiter, cacheMiddleware, compressionMiddleware, errorPages)
+
+	// Log middleware status
+	middleware.LogMiddlewareStatus(config)
+
 	// Create gateway instance
+	mux := http.NewServeMux()
 	gateway := &Gateway{
-		GatewayConfig:       config,
-		Server:              server,
-		Mux:                 mux,
-		Dependencies:        deps,
-		AuthMiddleware:      authMiddleware,
-		HttpCacheMiddleware: cacheMiddleware,
-		RouteChainBuilder:   routeChainBuilder,
-		RateLimiter:         rl,
-		templates:           templates,
-		WebappEmbedFS:       webappEmbedFS,
-		StartTime:           time.Now(),
+		GatewayConfig:         config,
+		Mux:                   mux,
+		Dependencies:          deps,
+		AuthMiddleware:        authMiddleware,
+		HttpCacheMiddleware:   cacheMiddleware,
+		CompressionMiddleware: compressionMiddleware,
+		RouteChainBuilder:     routeChainBuilder,
+		RateLimiter:           g.RateLimiter,
+		Certificates:          g.Certificates,
+		Upstreams:             upstream.NewRegistry(),
+		ResponseCache:         responseCache,
+		ConfigPath:            g.ConfigPath,
+		templates:             templates,
+		routeHandlers:         make(map[string]http.HandlerFunc),
+		router:                newRouter(),
+		identity:              identity,
+		reloadConfig:          g.ReloadConfig,
+		streams:               g.streams,
+		isDraining:            g.Draining,
+		WebappEmbedFS:         g.WebappEmbedFS,
+		StartTime:             g.StartTime,
 	}
 
+	// Build the global middleware chain with the limiter
+	globalChain := middleware.BuildGlobalChain(config, deps.SessionStore, deps.TokenService, deps.TrafficMetricRepo, g.RateLimiter, errorPages)
+	gateway.handler = globalChain.Build(mux)
+
 	// Configure routes
 	if err := configureRoutes(gateway); err != nil {
+		gateway.Upstreams.Close()
+		if responseCache != g.ResponseCache {
+			responseCache.Close()
+		}
 		return nil, fmt.Errorf("failed to configure routes: %w", err)
 	}
 
-	// Ensure admin user exists if configured
-	if err := ensureAdminUser(config, deps.UserRepo); err != nil {
-		return nil, fmt.Errorf("failed to ensure admin user: %w", err)
-	}
-
 	return gateway, nil
 }
 
-// createHTTPServer creates the HTTP server with middleware chain
-func createHTTPServer(config *config.GatewayConfig, deps *deps.Dependencies) (*http.Server, *http.ServeMux, *middleware.RateLimiter, error) {
-	mux := http.NewServeMux()
-
-	// instantiate rate limiter once and keep reference
-	rl := middleware.NewRateLimiter(config.Management.RateLimiter)
+// adopt makes next the gateway that serves the requests. The fields of g point to the
+// components of next for the code that runs before the gateway serves and after a reload
+// returns, like the tests. The requests and the code that may run during a reload use
+// g.current, or Config, instead: the fields change under them.
+func (g *Gateway) adopt(next *Gateway) {
+	next.Server = g.Server
+	g.GatewayConfig = next.GatewayConfig
+	g.Mux = next.Mux
+	g.AuthMiddleware = next.AuthMiddleware
+	g.HttpCacheMiddleware = next.HttpCacheMiddleware
+	g.CompressionMiddleware = next.CompressionMiddleware
+	g.RouteChainBuilder = next.RouteChainBuilder
+	g.Upstreams = next.Upstreams
+	g.ResponseCache = next.ResponseCache
+	g.templates = next.templates
+	g.routeHandlers = next.routeHandlers
+	g.router = next.router
+	g.identity = next.identity
+	g.current.Store(next)
+}
 
-	// Build the global middleware chain with the limiter
-	globalChain := middleware.BuildGlobalChain(config, deps.SessionStore, deps.TokenService, deps.TrafficMetricRepo, rl)
-	handler := globalChain.Build(mux)
+// Config returns the configuration being served. Unlike the GatewayConfig field, it is safe
+// to call while the configuration is reloaded.
+func (g *Gateway) Config() *config.GatewayConfig {
+	return g.current.Load().GatewayConfig
+}
 
-	// attach limiter to gateway via returned value later
-	// (caller is responsible for storing it)
+// ServeHTTP serves the request with the routes of the current configuration
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Counted because http.Server.Shutdown does not wait for hijacked connections
+	g.inflight.Add(1)
+	defer g.inflight.Add(-1)
+	g.current.Load().handler.ServeHTTP(w, r)
+}
 
-	server := &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  120 * time.Second,
-		Handler:      handler,
+// createHTTPServer creates the HTTP server of the gateway. The listener and timeouts are set
+// once; the handler switches to the new routes when the configuration is reloaded.
+func createHTTPServer(config *config.GatewayConfig, handler http.Handler) *http.Server {
+	// Routes with a request timeout override the read and write deadlines per request
+	timeouts := config.Server.Timeouts
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port),
+		ReadHeaderTimeout: timeouts.ReadHeader(),
+		ReadTimeout:       timeouts.Read(),
+		WriteTimeout:      timeouts.Write(),
+		IdleTimeout:       timeouts.Idle(),
+		Handler:           handler,
 	}
-
-	return server, mux, rl, nil
 }
 
 // configureRoutes sets up all the gateway routes
@@ -201,6 +354,21 @@ func (g *Gateway) configureManagementRoutes(staticAssetsFS embed.FS) {
 	// Register dashboard
 	g.registerDashboard(prefix)
 
+	// Public keys of the identity token
+	if g.identity != nil {
+		g.registerJWKSRoute(prefix)
+	}
+}
+
+// registerJWKSRoute publishes the keys upstreams use to verify the identity token.
+func (g *Gateway) registerJWKSRoute(prefix string) {
+	jwksPath := prefix + "/.well-known/jwks.json"
+	g.Mux.HandleFunc("GET "+jwksPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(g.identity.JWKS())
+	})
+	log.Printf("Registered Management Route: %-25s | Path: %s | Auth: %t", "JWKS", jwksPath, false)
 }
 
 func (g *Gateway) registerDashboard(prefix string) {
@@ -304,6 +472,11 @@ func (g *Gateway) registerOpenAPIRoutes(prefix string) {
 		g.Dependencies.TokenService,
 		g.StartTime,
 		g.RateLimiter,
+		g.Upstreams,
+		g.ResponseCache,
+		g.Certificates,
+		g.reloadConfig,
+		g.isDraining,
 	)
 	// Convert the StrictServerInterface to the standard ServerInterface
 
@@ -406,68 +579,74 @@ func (g *Gateway) configureUserRoutes() error {
 	for _, routeConfig := range g.GatewayConfig.Routes {
 		var handler http.HandlerFunc
 
+		rewriter, rewriteErr := newPathRewriter(routeConfig)
+		if rewriteErr != nil {
+			log.Printf("Warning: Invalid rewrite for route '%s': %v. Skipping registration.", routeConfig.Name, rewriteErr)
+			continue
+		}
+
 		// Create the base handler (proxy or static)
 		if routeConfig.Static {
-			handler = g.createStaticHandlerFunc(routeConfig)
+			handler = g.createStaticHandlerFunc(routeConfig, rewriter)
 			if handler == nil {
 				// Skip to next route if handler creation failed
 				continue
 			}
 		} else {
-			if routeConfig.To == "" {
+			if len(routeConfig.UpstreamTargets()) == 0 {
 				log.Printf("Warning: Empty 'to' URL for proxy route '%s'. Skipping registration.", routeConfig.Name)
 				continue
 			}
-			targetURL, parseErr := url.Parse(routeConfig.To)
-			if parseErr != nil {
-				log.Printf("Warning: Invalid target URL '%s' for proxy route '%s': %v. Skipping registration.", routeConfig.To, routeConfig.Name, parseErr)
+			pool, poolErr := upstream.NewPool(routeConfig)
+			if poolErr != nil {
+				log.Printf("Warning: Invalid upstream configuration for proxy route '%s': %v. Skipping registration.", routeConfig.Name, poolErr)
 				continue
 			}
-			handler = g.createProxyHandlerFunc(routeConfig, targetURL)
+			g.Upstreams.Add(pool)
+			handler = g.createProxyHandlerFunc(routeConfig, pool, rewriter)
+			if len(pool.Targets()) > 1 {
+				log.Printf("Proxy Route [%s]: Load balancing %d targets with strategy '%s'", routeConfig.Name, len(pool.Targets()), pool.Strategy)
+			}
+			if cb := routeConfig.CircuitBreaker; cb != nil {
+				log.Printf("Proxy Route [%s]: Circuit breaker enabled (consecutive failures: %d, failure ratio: %.2f, open: %s)",
+					routeConfig.Name, cb.ConsecutiveFailures, cb.FailureRatio, cb.OpenDuration())
+			}
+			if to := routeConfig.Timeouts; to != nil {
+				log.Printf("Proxy Route [%s]: Timeouts (dial: %s, response headers: %s, request: %s)",
+					routeConfig.Name, to.Dial(), to.ResponseHeader(), to.Request())
+			}
+			if rc := routeConfig.Retry; rc != nil {
+				log.Printf("Proxy Route [%s]: Retries enabled (attempts: %d, per-try timeout: %s)",
+					routeConfig.Name, rc.MaxAttempts(), rc.PerTryTimeout())
+			}
+			if hc := routeConfig.HealthCheck; hc != nil {
+				log.Printf("Proxy Route [%s]: Health checks enabled (probe path: '%s', interval: %s, passive max failures: %d)",
+					routeConfig.Name, hc.Path, hc.Interval(), hc.Passive.MaxFailures)
+			}
+			if rewriter != nil {
+				log.Printf("Proxy Route [%s]: Rewrite enabled (path: '%s', regex: '%s')", routeConfig.Name, routeConfig.Rewrite.Path, routeConfig.Rewrite.Regex)
+			}
 			if routeConfig.IsSPA {
-				log.Printf("Proxy Route [%s]: SPA mode enabled - upstream 404s will fall back to base URL: %s", routeConfig.Name, routeConfig.To)
+				log.Printf("Proxy Route [%s]: SPA mode enabled - upstream 404s will fall back to base URL: %s", routeConfig.Name, routeConfig.UpstreamDescription())
 			}
 		}
 
 		// Wrap with cache control for all routes
-		handler = g.RouteChainBuilder.BuildRouteChain(handler, routeConfig)
-
-		// Register the final handler for routeConfig.From
-		// To match /api with /api/hello and /api/foo, the pattern must be "/api/"
-		pattern := routeConfig.From
-		if strings.HasSuffix(pattern, "/*") {
-			// Register both the base and wildcard pattern for ServeMux
-			basePattern := strings.TrimSuffix(pattern, "*")
-			g.Mux.HandleFunc(basePattern, handler)
-			log.Printf("Registered User Route  : %-25s | From: %-20s | To: %s | Auth: %t (patterns: %s, %s)",
-				routeConfig.Name, routeConfig.From, routeConfig.To, routeConfig.Authentication.Enabled, basePattern, pattern)
-		} else {
-			// For static file routes, register both with and without trailing slash to avoid redirects
-			if routeConfig.Static && routeConfig.ToFile != "" {
-				// Register without trailing slash
-				g.Mux.HandleFunc(routeConfig.From, handler)
-
-				// Also register with trailing slash
-				patternWithSlash := routeConfig.From
-				if !strings.HasSuffix(patternWithSlash, "/") {
-					patternWithSlash += "/"
-				}
-				g.Mux.HandleFunc(patternWithSlash, handler)
+		handler = g.stripIdentityHeaders(g.RouteChainBuilder.BuildRouteChain(handler, routeConfig))
+		g.routeHandlers[routeConfig.Name] = handler
 
-				log.Printf("Registered User Route  : %-25s | From: %-20s | To: %s | Auth: %t (patterns: %s, %s)",
-					routeConfig.Name, routeConfig.From, routeConfig.To, routeConfig.Authentication.Enabled,
-					routeConfig.From, patternWithSlash)
-			} else {
-				// For other routes, ensure the pattern ends with a slash for consistency
-				if !strings.HasSuffix(pattern, "/") {
-					pattern += "/"
-				}
-				g.Mux.HandleFunc(pattern, handler)
-				log.Printf("Registered User Route  : %-25s | From: %-20s | To: %s | Auth: %t (pattern: %s)",
-					routeConfig.Name, routeConfig.From, routeConfig.To, routeConfig.Authentication.Enabled, pattern)
-			}
+		if err := g.router.add(routeConfig, handler); err != nil {
+			log.Printf("Warning: Invalid matching rules for route '%s': %v. Skipping registration.", routeConfig.Name, err)
+			continue
 		}
+		log.Printf("Registered User Route  : %-25s | From: %-20s | To: %s | Auth: %t",
+			routeConfig.Name, routeConfig.From, routeConfig.UpstreamDescription(), routeConfig.Authentication.Enabled)
 	}
+
+	// The router picks the user route by host, path, method, headers and query string;
+	// management routes have more specific ServeMux patterns and are not affected.
+	g.Mux.Handle("/", g.router)
+	g.router.logTable()
 	return nil
 }
 
@@ -491,42 +670,24 @@ func (g *Gateway) configureOAuthCallbackRoute() {
 
 // --- Route Handler Creation ---
 // createProxyHandlerFunc generates the core handler function for proxy routes (without auth).
-func (g *Gateway) createProxyHandlerFunc(routeConfig config.RouteConfig, targetURL *url.URL) http.HandlerFunc {
+// The target of every request is picked from the route's upstream pool by the proxy transport.
+// The rewriter, if any, changes the request path and query before the target path is joined.
+func (g *Gateway) createProxyHandlerFunc(routeConfig config.RouteConfig, pool *upstream.Pool, rewriter *pathRewriter) http.HandlerFunc {
 	// Create the proxy once when the handler is created
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-
-	// Store the original director
-	originalDirector := proxy.Director
+	transport := &upstream.Transport{
+		Pool:                  pool,
+		Retry:                 routeConfig.Retry,
+		ResponseHeaderTimeout: routeConfig.Timeouts.ResponseHeader(),
+		Rewrite:               rewriteForTarget(routeConfig),
+	}
+	if dial := routeConfig.Timeouts.Dial(); dial > 0 {
+		transport.Base = upstream.NewBaseTransport(dial)
+	}
+	proxy := &httputil.ReverseProxy{Transport: transport}
 
-	// Create a custom director that modifies the request
+	// The director only applies target-independent changes; the transport points
+	// the request at the chosen target.
 	proxy.Director = func(req *http.Request) {
-		// Call the original director first
-		originalDirector(req)
-
-		// Apply path stripping and join logic
-		if routeConfig.RemoveFromPath != "" {
-			if strings.HasPrefix(req.URL.Path, routeConfig.RemoveFromPath) {
-				req.URL.Path = strings.TrimPrefix(req.URL.Path, routeConfig.RemoveFromPath)
-
-				if len(req.URL.Path) > 0 && !strings.HasPrefix(req.URL.Path, "/") {
-					req.URL.Path = "/" + req.URL.Path
-				} else if len(req.URL.Path) == 0 {
-					req.URL.Path = "/"
-				}
-
-				// When using RemoveFromPath, we should NOT join with targetURL.Path
-				// as that would reintroduce the prefix we just removed
-				req.URL.RawPath = req.URL.EscapedPath()
-			} else {
-				req.URL.Path = singleJoiningSlash(targetURL.Path, req.URL.Path)
-				req.URL.RawPath = req.URL.EscapedPath()
-			}
-		} else {
-			// No RemoveFromPath, just join as before
-			req.URL.Path = singleJoiningSlash(targetURL.Path, req.URL.Path)
-			req.URL.RawPath = req.URL.EscapedPath()
-		}
-
 		// Set forwarded headers
 		req.Header.Set("X-Forwarded-Host", req.Host)
 		scheme := "http"
@@ -534,14 +695,25 @@ func (g *Gateway) createProxyHandlerFunc(routeConfig config.RouteConfig, targetU
 			scheme = "https"
 		}
 		req.Header.Set("X-Forwarded-Proto", scheme)
-		if clientIP := req.RemoteAddr; clientIP != "" {
-			if prior, ok := req.Header["X-Forwarded-For"]; ok {
-				clientIP = strings.Join(prior, ", ") + ", " + clientIP
-			}
-			req.Header.Set("X-Forwarded-For", clientIP)
+		// Only the client and the trusted proxies are passed on; the reverse proxy appends
+		// the peer of the connection. The headers of untrusted clients are dropped.
+		if forwardedFor := session.GetForwardedFor(req); forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		} else {
+			req.Header.Del("X-Forwarded-For")
+		}
+		if !session.IsFromTrustedProxy(req) {
+			req.Header.Del("Forwarded")
+			req.Header.Del("X-Real-IP")
+			req.Header.Del("X-Client-IP")
+		}
+		if _, ok := req.Header["User-Agent"]; !ok {
+			// explicitly disable User-Agent so it's not set to default value
+			req.Header.Set("User-Agent", "")
+		}
+		if rewriter != nil {
+			rewriter.rewrite(req, req.URL)
 		}
-
-		req.Host = targetURL.Host
 	}
 
 	// Set up SPA fallback via ModifyResponse when isSPA is enabled
@@ -551,10 +723,16 @@ func (g *Gateway) createProxyHandlerFunc(routeConfig config.RouteConfig, targetU
 				return nil
 			}
 
+			// Fall back to the base URL of the target that answered
+			baseURL := resp.Request.URL.Scheme + "://" + resp.Request.URL.Host
+			if info := upstream.ProxyInfoFromContext(resp.Request.Context()); info != nil && info.Target != "" {
+				baseURL = info.Target
+			}
+
 			log.Printf("Proxy Route [%s]: SPA fallback - upstream returned 404 for %s, fetching base URL: %s",
-				routeConfig.Name, resp.Request.URL.Path, targetURL.String())
+				routeConfig.Name, resp.Request.URL.Path, baseURL)
 
-			fallbackReq, err := http.NewRequestWithContext(resp.Request.Context(), http.MethodGet, targetURL.String(), nil)
+			fallbackReq, err := http.NewRequestWithContext(resp.Request.Context(), http.MethodGet, baseURL, nil)
 			if err != nil {
 				log.Printf("Proxy Route [%s]: SPA fallback failed - could not create request: %v", routeConfig.Name, err)
 				return nil
@@ -589,22 +767,46 @@ func (g *Gateway) createProxyHandlerFunc(routeConfig config.RouteConfig, targetU
 
 	// Set up error handler
 	proxy.ErrorHandler = func(rw http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Proxy error for route '%s' (From: %s) to %s: %v", routeConfig.Name, routeConfig.From, routeConfig.To, err)
-		// Avoid writing header if already written
-		if h, ok := rw.(http.Hijacker); ok {
-			_, _, hijackErr := h.Hijack()
-			if hijackErr == nil {
-				log.Printf("Proxy error occurred after response may have started for route '%s'. Connection hijacked.", routeConfig.Name)
-				// Cannot write status code anymore.
-				return
-			}
+		target := ""
+		if info := upstream.ProxyInfoFromContext(r.Context()); info != nil {
+			target = info.Target
+		}
+		log.Printf("Proxy error for route '%s' (From: %s) to %s: %v", routeConfig.Name, routeConfig.From, target, err)
+		if errors.Is(err, upstream.ErrCircuitOpen) {
+			g.serveCircuitOpen(rw, r, routeConfig, pool)
+			return
+		}
+		if errors.Is(err, upstream.ErrNoHealthyTarget) {
+			http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, upstream.ErrAttemptTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			http.Error(rw, "Gateway Timeout", http.StatusGatewayTimeout)
+			return
 		}
-		// Check if header has been written (best effort)
 		http.Error(rw, "Bad Gateway", http.StatusBadGateway)
 	}
 
 	// Return the handler function
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Expose the route to logging and traffic metrics; the transport adds the chosen target
+		r, info := upstream.WithProxyInfo(r)
+		info.Route = routeConfig.Name
+
+		// Let WebSocket upgrades and streamed responses outlive the server timeouts
+		stream, r := newStreamWriter(w, r, routeConfig, info, g.streams)
+		defer stream.finish()
+		w = stream
+
+		// Forward the verified client certificate; its identity is replaced by the session one, if any
+		if routeConfig.ClientCert != nil {
+			if cert := middleware.VerifiedClientCertificate(r); cert != nil {
+				r.Header.Set(middleware.ClientCertSubjectHeader, cert.Subject.String())
+				r.Header.Set(middleware.ClientCertFingerprintHeader, middleware.CertificateFingerprint(cert))
+				g.setIdentityHeaders(r, routeConfig, middleware.ClientCertIdentity(cert, g.Dependencies.UserRepo))
+			}
+		}
+
 		// For authenticated routes, extract user ID and set header
 		if routeConfig.Authentication.Enabled {
 
@@ -648,13 +850,7 @@ func (g *Gateway) createProxyHandlerFunc(routeConfig config.RouteConfig, targetU
 			}
 
 			if sessionObject != nil && sessionObject.UserID != "" {
-				// Modify the original request directly using header constants
-				r.Header.Set(session.UserIdHeader, sessionObject.UserID)
-				// Set X-User-Data header with serialized session object (JSON)
-				sessionJson, err := json.Marshal(sessionObject)
-				if err == nil {
-					r.Header.Set(session.UserDataHeader, string(sessionJson))
-				}
+				g.setIdentityHeaders(r, routeConfig, sessionObject)
 
 				// Serve the request with the modified headers
 				proxy.ServeHTTP(w, r)
@@ -668,16 +864,90 @@ func (g *Gateway) createProxyHandlerFunc(routeConfig config.RouteConfig, targetU
 	}
 }
 
+// stripIdentityHeaders removes the identity headers the client sent before the route chain
+// runs: they are only set by the gateway, or by the requestHeaders rules of the route.
+func (g *Gateway) stripIdentityHeaders(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(session.UserIdHeader)
+		r.Header.Del(session.UserDataHeader)
+		r.Header.Del(middleware.ClientCertSubjectHeader)
+		r.Header.Del(middleware.ClientCertFingerprintHeader)
+		if g.identity != nil {
+			r.Header.Del(g.identity.Header())
+		}
+		next(w, r)
+	}
+}
+
+// setIdentityHeaders sets the headers that carry the identity of a session, or of a client
+// certificate, to the upstream
+func (g *Gateway) setIdentityHeaders(r *http.Request, routeConfig config.RouteConfig, identity *db.Session) {
+	r.Header.Set(session.UserIdHeader, identity.UserID)
+	// Set X-User-Data header with serialized session object (JSON)
+	sessionJson, err := json.Marshal(identity)
+	if err == nil {
+		r.Header.Set(session.UserDataHeader, string(sessionJson))
+	}
+	// Set the signed identity token, if enabled
+	if g.identity != nil {
+		if token, err := g.identity.Token(identity); err == nil {
+			r.Header.Set(g.identity.Header(), token)
+		} else {
+			log.Printf("[auth] Failed to sign identity token for route %s: %v", routeConfig.Name, err)
+		}
+	}
+}
+
+// serveCircuitOpen answers a request rejected by the route circuit breaker, either with
+// the configured fallback route or with the configured static response (503 by default).
+func (g *Gateway) serveCircuitOpen(w http.ResponseWriter, r *http.Request, routeConfig config.RouteConfig, pool *upstream.Pool) {
+	fallback := routeConfig.CircuitBreaker.Fallback
+	if fallback.Route != "" {
+		if handler, ok := g.routeHandlers[fallback.Route]; ok {
+			handler(w, r)
+			return
+		}
+		log.Printf("Proxy Route [%s]: circuit breaker fallback route '%s' is not registered", routeConfig.Name, fallback.Route)
+	}
+
+	if retryAt := pool.Breaker().Stats().RetryAt; !retryAt.IsZero() {
+		if seconds := int(time.Until(retryAt).Seconds()) + 1; seconds > 0 {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+		}
+	}
+
+	status := fallback.StatusCode
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	body := fallback.Body
+	if body == "" {
+		body = "Service Unavailable"
+	}
+	contentType := fallback.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
 // createStaticHandlerFunc generates the core handler function for static routes (without auth).
 // This function continues to serve user-defined static routes from the OS filesystem.
-func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.HandlerFunc {
+// The rewriter, if any, maps the request path to a path inside the folder.
+func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig, rewriter *pathRewriter) http.HandlerFunc {
 	var fsPath string
 	var isDir bool
 
 	log.Printf("Static Route [%s]: Creating handler for route '%s'", routeConfig.Name, routeConfig.From)
 
 	// Determine path from configuration
-	if routeConfig.ToFile != "" {
+	if routeConfig.ToArchive != "" {
+		// The archive replaces the folder; ToFile is then a file inside it
+		fsPath = routeConfig.ToArchive
+		log.Printf("Static Route [%s]: Using ToArchive path: %s", routeConfig.Name, fsPath)
+	} else if routeConfig.ToFile != "" {
 		// Use ToFile directly as an independent path
 		fsPath = routeConfig.ToFile
 		log.Printf("Static Route [%s]: Using ToFile path: %s", routeConfig.Name, fsPath)
@@ -697,7 +967,17 @@ func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.H
 		return nil
 	}
 
-	isDir = fileInfo.IsDir()
+	// Files of the archive, loaded in memory and swapped when the archive changes
+	var archive *archiveFileSystem
+	if routeConfig.ToArchive != "" {
+		var err error
+		if archive, err = newArchiveFileSystem(fsPath); err != nil {
+			log.Printf("Warning: Invalid archive '%s' for static route '%s': %v. Skipping registration.", fsPath, routeConfig.Name, err)
+			return nil
+		}
+	}
+
+	isDir = fileInfo.IsDir() || (archive != nil && routeConfig.ToFile == "")
 	log.Printf("Static Route [%s]: Path '%s' is directory: %t", routeConfig.Name, fsPath, isDir)
 
 	// Check if removeFromPath is used with static routes (not applicable)
@@ -706,10 +986,30 @@ func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.H
 			routeConfig.Name, routeConfig.RemoveFromPath)
 	}
 
+	compression := g.CompressionMiddleware.Settings(routeConfig)
+	etags := newETagCache()
+
 	if isDir {
-		// Directory serving
-		fs := http.Dir(fsPath) // Serve from the resolved directory path
-		fileServer := http.FileServer(fs)
+		// Directory serving, from the resolved directory path or the archive
+		var files http.FileSystem = http.Dir(fsPath)
+		if archive != nil {
+			files = archive
+		}
+		// Precompressed siblings (.br, .gz) are served instead of compressing the files on the fly
+		var fileServer http.Handler = newPrecompressedHandler(files, http.FileServer(files), compression, "", etags)
+		fileServer = &staticAssetHandler{
+			files:     files,
+			next:      fileServer,
+			etags:     etags,
+			immutable: routeConfig.ImmutableAssetsEnabled(),
+			listing:   routeConfig.DirectoryListingEnabled(),
+		}
+
+		// Wrap with SPA handler if needed
+		if routeConfig.IsSPA {
+			fileServer = g.createSPAHandler(fileServer, files, routeConfig)
+			log.Printf("Static Route [%s]: Wrapped with SPA handler", routeConfig.Name)
+		}
 
 		// For static routes, determine if we should strip the route prefix
 		routePrefix := routeConfig.From
@@ -726,10 +1026,13 @@ func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.H
 			firstComponent := strings.Split(trimmedPrefix, "/")[0]
 
 			// Check if a subdirectory with this name exists in the target folder
-			potentialSubdir := filepath.Join(fsPath, firstComponent)
-			if stat, err := os.Stat(potentialSubdir); err == nil && stat.IsDir() {
-				shouldPreserveFullPath = true
-				log.Printf("Static Route [%s]: Found matching subdirectory '%s', preserving full URL path", routeConfig.Name, firstComponent)
+			if subdir, err := files.Open("/" + firstComponent); err == nil {
+				stat, err := subdir.Stat()
+				subdir.Close()
+				if err == nil && stat.IsDir() {
+					shouldPreserveFullPath = true
+					log.Printf("Static Route [%s]: Found matching subdirectory '%s', preserving full URL path", routeConfig.Name, firstComponent)
+				}
 			}
 		}
 
@@ -738,7 +1041,11 @@ func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.H
 
 		// Choose handler based on whether to preserve full path
 		var finalHandler http.Handler
-		if routePrefix == "/" || shouldPreserveFullPath {
+		if rewriter != nil {
+			// The rewritten path is looked up from the root of the folder
+			finalHandler = rewriter.handler(fileServer)
+			log.Printf("Static Route [%s]: Using rewritten paths (path: '%s', regex: '%s')", routeConfig.Name, routeConfig.Rewrite.Path, routeConfig.Rewrite.Regex)
+		} else if routePrefix == "/" || shouldPreserveFullPath {
 			finalHandler = fileServer
 			log.Printf("Static Route [%s]: Using direct file server handler (preserving full URL path)", routeConfig.Name)
 		} else {
@@ -746,12 +1053,6 @@ func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.H
 			log.Printf("Static Route [%s]: Using StripPrefix handler with prefix: %s", routeConfig.Name, routePrefix)
 		}
 
-		// Wrap with SPA handler if needed
-		if routeConfig.IsSPA {
-			finalHandler = g.createSPAHandler(finalHandler, fsPath, routeConfig)
-			log.Printf("Static Route [%s]: Wrapped with SPA handler", routeConfig.Name)
-		}
-
 		return func(w http.ResponseWriter, r *http.Request) {
 			finalHandler.ServeHTTP(w, r)
 		}
@@ -761,12 +1062,33 @@ func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.H
 		filePath := fsPath // Already cleaned in loadConfig
 		log.Printf("Static Route [%s]: Setting up single file serving - filePath: %s", routeConfig.Name, filePath)
 
+		var files http.FileSystem = http.Dir(filepath.Dir(filePath))
+		name := "/" + filepath.Base(filePath)
+		var serveFile http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, filePath)
+		})
+		if archive != nil {
+			// The file inside the archive
+			files, name = archive, path.Clean("/"+routeConfig.ToFile)
+			filePath = fsPath + ":" + name
+			serveFile = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				serveArchiveFile(w, r, files, name)
+			})
+		}
+		fileServer := &staticAssetHandler{
+			files:     files,
+			next:      newPrecompressedHandler(files, serveFile, compression, name, etags),
+			etags:     etags,
+			file:      name,
+			immutable: routeConfig.ImmutableAssetsEnabled(),
+		}
+
 		// For single file routes, we need to handle both with and without trailing slash
 		// Register the handler for both patterns to avoid redirects
 		return func(w http.ResponseWriter, r *http.Request) {
 
 			// Check existence/type at request time
-			fileInfo, err := os.Stat(filePath)
+			fileInfo, err := statStaticFile(files, name)
 			if err != nil {
 				if os.IsNotExist(err) {
 					log.Printf("Static Route [%s]: File not found: %s - returning 404", routeConfig.Name, filePath)
@@ -783,99 +1105,90 @@ func (g *Gateway) createStaticHandlerFunc(routeConfig config.RouteConfig) http.H
 				return
 			}
 
-			http.ServeFile(w, r, filePath)
+			fileServer.ServeHTTP(w, r)
 		}
 	}
 }
 
+// statStaticFile returns the information of the file with the name in files
+func statStaticFile(files http.FileSystem, name string) (fs.FileInfo, error) {
+	f, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// serveArchiveFile sends the file of a single file route served from an archive
+func serveArchiveFile(w http.ResponseWriter, r *http.Request, files http.FileSystem, name string) {
+	f, err := files.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
 // createSPAHandler wraps a file server handler with SPA (Single Page Application) routing logic.
-// When a file is not found (404), it serves the index.html from the root of the static folder.
-func (g *Gateway) createSPAHandler(handler http.Handler, fsPath string, routeConfig config.RouteConfig) http.Handler {
+// When the requested file does not exist, it serves the index.html from the root of the static folder.
+func (g *Gateway) createSPAHandler(handler http.Handler, files http.FileSystem, routeConfig config.RouteConfig) http.Handler {
 	return &spaHandler{
 		handler:     handler,
-		fsPath:      fsPath,
+		files:       files,
 		routeConfig: routeConfig,
 	}
 }
 
-// spaHandler implements http.Handler and provides SPA routing functionality
+// spaHandler implements http.Handler and provides SPA routing functionality. The file is
+// resolved before the file server runs, so both the assets and the index.html fallback are
+// streamed by it, with ranges, conditional requests and Content-Length.
 type spaHandler struct {
 	handler     http.Handler
-	fsPath      string
+	files       http.FileSystem
 	routeConfig config.RouteConfig
 }
 
 func (s *spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Create a custom ResponseWriter to capture 404 errors
-	recorder := &spaResponseRecorder{
-		ResponseWriter: w,
-		status:         200, // Default to 200
-	}
-
-	// Let the original handler process the request
-	s.handler.ServeHTTP(recorder, r)
-
-	// If we got a 404 and this is a SPA route, serve index.html instead
-	if recorder.status == 404 && !recorder.responseWritten {
-		indexPath := filepath.Join(s.fsPath, "index.html")
-
-		// Check if index.html exists
-		if _, err := os.Stat(indexPath); err == nil {
-			log.Printf("Static Route [%s]: SPA fallback - File not found, serving index.html: %s", s.routeConfig.Name, indexPath)
-
-			// Clear any headers and set appropriate content type
-			for key := range w.Header() {
-				w.Header().Del(key)
-			}
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-			// Serve the index.html file directly (this will handle status code)
-			http.ServeFile(w, r, indexPath)
-			return
-		} else {
-			log.Printf("Static Route [%s]: SPA fallback failed - index.html not found at: %s", s.routeConfig.Name, indexPath)
-			// Write the 404 response if index.html doesn't exist
-			w.WriteHeader(404)
-			return
-		}
+	if !s.notFound(r.URL.Path) {
+		s.handler.ServeHTTP(w, r)
+		return
 	}
 
-	// If not a 404 or response was already written, write the captured response
-	if recorder.responseWritten {
-		return // Response already sent
+	if s.notFound("/index.html") {
+		log.Printf("Static Route [%s]: SPA fallback failed - index.html not found for: %s", s.routeConfig.Name, r.URL.Path)
+		http.NotFound(w, r)
+		return
 	}
-
-	// Write the status and data if response wasn't written yet
-	w.WriteHeader(recorder.status)
-	if len(recorder.data) > 0 {
-		w.Write(recorder.data)
-	}
-}
-
-// spaResponseRecorder is a custom ResponseWriter that captures the status code
-type spaResponseRecorder struct {
-	http.ResponseWriter
-	status          int
-	wroteHeader     bool
-	responseWritten bool
-	data            []byte
+	log.Printf("Static Route [%s]: SPA fallback - File not found, serving index.html for: %s", s.routeConfig.Name, r.URL.Path)
+
+	// The file server serves index.html for the root of the folder
+	fallback := new(http.Request)
+	*fallback = *r
+	fallbackURL := *r.URL
+	fallbackURL.Path = "/"
+	fallbackURL.RawPath = ""
+	fallback.URL = &fallbackURL
+	s.handler.ServeHTTP(w, fallback)
 }
 
-func (r *spaResponseRecorder) WriteHeader(status int) {
-	if !r.wroteHeader {
-		r.status = status
-		r.wroteHeader = true
-		// Don't write to the underlying response yet
+// notFound reports whether the file server would answer 404 for the path
+func (s *spaHandler) notFound(name string) bool {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
 	}
-}
-
-func (r *spaResponseRecorder) Write(data []byte) (int, error) {
-	if !r.wroteHeader {
-		r.WriteHeader(200)
+	f, err := s.files.Open(path.Clean(name))
+	if err != nil {
+		return errors.Is(err, fs.ErrNotExist)
 	}
-	// Capture the data instead of writing it immediately
-	r.data = append(r.data, data...)
-	return len(data), nil
+	f.Close()
+	return false
 }
 
 // --- Utility Functions ---
@@ -925,25 +1238,26 @@ func hasMiddleWildcard(from string) bool {
 	return true
 }
 
-// singleJoiningSlash remains unchanged
-func singleJoiningSlash(a, b string) string {
-	aslash := strings.HasSuffix(a, "/")
-	bslash := strings.HasPrefix(b, "/")
-	switch {
-	case aslash && bslash:
-		return a + b[1:]
-	case !aslash && !bslash:
-		if b == "" {
-			return a
-		}
-		if len(b) > 0 && !strings.HasPrefix(b, "/") {
-			b = "/" + b
-		}
-		return a + b
-	case aslash && !bslash:
-		return a + b
-	case !aslash && bslash:
-		return a + b
-	}
-	return a + b
+// rewriteForTarget returns the function that points an outgoing proxy request at the
+// chosen upstream target, applying the route's path stripping rules.
+func rewriteForTarget(routeConfig config.RouteConfig) func(req *http.Request, target *upstream.Target) {
+	return func(req *http.Request, target *upstream.Target) {
+		inboundPath := req.URL.Path
+		upstream.DirectTo(req, target)
+
+		// Apply path stripping
+		if routeConfig.RemoveFromPath != "" && strings.HasPrefix(inboundPath, routeConfig.RemoveFromPath) {
+			req.URL.Path = strings.TrimPrefix(inboundPath, routeConfig.RemoveFromPath)
+
+			if len(req.URL.Path) > 0 && !strings.HasPrefix(req.URL.Path, "/") {
+				req.URL.Path = "/" + req.URL.Path
+			} else if len(req.URL.Path) == 0 {
+				req.URL.Path = "/"
+			}
+
+			// When using RemoveFromPath, we should NOT join with the target path
+			// as that would reintroduce the prefix we just removed
+			req.URL.RawPath = req.URL.EscapedPath()
+		}
+	}
 }
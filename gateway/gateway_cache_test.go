@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/cache"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Server", "backend/1.0")
+		w.Write([]byte("catalog"))
+	}))
+	defer backend.Close()
+
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes: []config.RouteConfig{
+			{
+				Name:            "Catalog",
+				From:            "/catalog/*",
+				To:              backend.URL,
+				Cache:           &config.RouteCacheConfig{},
+				ResponseHeaders: &config.HeadersConfig{Set: map[string]string{"X-Served-By": "{route}"}, Remove: []string{"Server"}},
+			},
+			{Name: "Orders", From: "/orders/*", To: backend.URL},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayResponseCache"))
+	require.NoError(t, err)
+	require.NotNil(t, gateway.ResponseCache)
+	defer gateway.ResponseCache.Close()
+
+	for i, want := range []string{cache.StatusMiss, cache.StatusHit} {
+		rec := httptest.NewRecorder()
+		gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/catalog/items", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "catalog", rec.Body.String())
+		assert.Equal(t, want, rec.Header().Get(cache.StatusHeader), "request %d", i)
+		assert.Equal(t, "Catalog", rec.Header().Get("X-Served-By"), "header rules apply to stored responses")
+		assert.Empty(t, rec.Header().Get("Server"))
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders/items", nil))
+		assert.Empty(t, rec.Header().Get(cache.StatusHeader), "routes without a cache block are not cached")
+	}
+	assert.Equal(t, int32(3), calls.Load())
+
+	stats := gateway.ResponseCache.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+
+	// The counters of the routes are kept across reloads
+	for range 2 {
+		_, err = gateway.Reload(gwConfig)
+		require.NoError(t, err)
+	}
+	rec := httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/catalog/items", nil))
+	assert.Equal(t, cache.StatusHit, rec.Header().Get(cache.StatusHeader))
+	stats = gateway.ResponseCache.Stats()
+	require.Len(t, stats.Routes, 1)
+	assert.Equal(t, "Catalog", stats.Routes[0].Route)
+	assert.Equal(t, int64(2), stats.Routes[0].Hits)
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+}
+
+func TestGatewayWithoutCachedRoutesHasNoCache(t *testing.T) {
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes:     []config.RouteConfig{{Name: "Orders", From: "/orders/*", To: "http://127.0.0.1:1"}},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayWithoutCachedRoutesHasNoCache"))
+	require.NoError(t, err)
+	assert.Nil(t, gateway.ResponseCache)
+}
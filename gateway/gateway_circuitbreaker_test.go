@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyCircuitBreaker(t *testing.T) {
+	var hits atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	backup := newNamedBackend("backup")
+	defer backup.Close()
+
+	t.Run("static fallback response", func(t *testing.T) {
+		hits.Store(0)
+		server := newLoadBalancedGateway(t, config.RouteConfig{
+			Name: "Fragile API",
+			From: "/api/*",
+			To:   failing.URL,
+			CircuitBreaker: &config.CircuitBreakerConfig{
+				ConsecutiveFailures: 2,
+				OpenSeconds:         60,
+				Fallback: config.CircuitBreakerFallback{
+					StatusCode:  http.StatusServiceUnavailable,
+					Body:        `{"error":"orders temporarily unavailable"}`,
+					ContentType: "application/json",
+				},
+			},
+		})
+
+		for i := 0; i < 2; i++ {
+			status, _ := getBody(t, server.URL+"/api/orders")
+			assert.Equal(t, http.StatusInternalServerError, status)
+		}
+
+		resp, err := http.Get(server.URL + "/api/orders")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+		assert.Equal(t, int32(2), hits.Load(), "the open breaker must not reach the upstream")
+	})
+
+	t.Run("fallback route", func(t *testing.T) {
+		gatewayConfig := &config.GatewayConfig{
+			Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+			Management: config.ManagementConfig{Prefix: "/_"},
+			Routes: []config.RouteConfig{
+				{
+					Name: "Fragile API",
+					From: "/api/*",
+					To:   failing.URL,
+					CircuitBreaker: &config.CircuitBreakerConfig{
+						ConsecutiveFailures: 1,
+						OpenSeconds:         60,
+						Fallback:            config.CircuitBreakerFallback{Route: "Backup API"},
+					},
+				},
+				{
+					Name: "Backup API",
+					From: "/backup/*",
+					To:   backup.URL,
+				},
+			},
+		}
+		gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, deps.NewTest())
+		require.NoError(t, err)
+		server := httptest.NewServer(gateway.Mux)
+		defer server.Close()
+
+		status, _ := getBody(t, server.URL+"/api/orders")
+		assert.Equal(t, http.StatusInternalServerError, status)
+
+		status, body := getBody(t, server.URL+"/api/orders")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "backup /api/orders", body)
+	})
+}
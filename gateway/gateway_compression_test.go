@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayCompression(t *testing.T) {
+	script := strings.Repeat("console.log('taronja');\n", 100)
+	styles := strings.Repeat("body { color: orange; }\n", 100)
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"app.js":        script,
+		"app.js.br":     "brotli bytes",
+		"app.js.gz":     "gzip bytes",
+		"styles.css":    styles,
+		"index.html":    "<!DOCTYPE html><title>Taronja</title>",
+		"index.html.gz": "gzip index",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[` + strings.Repeat(`{"id":1},`, 200) + `{"id":2}]`))
+	}))
+	defer backend.Close()
+
+	disabled := false
+	gwConfig := &config.GatewayConfig{
+		Server:      config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management:  config.ManagementConfig{Prefix: "/_"},
+		Compression: config.CompressionConfig{Enabled: true},
+		Routes: []config.RouteConfig{
+			{Name: "Orders", From: "/orders/*", To: backend.URL},
+			{Name: "Raw", From: "/raw/*", To: backend.URL, Compression: &config.RouteCompressionConfig{Enabled: &disabled}},
+			{Name: "Script", From: "/script.js", Static: true, ToFile: filepath.Join(dir, "app.js")},
+			{Name: "Site", From: "/*", Static: true, ToFolder: dir},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayCompression"))
+	require.NoError(t, err)
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		gateway.Mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("precompressed siblings", func(t *testing.T) {
+		tests := []struct {
+			path, accept, encoding, body string
+		}{
+			{"/app.js", "gzip, br", "br", "brotli bytes"},
+			{"/app.js", "gzip", "gzip", "gzip bytes"},
+			{"/script.js", "br", "br", "brotli bytes"},
+			{"/", "br, gzip", "gzip", "gzip index"},
+		}
+		for _, tt := range tests {
+			rec := get(tt.path, tt.accept)
+			require.Equal(t, http.StatusOK, rec.Code, tt.path)
+			assert.Equal(t, tt.encoding, rec.Header().Get("Content-Encoding"), tt.path)
+			assert.Equal(t, tt.body, rec.Body.String(), tt.path)
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"), tt.path)
+		}
+		assert.Equal(t, "text/javascript; charset=utf-8", get("/app.js", "br").Header().Get("Content-Type"))
+		assert.Equal(t, "text/html; charset=utf-8", get("/", "gzip").Header().Get("Content-Type"))
+	})
+
+	t.Run("original without a sibling accepted", func(t *testing.T) {
+		rec := get("/app.js", "identity")
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, script, rec.Body.String())
+	})
+
+	t.Run("static files compressed on the fly", func(t *testing.T) {
+		rec := get("/styles.css", "gzip")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, styles, gunzip(t, rec.Body))
+	})
+
+	t.Run("proxied responses", func(t *testing.T) {
+		rec := get("/orders/list", "gzip")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		assert.True(t, strings.HasSuffix(gunzip(t, rec.Body), `{"id":2}]`))
+
+		rec = get("/raw/list", "gzip")
+		assert.Empty(t, rec.Header().Get("Content-Encoding"), "compression disabled for the route")
+		assert.True(t, strings.HasSuffix(rec.Body.String(), `{"id":2}]`))
+	})
+}
+
+func gunzip(t *testing.T, r io.Reader) string {
+	t.Helper()
+	gr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	return string(data)
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newNamedBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + " " + r.URL.Path))
+	}))
+}
+
+func newLoadBalancedGateway(t *testing.T, route config.RouteConfig) *httptest.Server {
+	t.Helper()
+	gatewayConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes:     []config.RouteConfig{route},
+	}
+	gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, deps.NewTest())
+	require.NoError(t, err)
+
+	server := httptest.NewServer(gateway.Mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getBody(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestProxyRoundRobinAcrossTargets(t *testing.T) {
+	backendA := newNamedBackend("A")
+	defer backendA.Close()
+	backendB := newNamedBackend("B")
+	defer backendB.Close()
+
+	server := newLoadBalancedGateway(t, config.RouteConfig{
+		Name:           "Balanced API",
+		From:           "/api/*",
+		RemoveFromPath: "/api",
+		Targets: []config.UpstreamTarget{
+			{URL: backendA.URL},
+			{URL: backendB.URL},
+		},
+	})
+
+	var bodies []string
+	for i := 0; i < 4; i++ {
+		status, body := getBody(t, server.URL+"/api/items")
+		assert.Equal(t, http.StatusOK, status)
+		bodies = append(bodies, body)
+	}
+	assert.Equal(t, []string{"A /items", "B /items", "A /items", "B /items"}, bodies)
+}
+
+func TestProxyConsistentHashKeepsClientOnTarget(t *testing.T) {
+	backendA := newNamedBackend("A")
+	defer backendA.Close()
+	backendB := newNamedBackend("B")
+	defer backendB.Close()
+
+	server := newLoadBalancedGateway(t, config.RouteConfig{
+		Name: "Sticky API",
+		From: "/api/*",
+		Targets: []config.UpstreamTarget{
+			{URL: backendA.URL},
+			{URL: backendB.URL},
+		},
+		LoadBalancing: config.LoadBalancingConfig{
+			Strategy: config.StrategyConsistentHash,
+			HashOn:   "header",
+			HashKey:  "X-Tenant",
+		},
+	})
+
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequest("GET", server.URL+"/api/items", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Tenant", "acme")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		seen[string(body)] = true
+	}
+	assert.Len(t, seen, 1, "all requests of a tenant should reach the same target")
+}
+
+func TestProxyPassiveHealthCheckRemovesFailingTarget(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	backend := newNamedBackend("B")
+	defer backend.Close()
+
+	server := newLoadBalancedGateway(t, config.RouteConfig{
+		Name: "Guarded API",
+		From: "/api/*",
+		Targets: []config.UpstreamTarget{
+			{URL: failing.URL},
+			{URL: backend.URL},
+		},
+		HealthCheck: &config.HealthCheckConfig{
+			Passive: config.PassiveHealthCheckConfig{MaxFailures: 1, CooldownSeconds: 60},
+		},
+	})
+
+	status, _ := getBody(t, server.URL+"/api/items")
+	assert.Equal(t, http.StatusInternalServerError, status, "the first request reaches the failing target")
+
+	for i := 0; i < 3; i++ {
+		status, body := getBody(t, server.URL+"/api/items")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "B /api/items", body)
+	}
+}
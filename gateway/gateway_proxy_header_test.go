@@ -2,12 +2,20 @@ package gateway
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -138,3 +146,187 @@ func TestGatewayProxiesXUserIdHeader(t *testing.T) {
 	// Assert that the backend received the correct X-User-Id header
 	assert.Equal(t, testUser.ID, receivedUserId, "backend should receive correct X-User-Id header from gateway")
 }
+
+func TestGatewayRouteHeaders(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Server", "backend/1.0")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0644))
+
+	headers := &config.HeadersConfig{Set: map[string]string{"X-Served-By": "{route}"}, Remove: []string{"Server"}}
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes: []config.RouteConfig{
+			{
+				Name:           "Orders",
+				From:           "/orders/*",
+				To:             backend.URL,
+				Authentication: config.AuthenticationConfig{Enabled: true},
+				RequestHeaders: &config.HeadersConfig{
+					Set:    map[string]string{"X-Email": "{user.email}", "X-Admin": "{user.admin}"},
+					Remove: []string{"X-Debug"},
+				},
+				ResponseHeaders: headers,
+			},
+			{Name: "Site", From: "/*", Static: true, ToFolder: dir, ResponseHeaders: headers},
+		},
+	}
+	d := deps.NewTestWithName("TestGatewayRouteHeaders")
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, d)
+	require.NoError(t, err)
+
+	sess, err := d.SessionStore.NewSession(httptest.NewRequest(http.MethodGet, "/", nil),
+		&db.User{ID: "user-9", Username: "ana", Email: "ana@example.com"}, "test", time.Hour)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	req.AddCookie(&http.Cookie{Name: session.SessionCookieName, Value: sess.Token})
+	req.Header.Set("X-Debug", "1")
+	rec := httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ana@example.com", received.Get("X-Email"))
+	assert.Equal(t, "false", received.Get("X-Admin"))
+	assert.Empty(t, received.Get("X-Debug"))
+	assert.Equal(t, "Orders", rec.Header().Get("X-Served-By"))
+	assert.Empty(t, rec.Header().Get("Server"))
+
+	rec = httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	assert.Equal(t, "Site", rec.Header().Get("X-Served-By"), "static routes apply the same rules")
+}
+
+func TestGatewayStripsClientIdentityHeaders(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Identity:   config.IdentityConfig{JWT: config.IdentityJWTConfig{Enabled: true, Secret: strings.Repeat("s", 32)}},
+		Routes: []config.RouteConfig{
+			{Name: "Public", From: "/public/*", To: backend.URL},
+			{
+				Name:           "Tenant",
+				From:           "/tenant/*",
+				To:             backend.URL,
+				RequestHeaders: &config.HeadersConfig{Set: map[string]string{session.UserIdHeader: "tenant-1"}},
+			},
+			{Name: "Private", From: "/private/*", To: backend.URL, Authentication: config.AuthenticationConfig{Enabled: true}},
+		},
+	}
+	d := deps.NewTestWithName("TestGatewayStripsClientIdentityHeaders")
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, d)
+	require.NoError(t, err)
+
+	spoofed := func(path string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(session.UserIdHeader, "admin")
+		req.Header.Set(session.UserDataHeader, `{"userId":"admin","isAdmin":true}`)
+		req.Header.Set(config.DefaultIdentityJWTHeader, "forged")
+		return req
+	}
+
+	gateway.Mux.ServeHTTP(httptest.NewRecorder(), spoofed("/public/orders"))
+	require.NotNil(t, received)
+	assert.Empty(t, received.Get(session.UserIdHeader))
+	assert.Empty(t, received.Get(session.UserDataHeader))
+	assert.Empty(t, received.Get(config.DefaultIdentityJWTHeader))
+
+	// the rules of the route set the header after the client one is removed
+	received = nil
+	gateway.Mux.ServeHTTP(httptest.NewRecorder(), spoofed("/tenant/orders"))
+	require.NotNil(t, received)
+	assert.Equal(t, "tenant-1", received.Get(session.UserIdHeader))
+	assert.Empty(t, received.Get(session.UserDataHeader))
+
+	sess, err := d.SessionStore.NewSession(httptest.NewRequest(http.MethodGet, "/", nil),
+		&db.User{ID: "user-9", Username: "ana", Email: "ana@example.com"}, "test", time.Hour)
+	require.NoError(t, err)
+	req := spoofed("/private/orders")
+	req.AddCookie(&http.Cookie{Name: session.SessionCookieName, Value: sess.Token})
+	received = nil
+	gateway.Mux.ServeHTTP(httptest.NewRecorder(), req)
+	require.NotNil(t, received)
+	assert.Equal(t, "user-9", received.Get(session.UserIdHeader))
+	assert.NotContains(t, received.Get(session.UserDataHeader), `"isAdmin":true`)
+	token := received.Get(config.DefaultIdentityJWTHeader)
+	assert.NotEqual(t, "forged", token)
+	assert.Len(t, strings.Split(token, "."), 3, "a signed JWT is forwarded")
+}
+
+func TestGatewayForwardsClientAddress(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0, TrustedProxies: []string{"10.0.0.0/8"}},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes: []config.RouteConfig{
+			{Name: "Orders", From: "/orders/*", To: backend.URL},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayForwardsClientAddress"))
+	require.NoError(t, err)
+
+	// A client spoofing its address
+	req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	req.RemoteAddr = "198.51.100.7:4711"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Real-IP", "1.2.3.4")
+	req.Header.Set("Forwarded", "for=1.2.3.4")
+	gateway.ServeHTTP(httptest.NewRecorder(), req)
+	require.NotNil(t, received)
+	assert.Equal(t, "198.51.100.7", received.Get("X-Forwarded-For"))
+	assert.Empty(t, received.Get("X-Real-IP"))
+	assert.Empty(t, received.Get("Forwarded"))
+
+	// A client behind a trusted proxy
+	req = httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	req.RemoteAddr = "10.0.0.1:4711"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.1")
+	gateway.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "203.0.113.1, 10.0.0.1", received.Get("X-Forwarded-For"))
+}
+
+func TestGatewayServesJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	keyFile := filepath.Join(t.TempDir(), "identity.pem")
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Identity:   config.IdentityConfig{JWT: config.IdentityJWTConfig{Enabled: true, PrivateKeyFile: keyFile, KeyID: "k1"}},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayServesJWKS"))
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	gateway.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "k1", jwks.Keys[0]["kid"])
+	assert.Equal(t, "RS256", jwks.Keys[0]["alg"])
+	assert.Equal(t, "AQAB", jwks.Keys[0]["e"])
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyRetriesResetConnectionOnAnotherTarget(t *testing.T) {
+	var resets atomic.Int32
+	resetting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resets.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer resetting.Close()
+	backend := newNamedBackend("B")
+	defer backend.Close()
+
+	server := newLoadBalancedGateway(t, config.RouteConfig{
+		Name: "Retried API",
+		From: "/api/*",
+		Targets: []config.UpstreamTarget{
+			{URL: resetting.URL},
+			{URL: backend.URL},
+		},
+		Retry: &config.RetryConfig{Attempts: 2, BackoffMs: 1},
+	})
+
+	for i := 0; i < 4; i++ {
+		status, body := getBody(t, server.URL+"/api/items")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "B /api/items", body)
+	}
+	assert.Positive(t, resets.Load(), "requests must have reached the resetting target first")
+}
+
+func TestProxyPerTryTimeoutReturnsGatewayTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	server := newLoadBalancedGateway(t, config.RouteConfig{
+		Name:  "Slow API",
+		From:  "/api/*",
+		To:    slow.URL,
+		Retry: &config.RetryConfig{Attempts: 2, BackoffMs: 1, PerTryTimeoutMs: 50},
+	})
+
+	status, _ := getBody(t, server.URL+"/api/items")
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+}
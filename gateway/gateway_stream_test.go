@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStreamingGateway serves the full gateway handler, global middlewares included, with
+// server timeouts far shorter than the streams of the tests.
+func newStreamingGateway(t *testing.T, dependencies *deps.Dependencies, route config.RouteConfig) *httptest.Server {
+	t.Helper()
+	gatewayConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_", Logging: true, Analytics: true},
+		Routes:     []config.RouteConfig{route},
+	}
+	gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, dependencies)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(gateway.Server.Handler)
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newEchoUpgradeBackend switches to a line-based echo protocol on upgrade requests.
+func newEchoUpgradeBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString("echo: " + line)
+			brw.Flush()
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestProxyUpgradedConnectionOutlivesServerTimeouts(t *testing.T) {
+	backend := newEchoUpgradeBackend(t)
+	dependencies := deps.NewTestWithName("TestProxyUpgradedConnection")
+	server := newStreamingGateway(t, dependencies, config.RouteConfig{
+		Name: "Realtime",
+		From: "/ws/*",
+		To:   backend.URL,
+	})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /ws/echo HTTP/1.1\r\nHost: gateway\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// Keep talking past the server read and write timeouts
+	for i := 0; i < 3; i++ {
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprintf(conn, "ping %d\n", i)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("echo: ping %d\n", i), line)
+	}
+	conn.Close()
+
+	var metric db.TrafficMetric
+	require.Eventually(t, func() bool {
+		metrics, err := dependencies.TrafficMetricRepo.FindByPath("/ws/echo", 1)
+		if err != nil || len(metrics) == 0 {
+			return false
+		}
+		metric = metrics[0]
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, http.StatusSwitchingProtocols, metric.HttpStatus)
+	assert.Equal(t, "echo", metric.StreamType)
+	assert.Equal(t, int64(len("ping 0\nping 1\nping 2\n")), metric.StreamBytesIn)
+	assert.Equal(t, int64(len("echo: ping 0\necho: ping 1\necho: ping 2\n")), metric.StreamBytesOut)
+	assert.GreaterOrEqual(t, metric.ResponseTimeNs, int64(450*time.Millisecond))
+}
+
+func TestProxyServerSentEventsOutliveServerTimeouts(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 4; i++ {
+			fmt.Fprintf(w, "data: event %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	server := newStreamingGateway(t, deps.NewTestWithName("TestProxyServerSentEvents"), config.RouteConfig{
+		Name: "Events",
+		From: "/events/*",
+		To:   backend.URL,
+	})
+
+	resp, err := http.Get(server.URL + "/events/feed")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	first, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: event 0\n", first, "events must be flushed as they arrive")
+
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "\ndata: event 1\n\ndata: event 2\n\ndata: event 3\n\n", string(rest))
+}
+
+func TestProxyStreamIdleTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+
+	server := newStreamingGateway(t, deps.NewTestWithName("TestProxyStreamIdleTimeout"), config.RouteConfig{
+		Name:     "Quiet events",
+		From:     "/events/*",
+		To:       backend.URL,
+		Timeouts: &config.RouteTimeoutsConfig{StreamIdleSeconds: 1},
+	})
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/events/feed")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	io.ReadAll(resp.Body)
+
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, time.Second)
+	assert.Less(t, elapsed, 3*time.Second, "the idle stream must be closed")
+}
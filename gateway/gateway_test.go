@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/gateway/deps"
 	"github.com/jmaister/taronja-gateway/static"
 	"github.com/stretchr/testify/assert"
@@ -905,6 +906,75 @@ window.onload = function() {
 	}
 }
 
+func TestStaticSPARangeAndConditionalRequests(t *testing.T) {
+	tempDir := t.TempDir()
+	indexContent := "<!DOCTYPE html><html><body>SPA Application</body></html>"
+	assetContent := strings.Repeat("0123456789", 1000)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "index.html"), []byte(indexContent), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "bundle.js"), []byte(assetContent), 0644))
+
+	gatewayConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes:     []config.RouteConfig{{Name: "SPA Route", From: "/app/*", ToFolder: tempDir, Static: true, IsSPA: true}},
+	}
+	gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, deps.NewTest())
+	require.NoError(t, err)
+
+	serve := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		gateway.Mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Assets keep their length and ranges
+	rec := serve("/app/bundle.js", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, fmt.Sprint(len(assetContent)), rec.Header().Get("Content-Length"))
+	rec = serve("/app/bundle.js", map[string]string{"Range": "bytes=10-19"})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "0123456789", rec.Body.String())
+	assert.Equal(t, fmt.Sprintf("bytes 10-19/%d", len(assetContent)), rec.Header().Get("Content-Range"))
+
+	// The index.html fallback supports the same
+	rec = serve("/app/settings/profile", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, indexContent, rec.Body.String())
+	assert.Equal(t, fmt.Sprint(len(indexContent)), rec.Header().Get("Content-Length"))
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	lastModified := rec.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+
+	rec = serve("/app/settings/profile", map[string]string{"If-Modified-Since": lastModified})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	rec = serve("/app/settings/profile", map[string]string{"Range": "bytes=0-14"})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "<!DOCTYPE html>", rec.Body.String())
+}
+
+func TestStaticSPAWithoutIndex(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "app.js"), []byte("console.log('app');"), 0644))
+
+	gatewayConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes:     []config.RouteConfig{{Name: "SPA Route", From: "/*", ToFolder: tempDir, Static: true, IsSPA: true}},
+	}
+	gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, deps.NewTest())
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/about", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "no directory listing when index.html is missing")
+}
+
 func TestProxySPAFallback(t *testing.T) {
 	const indexContent = "<html><body>SPA Root</body></html>"
 	const cssContent = "body { color: red; }"
@@ -1016,3 +1086,116 @@ func TestProxySPAFallback(t *testing.T) {
 func contains(s, substr string) bool {
 	return substr != "" && s != substr && s != "" && strings.Contains(s, substr)
 }
+
+func TestGatewayRateLimitPolicies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	gwConfig := &config.GatewayConfig{
+		Server: config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_", RateLimiter: config.RateLimiterConfig{
+			Policies: map[string]config.RateLimitPolicyConfig{
+				"search": {Requests: 2, PeriodSeconds: 1, Key: "header:X-Api-Key"},
+			},
+		}},
+		Routes: []config.RouteConfig{
+			{Name: "Search", From: "/search/*", To: backend.URL, RateLimit: []string{"search"}},
+			{Name: "Orders", From: "/orders/*", To: backend.URL},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayRateLimitPolicies"))
+	require.NoError(t, err)
+	defer gateway.RateLimiter.Close()
+
+	serve := func(path, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Api-Key", apiKey)
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 2 {
+		assert.Equal(t, http.StatusOK, serve("/search/q", "key-1").Code)
+	}
+	rec := serve("/search/q", "key-1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "2;w=1;burst=2", rec.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusOK, serve("/search/q", "key-2").Code, "another API key")
+	rec = serve("/orders/1", "key-1")
+	assert.Equal(t, http.StatusOK, rec.Code, "another route")
+	assert.Empty(t, rec.Header().Get("RateLimit-Remaining"))
+}
+
+func TestGatewayRestoresRateLimiterBlocks(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	dependencies := deps.NewTestWithName("TestGatewayRestoresRateLimiterBlocks")
+	until := time.Now().Add(time.Hour)
+	require.NoError(t, dependencies.BlockListRepo.CreateBlockEvent(&db.BlockEvent{
+		Network: "192.0.2.1", Reason: db.BlockReasonScan, Path: "/.env", StartedAt: time.Now(), EndsAt: &until,
+	}))
+	require.NoError(t, dependencies.BlockListRepo.CreateBlockEvent(&db.BlockEvent{
+		Network: "198.51.100.0/24", Reason: db.BlockReasonManual, StartedAt: time.Now(),
+	}))
+	require.NoError(t, dependencies.BlockListRepo.CreateAllowlistEntry(&db.AllowlistEntry{Network: "198.51.100.7"}))
+
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_", RateLimiter: config.RateLimiterConfig{RequestsPerMinute: 100, BlockMinutes: 5}},
+		Routes:     []config.RouteConfig{{Name: "Orders", From: "/orders/*", To: backend.URL}},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, dependencies)
+	require.NoError(t, err)
+	defer gateway.RateLimiter.Close()
+
+	serve := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve("192.0.2.1:1234"), "blocked before the restart")
+	assert.Equal(t, http.StatusForbidden, serve("198.51.100.8:1234"), "blocked network")
+	assert.Equal(t, http.StatusOK, serve("198.51.100.7:1234"), "allowlist")
+	assert.Equal(t, http.StatusOK, serve("203.0.113.1:1234"))
+}
+
+func TestGatewaySharesRateLimiter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	// Two instances behind a load balancer, on the same database
+	dependencies := deps.NewTestWithName("TestGatewaySharesRateLimiter")
+	gwConfig := &config.GatewayConfig{
+		Server: config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_", RateLimiter: config.RateLimiterConfig{
+			RequestsPerMinute: 2,
+			BlockMinutes:      5,
+			Storage:           &config.RateLimitStorageConfig{Type: config.RateLimitStorageDatabase},
+		}},
+		Routes: []config.RouteConfig{{Name: "Orders", From: "/orders/*", To: backend.URL}},
+	}
+	var gateways []*Gateway
+	for range 2 {
+		gateway, err := NewGatewayWithDependencies(gwConfig, nil, dependencies)
+		require.NoError(t, err)
+		defer gateway.RateLimiter.Close()
+		gateways = append(gateways, gateway)
+	}
+
+	serve := func(gateway *Gateway) int {
+		req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+		req.RemoteAddr = "203.0.113.1:1234"
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, serve(gateways[0]))
+	assert.Equal(t, http.StatusOK, serve(gateways[1]))
+	assert.Equal(t, http.StatusTooManyRequests, serve(gateways[0]), "the limit counts the requests of both instances")
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyResponseHeaderTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	server := newLoadBalancedGateway(t, config.RouteConfig{
+		Name:     "Strict API",
+		From:     "/api/*",
+		To:       slow.URL,
+		Timeouts: &config.RouteTimeoutsConfig{ResponseHeaderMs: 50},
+	})
+
+	start := time.Now()
+	status, _ := getBody(t, server.URL+"/api/items")
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestProxyRequestDeadline(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+			w.Write([]byte("too late"))
+		}
+	}))
+	defer slow.Close()
+
+	server := newLoadBalancedGateway(t, config.RouteConfig{
+		Name:     "Report API",
+		From:     "/reports/*",
+		To:       slow.URL,
+		Timeouts: &config.RouteTimeoutsConfig{RequestSeconds: 1},
+	})
+
+	start := time.Now()
+	status, _ := getBody(t, server.URL+"/reports/monthly")
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
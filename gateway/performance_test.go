@@ -1,14 +1,18 @@
 package gateway
 
 import (
+	"bytes"
 	"embed"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"testing"
 	"time"
 
@@ -167,6 +171,65 @@ func BenchmarkWithoutMiddleware(b *testing.B) {
 	}
 }
 
+// discardResponseWriter drops the body, so the benchmarks only measure the memory used by
+// the gateway and not the one of a recorder holding the response
+type discardResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) WriteHeader(status int)      { w.status = status }
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// BenchmarkStaticSPAFallback serves a large asset and the index.html fallback of a SPA route.
+// The bytes allocated per request stay the same whatever the size of the file, since the
+// files are streamed instead of held in memory.
+func BenchmarkStaticSPAFallback(b *testing.B) {
+	// Disable logging for cleaner benchmark output
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	for _, size := range []int{64 << 10, 1 << 20, 16 << 20} {
+		dir := b.TempDir()
+		content := bytes.Repeat([]byte("a"), size)
+		if err := os.WriteFile(filepath.Join(dir, "index.html"), content, 0644); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "bundle.js"), content, 0644); err != nil {
+			b.Fatal(err)
+		}
+
+		cfg := &config.GatewayConfig{
+			Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+			Management: config.ManagementConfig{Prefix: "/_"},
+			Routes:     []config.RouteConfig{{Name: "SPA", From: "/*", ToFolder: dir, Static: true, IsSPA: true}},
+		}
+		gw, err := NewTestGateway(cfg, nil)
+		if err != nil {
+			b.Fatalf("Failed to create gateway: %v", err)
+		}
+
+		for _, path := range []string{"/bundle.js", "/settings/profile"} {
+			b.Run(fmt.Sprintf("%s/%dKB", strings.TrimPrefix(path, "/"), size>>10), func(b *testing.B) {
+				req := httptest.NewRequest("GET", path, nil)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					w := &discardResponseWriter{header: make(http.Header)}
+					gw.Mux.ServeHTTP(w, req)
+					if w.status != http.StatusOK {
+						b.Errorf("Expected status 200, got %d", w.status)
+					}
+				}
+			})
+		}
+	}
+}
+
 // BenchmarkProfileAPIRequest creates a CPU profile of API request handling
 func BenchmarkProfileAPIRequest(b *testing.B) {
 	// Disable logging for cleaner benchmark output
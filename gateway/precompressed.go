@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/middleware"
+)
+
+// precompressedExtensions are the file name suffixes of the precompressed siblings by encoding
+var precompressedExtensions = map[string]string{
+	config.CompressionBrotli: ".br",
+	config.CompressionGzip:   ".gz",
+}
+
+// precompressedHandler serves the precompressed sibling of a static file (index.js.br,
+// index.js.gz) when the client accepts its encoding, so the file is not compressed on every
+// request. Other requests go to the next handler.
+type precompressedHandler struct {
+	fs        http.FileSystem
+	next      http.Handler
+	etags     *etagCache
+	encodings []string // encodings that may have a sibling, in order of preference
+	file      string   // file served for every request, "" to use the request path
+}
+
+// newPrecompressedHandler wraps the file server of a static route when the route is
+// compressed; otherwise it returns next as is.
+func newPrecompressedHandler(fs http.FileSystem, next http.Handler, settings config.CompressionConfig, file string, etags *etagCache) http.Handler {
+	if !settings.Enabled {
+		return next
+	}
+	var encodings []string
+	for _, encoding := range settings.Algorithms {
+		if _, ok := precompressedExtensions[encoding]; ok {
+			encodings = append(encodings, encoding)
+		}
+	}
+	if len(encodings) == 0 {
+		return next
+	}
+	return &precompressedHandler{fs: fs, next: next, etags: etags, encodings: encodings, file: file}
+}
+
+func (p *precompressedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !p.serve(w, r) {
+		p.next.ServeHTTP(w, r)
+	}
+}
+
+// serve writes the precompressed sibling of the requested file and reports whether it did
+func (p *precompressedHandler) serve(w http.ResponseWriter, r *http.Request) bool {
+	name, ok := staticFileName(r.URL.Path, p.file)
+	if !ok {
+		return false
+	}
+
+	original, err := p.fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer original.Close()
+	info, err := original.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	encodings := slices.Clone(p.encodings)
+	for {
+		encoding := middleware.NegotiateEncoding(r.Header.Get("Accept-Encoding"), encodings)
+		if encoding == "" {
+			return false
+		}
+		encodings = slices.DeleteFunc(encodings, func(e string) bool { return e == encoding })
+
+		sibling, err := p.fs.Open(name + precompressedExtensions[encoding])
+		if err != nil {
+			continue
+		}
+		defer sibling.Close()
+		siblingInfo, err := sibling.Stat()
+		if err != nil || !siblingInfo.Mode().IsRegular() {
+			continue
+		}
+
+		// The content type is the one of the original file, not of the compressed sibling
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			var sniff [512]byte
+			n, _ := io.ReadFull(original, sniff[:])
+			contentType = http.DetectContentType(sniff[:n])
+		}
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Encoding", encoding)
+		h.Add("Vary", "Accept-Encoding")
+		// Each encoding is a representation of its own, with its own validator
+		if etag, err := p.etags.get(name+precompressedExtensions[encoding], sibling, siblingInfo); err == nil {
+			h.Set("ETag", etag)
+		}
+		http.ServeContent(w, r, name, siblingInfo.ModTime(), sibling)
+		return true
+	}
+}
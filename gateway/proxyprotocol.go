@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// proxyHeaderTimeout is the time an allowed source has to send the PROXY protocol header
+var proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest v1 header, CRLF included
+const proxyV1MaxLength = 107
+
+// errNoProxyHeader is returned when a header is required and the connection has none
+var errNoProxyHeader = errors.New("missing PROXY protocol header")
+
+// proxyProtocolListener reads the PROXY protocol header that load balancers send at the
+// start of the connections, so RemoteAddr is the client instead of the load balancer. Only
+// the connections of the allowed sources are read; the others are served as they are.
+type proxyProtocolListener struct {
+	net.Listener
+	sources  []netip.Prefix
+	required bool
+}
+
+// newProxyProtocolListener wraps the listener to read the headers of the configured sources
+func newProxyProtocolListener(listener net.Listener, proxyConfig *config.ProxyProtocolConfig) net.Listener {
+	return &proxyProtocolListener{
+		Listener: listener,
+		sources:  proxyConfig.SourcePrefixes(),
+		required: proxyConfig.Required,
+	}
+}
+
+// Accept returns the next connection. The header is read on the first use of the connection,
+// in its own goroutine, so a slow load balancer does not hold back the other connections.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isSource(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn), required: l.required}, nil
+}
+
+// isSource reports whether the peer is an allowed source of PROXY protocol headers
+func (l *proxyProtocolListener) isSource(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	peer, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	peer = peer.Unmap()
+	for _, prefix := range l.sources {
+		if prefix.Contains(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyProtocolConn is a connection of an allowed source. Its remote address is the one of
+// the header; the connection fails when the header is invalid.
+type proxyProtocolConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	required bool
+	once     sync.Once
+	remote   net.Addr // client of the header, nil when the header has none
+	err      error
+}
+
+// readHeader reads the header once, before the first read or address of the connection
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.reader, c.required)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil && !errors.Is(c.err, io.EOF) {
+			log.Printf("PROXY protocol: closing the connection of %s: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client of the header, or the load balancer when it sent none or
+// a LOCAL header, like its health checks
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the address of the client, nil when
+// the header has none. Without a header nothing is read.
+func readProxyHeader(reader *bufio.Reader, required bool) (net.Addr, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := reader.Peek(6); err == nil && string(prefix) == "PROXY " {
+			return readProxyV1(reader)
+		}
+	case '\r':
+		if prefix, err := reader.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(prefix, proxyV2Signature) {
+			return readProxyV2(reader)
+		}
+	}
+	if required {
+		return nil, errNoProxyHeader
+	}
+	return nil, nil
+}
+
+// readProxyV1 reads a text header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLength {
+			return nil, fmt.Errorf("PROXY v1 header is too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil || addr.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid PROXY v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readProxyV2 reads a binary header: the signature, the version and command, the address
+// family, the length and the addresses, followed by TLVs that are skipped
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	versionCommand, family := header[12], header[13]
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", versionCommand>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	switch versionCommand & 0x0F {
+	case 0x0: // LOCAL: sent by the load balancer itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", versionCommand&0x0F)
+	}
+
+	switch family >> 4 {
+	case 0x1: // IPv4: source, destination, source port, destination port
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("PROXY v2 IPv4 addresses are truncated")
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[8:10]))), nil
+	case 0x2: // IPv6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("PROXY v2 IPv6 addresses are truncated")
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[32:34]))), nil
+	}
+	// UNSPEC and Unix sockets have no IP address
+	return nil, nil
+}
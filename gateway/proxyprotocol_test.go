@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyV2Header builds a v2 header of the command with the addresses and a TLV
+func proxyV2Header(command byte, src, dst netip.AddrPort) []byte {
+	var addresses bytes.Buffer
+	family := byte(0x11)
+	if src.Addr().Is6() {
+		family = 0x21
+	}
+	addresses.Write(src.Addr().AsSlice())
+	addresses.Write(dst.Addr().AsSlice())
+	binary.Write(&addresses, binary.BigEndian, src.Port())
+	binary.Write(&addresses, binary.BigEndian, dst.Port())
+	addresses.Write([]byte{0x04, 0x00, 0x01, 0x00}) // PP2_TYPE_NOOP
+
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(addresses.Len()))
+	return append(header, addresses.Bytes()...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	src4 := netip.MustParseAddrPort("203.0.113.7:56324")
+	dst4 := netip.MustParseAddrPort("10.0.0.5:443")
+	src6 := netip.MustParseAddrPort("[2001:db8::7]:56324")
+	dst6 := netip.MustParseAddrPort("[2001:db8::5]:443")
+
+	tests := []struct {
+		name     string
+		input    string
+		required bool
+		expected string // client address, empty when the header has none
+		wantErr  bool
+	}{
+		{name: "v1 TCP4", input: "PROXY TCP4 203.0.113.7 10.0.0.5 56324 443\r\n", expected: "203.0.113.7:56324"},
+		{name: "v1 TCP6", input: "PROXY TCP6 2001:db8::7 2001:db8::5 56324 443\r\n", expected: "[2001:db8::7]:56324"},
+		{name: "v1 UNKNOWN", input: "PROXY UNKNOWN\r\n"},
+		{name: "v1 family mismatch", input: "PROXY TCP4 2001:db8::7 10.0.0.5 56324 443\r\n", wantErr: true},
+		{name: "v1 invalid port", input: "PROXY TCP4 203.0.113.7 10.0.0.5 70000 443\r\n", wantErr: true},
+		{name: "v1 too long", input: "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", wantErr: true},
+		{name: "v2 IPv4", input: string(proxyV2Header(0x1, src4, dst4)), expected: "203.0.113.7:56324"},
+		{name: "v2 IPv6", input: string(proxyV2Header(0x1, src6, dst6)), expected: "[2001:db8::7]:56324"},
+		{name: "v2 LOCAL", input: string(proxyV2Header(0x0, src4, dst4))},
+		{name: "v2 without addresses", input: string(proxyV2Signature) + "\x21\x21\x00\x00", wantErr: true},
+		{name: "v2 version", input: string(proxyV2Signature) + "\x11\x11\x00\x00", wantErr: true},
+		{name: "no header", input: "POST /orders HTTP/1.1\r\n"},
+		{name: "required header", input: "GET / HTTP/1.1\r\n", required: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input + "GET / HTTP/1.1\r\n"))
+			addr, err := readProxyHeader(reader, tt.required)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expected == "" {
+				assert.Nil(t, addr)
+			} else {
+				require.NotNil(t, addr)
+				assert.Equal(t, tt.expected, addr.String())
+			}
+
+			// The request follows the header
+			rest, _ := io.ReadAll(reader)
+			assert.True(t, strings.HasSuffix(string(rest), "GET / HTTP/1.1\r\n"))
+			assert.False(t, strings.HasPrefix(string(rest), "PROXY"))
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	serve := func(t *testing.T, proxyConfig *config.ProxyProtocolConfig) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.RemoteAddr)
+		})}
+		go server.Serve(newProxyProtocolListener(listener, proxyConfig))
+		t.Cleanup(func() { server.Close() })
+		return listener.Addr().String()
+	}
+	request := func(t *testing.T, addr string, header string) (string, error) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		fmt.Fprintf(conn, "%sGET / HTTP/1.1\r\nHost: gateway.test\r\nConnection: close\r\n\r\n", header)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return string(body), err
+	}
+
+	t.Run("allowed source", func(t *testing.T) {
+		addr := serve(t, &config.ProxyProtocolConfig{AllowedSources: []string{"127.0.0.0/8"}})
+		remote, err := request(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.5 56324 443\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, "203.0.113.7:56324", remote)
+
+		remote, err = request(t, addr, string(proxyV2Header(0x1, netip.MustParseAddrPort("[2001:db8::7]:4711"), netip.MustParseAddrPort("[2001:db8::5]:443"))))
+		require.NoError(t, err)
+		assert.Equal(t, "[2001:db8::7]:4711", remote)
+
+		remote, err = request(t, addr, "")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(remote, "127.0.0.1:"), "the header is optional")
+
+		_, err = request(t, addr, "PROXY TCP4 not-an-ip 10.0.0.5 56324 443\r\n")
+		assert.Error(t, err, "invalid headers close the connection")
+	})
+
+	t.Run("required header", func(t *testing.T) {
+		addr := serve(t, &config.ProxyProtocolConfig{AllowedSources: []string{"127.0.0.1"}, Required: true})
+		_, err := request(t, addr, "")
+		assert.Error(t, err)
+	})
+
+	t.Run("source not allowed", func(t *testing.T) {
+		addr := serve(t, &config.ProxyProtocolConfig{AllowedSources: []string{"10.0.0.0/8"}})
+		_, err := request(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.5 56324 443\r\n")
+		assert.Error(t, err, "the header is not read from other peers")
+
+		remote, err := request(t, addr, "")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(remote, "127.0.0.1:"))
+	})
+}
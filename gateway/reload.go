@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// Reload replaces the configuration of the running gateway. The new routes and middleware
+// are built and validated first, then swapped in at once: requests in flight finish with
+// the routes they started with. When the configuration is invalid the current one keeps
+// being served. The rate limiter entries, the response cache and the sessions are kept.
+func (g *Gateway) Reload(newConfig *config.GatewayConfig) (config.ConfigDiff, error) {
+	g.reloadMu.Lock()
+	defer g.reloadMu.Unlock()
+	if g.Draining() {
+		return config.ConfigDiff{}, errors.New("the gateway is shutting down")
+	}
+
+	current := g.current.Load()
+	hadCache := current.ResponseCache != nil
+	next, err := g.build(newConfig)
+	if err != nil {
+		log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+		return config.ConfigDiff{}, err
+	}
+
+	diff := config.DiffConfig(current.GatewayConfig, newConfig)
+	if !hadCache {
+		// The cache is created with the new sizes
+		diff.RestartRequired = slices.DeleteFunc(diff.RestartRequired, func(s string) bool { return s == "cache" })
+	}
+
+	g.RateLimiter.Reconfigure(newConfig.Management.RateLimiter)
+	g.adopt(next)
+	// The pools of the old routes keep serving their requests in flight, without probes
+	current.Upstreams.Close()
+
+	if err := ensureAdminUser(newConfig, g.Dependencies.UserRepo); err != nil {
+		log.Printf("Warning: Configuration reloaded but the admin user could not be updated: %v", err)
+	}
+
+	logConfigDiff(diff)
+	return diff, nil
+}
+
+// ReloadConfig loads the configuration file again and applies it with Reload
+func (g *Gateway) ReloadConfig() (config.ConfigDiff, error) {
+	if g.ConfigPath == "" {
+		return config.ConfigDiff{}, errors.New("the gateway was not started from a configuration file")
+	}
+	newConfig, err := config.LoadConfig(g.ConfigPath)
+	if err != nil {
+		log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+		return config.ConfigDiff{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return g.Reload(newConfig)
+}
+
+// WatchConfig reloads the configuration file when it changes, checking it every interval,
+// until ctx is done. A file that is saved in several steps may fail to load in between;
+// it is loaded once it is complete.
+func (g *Gateway) WatchConfig(ctx context.Context, interval time.Duration) {
+	last, _ := os.Stat(g.ConfigPath)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	log.Printf("Watching configuration file %s for changes every %s", g.ConfigPath, interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(g.ConfigPath)
+		if err != nil {
+			continue // being replaced
+		}
+		if last != nil && info.Size() == last.Size() && info.ModTime().Equal(last.ModTime()) {
+			continue
+		}
+		last = info
+		log.Printf("Configuration file %s changed, reloading", g.ConfigPath)
+		g.ReloadConfig()
+	}
+}
+
+// logConfigDiff logs the route changes of a reload
+func logConfigDiff(diff config.ConfigDiff) {
+	if !diff.HasChanges() {
+		log.Printf("Configuration reloaded: no changes (%d routes)", diff.Unchanged)
+		return
+	}
+	log.Printf("Configuration reloaded: %d added, %d removed, %d changed, %d unchanged routes",
+		len(diff.Added), len(diff.Removed), len(diff.Changed), diff.Unchanged)
+	for _, name := range diff.Added {
+		log.Printf("  + route '%s'", name)
+	}
+	for _, name := range diff.Removed {
+		log.Printf("  - route '%s'", name)
+	}
+	for _, name := range diff.Changed {
+		log.Printf("  ~ route '%s'", name)
+	}
+	if len(diff.RestartRequired) > 0 {
+		log.Printf("Warning: Changes to %s take effect after a restart", strings.Join(diff.RestartRequired, ", "))
+	}
+}
@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBackend(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestGatewayReload(t *testing.T) {
+	v1, v2, users := newBackend(t, "orders v1"), newBackend(t, "orders v2"), newBackend(t, "users")
+
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_", RateLimiter: config.RateLimiterConfig{RequestsPerMinute: 100, BlockMinutes: 1}},
+		Routes: []config.RouteConfig{
+			{Name: "Orders", From: "/orders/*", To: v1.URL},
+			{Name: "Legacy", From: "/legacy/*", To: v1.URL},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayReload"))
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.10:1234"
+		rec := httptest.NewRecorder()
+		gateway.Server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	require.Equal(t, "orders v1", get("/orders/1").Body.String())
+	require.Equal(t, "orders v1", get("/legacy/1").Body.String())
+
+	t.Run("routes are swapped and diffed", func(t *testing.T) {
+		newConfig := &config.GatewayConfig{
+			Server:     gwConfig.Server,
+			Management: config.ManagementConfig{Prefix: "/_", RateLimiter: config.RateLimiterConfig{RequestsPerMinute: 5, BlockMinutes: 1}},
+			Routes: []config.RouteConfig{
+				{Name: "Orders", From: "/orders/*", To: v2.URL},
+				{Name: "Users", From: "/users/*", To: users.URL},
+			},
+		}
+		diff, err := gateway.Reload(newConfig)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Users"}, diff.Added)
+		assert.Equal(t, []string{"Legacy"}, diff.Removed)
+		assert.Equal(t, []string{"Orders"}, diff.Changed)
+		assert.Empty(t, diff.RestartRequired)
+
+		assert.Equal(t, "orders v2", get("/orders/1").Body.String())
+		assert.Equal(t, "users", get("/users/1").Body.String())
+		assert.Equal(t, http.StatusNotFound, get("/legacy/1").Code)
+		assert.Same(t, newConfig, gateway.GatewayConfig)
+	})
+
+	t.Run("rate limiter entries are kept", func(t *testing.T) {
+		// The client made 5 requests before and after the reload; the new limit is 5 per minute
+		assert.Equal(t, http.StatusTooManyRequests, get("/orders/1").Code)
+		assert.Equal(t, 5, gateway.RateLimiter.Config().RequestsPerMinute)
+	})
+
+	t.Run("invalid configurations are rejected", func(t *testing.T) {
+		current := gateway.GatewayConfig
+		_, err := gateway.Reload(&config.GatewayConfig{
+			Server:     gwConfig.Server,
+			Management: config.ManagementConfig{Prefix: "/_"},
+			ErrorPages: map[string]string{"404": filepath.Join(t.TempDir(), "missing.html")},
+			Routes:     []config.RouteConfig{{Name: "Orders", From: "/orders/*", To: v1.URL}},
+		})
+		assert.Error(t, err)
+		assert.Same(t, current, gateway.GatewayConfig, "the current configuration is kept")
+	})
+
+	t.Run("settings applied on restart", func(t *testing.T) {
+		newConfig := *gateway.GatewayConfig
+		newConfig.Server.Port = 9999
+		diff, err := gateway.Reload(&newConfig)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"server"}, diff.RestartRequired)
+		assert.Equal(t, 2, diff.Unchanged)
+	})
+}
+
+func TestGatewayReloadConfigFile(t *testing.T) {
+	v1, v2 := newBackend(t, "v1"), newBackend(t, "v2")
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(backend, extra string) {
+		content := fmt.Sprintf("name: test\nserver:\n  host: 127.0.0.1\n  port: 8080\nroutes:\n  - name: API\n    from: /api/*\n    to: %s\n%s", backend, extra)
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0o644))
+	}
+	writeConfig(v1.URL, "")
+	gwConfig, err := config.LoadConfig(configFile)
+	require.NoError(t, err)
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName("TestGatewayReloadConfigFile"))
+	require.NoError(t, err)
+	gateway.ConfigPath = configFile
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		gateway.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/items", nil))
+		return rec.Body.String()
+	}
+	require.Equal(t, "v1", get())
+
+	// Broken YAML never replaces a good configuration
+	require.NoError(t, os.WriteFile(configFile, []byte("routes: [\n"), 0o644))
+	_, err = gateway.ReloadConfig()
+	assert.Error(t, err)
+	assert.Equal(t, "v1", get())
+
+	// The watched file is reloaded when it changes
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gateway.WatchConfig(ctx, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	writeConfig(v2.URL, "  - name: Broken\n    from: /broken/*\n    static: true\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "v1", get(), "a static route without a folder is rejected")
+
+	writeConfig(v2.URL, "")
+	assert.Eventually(t, func() bool { return get() == "v2" }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = (&Gateway{}).ReloadConfig()
+	assert.Error(t, err, "gateways without a configuration file cannot reload")
+}
+
+func TestGatewayReloadUnderLoad(t *testing.T) {
+	v1, v2 := newBackend(t, "v1"), newBackend(t, "v2")
+	configFor := func(backend string) *config.GatewayConfig {
+		return &config.GatewayConfig{
+			Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+			Management: config.ManagementConfig{Prefix: "/_"},
+			Routes:     []config.RouteConfig{{Name: "API", From: "/api/*", To: backend}},
+		}
+	}
+	gateway, err := NewGatewayWithDependencies(configFor(v1.URL), nil, deps.NewTestWithName("TestGatewayReloadUnderLoad"))
+	require.NoError(t, err)
+
+	// Requests and reads of the configuration run while it is replaced; run with -race
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, path := range []string{"/api/items", "/_/health", "/_/login"} {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				rec := httptest.NewRecorder()
+				gateway.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				assert.NotEqual(t, http.StatusInternalServerError, rec.Code, path)
+				assert.NotEmpty(t, gateway.Config().Routes)
+			}
+		})
+	}
+	for i := range 20 {
+		_, err := gateway.Reload(configFor([]string{v1.URL, v2.URL}[i%2]))
+		require.NoError(t, err)
+	}
+	cancel()
+	wg.Wait()
+	assert.Equal(t, v2.URL, gateway.Config().Routes[0].To)
+}
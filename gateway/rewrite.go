@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// pathRewriter applies the rewrite of a route to the URL of a request. The values of the
+// templates come from the route wildcards set by the router, the regex groups and the
+// original path.
+type pathRewriter struct {
+	path      string     // path template, "" keeps the request path
+	query     url.Values // parameters set by the query string of the path template
+	regex     *regexp.Regexp
+	set       map[string]string
+	add       map[string]string
+	remove    []string
+	wildcards int
+}
+
+// newPathRewriter compiles the rewrite of a route, or returns nil when it has none.
+func newPathRewriter(routeConfig config.RouteConfig) (*pathRewriter, error) {
+	rc := routeConfig.Rewrite
+	if rc == nil {
+		return nil, nil
+	}
+	pr := &pathRewriter{
+		set:       rc.Query.Set,
+		add:       rc.Query.Add,
+		remove:    rc.Query.Remove,
+		wildcards: routeConfig.WildcardCount(),
+	}
+	path, rawQuery, _ := strings.Cut(rc.Path, "?")
+	pr.path = path
+	if rawQuery != "" {
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query string in rewrite path: %w", err)
+		}
+		pr.query = query
+	}
+	if rc.Regex != "" {
+		re, err := regexp.Compile(rc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite regex: %w", err)
+		}
+		pr.regex = re
+	}
+	return pr, nil
+}
+
+// rewrite changes u, the URL the request r is sent to. It reports false and leaves u
+// unchanged when the request does not match the rewrite regex.
+func (pr *pathRewriter) rewrite(r *http.Request, u *url.URL) bool {
+	values := map[string]string{"path": u.Path}
+	for i := range pr.wildcards {
+		name := fmt.Sprintf("w%d", i)
+		values[name] = r.PathValue(name)
+	}
+	if pr.regex != nil {
+		groups := pr.regex.FindStringSubmatch(u.Path)
+		if groups == nil {
+			return false
+		}
+		for i, name := range pr.regex.SubexpNames() {
+			values[strconv.Itoa(i)] = groups[i]
+			if name != "" {
+				values[name] = groups[i]
+			}
+		}
+	}
+	expand := func(tmpl string) string {
+		return config.TemplatePlaceholder.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+			return values[placeholder[1:len(placeholder)-1]]
+		})
+	}
+
+	if pr.path != "" {
+		u.Path = expand(pr.path)
+		u.RawPath = ""
+	}
+	if len(pr.query) == 0 && len(pr.set) == 0 && len(pr.add) == 0 && len(pr.remove) == 0 {
+		return true
+	}
+	query := u.Query()
+	for name, tmpls := range pr.query {
+		query.Del(name)
+		for _, tmpl := range tmpls {
+			query.Add(name, expand(tmpl))
+		}
+	}
+	for name, tmpl := range pr.set {
+		query.Set(name, expand(tmpl))
+	}
+	for name, tmpl := range pr.add {
+		query.Add(name, expand(tmpl))
+	}
+	for _, name := range pr.remove {
+		query.Del(name)
+	}
+	u.RawQuery = query.Encode()
+	return true
+}
+
+// handler serves requests with the rewritten URL. The request is copied so the middlewares
+// around the route keep seeing the original URL, as with http.StripPrefix.
+func (pr *pathRewriter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := new(url.URL)
+		*u = *r.URL
+		if !pr.rewrite(r, u) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = u
+		next.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rewriteThroughRouter routes target through a router with the given route and returns the
+// URL the rewriter produced.
+func rewriteThroughRouter(t *testing.T, route config.RouteConfig, target string) string {
+	t.Helper()
+	rewriter, err := newPathRewriter(route)
+	require.NoError(t, err)
+	var got string
+	rt := newRouter()
+	require.NoError(t, rt.add(route, rewriter.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+	}))))
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	return got
+}
+
+func TestRewriteWildcards(t *testing.T) {
+	route := config.RouteConfig{
+		Name:    "certs",
+		From:    "/api/boxes/*/certs/*",
+		Rewrite: &config.RewriteConfig{Path: "/v2/certificates/{w1}?box={w0}"},
+	}
+	assert.Equal(t, "/v2/certificates/main.pem?box=7", rewriteThroughRouter(t, route, "/api/boxes/7/certs/main.pem"))
+	assert.Equal(t, "/v2/certificates/a/b.pem?box=7&format=der", rewriteThroughRouter(t, route, "/api/boxes/7/certs/a/b.pem?format=der"),
+		"the trailing wildcard keeps the rest of the path and the client query is kept")
+	assert.Equal(t, "/v2/certificates/x%20y?box=7", rewriteThroughRouter(t, route, "/api/boxes/7/certs/x%20y"))
+}
+
+func TestRewriteRegexCaptures(t *testing.T) {
+	route := config.RouteConfig{
+		Name: "users",
+		From: "/users/*",
+		Rewrite: &config.RewriteConfig{
+			Path:  "/v{version}/people/{2}",
+			Regex: `^/users/v(?P<version>\d+)/(.+)$`,
+		},
+	}
+	assert.Equal(t, "/v3/people/42/profile", rewriteThroughRouter(t, route, "/users/v3/42/profile"))
+	assert.Equal(t, "/users/latest/42", rewriteThroughRouter(t, route, "/users/latest/42"), "paths that do not match the regex are unchanged")
+}
+
+func TestRewriteQuery(t *testing.T) {
+	route := config.RouteConfig{
+		Name: "search",
+		From: "/search/*",
+		Rewrite: &config.RewriteConfig{Query: config.RewriteQueryConfig{
+			Set:    map[string]string{"q": "{w0}"},
+			Add:    map[string]string{"tag": "gateway"},
+			Remove: []string{"debug"},
+		}},
+	}
+	assert.Equal(t, "/search/shoes?q=shoes&tag=web&tag=gateway", rewriteThroughRouter(t, route, "/search/shoes?q=ignored&tag=web&debug=1"))
+}
+
+func TestProxyRewrite(t *testing.T) {
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.RequestURI()
+	}))
+	defer backend.Close()
+
+	gatewayConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_", Logging: true, Analytics: true},
+		Routes: []config.RouteConfig{{
+			Name:    "Certificates",
+			From:    "/api/boxes/*/certs/*",
+			To:      backend.URL + "/base",
+			Rewrite: &config.RewriteConfig{Path: "/v2/certificates/{w1}?box={w0}"},
+		}},
+	}
+	dependencies := deps.NewTestWithName("TestProxyRewrite")
+	gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, dependencies)
+	require.NoError(t, err)
+	server := httptest.NewServer(gateway.Server.Handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/boxes/7/certs/main.pem")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/base/v2/certificates/main.pem?box=7", received)
+
+	require.Eventually(t, func() bool {
+		metrics, err := dependencies.TrafficMetricRepo.FindByPath("/api/boxes/7/certs/main.pem", 1)
+		return err == nil && len(metrics) == 1
+	}, 2*time.Second, 20*time.Millisecond, "metrics record the path the client requested")
+}
+
+func TestStaticRewrite(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "v3", "guide"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "v3", "guide", "intro.html"), []byte("v3 intro"), 0644))
+
+	gatewayConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes: []config.RouteConfig{{
+			Name:     "Docs",
+			From:     "/docs/*/*",
+			Static:   true,
+			ToFolder: dir,
+			Rewrite:  &config.RewriteConfig{Path: "/{w0}/guide/{w1}"},
+		}},
+	}
+	gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, deps.NewTestWithName("TestStaticRewrite"))
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/v3/intro.html", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body, _ := io.ReadAll(rec.Body)
+	assert.Equal(t, "v3 intro", string(body))
+}
@@ -0,0 +1,426 @@
+package gateway
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// router selects the user route that serves a request from its host, path, method,
+// headers and query string. It is mounted on the ServeMux as the catch-all pattern, so the
+// management routes keep their own, more specific patterns.
+//
+// A route with several hosts is added once per host. Candidates are tried in a fixed
+// priority order and the first one that matches serves the request:
+//
+//  1. Exact hosts, then wildcard hosts (longest suffix first), then routes for any host.
+//  2. Paths with more literal segments, then exact paths before subtrees, then fewer wildcards.
+//  3. Routes restricted to some methods before routes for any method.
+//  4. Routes with more header and query conditions.
+//  5. Configuration order.
+type router struct {
+	entries []*routeEntry
+	count   int // routes added so far, gives the configuration order
+}
+
+// routeEntry is a route compiled for one of its hosts.
+type routeEntry struct {
+	route   config.RouteConfig
+	order   int
+	handler http.Handler
+	host    string // "" = any host, "*.example.com" = any subdomain of example.com
+	path    pathPattern
+	methods []string
+	headers []valueMatcher
+	query   []valueMatcher
+}
+
+// pathPattern is a route "from" path split into segments. A "*" segment in the middle
+// matches exactly one segment and is exposed as the path value {wN}; every pattern also
+// matches the paths below it, like a ServeMux pattern ending in "/". A trailing "*"
+// exposes the rest of the path as the last {wN}.
+type pathPattern struct {
+	segments  []string // literal segments, "*" for wildcards
+	matchRoot bool     // also match the path without the trailing slash
+	tail      bool     // the rest of the path is a wildcard value
+	wildcards int
+}
+
+// valueMatcher is a compiled header or query condition.
+type valueMatcher struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+// routeMatch is the outcome of matching a request against the routes.
+type routeMatch struct {
+	entry    *routeEntry
+	values   []string // values of the path wildcards
+	redirect string   // set when the request should be redirected to the trailing-slash path
+	allowed  []string // methods of the routes that only failed on the method
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// add registers the handler of a route.
+func (rt *router) add(routeConfig config.RouteConfig, handler http.Handler) error {
+	headers, err := compileValueMatchers(routeConfig.Match, true)
+	if err != nil {
+		return err
+	}
+	query, err := compileValueMatchers(routeConfig.Match, false)
+	if err != nil {
+		return err
+	}
+	methods := make([]string, 0, len(routeConfig.Methods))
+	for _, m := range routeConfig.Methods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+	hosts := routeConfig.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+
+	order := rt.count
+	rt.count++
+	for _, host := range hosts {
+		rt.entries = append(rt.entries, &routeEntry{
+			route:   routeConfig,
+			order:   order,
+			handler: handler,
+			host:    strings.ToLower(strings.TrimSuffix(host, ".")),
+			path:    compilePathPattern(routeConfig),
+			methods: methods,
+			headers: headers,
+			query:   query,
+		})
+	}
+	slices.SortStableFunc(rt.entries, compareEntries)
+	return nil
+}
+
+// ServeHTTP dispatches the request to the first matching route.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := rt.match(r)
+	switch {
+	case m.entry != nil:
+		for i, value := range m.values {
+			r.SetPathValue(fmt.Sprintf("w%d", i), value)
+		}
+		m.entry.handler.ServeHTTP(w, r)
+	case m.redirect != "":
+		http.Redirect(w, r, m.redirect, http.StatusMovedPermanently)
+	case len(m.allowed) > 0:
+		w.Header().Set("Allow", strings.Join(m.allowed, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// match finds the route of a request. Like ServeMux, a request for the root of a subtree
+// without its trailing slash is redirected unless a route matches that exact path.
+func (rt *router) match(r *http.Request) routeMatch {
+	host := requestHost(r)
+	var query url.Values
+	if r.URL.RawQuery != "" {
+		query = r.URL.Query()
+	}
+	var result routeMatch
+	exact := false
+	for _, e := range rt.entries {
+		values, isExact, ok := e.path.match(r.URL.Path)
+		if !ok || !e.matchesHost(host) || !e.matchesConditions(r, query) {
+			continue
+		}
+		if !e.matchesMethod(r.Method) {
+			for _, m := range e.methods {
+				if !slices.Contains(result.allowed, m) {
+					result.allowed = append(result.allowed, m)
+				}
+			}
+			continue
+		}
+		result.entry, result.values, exact = e, values, isExact
+		break
+	}
+	if !exact && !strings.HasSuffix(r.URL.Path, "/") && rt.isSubtreeRoot(r, host, query, r.URL.Path+"/") {
+		u := &url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+		return routeMatch{redirect: u.String()}
+	}
+	slices.Sort(result.allowed)
+	return result
+}
+
+// isSubtreeRoot reports whether path is exactly the root of a route that accepts the request.
+func (rt *router) isSubtreeRoot(r *http.Request, host string, query url.Values, path string) bool {
+	for _, e := range rt.entries {
+		if _, exact, ok := e.path.match(path); ok && exact &&
+			e.matchesHost(host) && e.matchesMethod(r.Method) && e.matchesConditions(r, query) {
+			return true
+		}
+	}
+	return false
+}
+
+// logTable prints the routes in the order they are tried.
+func (rt *router) logTable() {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tROUTE\tHOST\tMETHODS\tPATH\tCONDITIONS\tTO")
+	for i, e := range rt.entries {
+		host, methods := e.host, strings.Join(e.methods, ",")
+		if host == "" {
+			host = "*"
+		}
+		if methods == "" {
+			methods = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, e.route.Name, host, methods, e.route.From, e.describeConditions(), routeTarget(e.route))
+	}
+	tw.Flush()
+	log.Printf("Route table (first match wins):")
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		log.Printf("  %s", line)
+	}
+}
+
+// --- Matching ---
+
+func (e *routeEntry) matchesHost(host string) bool {
+	switch {
+	case e.host == "":
+		return true
+	case strings.HasPrefix(e.host, "*."):
+		return strings.HasSuffix(host, e.host[1:])
+	}
+	return host == e.host
+}
+
+// matchesMethod reports whether the route accepts the method. Routes that accept GET
+// also accept HEAD, as ServeMux does.
+func (e *routeEntry) matchesMethod(method string) bool {
+	if len(e.methods) == 0 || slices.Contains(e.methods, method) {
+		return true
+	}
+	return method == http.MethodHead && slices.Contains(e.methods, http.MethodGet)
+}
+
+func (e *routeEntry) matchesConditions(r *http.Request, query url.Values) bool {
+	for _, m := range e.headers {
+		if !m.matches(r.Header.Values(m.name)) {
+			return false
+		}
+	}
+	for _, m := range e.query {
+		if !m.matches(query[m.name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether one of the values satisfies the condition.
+func (m valueMatcher) matches(values []string) bool {
+	for _, v := range values {
+		switch {
+		case m.regex != nil:
+			if m.regex.MatchString(v) {
+				return true
+			}
+		case m.value != "":
+			if v == m.value {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the path is served by the pattern, the values of its wildcards,
+// and whether the path is the pattern itself rather than a path below it.
+func (p pathPattern) match(path string) (values []string, exact bool, ok bool) {
+	rest := strings.TrimPrefix(path, "/")
+	for i, seg := range p.segments {
+		if rest == "" {
+			return nil, false, false
+		}
+		part, tail, found := strings.Cut(rest, "/")
+		if seg == "*" {
+			values = append(values, part)
+		} else if part != seg {
+			return nil, false, false
+		}
+		if !found {
+			// The path ends without the trailing slash of the subtree
+			if !p.matchRoot || i != len(p.segments)-1 {
+				return nil, false, false
+			}
+			rest = ""
+			break
+		}
+		rest = tail
+	}
+	if p.tail {
+		values = append(values, rest)
+	}
+	return values, rest == "", true
+}
+
+// --- Compilation ---
+
+// compilePathPattern splits the "from" path of a route. Every pattern matches its subtree,
+// so a trailing "*" only names the rest of the path; single file routes also match their
+// own path.
+func compilePathPattern(routeConfig config.RouteConfig) pathPattern {
+	p := pathPattern{
+		matchRoot: routeConfig.Static && routeConfig.ToFile != "",
+		tail:      strings.HasSuffix(routeConfig.From, "/*"),
+	}
+	from := strings.TrimSuffix(strings.TrimSuffix(routeConfig.From, "*"), "/")
+	for _, seg := range strings.Split(strings.TrimPrefix(from, "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		if seg == "*" {
+			p.wildcards++
+		}
+		p.segments = append(p.segments, seg)
+	}
+	return p
+}
+
+func compileValueMatchers(match *config.RouteMatchConfig, headers bool) ([]valueMatcher, error) {
+	if match == nil {
+		return nil, nil
+	}
+	source, kind := match.Query, "query"
+	if headers {
+		source, kind = match.Headers, "header"
+	}
+	matchers := make([]valueMatcher, 0, len(source))
+	for _, m := range source {
+		vm := valueMatcher{name: m.Name, value: m.Value}
+		if headers {
+			vm.name = http.CanonicalHeaderKey(m.Name)
+		}
+		if m.Regex != "" {
+			re, err := regexp.Compile(m.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s regex for '%s': %w", kind, m.Name, err)
+			}
+			vm.regex = re
+		}
+		matchers = append(matchers, vm)
+	}
+	return matchers, nil
+}
+
+// compareEntries orders entries by the priority rules documented on router.
+func compareEntries(a, b *routeEntry) int {
+	if c := cmp.Compare(hostRank(b.host), hostRank(a.host)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(len(b.host), len(a.host)); c != 0 {
+		return c
+	}
+	literalsA, literalsB := len(a.path.segments)-a.path.wildcards, len(b.path.segments)-b.path.wildcards
+	if c := cmp.Compare(literalsB, literalsA); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(boolRank(b.path.matchRoot), boolRank(a.path.matchRoot)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.path.wildcards, b.path.wildcards); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(boolRank(len(b.methods) > 0), boolRank(len(a.methods) > 0)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(len(b.headers)+len(b.query), len(a.headers)+len(a.query)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.order, b.order)
+}
+
+// hostRank ranks exact hosts over wildcard hosts over routes for any host.
+func hostRank(host string) int {
+	switch {
+	case host == "":
+		return 0
+	case strings.HasPrefix(host, "*."):
+		return 1
+	}
+	return 2
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// --- Helpers ---
+
+// requestHost returns the lower-case host of the request without port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func (e *routeEntry) describeConditions() string {
+	var parts []string
+	for _, m := range e.headers {
+		parts = append(parts, "header "+m.describe())
+	}
+	for _, m := range e.query {
+		parts = append(parts, "query "+m.describe())
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (m valueMatcher) describe() string {
+	switch {
+	case m.regex != nil:
+		return m.name + "~" + m.regex.String()
+	case m.value != "":
+		return m.name + "=" + m.value
+	}
+	return m.name
+}
+
+// routeTarget describes where a route sends its requests.
+func routeTarget(routeConfig config.RouteConfig) string {
+	switch {
+	case routeConfig.Static && routeConfig.ToArchive != "" && routeConfig.ToFile != "":
+		return "file " + routeConfig.ToFile + " in archive " + routeConfig.ToArchive
+	case routeConfig.Static && routeConfig.ToArchive != "":
+		return "archive " + routeConfig.ToArchive
+	case routeConfig.Static && routeConfig.ToFile != "":
+		return "file " + routeConfig.ToFile
+	case routeConfig.Static:
+		return "folder " + routeConfig.ToFolder
+	}
+	return routeConfig.UpstreamDescription()
+}
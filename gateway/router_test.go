@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter adds the routes in order; every route answers with its name and returns
+// the first two wildcard values in the Wildcards header.
+func newTestRouter(t *testing.T, routes ...config.RouteConfig) *router {
+	t.Helper()
+	rt := newRouter()
+	for _, route := range routes {
+		name := route.Name
+		require.NoError(t, rt.add(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Wildcards", r.PathValue("w0")+","+r.PathValue("w1"))
+			w.Write([]byte(name))
+		})))
+	}
+	return rt
+}
+
+func serveRouter(rt *router, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouterHosts(t *testing.T) {
+	rt := newTestRouter(t,
+		config.RouteConfig{Name: "any", From: "/*"},
+		config.RouteConfig{Name: "tenants", From: "/*", Hosts: []string{"*.example.com"}},
+		config.RouteConfig{Name: "api", From: "/*", Hosts: []string{"api.example.com", "api.example.org"}},
+		config.RouteConfig{Name: "eu-tenants", From: "/*", Hosts: []string{"*.eu.example.com"}},
+	)
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"http://api.example.com/orders", "api"},
+		{"http://API.example.org:8443/orders", "api"},
+		{"http://acme.example.com/orders", "tenants"},
+		{"http://acme.eu.example.com/orders", "eu-tenants"},
+		{"http://example.com/orders", "any"},
+		{"http://other.test/orders", "any"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			assert.Equal(t, tt.want, serveRouter(rt, http.MethodGet, tt.target, nil).Body.String())
+		})
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	rt := newTestRouter(t,
+		config.RouteConfig{Name: "read", From: "/orders/*", Methods: []string{"GET"}},
+		config.RouteConfig{Name: "write", From: "/orders/*", Methods: []string{"POST", "PUT"}},
+	)
+
+	assert.Equal(t, "read", serveRouter(rt, http.MethodGet, "/orders/1", nil).Body.String())
+	assert.Equal(t, http.StatusOK, serveRouter(rt, http.MethodHead, "/orders/1", nil).Code, "GET routes answer HEAD")
+	assert.Equal(t, "write", serveRouter(rt, http.MethodPost, "/orders/1", nil).Body.String())
+
+	rec := serveRouter(rt, http.MethodDelete, "/orders/1", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, POST, PUT", rec.Header().Get("Allow"))
+}
+
+func TestRouterHeaderAndQueryConditions(t *testing.T) {
+	rt := newTestRouter(t,
+		config.RouteConfig{Name: "v1", From: "/api/*"},
+		config.RouteConfig{Name: "v2", From: "/api/*", Match: &config.RouteMatchConfig{
+			Headers: []config.ValueMatch{{Name: "x-api-version", Regex: "^v[23]$"}},
+		}},
+		config.RouteConfig{Name: "beta", From: "/api/*", Match: &config.RouteMatchConfig{
+			Headers: []config.ValueMatch{{Name: "X-Api-Version", Value: "v2"}},
+			Query:   []config.ValueMatch{{Name: "beta"}},
+		}},
+	)
+
+	assert.Equal(t, "v1", serveRouter(rt, http.MethodGet, "/api/items", nil).Body.String())
+	assert.Equal(t, "v2", serveRouter(rt, http.MethodGet, "/api/items", http.Header{"X-Api-Version": {"v3"}}).Body.String())
+	assert.Equal(t, "v2", serveRouter(rt, http.MethodGet, "/api/items?alpha=1", http.Header{"X-Api-Version": {"v2"}}).Body.String())
+	assert.Equal(t, "beta", serveRouter(rt, http.MethodGet, "/api/items?beta", http.Header{"X-Api-Version": {"v2"}}).Body.String(),
+		"the route with more conditions is tried first")
+}
+
+func TestRouterPathPriority(t *testing.T) {
+	rt := newTestRouter(t,
+		config.RouteConfig{Name: "root", From: "/*"},
+		config.RouteConfig{Name: "certs", From: "/api/boxes/*/certs/*"},
+		config.RouteConfig{Name: "api", From: "/api/*"},
+		config.RouteConfig{Name: "boxes", From: "/api/boxes/"},
+	)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "root"},
+		{"/index.html", "root"},
+		{"/api/", "api"},
+		{"/api/users", "api"},
+		{"/api/boxes/7", "boxes"},
+		{"/api/boxes/7/certs/", "certs"},
+		{"/api/boxes/7/certs/main.pem", "certs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, serveRouter(rt, http.MethodGet, tt.path, nil).Body.String())
+		})
+	}
+}
+
+func TestRouterWildcardValues(t *testing.T) {
+	rt := newTestRouter(t, config.RouteConfig{Name: "certs", From: "/api/boxes/*/certs/*"})
+
+	assert.Equal(t, "7,main.pem", serveRouter(rt, http.MethodGet, "/api/boxes/7/certs/main.pem", nil).Header().Get("Wildcards"))
+	assert.Equal(t, "7,a/b.pem", serveRouter(rt, http.MethodGet, "/api/boxes/7/certs/a/b.pem", nil).Header().Get("Wildcards"),
+		"a trailing wildcard takes the rest of the path")
+	assert.Equal(t, "7,", serveRouter(rt, http.MethodGet, "/api/boxes/7/certs/", nil).Header().Get("Wildcards"))
+}
+
+func TestRouterRedirectsToSubtreeRoot(t *testing.T) {
+	rt := newTestRouter(t,
+		config.RouteConfig{Name: "root", From: "/*"},
+		config.RouteConfig{Name: "api", From: "/api/*"},
+		config.RouteConfig{Name: "file", From: "/robots.txt", Static: true, ToFile: "robots.txt"},
+	)
+
+	rec := serveRouter(rt, http.MethodGet, "/api?x=1", nil)
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "/api/?x=1", rec.Header().Get("Location"))
+
+	assert.Equal(t, "file", serveRouter(rt, http.MethodGet, "/robots.txt", nil).Body.String(), "single file routes match their own path")
+	assert.Equal(t, http.StatusNotFound, serveRouter(newTestRouter(t), http.MethodGet, "/", nil).Code)
+}
+
+func TestRouterOrderIsDeterministic(t *testing.T) {
+	rt := newTestRouter(t,
+		config.RouteConfig{Name: "first", From: "/api/*"},
+		config.RouteConfig{Name: "second", From: "/api/*"},
+	)
+	assert.Equal(t, "first", serveRouter(rt, http.MethodGet, "/api/x", nil).Body.String(), "ties keep the configuration order")
+}
+
+func TestGatewayRoutesByHost(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+		t.Cleanup(backend.Close)
+		return backend
+	}
+	apiBackend, appBackend := newBackend("api"), newBackend("app")
+
+	gatewayConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes: []config.RouteConfig{
+			{Name: "App", From: "/*", Hosts: []string{"app.example.com"}, To: appBackend.URL},
+			{Name: "API", From: "/*", Hosts: []string{"api.example.com"}, To: apiBackend.URL},
+		},
+	}
+	gateway, err := NewGatewayWithDependencies(gatewayConfig, nil, deps.NewTestWithName("TestGatewayRoutesByHost"))
+	require.NoError(t, err)
+
+	for host, want := range map[string]string{"api.example.com": "api /orders", "app.example.com": "app /orders"} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/orders", nil)
+		rec := httptest.NewRecorder()
+		gateway.Mux.ServeHTTP(rec, req)
+		assert.Equal(t, want, rec.Body.String())
+	}
+
+	rec := httptest.NewRecorder()
+	gateway.Mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://unknown.example.com/orders", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
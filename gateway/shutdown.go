@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/middleware"
+)
+
+// closeGracePeriod is the time the requests cut off at the drain timeout have to return,
+// and the pending traffic metrics have to be stored, before the database is closed
+var closeGracePeriod = 5 * time.Second
+
+// Draining reports whether the gateway is shutting down, for the health check
+func (g *Gateway) Draining() bool {
+	return g.draining.Load()
+}
+
+// Shutdown stops the gateway gracefully. The health check reports "draining" first, for the
+// shutdown delay of the configuration, so load balancers stop sending traffic. Then the
+// listener closes and the requests in flight, WebSocket connections included, have until
+// ctx is done to finish; the ones still open are cut off. Finally the background tasks stop,
+// the pending traffic metrics are stored and the database is closed.
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	if !g.draining.CompareAndSwap(false, true) {
+		return errors.New("the gateway is already shutting down")
+	}
+
+	if delay := g.Config().Server.Shutdown.Delay(); delay > 0 {
+		log.Printf("Shutdown: reporting draining for %s before closing the listener", delay)
+		g.Server.SetKeepAlivesEnabled(false)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	log.Printf("Shutdown: waiting for %d request(s) in flight", g.inflight.Load())
+	if g.RedirectServer != nil {
+		g.RedirectServer.Shutdown(ctx)
+	}
+	err := g.Server.Shutdown(ctx)
+	if err == nil {
+		err = g.waitIdle(ctx)
+	}
+	if err != nil {
+		log.Printf("Shutdown: drain timeout, closing %d request(s) still in flight", g.inflight.Load())
+		g.Server.Close()
+		g.streams.closeAll()
+		graceCtx, cancel := context.WithTimeout(context.Background(), closeGracePeriod)
+		g.waitIdle(graceCtx)
+		cancel()
+	}
+
+	g.close()
+	log.Printf("Shutdown: complete")
+	return err
+}
+
+// waitIdle waits until no request is being served, or ctx is done
+func (g *Gateway) waitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for g.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// close stops the background tasks of the gateway, stores the pending traffic metrics and
+// closes the database
+func (g *Gateway) close() {
+	// No configuration reload in between
+	g.reloadMu.Lock()
+	defer g.reloadMu.Unlock()
+
+	g.RateLimiter.Close()
+	if g.Certificates != nil {
+		g.Certificates.Close()
+	}
+	current := g.current.Load()
+	current.Upstreams.Close()
+	if current.ResponseCache != nil {
+		current.ResponseCache.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeGracePeriod)
+	defer cancel()
+	if err := middleware.FlushTrafficMetrics(ctx); err != nil {
+		log.Printf("Shutdown: some traffic metrics were not stored: %v", err)
+	}
+	if err := g.Dependencies.Close(); err != nil {
+		log.Printf("Shutdown: failed to close the database: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startGateway serves the gateway on a local port, as runGateway does, and returns its address
+func startGateway(t *testing.T, name string, routes ...config.RouteConfig) (*Gateway, string) {
+	t.Helper()
+	gwConfig := &config.GatewayConfig{
+		Server:     config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Management: config.ManagementConfig{Prefix: "/_", Analytics: true, RateLimiter: config.RateLimiterConfig{RequestsPerMinute: 100, BlockMinutes: 1}},
+		Routes:     routes,
+	}
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName(name))
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- gateway.Server.Serve(listener) }()
+	t.Cleanup(func() {
+		gateway.Server.Close()
+		assert.ErrorIs(t, <-served, http.ErrServerClosed)
+	})
+	return gateway, listener.Addr().String()
+}
+
+func TestGatewayShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "slow report")
+	}))
+	defer backend.Close()
+	gateway, addr := startGateway(t, "TestGatewayShutdown", config.RouteConfig{Name: "Reports", From: "/reports/*", To: backend.URL})
+
+	type result struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/reports/daily")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		inFlight <- result{string(body), err}
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- gateway.Shutdown(ctx)
+	}()
+
+	// The health check reports draining while the request is in flight
+	require.Eventually(t, gateway.Draining, time.Second, 5*time.Millisecond)
+	rec := httptest.NewRecorder()
+	gateway.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"draining"`)
+
+	_, err := gateway.Reload(gateway.GatewayConfig)
+	assert.Error(t, err, "the configuration is not reloaded while shutting down")
+
+	// New connections are refused once the listener is closed
+	require.Eventually(t, func() bool {
+		_, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	close(release)
+	got := <-inFlight
+	require.NoError(t, got.err)
+	assert.Equal(t, "slow report", got.body, "the request in flight finishes")
+	require.NoError(t, <-shutdown)
+
+	assert.Error(t, gateway.Dependencies.DB.Exec("SELECT 1").Error, "the database is closed")
+	assert.Error(t, gateway.Shutdown(context.Background()), "the gateway shuts down once")
+}
+
+func TestGatewayShutdownDrainTimeout(t *testing.T) {
+	grace := closeGracePeriod
+	closeGracePeriod = time.Second
+	t.Cleanup(func() { closeGracePeriod = grace })
+
+	backend := newEchoUpgradeBackend(t)
+	gateway, addr := startGateway(t, "TestGatewayShutdownDrainTimeout", config.RouteConfig{Name: "Realtime", From: "/ws/*", To: backend.URL})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /ws/echo HTTP/1.1\r\nHost: gateway\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// The WebSocket connection keeps the gateway draining until the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = gateway.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	// Then it is cut off
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+	assert.Zero(t, gateway.inflight.Load())
+}
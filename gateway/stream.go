@@ -0,0 +1,232 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/upstream"
+)
+
+// Stream types recorded in ProxyInfo.Stream.
+const (
+	streamSSE     = "sse"
+	streamChunked = "chunked"
+)
+
+// streamWriter wraps the ResponseWriter of a proxy route so WebSocket upgrades and streamed
+// responses (SSE, chunked) are not cut off by the server read/write timeouts. A stream is
+// closed once it stays idle for the route's stream idle timeout instead, and its traffic is
+// reported through ProxyInfo.
+type streamWriter struct {
+	http.ResponseWriter
+	route   string
+	idle    time.Duration
+	info    *upstream.ProxyInfo
+	cancel  context.CancelFunc // cancels the upstream request of an idle streamed response
+	start   time.Time
+	upgrade string // protocol requested in the Upgrade header, if any
+
+	streaming bool
+	idleTimer *time.Timer
+	conn      *idleConn      // client connection of an upgraded request
+	streams   *streamTracker // upgraded connections of the gateway, drained on shutdown
+}
+
+// newStreamWriter wraps w for a request of the given proxy route. The returned request
+// carries the context that is cancelled when a streamed response goes idle.
+func newStreamWriter(w http.ResponseWriter, r *http.Request, routeConfig config.RouteConfig, info *upstream.ProxyInfo, streams *streamTracker) (*streamWriter, *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	sw := &streamWriter{
+		ResponseWriter: w,
+		route:          routeConfig.Name,
+		idle:           routeConfig.Timeouts.StreamIdle(),
+		info:           info,
+		cancel:         cancel,
+		start:          time.Now(),
+		upgrade:        r.Header.Get("Upgrade"),
+		streams:        streams,
+	}
+	return sw, r.WithContext(ctx)
+}
+
+// WriteHeader detects streamed responses before the headers go out.
+func (sw *streamWriter) WriteHeader(code int) {
+	if !sw.streaming {
+		if kind := streamKind(code, sw.Header()); kind != "" {
+			sw.startStreaming(kind)
+		}
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Write forwards data to the client, keeping a streamed response alive.
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.streaming {
+		sw.touch()
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client; the reverse proxy flushes streamed responses
+// after every write.
+func (sw *streamWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Hijack hands the client connection of an upgraded request to the reverse proxy. The
+// connection is closed after the idle timeout and counts the bytes in each direction.
+func (sw *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(sw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	sw.info.Stream = strings.ToLower(sw.upgrade) // e.g. "websocket"
+	if sw.info.Stream == "" {
+		sw.info.Stream = "upgrade"
+	}
+	sw.conn = newIdleConn(conn, sw.idle)
+	sw.streams.add(sw.conn)
+	return sw.conn, brw, nil
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (sw *streamWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// startStreaming replaces the server timeouts with the idle timeout.
+func (sw *streamWriter) startStreaming(kind string) {
+	sw.streaming = true
+	sw.info.Stream = kind
+	// The server read deadline would cancel the request context in the middle of the stream
+	http.NewResponseController(sw.ResponseWriter).SetReadDeadline(time.Time{})
+	sw.idleTimer = time.AfterFunc(sw.idle, func() {
+		log.Printf("Stream [%s]: %s response idle for %s, closing", sw.route, kind, sw.idle)
+		sw.cancel()
+	})
+	sw.touch()
+}
+
+// touch pushes the idle timeout and the write deadline of a streamed response forward.
+func (sw *streamWriter) touch() {
+	sw.idleTimer.Reset(sw.idle)
+	http.NewResponseController(sw.ResponseWriter).SetWriteDeadline(time.Now().Add(sw.idle))
+}
+
+// finish releases the stream resources once the proxy is done with the request and
+// records the traffic of upgraded connections.
+func (sw *streamWriter) finish() {
+	sw.cancel()
+	if sw.idleTimer != nil {
+		sw.idleTimer.Stop()
+	}
+	if sw.conn == nil {
+		return
+	}
+	sw.streams.remove(sw.conn)
+	sw.info.StreamBytesIn = sw.conn.bytesIn.Load()
+	sw.info.StreamBytesOut = sw.conn.bytesOut.Load()
+	log.Printf("Stream [%s]: %s connection closed after %s (in: %d bytes, out: %d bytes)",
+		sw.route, sw.info.Stream, time.Since(sw.start).Round(time.Millisecond), sw.info.StreamBytesIn, sw.info.StreamBytesOut)
+}
+
+// streamKind returns the stream type of a response, or "" for a regular response.
+// Event streams and responses of unknown length are streamed.
+func streamKind(code int, header http.Header) string {
+	if code != http.StatusOK {
+		return ""
+	}
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return streamSSE
+	}
+	if header.Get("Content-Length") == "" {
+		return streamChunked
+	}
+	return ""
+}
+
+// idleConn is a hijacked client connection that is closed when no data flows in either
+// direction for the idle timeout, and that counts the bytes it carries.
+type idleConn struct {
+	net.Conn
+	idle     time.Duration
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+func newIdleConn(conn net.Conn, idle time.Duration) *idleConn {
+	c := &idleConn{Conn: conn, idle: idle}
+	// Replaces the deadlines the server set for the handshake request
+	conn.SetDeadline(time.Now().Add(idle))
+	return c
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.bytesIn.Add(int64(n))
+		c.Conn.SetDeadline(time.Now().Add(c.idle))
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.bytesOut.Add(int64(n))
+		c.Conn.SetDeadline(time.Now().Add(c.idle))
+	}
+	return n, err
+}
+
+// streamTracker keeps the upgraded connections being proxied, which http.Server.Close does
+// not close, so they can be cut off when the shutdown drain timeout expires. A nil tracker
+// keeps nothing.
+type streamTracker struct {
+	mu    sync.Mutex
+	conns map[*idleConn]struct{}
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{conns: make(map[*idleConn]struct{})}
+}
+
+func (t *streamTracker) add(conn *idleConn) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.conns[conn] = struct{}{}
+	t.mu.Unlock()
+}
+
+func (t *streamTracker) remove(conn *idleConn) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+}
+
+// closeAll closes the upgraded connections still open and returns how many there were.
+func (t *streamTracker) closeAll() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	return len(t.conns)
+}
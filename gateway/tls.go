@@ -0,0 +1,291 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/certs"
+	"github.com/jmaister/taronja-gateway/config"
+	"golang.org/x/crypto/acme"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+var certCheckInterval = 10 * time.Second
+
+// certStore serves the certificate of each SNI hostname. The certificate and key files are
+// loaded again when they change: new handshakes get the new certificates at once, and the
+// established connections keep theirs. The hostnames no file covers get their certificate
+// from ACME, when configured.
+type certStore struct {
+	configs   []config.TLSCertificateConfig
+	acme      *certs.Manager // nil when ACME is not configured
+	current   atomic.Pointer[certSnapshot]
+	checked   atomic.Int64 // last check for changes, in Unix nanoseconds
+	reloading sync.Mutex
+}
+
+// certSnapshot is the content of the certificate files at a point in time
+type certSnapshot struct {
+	fallback  *tls.Certificate            // first certificate, for unknown hostnames
+	exact     map[string]*tls.Certificate // by hostname
+	wildcards []wildcardCert              // longest suffix first
+	modTimes  map[string]time.Time        // of every file, to detect changes
+}
+
+type wildcardCert struct {
+	suffix string // ".example.com" for "*.example.com"
+	cert   *tls.Certificate
+}
+
+// newCertStore loads the certificates of the configuration
+func newCertStore(configs []config.TLSCertificateConfig, acme *certs.Manager) (*certStore, error) {
+	s := &certStore{configs: configs, acme: acme}
+	snapshot, err := loadCertificates(configs)
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(snapshot)
+	s.checked.Store(time.Now().UnixNano())
+	return s, nil
+}
+
+// getCertificate is the tls.Config.GetCertificate callback
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.acme != nil && certs.IsChallenge(hello) {
+		return s.acme.GetCertificate(hello)
+	}
+	s.refresh()
+	snapshot := s.current.Load()
+	if s.acme != nil && snapshot.match(hello.ServerName) == nil && s.acme.Manages(hello.ServerName) {
+		return s.acme.GetCertificate(hello)
+	}
+	if cert := snapshot.certificate(hello.ServerName); cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no TLS certificate for '%s'", hello.ServerName)
+}
+
+// certificate returns the certificate of the hostname, or the first certificate when no
+// file covers it
+func (snapshot *certSnapshot) certificate(serverName string) *tls.Certificate {
+	if cert := snapshot.match(serverName); cert != nil {
+		return cert
+	}
+	return snapshot.fallback
+}
+
+// match returns the certificate of the hostname: an exact match, then the longest wildcard
+// match; nil when there is none.
+func (snapshot *certSnapshot) match(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert, ok := snapshot.exact[name]; ok {
+		return cert
+	}
+	for _, wildcard := range snapshot.wildcards {
+		if strings.HasSuffix(name, wildcard.suffix) {
+			return wildcard.cert
+		}
+	}
+	return nil
+}
+
+// refresh loads the certificates again when a file changed since they were loaded. Only one
+// handshake per interval checks them; if the new files cannot be loaded, for instance while
+// only the certificate was replaced, the old certificates are kept.
+func (s *certStore) refresh() {
+	now := time.Now().UnixNano()
+	last := s.checked.Load()
+	if now-last < int64(certCheckInterval) || !s.checked.CompareAndSwap(last, now) {
+		return
+	}
+	if !s.reloading.TryLock() {
+		return
+	}
+	defer s.reloading.Unlock()
+
+	changed := false
+	for file, modTime := range s.current.Load().modTimes {
+		info, err := os.Stat(file)
+		if err != nil {
+			// The file may be in the middle of a replacement
+			return
+		}
+		changed = changed || !info.ModTime().Equal(modTime)
+	}
+	if !changed {
+		return
+	}
+	snapshot, err := loadCertificates(s.configs)
+	if err != nil {
+		log.Printf("Warning: TLS certificates changed but could not be loaded, serving the previous ones: %v", err)
+		return
+	}
+	s.current.Store(snapshot)
+	log.Printf("TLS certificates reloaded")
+}
+
+// loadCertificates reads the certificate and key files of the configuration
+func loadCertificates(configs []config.TLSCertificateConfig) (*certSnapshot, error) {
+	snapshot := &certSnapshot{
+		exact:    make(map[string]*tls.Certificate),
+		modTimes: make(map[string]time.Time),
+	}
+	for _, certConfig := range configs {
+		for _, file := range []string{certConfig.CertFile, certConfig.KeyFile} {
+			info, err := os.Stat(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read TLS certificate: %w", err)
+			}
+			snapshot.modTimes[file] = info.ModTime()
+		}
+		cert, err := tls.LoadX509KeyPair(certConfig.CertFile, certConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate '%s': %w", certConfig.CertFile, err)
+		}
+		if snapshot.fallback == nil {
+			snapshot.fallback = &cert
+		}
+
+		hosts := certConfig.Hosts
+		if len(hosts) == 0 && cert.Leaf != nil {
+			hosts = cert.Leaf.DNSNames
+		}
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+			if suffix, ok := strings.CutPrefix(host, "*"); ok {
+				snapshot.wildcards = append(snapshot.wildcards, wildcardCert{suffix: suffix, cert: &cert})
+			} else if _, taken := snapshot.exact[host]; !taken {
+				snapshot.exact[host] = &cert
+			}
+		}
+	}
+	slices.SortStableFunc(snapshot.wildcards, func(a, b wildcardCert) int {
+		return len(b.suffix) - len(a.suffix)
+	})
+	return snapshot, nil
+}
+
+// newServerTLSConfig creates the TLS settings of the HTTPS listener: the certificates by
+// SNI hostname, the protocol versions and ciphers, and the client certificate verification.
+// acmeManager is nil when ACME is not configured.
+func newServerTLSConfig(tlsConfig *config.TLSConfig, acmeManager *certs.Manager) (*tls.Config, error) {
+	store, err := newCertStore(tlsConfig.Certificates, acmeManager)
+	if err != nil {
+		return nil, err
+	}
+	serverConfig := &tls.Config{
+		GetCertificate: store.getCertificate,
+		MinVersion:     tlsConfig.MinTLSVersion(),
+		CipherSuites:   tlsConfig.CipherSuiteIDs(),
+	}
+	if acmeManager != nil {
+		// The tls-alpn-01 challenge is a handshake for this protocol
+		serverConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+
+	if clientAuth := tlsConfig.ClientAuth; clientAuth != nil {
+		pem, err := os.ReadFile(clientAuth.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA file '%s' has no PEM certificates", clientAuth.CAFile)
+		}
+		serverConfig.ClientCAs = pool
+		serverConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if clientAuth.ClientAuthMode() == config.ClientAuthRequire {
+			serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return serverConfig, nil
+}
+
+// newRedirectServer creates the plain HTTP listener that redirects to the HTTPS port, nil
+// when the configuration has none. It also answers the ACME http-01 challenges.
+func newRedirectServer(gatewayConfig *config.GatewayConfig, acmeManager *certs.Manager) *http.Server {
+	tlsConfig := gatewayConfig.Server.TLS
+	if tlsConfig == nil || tlsConfig.HTTPRedirectPort == 0 {
+		return nil
+	}
+	handler := httpsRedirectHandler(gatewayConfig.Server.Port)
+	if acmeManager != nil {
+		handler = acmeManager.HTTPHandler(handler)
+	}
+	return &http.Server{
+		Addr:              net.JoinHostPort(gatewayConfig.Server.Host, strconv.Itoa(tlsConfig.HTTPRedirectPort)),
+		Handler:           handler,
+		ReadHeaderTimeout: gatewayConfig.Server.Timeouts.ReadHeader(),
+		IdleTimeout:       gatewayConfig.Server.Timeouts.Idle(),
+	}
+}
+
+// httpsRedirectHandler redirects every request to the same URL on the HTTPS port. 308
+// keeps the method and body of the request.
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := requestHost(r)
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// ListenAndServe serves the gateway on its port, with TLS when configured, and the HTTP
+// redirect listener if any. The ACME certificates are obtained once both listen. It returns
+// http.ErrServerClosed after Shutdown.
+func (g *Gateway) ListenAndServe() error {
+	if g.RedirectServer != nil {
+		listener, err := g.listen(g.RedirectServer.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to start the HTTP redirect listener: %w", err)
+		}
+		log.Printf("Redirecting HTTP on %s to HTTPS", g.RedirectServer.Addr)
+		go func() {
+			if err := g.RedirectServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("HTTP redirect listener stopped: %v", err)
+			}
+		}()
+	}
+	listener, err := g.listen(g.Server.Addr)
+	if err != nil {
+		return err
+	}
+	if g.Server.TLSConfig == nil {
+		return g.Server.Serve(listener)
+	}
+	if g.Certificates != nil {
+		g.Certificates.Start()
+	}
+	// The certificates come from TLSConfig.GetCertificate
+	return g.Server.ServeTLS(listener, "", "")
+}
+
+// listen opens a TCP listener on the address, which reads the PROXY protocol headers when
+// configured. TLS is handshaked after the header.
+func (g *Gateway) listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyConfig := g.GatewayConfig.Server.ProxyProtocol; proxyConfig != nil {
+		log.Printf("Reading PROXY protocol headers on %s from %s", addr, strings.Join(proxyConfig.AllowedSources, ", "))
+		return newProxyProtocolListener(listener, proxyConfig), nil
+	}
+	return listener, nil
+}
@@ -0,0 +1,295 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues the server and client certificates of the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM certificate
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(t.TempDir(), "ca.pem"), pool: x509.NewCertPool()}
+	ca.pool.AddCert(cert)
+	require.NoError(t, os.WriteFile(ca.file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	return ca
+}
+
+// issue signs a certificate for the common name, a server certificate when it has DNS names
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	usage := x509.ExtKeyUsageClientAuth
+	if len(dnsNames) > 0 {
+		usage = x509.ExtKeyUsageServerAuth
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Taronja"}},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePair writes the certificate and key in PEM files of dir named after name
+func writePair(t *testing.T, dir, name string, cert tls.Certificate) config.TLSCertificateConfig {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	pair := config.TLSCertificateConfig{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644))
+	return pair
+}
+
+// startTLSGateway serves the gateway with its TLS settings on a local port and returns the address
+func startTLSGateway(t *testing.T, gwConfig *config.GatewayConfig) (*Gateway, string) {
+	t.Helper()
+	gateway, err := NewGatewayWithDependencies(gwConfig, nil, deps.NewTestWithName(t.Name()))
+	require.NoError(t, err)
+	require.NotNil(t, gateway.Server.TLSConfig)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go gateway.Server.ServeTLS(listener, "", "")
+	t.Cleanup(func() { gateway.Server.Close() })
+	return gateway, listener.Addr().String()
+}
+
+// servedCertificate returns the common name of the certificate the server sends for the name
+func servedCertificate(t *testing.T, addr, serverName string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestTLSCertificatesBySNI(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { certCheckInterval = interval })
+
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	api := writePair(t, dir, "api", ca.issue(t, "api", "api.example.com"))
+	apps := writePair(t, dir, "apps", ca.issue(t, "apps", "apps.example.com"))
+	apps.Hosts = []string{"*.apps.example.com"}
+	gateway, addr := startTLSGateway(t, &config.GatewayConfig{
+		Server: config.ServerConfig{Host: "127.0.0.1", TLS: &config.TLSConfig{
+			Certificates: []config.TLSCertificateConfig{api, apps},
+			MinVersion:   "1.3",
+		}},
+		Management: config.ManagementConfig{Prefix: "/_"},
+	})
+
+	assert.Equal(t, "api", servedCertificate(t, addr, "api.example.com"), "hosts default to the names of the certificate")
+	assert.Equal(t, "apps", servedCertificate(t, addr, "shop.apps.example.com"))
+	assert.Equal(t, "api", servedCertificate(t, addr, "other.test"), "the first certificate is the default")
+
+	// The certificate verifies against the CA
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "api.example.com", RootCAs: ca.pool})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+	conn.Close()
+
+	_, err = tls.Dial("tcp", addr, &tls.Config{ServerName: "api.example.com", RootCAs: ca.pool, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err, "TLS 1.2 is below the minimum version")
+
+	// A renewed certificate is served once its files change
+	renewed := writePair(t, dir, "api", ca.issue(t, "api renewed", "api.example.com"))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(renewed.CertFile, later, later))
+	assert.Eventually(t, func() bool {
+		return servedCertificate(t, addr, "api.example.com") == "api renewed"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// Broken files keep the current certificates
+	require.NoError(t, os.WriteFile(renewed.CertFile, []byte("not a certificate"), 0o644))
+	require.NoError(t, os.Chtimes(renewed.CertFile, later.Add(time.Minute), later.Add(time.Minute)))
+	time.Sleep(5 * certCheckInterval)
+	assert.Equal(t, "api renewed", servedCertificate(t, addr, "api.example.com"))
+	assert.Nil(t, gateway.RedirectServer)
+}
+
+func TestTLSClientCertificateRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-User-Id")+"|"+r.Header.Get("X-Client-Cert-Subject"))
+	}))
+	defer backend.Close()
+
+	ca := newTestCA(t)
+	server := writePair(t, t.TempDir(), "server", ca.issue(t, "gateway", "gateway.test"))
+	gateway, addr := startTLSGateway(t, &config.GatewayConfig{
+		Server: config.ServerConfig{Host: "127.0.0.1", TLS: &config.TLSConfig{
+			Certificates: []config.TLSCertificateConfig{server},
+			ClientAuth:   &config.ClientAuthConfig{CAFile: ca.file},
+		}},
+		Management: config.ManagementConfig{Prefix: "/_"},
+		Routes: []config.RouteConfig{
+			{Name: "Billing", From: "/billing/*", To: backend.URL, ClientCert: &config.RouteClientCertConfig{Required: true, AllowedSubjects: []string{"billing*"}}},
+			{Name: "Public", From: "/public/*", To: backend.URL},
+		},
+	})
+	require.NoError(t, gateway.Dependencies.UserRepo.CreateUser(&db.User{Username: "billing-service", Email: "billing@example.com", Password: "secret"}))
+
+	get := func(path string, certs ...tls.Certificate) (int, string) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			ServerName: "gateway.test", RootCAs: ca.pool, Certificates: certs,
+		}}}
+		req, err := http.NewRequest(http.MethodGet, "https://"+addr+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Client-Cert-Subject", "CN=spoofed")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, _ := get("/billing/invoices")
+	assert.Equal(t, http.StatusForbidden, status, "the route requires a client certificate")
+
+	status, _ = get("/billing/invoices", ca.issue(t, "reports"))
+	assert.Equal(t, http.StatusForbidden, status, "the subject is not allowed")
+
+	status, body := get("/billing/invoices", ca.issue(t, "billing"))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "cert:billing|CN=billing,O=Taronja", body)
+
+	status, body = get("/billing/invoices", ca.issue(t, "billing-service"))
+	assert.Equal(t, http.StatusOK, status)
+	user, err := gateway.Dependencies.UserRepo.FindUserByIdOrUsername("", "billing-service", "")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID+"|CN=billing-service,O=Taronja", body, "the certificate maps to the gateway user")
+
+	status, body = get("/public/page", ca.issue(t, "billing"))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "|", body, "routes without a client certificate setting forward nothing")
+
+	// Certificates of another CA fail the handshake
+	other := newTestCA(t)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		ServerName: "gateway.test", RootCAs: ca.pool, Certificates: []tls.Certificate{other.issue(t, "billing")},
+	}}}
+	_, err = client.Get("https://" + addr + "/public/page")
+	assert.Error(t, err)
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	redirect := func(port int, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		httpsRedirectHandler(port).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		return rec
+	}
+
+	rec := redirect(443, "http://example.com/orders?page=2")
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/orders?page=2", rec.Header().Get("Location"))
+
+	rec = redirect(8443, "http://Example.com:8080/orders")
+	assert.Equal(t, "https://example.com:8443/orders", rec.Header().Get("Location"))
+
+	server := newRedirectServer(&config.GatewayConfig{Server: config.ServerConfig{Host: "0.0.0.0", Port: 443, TLS: &config.TLSConfig{HTTPRedirectPort: 80}}}, nil)
+	require.NotNil(t, server)
+	assert.Equal(t, "0.0.0.0:80", server.Addr)
+}
+
+func TestTLSACMECertificates(t *testing.T) {
+	ca := newTestCA(t)
+	files := writePair(t, t.TempDir(), "files", ca.issue(t, "files", "files.example.test"))
+	directory := httptest.NewServer(http.NotFoundHandler())
+	defer directory.Close()
+	gateway, addr := startTLSGateway(t, &config.GatewayConfig{
+		Server: config.ServerConfig{Host: "127.0.0.1", Port: 443, TLS: &config.TLSConfig{
+			Certificates:     []config.TLSCertificateConfig{files},
+			HTTPRedirectPort: 80,
+			ACME: &config.ACMEConfig{
+				AcceptTOS:    true,
+				DirectoryURL: directory.URL,
+				Hosts:        []string{"api.example.test", "files.example.test"},
+			},
+		}},
+		Management: config.ManagementConfig{Prefix: "/_"},
+	})
+	require.NotNil(t, gateway.Certificates)
+	assert.Contains(t, gateway.Server.TLSConfig.NextProtos, "acme-tls/1", "tls-alpn-01 challenges")
+
+	// A certificate obtained earlier, stored in the database
+	issued := ca.issue(t, "acme", "api.example.test")
+	keyDER, err := x509.MarshalECPrivateKey(issued.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	cached := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued.Certificate[0]})...)
+	require.NoError(t, gateway.Dependencies.CertificateRepo.PutCertificateData("api.example.test", cached))
+
+	assert.Equal(t, "acme", servedCertificate(t, addr, "api.example.test"))
+	assert.Equal(t, "files", servedCertificate(t, addr, "files.example.test"), "certificate files take precedence")
+	assert.Equal(t, "files", servedCertificate(t, addr, "other.example.test"), "the first certificate is the default")
+
+	statuses := gateway.Certificates.Statuses()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "renewing", statuses[0].Status, "the test certificates expire within the hour")
+	assert.Equal(t, issued.Leaf.NotAfter, statuses[0].NotAfter)
+	assert.Equal(t, "pending", statuses[1].Status, "never requested from ACME")
+
+	// The redirect listener answers the http-01 challenges
+	rec := httptest.NewRecorder()
+	gateway.RedirectServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api.example.test/.well-known/acme-challenge/token", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "no pending challenge")
+	rec = httptest.NewRecorder()
+	gateway.RedirectServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api.example.test/orders", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+}
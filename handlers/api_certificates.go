@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// GetCertificates implements GET /_/api/certificates
+func (s *StrictApiServer) GetCertificates(ctx context.Context, req api.GetCertificatesRequestObject) (api.GetCertificatesResponseObject, error) {
+	// admin check
+	sess, ok := ctx.Value(session.SessionKey).(*db.Session)
+	if !ok || sess == nil || !sess.IsAuthenticated || !sess.IsAdmin {
+		return api.GetCertificates401JSONResponse{}, nil
+	}
+	return api.GetCertificates200JSONResponse(s.certificateStatuses()), nil
+}
+
+// certificateStatuses returns the status of the ACME certificates, empty when ACME is not
+// configured
+func (s *StrictApiServer) certificateStatuses() api.CertificateStatuses {
+	if s.certificates == nil {
+		return api.CertificateStatuses{}
+	}
+	statuses := s.certificates.Statuses()
+	apiStatuses := make(api.CertificateStatuses, 0, len(statuses))
+	for _, status := range statuses {
+		apiStatuses = append(apiStatuses, api.CertificateStatus{
+			Host:      status.Host,
+			Status:    api.CertificateStatusStatus(status.Status),
+			Issuer:    stringToPointer(status.Issuer),
+			NotBefore: timeToPointer(status.NotBefore),
+			NotAfter:  timeToPointer(status.NotAfter),
+			LastCheck: timeToPointer(status.LastCheck),
+			LastError: stringToPointer(status.LastError),
+		})
+	}
+	return apiStatuses
+}
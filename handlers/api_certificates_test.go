@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/certs"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/jmaister/taronja-gateway/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCertificates(t *testing.T) {
+	directory := httptest.NewServer(http.NotFoundHandler())
+	defer directory.Close()
+	dependencies := deps.NewTestWithName(t.Name())
+	manager, err := certs.NewManager(&config.ACMEConfig{
+		AcceptTOS:    true,
+		DirectoryURL: directory.URL,
+		Hosts:        []string{"api.example.test", "www.example.test"},
+	}, dependencies.CertificateRepo)
+	require.NoError(t, err)
+	defer manager.Close()
+
+	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, nil, nil, nil, manager, nil, nil)
+	sess := &db.Session{Token: "x", IsAuthenticated: true, IsAdmin: true, ValidUntil: time.Now().Add(time.Hour)}
+	adminCtx := context.WithValue(context.Background(), session.SessionKey, sess)
+
+	t.Run("requires admin", func(t *testing.T) {
+		resp, err := s.GetCertificates(context.Background(), api.GetCertificatesRequestObject{})
+		assert.NoError(t, err)
+		assert.IsType(t, api.GetCertificates401JSONResponse{}, resp)
+	})
+
+	t.Run("reports pending certificates", func(t *testing.T) {
+		resp, err := s.GetCertificates(adminCtx, api.GetCertificatesRequestObject{})
+		require.NoError(t, err)
+		statuses, ok := resp.(api.GetCertificates200JSONResponse)
+		require.True(t, ok)
+		require.Len(t, statuses, 2)
+		assert.Equal(t, "api.example.test", statuses[0].Host)
+		assert.Equal(t, api.Pending, statuses[0].Status)
+		assert.Nil(t, statuses[0].NotAfter)
+		assert.Nil(t, statuses[0].LastError)
+
+		health, err := s.HealthCheck(context.Background(), api.HealthCheckRequestObject{})
+		require.NoError(t, err)
+		healthy, ok := health.(api.HealthCheck200JSONResponse)
+		require.True(t, ok)
+		assert.Equal(t, "ok", healthy.Status)
+		require.NotNil(t, healthy.Certificates)
+		assert.Len(t, *healthy.Certificates, 2)
+	})
+
+	t.Run("failed certificates degrade the health", func(t *testing.T) {
+		_, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "api.example.test"})
+		require.Error(t, err, "the directory is unavailable")
+
+		resp, err := s.GetCertificates(adminCtx, api.GetCertificatesRequestObject{})
+		require.NoError(t, err)
+		statuses := resp.(api.GetCertificates200JSONResponse)
+		assert.Equal(t, api.Failed, statuses[0].Status)
+		require.NotNil(t, statuses[0].LastError)
+		assert.NotNil(t, statuses[0].LastCheck)
+
+		health, err := s.HealthCheck(context.Background(), api.HealthCheckRequestObject{})
+		require.NoError(t, err)
+		degraded, ok := health.(api.HealthCheck200JSONResponse)
+		require.True(t, ok)
+		assert.Equal(t, "degraded", degraded.Status)
+	})
+
+	t.Run("empty without ACME", func(t *testing.T) {
+		noACME := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, nil, nil, nil, nil, nil, nil)
+		resp, err := noACME.GetCertificates(adminCtx, api.GetCertificatesRequestObject{})
+		require.NoError(t, err)
+		assert.Equal(t, api.GetCertificates200JSONResponse{}, resp)
+	})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// ReloadConfig handles POST /api/config/reload
+func (s *StrictApiServer) ReloadConfig(ctx context.Context, request api.ReloadConfigRequestObject) (api.ReloadConfigResponseObject, error) {
+	sessionObj, ok := ctx.Value(session.SessionKey).(*db.Session)
+	if !ok || sessionObj == nil {
+		return api.ReloadConfig401JSONResponse{
+			Code:    401,
+			Message: "Unauthorized",
+		}, nil
+	}
+
+	// Only admins can reload the configuration
+	if !sessionObj.IsAdmin {
+		return api.ReloadConfig403JSONResponse{
+			Code:    403,
+			Message: "Forbidden: admin access required",
+		}, nil
+	}
+
+	if s.reloadConfig == nil {
+		return api.ReloadConfig400JSONResponse{
+			Code:    400,
+			Message: "The configuration cannot be reloaded",
+		}, nil
+	}
+	diff, err := s.reloadConfig()
+	if err != nil {
+		return api.ReloadConfig400JSONResponse{
+			Code:    400,
+			Message: "Configuration not reloaded: " + err.Error(),
+		}, nil
+	}
+
+	return api.ReloadConfig200JSONResponse{
+		Added:           nonNil(diff.Added),
+		Removed:         nonNil(diff.Removed),
+		Changed:         nonNil(diff.Changed),
+		Unchanged:       diff.Unchanged,
+		RestartRequired: nonNil(diff.RestartRequired),
+	}, nil
+}
+
+// nonNil returns an empty slice for nil, so it is encoded as [] instead of null
+func nonNil(names []string) []string {
+	if names == nil {
+		return []string{}
+	}
+	return names
+}
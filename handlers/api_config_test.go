@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/jmaister/taronja-gateway/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadConfig(t *testing.T) {
+	dependencies := deps.NewTest()
+	var reloadErr error
+	reload := func() (config.ConfigDiff, error) {
+		if reloadErr != nil {
+			return config.ConfigDiff{}, reloadErr
+		}
+		return config.ConfigDiff{Added: []string{"Users"}, Changed: []string{"Orders"}, Unchanged: 3}, nil
+	}
+	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, nil, nil, nil, nil, reload, nil)
+
+	withSession := func(isAdmin bool) context.Context {
+		sess := &db.Session{Token: "x", IsAuthenticated: true, IsAdmin: isAdmin, ValidUntil: time.Now().Add(time.Hour)}
+		return context.WithValue(context.Background(), session.SessionKey, sess)
+	}
+
+	resp, err := s.ReloadConfig(context.Background(), api.ReloadConfigRequestObject{})
+	require.NoError(t, err)
+	assert.IsType(t, api.ReloadConfig401JSONResponse{}, resp)
+
+	resp, err = s.ReloadConfig(withSession(false), api.ReloadConfigRequestObject{})
+	require.NoError(t, err)
+	assert.IsType(t, api.ReloadConfig403JSONResponse{}, resp)
+
+	resp, err = s.ReloadConfig(withSession(true), api.ReloadConfigRequestObject{})
+	require.NoError(t, err)
+	assert.Equal(t, api.ReloadConfig200JSONResponse{
+		Added:           []string{"Users"},
+		Removed:         []string{},
+		Changed:         []string{"Orders"},
+		Unchanged:       3,
+		RestartRequired: []string{},
+	}, resp)
+
+	reloadErr = errors.New("route 'Broken' is marked as static but neither 'toFolder', 'toFile' nor 'toArchive' is specified")
+	resp, err = s.ReloadConfig(withSession(true), api.ReloadConfigRequestObject{})
+	require.NoError(t, err)
+	require.IsType(t, api.ReloadConfig400JSONResponse{}, resp)
+	assert.Contains(t, resp.(api.ReloadConfig400JSONResponse).Message, "route 'Broken'")
+}
@@ -5,17 +5,64 @@ import (
 	"time"
 
 	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/upstream"
 )
 
 // HealthCheck implements the HealthCheck operation for the api.StrictServerInterface.
+// It answers 503 when any proxy route has no healthy upstream target left, and while the
+// gateway drains its connections on shutdown so load balancers stop sending traffic. An
+// ACME certificate that expired or could not be obtained makes it degraded.
 func (s *StrictApiServer) HealthCheck(ctx context.Context, request api.HealthCheckRequestObject) (api.HealthCheckResponseObject, error) {
 	uptime := time.Since(s.startTime)
 
-	response := api.HealthCheck200JSONResponse{
+	response := api.HealthResponse{
 		Status:    "ok",
 		Timestamp: time.Now(),
 		Uptime:    uptime.String(),
 	}
 
-	return response, nil
+	if s.draining != nil && s.draining() {
+		response.Status = "draining"
+		return api.HealthCheck503JSONResponse(response), nil
+	}
+
+	if s.certificates != nil {
+		certificates := s.certificateStatuses()
+		for _, certificate := range certificates {
+			if certificate.Status == api.Expired || certificate.Status == api.Failed {
+				response.Status = "degraded"
+			}
+		}
+		response.Certificates = &certificates
+	}
+
+	if s.upstreams == nil {
+		return api.HealthCheck200JSONResponse(response), nil
+	}
+
+	pools := s.upstreams.Pools()
+	if len(pools) > 0 {
+		summaries := make([]api.UpstreamPoolSummary, 0, len(pools))
+		for _, pool := range pools {
+			status := pool.Status()
+			summaries = append(summaries, api.UpstreamPoolSummary{
+				Route:          pool.Name,
+				Status:         api.UpstreamPoolSummaryStatus(status),
+				HealthyTargets: pool.HealthyCount(),
+				TotalTargets:   len(pool.Targets()),
+			})
+			switch {
+			case status == upstream.StatusUnhealthy:
+				response.Status = "unavailable"
+			case status == upstream.StatusDegraded && response.Status == "ok":
+				response.Status = "degraded"
+			}
+		}
+		response.Upstreams = &summaries
+	}
+
+	if response.Status == "unavailable" {
+		return api.HealthCheck503JSONResponse(response), nil
+	}
+	return api.HealthCheck200JSONResponse(response), nil
 }
@@ -27,6 +27,11 @@ func TestHealthCheck(t *testing.T) {
 		testDeps.TokenService,
 		testDeps.StartTime,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 
 	t.Run("SuccessfulHealthCheck", func(t *testing.T) {
@@ -67,4 +72,20 @@ func TestHealthCheck(t *testing.T) {
 		assert.False(t, healthResp.Timestamp.IsZero(), "Timestamp should not be zero")
 	})
 
+	t.Run("DrainingHealthCheck", func(t *testing.T) {
+		draining := false
+		s.draining = func() bool { return draining }
+
+		resp, err := s.HealthCheck(context.Background(), api.HealthCheckRequestObject{})
+		assert.NoError(t, err)
+		assert.IsType(t, api.HealthCheck200JSONResponse{}, resp)
+
+		// Load balancers stop sending traffic once the gateway shuts down
+		draining = true
+		resp, err = s.HealthCheck(context.Background(), api.HealthCheckRequestObject{})
+		assert.NoError(t, err)
+		drainingResp, ok := resp.(api.HealthCheck503JSONResponse)
+		assert.True(t, ok, "Response should be HealthCheck503JSONResponse")
+		assert.Equal(t, "draining", drainingResp.Status)
+	})
 }
@@ -5,9 +5,13 @@ import (
 
 	"github.com/jmaister/taronja-gateway/api"
 	"github.com/jmaister/taronja-gateway/auth"
+	"github.com/jmaister/taronja-gateway/cache"
+	"github.com/jmaister/taronja-gateway/certs"
+	"github.com/jmaister/taronja-gateway/config"
 	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/middleware"
 	"github.com/jmaister/taronja-gateway/session"
+	"github.com/jmaister/taronja-gateway/upstream"
 )
 
 type StrictApiServer struct {
@@ -22,10 +26,23 @@ type StrictApiServer struct {
 	startTime         time.Time
 	// rate limiter instance for stats/config endpoints
 	rateLimiter *middleware.RateLimiter
+	// upstream pools of the proxy routes for health endpoints
+	upstreams *upstream.Registry
+	// shared response cache for cache stats endpoints, nil when no route uses it
+	responseCache *cache.Cache
+	// ACME certificates for the certificate status endpoints, nil when not configured
+	certificates *certs.Manager
+	// reloads the gateway configuration, nil when it cannot be reloaded
+	reloadConfig ConfigReloader
+	// reports whether the gateway is shutting down, nil when it never drains
+	draining func() bool
 }
 
+// ConfigReloader reloads the configuration of the gateway and returns what changed
+type ConfigReloader func() (config.ConfigDiff, error)
+
 // NewStrictApiServer creates a new StrictApiServer.
-func NewStrictApiServer(sessionStore session.SessionStore, userRepo db.UserRepository, trafficMetricRepo db.TrafficMetricRepository, tokenRepo db.TokenRepository, countersRepo db.CountersRepository, tokenService *auth.TokenService, startTime time.Time, rateLimiter *middleware.RateLimiter) *StrictApiServer {
+func NewStrictApiServer(sessionStore session.SessionStore, userRepo db.UserRepository, trafficMetricRepo db.TrafficMetricRepository, tokenRepo db.TokenRepository, countersRepo db.CountersRepository, tokenService *auth.TokenService, startTime time.Time, rateLimiter *middleware.RateLimiter, upstreams *upstream.Registry, responseCache *cache.Cache, certificates *certs.Manager, reloadConfig ConfigReloader, draining func() bool) *StrictApiServer {
 	return &StrictApiServer{
 		sessionStore:      sessionStore,
 		userRepo:          userRepo,
@@ -35,6 +52,11 @@ func NewStrictApiServer(sessionStore session.SessionStore, userRepo db.UserRepos
 		tokenService:      tokenService,
 		startTime:         startTime,
 		rateLimiter:       rateLimiter,
+		upstreams:         upstreams,
+		responseCache:     responseCache,
+		certificates:      certificates,
+		reloadConfig:      reloadConfig,
+		draining:          draining,
 	}
 }
 
@@ -46,6 +46,11 @@ func TestGetCurrentUser(t *testing.T) {
 		dependencies.TokenService,
 		dependencies.StartTime,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 
 	t.Run("AuthenticatedUser", func(t *testing.T) {
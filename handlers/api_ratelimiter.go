@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/middleware"
+	"github.com/jmaister/taronja-gateway/session"
+	"gorm.io/gorm"
+)
+
+// Page size of the block history
+const (
+	defaultBlockEventsLimit = 100
+	maxBlockEventsLimit     = 1000
+)
+
+// noBlockList is the error of the block list endpoints when the blocks are not stored
+var noBlockList = api.Error{Code: 500, Message: "The rate limiter does not store its blocks"}
+
+// adminSession returns the session of an authenticated administrator, nil for anyone else
+func adminSession(ctx context.Context) *db.Session {
+	sess, ok := ctx.Value(session.SessionKey).(*db.Session)
+	if !ok || sess == nil || !sess.IsAuthenticated || !sess.IsAdmin {
+		return nil
+	}
+	return sess
+}
+
+// ListRateLimiterBlocks implements GET /_/api/rate-limiter/blocks
+func (s *StrictApiServer) ListRateLimiterBlocks(ctx context.Context, req api.ListRateLimiterBlocksRequestObject) (api.ListRateLimiterBlocksResponseObject, error) {
+	if adminSession(ctx) == nil {
+		return api.ListRateLimiterBlocks401JSONResponse{}, nil
+	}
+	if s.rateLimiter == nil {
+		return api.ListRateLimiterBlocks500JSONResponse(noBlockList), nil
+	}
+
+	limit := defaultBlockEventsLimit
+	if req.Params.Limit != nil && *req.Params.Limit > 0 {
+		limit = min(*req.Params.Limit, maxBlockEventsLimit)
+	}
+	offset := 0
+	if req.Params.Offset != nil && *req.Params.Offset > 0 {
+		offset = *req.Params.Offset
+	}
+	var filter db.BlockEventFilter
+	if req.Params.Network != nil {
+		filter.Network = *req.Params.Network
+	}
+	if req.Params.Reason != nil {
+		filter.Reason = string(*req.Params.Reason)
+	}
+	now := time.Now()
+	if req.Params.Active != nil && *req.Params.Active {
+		filter.ActiveAt = &now
+	}
+
+	events, total, err := s.rateLimiter.BlockEvents(filter, limit, offset)
+	if err != nil {
+		log.Printf("ListRateLimiterBlocks: %v", err)
+		return api.ListRateLimiterBlocks500JSONResponse{Code: 500, Message: "Failed to retrieve the blocks"}, nil
+	}
+	apiEvents := make([]api.BlockEvent, 0, len(events))
+	for _, event := range events {
+		apiEvents = append(apiEvents, convertBlockEvent(event, now))
+	}
+	return api.ListRateLimiterBlocks200JSONResponse{
+		Events:     apiEvents,
+		TotalCount: int(total),
+		Limit:      limit,
+		Offset:     offset,
+	}, nil
+}
+
+// CreateRateLimiterBlock implements POST /_/api/rate-limiter/blocks
+func (s *StrictApiServer) CreateRateLimiterBlock(ctx context.Context, req api.CreateRateLimiterBlockRequestObject) (api.CreateRateLimiterBlockResponseObject, error) {
+	sess := adminSession(ctx)
+	if sess == nil {
+		return api.CreateRateLimiterBlock401JSONResponse{}, nil
+	}
+	if s.rateLimiter == nil {
+		return api.CreateRateLimiterBlock500JSONResponse(noBlockList), nil
+	}
+	if req.Body == nil {
+		return api.CreateRateLimiterBlock400JSONResponse{Code: 400, Message: "Bad request: network is required"}, nil
+	}
+
+	var duration time.Duration
+	if req.Body.DurationMinutes != nil {
+		if *req.Body.DurationMinutes < 1 {
+			return api.CreateRateLimiterBlock400JSONResponse{Code: 400, Message: "Bad request: durationMinutes must be at least 1"}, nil
+		}
+		duration = time.Duration(*req.Body.DurationMinutes) * time.Minute
+	}
+	note := ""
+	if req.Body.Note != nil {
+		note = *req.Body.Note
+	}
+
+	event, err := s.rateLimiter.BlockNetwork(req.Body.Network, duration, note, sess.Username)
+	switch {
+	case errors.Is(err, middleware.ErrInvalidNetwork):
+		return api.CreateRateLimiterBlock400JSONResponse{Code: 400, Message: "Bad request: " + err.Error()}, nil
+	case errors.Is(err, middleware.ErrNoBlockList):
+		return api.CreateRateLimiterBlock500JSONResponse(noBlockList), nil
+	case err != nil:
+		log.Printf("CreateRateLimiterBlock: %v", err)
+		return api.CreateRateLimiterBlock500JSONResponse{Code: 500, Message: "Failed to store the block"}, nil
+	}
+	log.Printf("Rate limiter: %s blocked %s", sess.Username, event.Network)
+	return api.CreateRateLimiterBlock201JSONResponse(convertBlockEvent(*event, time.Now())), nil
+}
+
+// DeleteRateLimiterBlock implements DELETE /_/api/rate-limiter/blocks/{blockId}
+func (s *StrictApiServer) DeleteRateLimiterBlock(ctx context.Context, req api.DeleteRateLimiterBlockRequestObject) (api.DeleteRateLimiterBlockResponseObject, error) {
+	sess := adminSession(ctx)
+	if sess == nil {
+		return api.DeleteRateLimiterBlock401JSONResponse{}, nil
+	}
+	if s.rateLimiter == nil {
+		return api.DeleteRateLimiterBlock500JSONResponse(noBlockList), nil
+	}
+
+	event, err := s.rateLimiter.Unblock(req.BlockId, sess.Username)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return api.DeleteRateLimiterBlock404JSONResponse{Code: 404, Message: "Block not found or already ended"}, nil
+	case errors.Is(err, middleware.ErrNoBlockList):
+		return api.DeleteRateLimiterBlock500JSONResponse(noBlockList), nil
+	case err != nil:
+		log.Printf("DeleteRateLimiterBlock: %v", err)
+		return api.DeleteRateLimiterBlock500JSONResponse{Code: 500, Message: "Failed to end the block"}, nil
+	}
+	log.Printf("Rate limiter: %s unblocked %s", sess.Username, event.Network)
+	return api.DeleteRateLimiterBlock200JSONResponse(convertBlockEvent(*event, time.Now())), nil
+}
+
+// ListRateLimiterAllowlist implements GET /_/api/rate-limiter/allowlist
+func (s *StrictApiServer) ListRateLimiterAllowlist(ctx context.Context, req api.ListRateLimiterAllowlistRequestObject) (api.ListRateLimiterAllowlistResponseObject, error) {
+	if adminSession(ctx) == nil {
+		return api.ListRateLimiterAllowlist401JSONResponse{}, nil
+	}
+	if s.rateLimiter == nil {
+		return api.ListRateLimiterAllowlist500JSONResponse(noBlockList), nil
+	}
+
+	entries, err := s.rateLimiter.Allowlist()
+	if errors.Is(err, middleware.ErrNoBlockList) {
+		return api.ListRateLimiterAllowlist500JSONResponse(noBlockList), nil
+	}
+	if err != nil {
+		log.Printf("ListRateLimiterAllowlist: %v", err)
+		return api.ListRateLimiterAllowlist500JSONResponse{Code: 500, Message: "Failed to retrieve the allowlist"}, nil
+	}
+	apiEntries := make(api.AllowlistEntries, 0, len(entries))
+	for _, entry := range entries {
+		apiEntries = append(apiEntries, convertAllowlistEntry(entry))
+	}
+	return api.ListRateLimiterAllowlist200JSONResponse(apiEntries), nil
+}
+
+// CreateRateLimiterAllowlistEntry implements POST /_/api/rate-limiter/allowlist
+func (s *StrictApiServer) CreateRateLimiterAllowlistEntry(ctx context.Context, req api.CreateRateLimiterAllowlistEntryRequestObject) (api.CreateRateLimiterAllowlistEntryResponseObject, error) {
+	sess := adminSession(ctx)
+	if sess == nil {
+		return api.CreateRateLimiterAllowlistEntry401JSONResponse{}, nil
+	}
+	if s.rateLimiter == nil {
+		return api.CreateRateLimiterAllowlistEntry500JSONResponse(noBlockList), nil
+	}
+	if req.Body == nil {
+		return api.CreateRateLimiterAllowlistEntry400JSONResponse{Code: 400, Message: "Bad request: network is required"}, nil
+	}
+	note := ""
+	if req.Body.Note != nil {
+		note = *req.Body.Note
+	}
+
+	entry, err := s.rateLimiter.AllowNetwork(req.Body.Network, note, sess.Username)
+	switch {
+	case errors.Is(err, middleware.ErrInvalidNetwork), errors.Is(err, middleware.ErrAlreadyAllowed):
+		return api.CreateRateLimiterAllowlistEntry400JSONResponse{Code: 400, Message: "Bad request: " + err.Error()}, nil
+	case errors.Is(err, middleware.ErrNoBlockList):
+		return api.CreateRateLimiterAllowlistEntry500JSONResponse(noBlockList), nil
+	case err != nil:
+		log.Printf("CreateRateLimiterAllowlistEntry: %v", err)
+		return api.CreateRateLimiterAllowlistEntry500JSONResponse{Code: 500, Message: "Failed to store the allowlist entry"}, nil
+	}
+	log.Printf("Rate limiter: %s allowed %s", sess.Username, entry.Network)
+	return api.CreateRateLimiterAllowlistEntry201JSONResponse(convertAllowlistEntry(*entry)), nil
+}
+
+// DeleteRateLimiterAllowlistEntry implements DELETE /_/api/rate-limiter/allowlist/{entryId}
+func (s *StrictApiServer) DeleteRateLimiterAllowlistEntry(ctx context.Context, req api.DeleteRateLimiterAllowlistEntryRequestObject) (api.DeleteRateLimiterAllowlistEntryResponseObject, error) {
+	if adminSession(ctx) == nil {
+		return api.DeleteRateLimiterAllowlistEntry401JSONResponse{}, nil
+	}
+	if s.rateLimiter == nil {
+		return api.DeleteRateLimiterAllowlistEntry500JSONResponse(noBlockList), nil
+	}
+
+	err := s.rateLimiter.RemoveAllowed(req.EntryId)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return api.DeleteRateLimiterAllowlistEntry404JSONResponse{Code: 404, Message: "Allowlist entry not found"}, nil
+	case errors.Is(err, middleware.ErrNoBlockList):
+		return api.DeleteRateLimiterAllowlistEntry500JSONResponse(noBlockList), nil
+	case err != nil:
+		log.Printf("DeleteRateLimiterAllowlistEntry: %v", err)
+		return api.DeleteRateLimiterAllowlistEntry500JSONResponse{Code: 500, Message: "Failed to remove the allowlist entry"}, nil
+	}
+	return api.DeleteRateLimiterAllowlistEntry204Response{}, nil
+}
+
+// convertBlockEvent converts a stored block to the API response, active as of now
+func convertBlockEvent(event db.BlockEvent, now time.Time) api.BlockEvent {
+	apiEvent := api.BlockEvent{
+		Id:          event.ID,
+		Network:     event.Network,
+		Reason:      api.BlockEventReason(event.Reason),
+		Path:        stringToPointer(event.Path),
+		Note:        stringToPointer(event.Note),
+		CreatedBy:   stringToPointer(event.CreatedBy),
+		StartedAt:   event.StartedAt,
+		EndsAt:      event.EndsAt,
+		UnblockedAt: event.UnblockedAt,
+		UnblockedBy: stringToPointer(event.UnblockedBy),
+		Active:      event.IsActive(now),
+		UserAgent:   stringToPointer(event.UserAgent),
+		Ja4h:        stringToPointer(event.JA4Fingerprint),
+		Country:     stringToPointer(event.Country),
+		CountryCode: stringToPointer(event.CountryCode),
+		City:        stringToPointer(event.City),
+	}
+	if event.Latitude != 0 || event.Longitude != 0 {
+		latitude, longitude := float32(event.Latitude), float32(event.Longitude)
+		apiEvent.Latitude = &latitude
+		apiEvent.Longitude = &longitude
+	}
+	return apiEvent
+}
+
+// convertAllowlistEntry converts a stored allowlist entry to the API response
+func convertAllowlistEntry(entry db.AllowlistEntry) api.AllowlistEntry {
+	return api.AllowlistEntry{
+		Id:        entry.ID,
+		Network:   entry.Network,
+		Note:      stringToPointer(entry.Note),
+		CreatedBy: stringToPointer(entry.CreatedBy),
+		CreatedAt: entry.CreatedAt,
+	}
+}
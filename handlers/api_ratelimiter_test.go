@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/jmaister/taronja-gateway/middleware"
+	"github.com/jmaister/taronja-gateway/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterBlockEndpoints(t *testing.T) {
+	dependencies := deps.NewTestWithName(t.Name())
+	rateLimiter := middleware.NewRateLimiter(config.RateLimiterConfig{})
+	defer rateLimiter.Close()
+	require.NoError(t, rateLimiter.UseBlockList(dependencies.BlockListRepo))
+
+	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, rateLimiter, nil, nil, nil, nil, nil)
+	sess := &db.Session{Token: "x", Username: "admin", IsAuthenticated: true, IsAdmin: true, ValidUntil: time.Now().Add(time.Hour)}
+	adminCtx := context.WithValue(context.Background(), session.SessionKey, sess)
+
+	t.Run("requires admin", func(t *testing.T) {
+		user := &db.Session{Token: "y", IsAuthenticated: true, ValidUntil: time.Now().Add(time.Hour)}
+		userCtx := context.WithValue(context.Background(), session.SessionKey, user)
+		resp, err := s.ListRateLimiterBlocks(userCtx, api.ListRateLimiterBlocksRequestObject{})
+		require.NoError(t, err)
+		assert.IsType(t, api.ListRateLimiterBlocks401JSONResponse{}, resp)
+		created, err := s.CreateRateLimiterBlock(context.Background(), api.CreateRateLimiterBlockRequestObject{Body: &api.BlockRequest{Network: "203.0.113.7"}})
+		require.NoError(t, err)
+		assert.IsType(t, api.CreateRateLimiterBlock401JSONResponse{}, created)
+		allowlist, err := s.ListRateLimiterAllowlist(context.Background(), api.ListRateLimiterAllowlistRequestObject{})
+		require.NoError(t, err)
+		assert.IsType(t, api.ListRateLimiterAllowlist401JSONResponse{}, allowlist)
+	})
+
+	t.Run("blocks and unblocks", func(t *testing.T) {
+		invalid, err := s.CreateRateLimiterBlock(adminCtx, api.CreateRateLimiterBlockRequestObject{Body: &api.BlockRequest{Network: "not-an-ip"}})
+		require.NoError(t, err)
+		assert.IsType(t, api.CreateRateLimiterBlock400JSONResponse{}, invalid)
+		zero := 0
+		invalid, err = s.CreateRateLimiterBlock(adminCtx, api.CreateRateLimiterBlockRequestObject{Body: &api.BlockRequest{Network: "203.0.113.7", DurationMinutes: &zero}})
+		require.NoError(t, err)
+		assert.IsType(t, api.CreateRateLimiterBlock400JSONResponse{}, invalid)
+
+		minutes, note := 60, "credential stuffing"
+		resp, err := s.CreateRateLimiterBlock(adminCtx, api.CreateRateLimiterBlockRequestObject{Body: &api.BlockRequest{Network: "198.51.100.20/24", DurationMinutes: &minutes, Note: &note}})
+		require.NoError(t, err)
+		created, ok := resp.(api.CreateRateLimiterBlock201JSONResponse)
+		require.True(t, ok)
+		assert.Equal(t, "198.51.100.0/24", created.Network)
+		assert.Equal(t, api.BlockEventReasonManual, created.Reason)
+		assert.True(t, created.Active)
+		require.NotNil(t, created.EndsAt)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *created.EndsAt, time.Minute)
+		assert.Equal(t, "admin", *created.CreatedBy)
+
+		permanent, err := s.CreateRateLimiterBlock(adminCtx, api.CreateRateLimiterBlockRequestObject{Body: &api.BlockRequest{Network: "203.0.113.7"}})
+		require.NoError(t, err)
+		assert.Nil(t, permanent.(api.CreateRateLimiterBlock201JSONResponse).EndsAt)
+
+		list, err := s.ListRateLimiterBlocks(adminCtx, api.ListRateLimiterBlocksRequestObject{})
+		require.NoError(t, err)
+		page, ok := list.(api.ListRateLimiterBlocks200JSONResponse)
+		require.True(t, ok)
+		assert.Equal(t, 2, page.TotalCount)
+		assert.Equal(t, 100, page.Limit)
+		assert.Equal(t, "203.0.113.7", page.Events[0].Network, "newest first")
+
+		unblocked, err := s.DeleteRateLimiterBlock(adminCtx, api.DeleteRateLimiterBlockRequestObject{BlockId: created.Id})
+		require.NoError(t, err)
+		ended, ok := unblocked.(api.DeleteRateLimiterBlock200JSONResponse)
+		require.True(t, ok)
+		assert.False(t, ended.Active)
+		assert.NotNil(t, ended.UnblockedAt)
+		assert.Equal(t, "admin", *ended.UnblockedBy)
+
+		again, err := s.DeleteRateLimiterBlock(adminCtx, api.DeleteRateLimiterBlockRequestObject{BlockId: created.Id})
+		require.NoError(t, err)
+		assert.IsType(t, api.DeleteRateLimiterBlock404JSONResponse{}, again)
+
+		active := true
+		network := "198.51.100.99/24"
+		list, err = s.ListRateLimiterBlocks(adminCtx, api.ListRateLimiterBlocksRequestObject{Params: api.ListRateLimiterBlocksParams{Active: &active}})
+		require.NoError(t, err)
+		page = list.(api.ListRateLimiterBlocks200JSONResponse)
+		require.Len(t, page.Events, 1)
+		assert.Equal(t, "203.0.113.7", page.Events[0].Network)
+		list, err = s.ListRateLimiterBlocks(adminCtx, api.ListRateLimiterBlocksRequestObject{Params: api.ListRateLimiterBlocksParams{Network: &network}})
+		require.NoError(t, err)
+		page = list.(api.ListRateLimiterBlocks200JSONResponse)
+		require.Len(t, page.Events, 1, "the filter is normalized")
+		assert.Equal(t, created.Id, page.Events[0].Id)
+	})
+
+	t.Run("allowlist", func(t *testing.T) {
+		note := "office"
+		resp, err := s.CreateRateLimiterAllowlistEntry(adminCtx, api.CreateRateLimiterAllowlistEntryRequestObject{Body: &api.AllowlistRequest{Network: "192.0.2.0/24", Note: &note}})
+		require.NoError(t, err)
+		entry, ok := resp.(api.CreateRateLimiterAllowlistEntry201JSONResponse)
+		require.True(t, ok)
+		assert.Equal(t, "192.0.2.0/24", entry.Network)
+		assert.Equal(t, "office", *entry.Note)
+
+		duplicate, err := s.CreateRateLimiterAllowlistEntry(adminCtx, api.CreateRateLimiterAllowlistEntryRequestObject{Body: &api.AllowlistRequest{Network: "192.0.2.0/24"}})
+		require.NoError(t, err)
+		assert.IsType(t, api.CreateRateLimiterAllowlistEntry400JSONResponse{}, duplicate)
+
+		list, err := s.ListRateLimiterAllowlist(adminCtx, api.ListRateLimiterAllowlistRequestObject{})
+		require.NoError(t, err)
+		entries := list.(api.ListRateLimiterAllowlist200JSONResponse)
+		require.Len(t, entries, 1)
+		assert.Equal(t, entry.Id, entries[0].Id)
+
+		deleted, err := s.DeleteRateLimiterAllowlistEntry(adminCtx, api.DeleteRateLimiterAllowlistEntryRequestObject{EntryId: entry.Id})
+		require.NoError(t, err)
+		assert.IsType(t, api.DeleteRateLimiterAllowlistEntry204Response{}, deleted)
+		deleted, err = s.DeleteRateLimiterAllowlistEntry(adminCtx, api.DeleteRateLimiterAllowlistEntryRequestObject{EntryId: entry.Id})
+		require.NoError(t, err)
+		assert.IsType(t, api.DeleteRateLimiterAllowlistEntry404JSONResponse{}, deleted)
+	})
+
+	t.Run("without a block list", func(t *testing.T) {
+		unstored := middleware.NewRateLimiter(config.RateLimiterConfig{})
+		defer unstored.Close()
+		s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, unstored, nil, nil, nil, nil, nil)
+		resp, err := s.ListRateLimiterBlocks(adminCtx, api.ListRateLimiterBlocksRequestObject{})
+		require.NoError(t, err)
+		assert.IsType(t, api.ListRateLimiterBlocks500JSONResponse{}, resp)
+		created, err := s.CreateRateLimiterBlock(adminCtx, api.CreateRateLimiterBlockRequestObject{Body: &api.BlockRequest{Network: "203.0.113.7"}})
+		require.NoError(t, err)
+		assert.IsType(t, api.CreateRateLimiterBlock500JSONResponse{}, created)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/cache"
 	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/session"
 )
@@ -65,6 +66,13 @@ func (s *StrictApiServer) GetRequestStatistics(ctx context.Context, request api.
 		return api.GetRequestStatistics500JSONResponse{}, nil
 	}
 
+	// Get requests answered with the response of an identical request in flight
+	coalescedRequests, err := s.trafficMetricRepo.GetCoalescedRequestCount(startDate, endDate)
+	if err != nil {
+		log.Printf("Error getting coalesced request count: %v", err)
+		return api.GetRequestStatistics500JSONResponse{}, nil
+	}
+
 	// Get requests by country
 	requestsByCountry, err := s.trafficMetricRepo.GetRequestCountByCountry(startDate, endDate)
 	if err != nil {
@@ -161,6 +169,7 @@ func (s *StrictApiServer) GetRequestStatistics(ctx context.Context, request api.
 		RequestsByStatus:         statusMap,
 		AverageResponseTime:      float32(avgResponseTimeMs),
 		AverageResponseSize:      float32(avgResponseSize),
+		CoalescedRequests:        coalescedRequests,
 		RequestsByCountry:        countryMap,
 		RequestsByDeviceType:     deviceMap,
 		RequestsByPlatform:       platformMap,
@@ -215,6 +224,22 @@ func (s *StrictApiServer) GetRequestDetails(ctx context.Context, req api.GetRequ
 			longitude = &lon
 		}
 
+		var routeName, upstreamTarget *string
+		if m.TrafficMetric.RouteName != "" {
+			routeName = &m.TrafficMetric.RouteName
+		}
+		if m.TrafficMetric.Upstream != "" {
+			upstreamTarget = &m.TrafficMetric.Upstream
+		}
+		var attempts *int
+		if m.TrafficMetric.Attempts > 0 {
+			attempts = &m.TrafficMetric.Attempts
+		}
+		var coalesced *bool
+		if m.TrafficMetric.Coalesced {
+			coalesced = &m.TrafficMetric.Coalesced
+		}
+
 		details = append(details, api.RequestDetail{
 			Id:              fmt.Sprintf("%v", m.TrafficMetric.ID),
 			Timestamp:       m.TrafficMetric.Timestamp,
@@ -233,6 +258,10 @@ func (s *StrictApiServer) GetRequestDetails(ctx context.Context, req api.GetRequ
 			PlatformVersion: m.TrafficMetric.OSVersion,
 			Browser:         m.TrafficMetric.BrowserFamily,
 			BrowserVersion:  m.TrafficMetric.BrowserVersion,
+			RouteName:       routeName,
+			Upstream:        upstreamTarget,
+			Attempts:        attempts,
+			Coalesced:       coalesced,
 		})
 	}
 	return api.GetRequestDetails200JSONResponse{Requests: details}, nil
@@ -263,6 +292,86 @@ func (s *StrictApiServer) GetRateLimiterStats(ctx context.Context, req api.GetRa
 	return api.GetRateLimiterStats200JSONResponse(apiStats), nil
 }
 
+// GetCircuitBreakerStats implements GET /_/api/statistics/circuit-breakers
+func (s *StrictApiServer) GetCircuitBreakerStats(ctx context.Context, req api.GetCircuitBreakerStatsRequestObject) (api.GetCircuitBreakerStatsResponseObject, error) {
+	// admin check
+	sess, ok := ctx.Value(session.SessionKey).(*db.Session)
+	if !ok || sess == nil || !sess.IsAuthenticated || !sess.IsAdmin {
+		return api.GetCircuitBreakerStats401JSONResponse{}, nil
+	}
+	apiStats := api.CircuitBreakerStats{}
+	if s.upstreams == nil {
+		return api.GetCircuitBreakerStats200JSONResponse(apiStats), nil
+	}
+	for _, pool := range s.upstreams.Pools() {
+		breaker := pool.Breaker()
+		if breaker == nil {
+			continue
+		}
+		st := breaker.Stats()
+		apiStats = append(apiStats, api.CircuitBreakerStat{
+			Route:               st.Route,
+			State:               api.CircuitBreakerStatState(st.State),
+			ConsecutiveFailures: st.ConsecutiveFailures,
+			Requests:            st.Requests,
+			Failures:            st.Failures,
+			OpenedAt:            timeToPointer(st.OpenedAt),
+			RetryAt:             timeToPointer(st.RetryAt),
+			Transitions:         st.Transitions,
+			LastTransition:      timeToPointer(st.LastTransition),
+		})
+	}
+	return api.GetCircuitBreakerStats200JSONResponse(apiStats), nil
+}
+
+// GetCacheStats implements GET /_/api/statistics/cache
+func (s *StrictApiServer) GetCacheStats(ctx context.Context, req api.GetCacheStatsRequestObject) (api.GetCacheStatsResponseObject, error) {
+	// admin check
+	sess, ok := ctx.Value(session.SessionKey).(*db.Session)
+	if !ok || sess == nil || !sess.IsAuthenticated || !sess.IsAdmin {
+		return api.GetCacheStats401JSONResponse{}, nil
+	}
+	apiStats := api.CacheStats{Routes: []api.CacheRouteStats{}}
+	if s.responseCache == nil {
+		return api.GetCacheStats200JSONResponse(apiStats), nil
+	}
+	st := s.responseCache.Stats()
+	apiStats.Enabled = true
+	apiStats.Hits = st.Hits
+	apiStats.StaleHits = st.StaleHits
+	apiStats.Revalidations = st.Revalidations
+	apiStats.Misses = st.Misses
+	apiStats.Bypasses = st.Bypasses
+	apiStats.Stores = st.Stores
+	apiStats.HitRatio = st.HitRatio()
+	apiStats.Memory = toApiCacheTierStats(st.Memory)
+	if st.Disk != nil {
+		disk := toApiCacheTierStats(*st.Disk)
+		apiStats.Disk = &disk
+	}
+	for _, rs := range st.Routes {
+		apiStats.Routes = append(apiStats.Routes, api.CacheRouteStats{
+			Route:         rs.Route,
+			Hits:          rs.Hits,
+			StaleHits:     rs.StaleHits,
+			Revalidations: rs.Revalidations,
+			Misses:        rs.Misses,
+			Bypasses:      rs.Bypasses,
+			Stores:        rs.Stores,
+		})
+	}
+	return api.GetCacheStats200JSONResponse(apiStats), nil
+}
+
+func toApiCacheTierStats(st cache.TierStats) api.CacheTierStats {
+	return api.CacheTierStats{
+		Entries:      st.Entries,
+		SizeBytes:    st.SizeBytes,
+		MaxSizeBytes: st.MaxSizeBytes,
+		Evictions:    st.Evictions,
+	}
+}
+
 // GetRateLimiterConfig implements GET /_/api/config/rate-limiter
 func (s *StrictApiServer) GetRateLimiterConfig(ctx context.Context, req api.GetRateLimiterConfigRequestObject) (api.GetRateLimiterConfigResponseObject, error) {
 	// admin check
@@ -3,16 +3,20 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/cache"
 	"github.com/jmaister/taronja-gateway/config"
 	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/gateway/deps"
 	"github.com/jmaister/taronja-gateway/middleware"
 	"github.com/jmaister/taronja-gateway/session"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupStatsTestServer() (*StrictApiServer, db.TrafficMetricRepository) {
@@ -31,6 +35,11 @@ func setupStatsTestServer() (*StrictApiServer, db.TrafficMetricRepository) {
 		dependencies.TokenService,
 		dependencies.StartTime,
 		nil, // no rate limiter for basic stats tests
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 	return server, dependencies.TrafficMetricRepo
 }
@@ -131,6 +140,7 @@ func TestGetRequestStatistics_Success(t *testing.T) {
 			ResponseTimeNs: 500000000, // 0.5 seconds in nanoseconds
 			ResponseSize:   512,       // 0.5KB
 			Timestamp:      now.Add(-15 * time.Minute),
+			Coalesced:      true,
 			ClientInfo: db.ClientInfo{
 				Country:        "US",
 				DeviceFamily:   "tablet",
@@ -184,6 +194,9 @@ func TestGetRequestStatistics_Success(t *testing.T) {
 	expectedAvgSize := float32((1024 + 2048 + 512) / 3)
 	assert.InDelta(t, expectedAvgSize, stats.AverageResponseSize, 1.0)
 
+	// Verify requests answered with the response of an identical request
+	assert.Equal(t, int64(1), stats.CoalescedRequests)
+
 	// Verify geographical data
 	assert.Contains(t, stats.RequestsByCountry, "US")
 	assert.Contains(t, stats.RequestsByCountry, "ES")
@@ -229,6 +242,11 @@ func TestStatisticsShowUsernames(t *testing.T) {
 		dependencies.TokenService,
 		dependencies.StartTime,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 
 	// Create test users
@@ -357,7 +375,7 @@ func TestRateLimiterEndpoints(t *testing.T) {
 	cfg := &config.RateLimiterConfig{RequestsPerMinute: 5, MaxErrors: 0, BlockMinutes: 1}
 	rl := middleware.NewRateLimiter(*cfg)
 	dependencies := deps.NewTest()
-	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, rl)
+	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, rl, nil, nil, nil, nil, nil)
 	// admin session
 	sess := &db.Session{Token: "x", IsAuthenticated: true, IsAdmin: true, ValidUntil: time.Now().Add(time.Hour)}
 	ctx := context.WithValue(context.Background(), session.SessionKey, sess)
@@ -375,3 +393,54 @@ func TestRateLimiterEndpoints(t *testing.T) {
 	assert.NotNil(t, conf.RequestsPerMinute)
 	assert.Equal(t, cfg.RequestsPerMinute, *conf.RequestsPerMinute)
 }
+
+func TestGetCacheStats(t *testing.T) {
+	responseCache, err := cache.New(config.CacheConfig{})
+	require.NoError(t, err)
+	defer responseCache.Close()
+	handler := responseCache.Middleware(config.RouteConfig{Name: "catalog", Cache: &config.RouteCacheConfig{}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	}))
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items", nil))
+	}
+
+	dependencies := deps.NewTest()
+	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, nil, nil, responseCache, nil, nil, nil)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		resp, err := s.GetCacheStats(context.Background(), api.GetCacheStatsRequestObject{})
+		require.NoError(t, err)
+		assert.IsType(t, api.GetCacheStats401JSONResponse{}, resp)
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		sess := &db.Session{Token: "x", IsAuthenticated: true, IsAdmin: true, ValidUntil: time.Now().Add(time.Hour)}
+		ctx := context.WithValue(context.Background(), session.SessionKey, sess)
+		resp, err := s.GetCacheStats(ctx, api.GetCacheStatsRequestObject{})
+		require.NoError(t, err)
+		stats, ok := resp.(api.GetCacheStats200JSONResponse)
+		require.True(t, ok)
+		assert.True(t, stats.Enabled)
+		assert.Equal(t, int64(2), stats.Hits)
+		assert.Equal(t, int64(1), stats.Misses)
+		assert.InDelta(t, 2.0/3.0, stats.HitRatio, 0.001)
+		assert.Equal(t, 1, stats.Memory.Entries)
+		assert.Nil(t, stats.Disk)
+		require.Len(t, stats.Routes, 1)
+		assert.Equal(t, "catalog", stats.Routes[0].Route)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		sess := &db.Session{Token: "x", IsAuthenticated: true, IsAdmin: true, ValidUntil: time.Now().Add(time.Hour)}
+		ctx := context.WithValue(context.Background(), session.SessionKey, sess)
+		noCache := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, nil, nil, nil, nil, nil, nil)
+		resp, err := noCache.GetCacheStats(ctx, api.GetCacheStatsRequestObject{})
+		require.NoError(t, err)
+		stats, ok := resp.(api.GetCacheStats200JSONResponse)
+		require.True(t, ok)
+		assert.False(t, stats.Enabled)
+		assert.Empty(t, stats.Routes)
+	})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// GetUpstreams implements GET /_/api/upstreams
+func (s *StrictApiServer) GetUpstreams(ctx context.Context, req api.GetUpstreamsRequestObject) (api.GetUpstreamsResponseObject, error) {
+	// admin check
+	sess, ok := ctx.Value(session.SessionKey).(*db.Session)
+	if !ok || sess == nil || !sess.IsAuthenticated || !sess.IsAdmin {
+		return api.GetUpstreams401JSONResponse{}, nil
+	}
+	if s.upstreams == nil {
+		return api.GetUpstreams200JSONResponse(api.UpstreamPools{}), nil
+	}
+
+	pools := s.upstreams.Pools()
+	apiPools := make(api.UpstreamPools, 0, len(pools))
+	for _, pool := range pools {
+		checker := pool.HealthChecker()
+		apiPool := api.UpstreamPool{
+			Route:              pool.Name,
+			Strategy:           pool.Strategy,
+			Status:             api.UpstreamPoolStatus(pool.Status()),
+			ActiveHealthCheck:  checker != nil && checker.Active(),
+			PassiveHealthCheck: checker != nil && checker.Passive(),
+			Targets:            []api.UpstreamTargetHealth{},
+		}
+		for _, th := range pool.Health() {
+			apiPool.Targets = append(apiPool.Targets, api.UpstreamTargetHealth{
+				Url:                 th.URL,
+				Healthy:             th.Healthy,
+				ActiveRequests:      th.ActiveRequests,
+				ConsecutiveFailures: th.ConsecutiveFailures,
+				LastCheck:           timeToPointer(th.LastCheck),
+				LastError:           stringToPointer(th.LastError),
+				LastStatusChange:    timeToPointer(th.LastStatusChange),
+			})
+		}
+		apiPools = append(apiPools, apiPool)
+	}
+	return api.GetUpstreams200JSONResponse(apiPools), nil
+}
+
+// timeToPointer returns nil for the zero time so unset timestamps are sent as null.
+func timeToPointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
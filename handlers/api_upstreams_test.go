@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/api"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/gateway/deps"
+	"github.com/jmaister/taronja-gateway/session"
+	"github.com/jmaister/taronja-gateway/upstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newUpstreamTestServer(t *testing.T) (*StrictApiServer, *upstream.Pool) {
+	t.Helper()
+	pool, err := upstream.NewPool(config.RouteConfig{
+		Name:        "Orders API",
+		Targets:     []config.UpstreamTarget{{URL: "http://orders-1:8080"}, {URL: "http://orders-2:8080"}},
+		HealthCheck: &config.HealthCheckConfig{Passive: config.PassiveHealthCheckConfig{MaxFailures: 3}},
+	})
+	require.NoError(t, err)
+	registry := upstream.NewRegistry()
+	t.Cleanup(registry.Close)
+	registry.Add(pool)
+
+	dependencies := deps.NewTest()
+	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, nil, registry, nil, nil, nil, nil)
+	return s, pool
+}
+
+func TestGetUpstreams(t *testing.T) {
+	s, pool := newUpstreamTestServer(t)
+
+	t.Run("requires admin", func(t *testing.T) {
+		resp, err := s.GetUpstreams(context.Background(), api.GetUpstreamsRequestObject{})
+		assert.NoError(t, err)
+		assert.IsType(t, api.GetUpstreams401JSONResponse{}, resp)
+	})
+
+	t.Run("reports target state", func(t *testing.T) {
+		pool.Targets()[1].SetHealthy(false)
+		defer pool.Targets()[1].SetHealthy(true)
+
+		sess := &db.Session{Token: "x", IsAuthenticated: true, IsAdmin: true, ValidUntil: time.Now().Add(time.Hour)}
+		ctx := context.WithValue(context.Background(), session.SessionKey, sess)
+		resp, err := s.GetUpstreams(ctx, api.GetUpstreamsRequestObject{})
+		require.NoError(t, err)
+		pools, ok := resp.(api.GetUpstreams200JSONResponse)
+		require.True(t, ok)
+		require.Len(t, pools, 1)
+
+		assert.Equal(t, "Orders API", pools[0].Route)
+		assert.Equal(t, config.StrategyRoundRobin, pools[0].Strategy)
+		assert.Equal(t, api.UpstreamPoolStatus(upstream.StatusDegraded), pools[0].Status)
+		assert.False(t, pools[0].ActiveHealthCheck)
+		assert.True(t, pools[0].PassiveHealthCheck)
+		require.Len(t, pools[0].Targets, 2)
+		assert.True(t, pools[0].Targets[0].Healthy)
+		assert.False(t, pools[0].Targets[1].Healthy)
+		assert.NotNil(t, pools[0].Targets[1].LastStatusChange)
+		assert.Nil(t, pools[0].Targets[0].LastCheck)
+	})
+}
+
+func TestHealthCheckReportsUpstreams(t *testing.T) {
+	s, pool := newUpstreamTestServer(t)
+
+	resp, err := s.HealthCheck(context.Background(), api.HealthCheckRequestObject{})
+	require.NoError(t, err)
+	healthy, ok := resp.(api.HealthCheck200JSONResponse)
+	require.True(t, ok)
+	assert.Equal(t, "ok", healthy.Status)
+	require.NotNil(t, healthy.Upstreams)
+	assert.Equal(t, 2, (*healthy.Upstreams)[0].HealthyTargets)
+
+	pool.Targets()[0].SetHealthy(false)
+	resp, err = s.HealthCheck(context.Background(), api.HealthCheckRequestObject{})
+	require.NoError(t, err)
+	degraded, ok := resp.(api.HealthCheck200JSONResponse)
+	require.True(t, ok)
+	assert.Equal(t, "degraded", degraded.Status)
+
+	pool.Targets()[1].SetHealthy(false)
+	resp, err = s.HealthCheck(context.Background(), api.HealthCheckRequestObject{})
+	require.NoError(t, err)
+	unavailable, ok := resp.(api.HealthCheck503JSONResponse)
+	require.True(t, ok, "a route without healthy targets should make /health answer 503")
+	assert.Equal(t, "unavailable", unavailable.Status)
+	assert.Equal(t, api.UpstreamPoolSummaryStatus(upstream.StatusUnhealthy), (*unavailable.Upstreams)[0].Status)
+}
+
+func TestGetCircuitBreakerStats(t *testing.T) {
+	pool, err := upstream.NewPool(config.RouteConfig{
+		Name:           "Orders API",
+		To:             "http://orders:8080",
+		CircuitBreaker: &config.CircuitBreakerConfig{ConsecutiveFailures: 1},
+	})
+	require.NoError(t, err)
+	registry := upstream.NewRegistry()
+	t.Cleanup(registry.Close)
+	registry.Add(pool)
+	// Routes without a breaker are not listed
+	plain, err := upstream.NewPool(config.RouteConfig{Name: "Plain", To: "http://plain:8080"})
+	require.NoError(t, err)
+	registry.Add(plain)
+
+	dependencies := deps.NewTest()
+	s := NewStrictApiServer(dependencies.SessionStore, dependencies.UserRepo, dependencies.TrafficMetricRepo, dependencies.TokenRepo, dependencies.CountersRepo, dependencies.TokenService, dependencies.StartTime, nil, registry, nil, nil, nil, nil)
+
+	resp, err := s.GetCircuitBreakerStats(context.Background(), api.GetCircuitBreakerStatsRequestObject{})
+	require.NoError(t, err)
+	assert.IsType(t, api.GetCircuitBreakerStats401JSONResponse{}, resp)
+
+	state, _, err := pool.Breaker().Allow()
+	require.NoError(t, err)
+	pool.Breaker().Record(state, false)
+
+	sess := &db.Session{Token: "x", IsAuthenticated: true, IsAdmin: true, ValidUntil: time.Now().Add(time.Hour)}
+	ctx := context.WithValue(context.Background(), session.SessionKey, sess)
+	resp, err = s.GetCircuitBreakerStats(ctx, api.GetCircuitBreakerStatsRequestObject{})
+	require.NoError(t, err)
+	stats, ok := resp.(api.GetCircuitBreakerStats200JSONResponse)
+	require.True(t, ok)
+	require.Len(t, stats, 1)
+	assert.Equal(t, "Orders API", stats[0].Route)
+	assert.Equal(t, api.CircuitBreakerStatState(upstream.CircuitOpen), stats[0].State)
+	assert.Equal(t, 1, stats[0].Transitions)
+	assert.NotNil(t, stats[0].OpenedAt)
+	assert.NotNil(t, stats[0].RetryAt)
+}
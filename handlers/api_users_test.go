@@ -31,6 +31,11 @@ func setupTestServer() *StrictApiServer {
 		dependencies.TokenService,
 		dependencies.StartTime,
 		nil, // no rate limiter for tests
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 }
 
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/jmaister/taronja-gateway/config"
@@ -116,18 +119,58 @@ func runGateway(configFilePath string) {
 	if err != nil {
 		log.Fatalf("FATAL: Failed to create gateway instance: %v", err)
 	}
+	gateway.ConfigPath = configFilePath
+
+	// Reload the configuration on SIGHUP and, if enabled, when the file changes
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			log.Printf("SIGHUP received, reloading configuration from %s", configFilePath)
+			gateway.ReloadConfig()
+		}
+	}()
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	if config.Management.Reload.Watch {
+		go gateway.WatchConfig(watchCtx, config.Management.Reload.WatchInterval())
+	}
 
-	log.Printf("API Gateway '%s' listening on %s", config.Name, gateway.Server.Addr)
+	// Drain the connections on SIGINT/SIGTERM; a second signal stops the process at once
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := <-stop
+		signal.Stop(stop)
+		signal.Stop(reload)
+		stopWatch()
+
+		shutdown := gateway.Config().Server.Shutdown
+		log.Printf("%s received, shutting down (drain timeout %s)", sig, shutdown.Drain())
+		ctx, cancel := context.WithTimeout(context.Background(), shutdown.Delay()+shutdown.Drain())
+		defer cancel()
+		if err := gateway.Shutdown(ctx); err != nil {
+			log.Printf("Requests were cut off on shutdown: %v", err)
+		}
+	}()
+
+	scheme := "http"
+	if config.Server.TLS != nil {
+		scheme = "https"
+	}
+	log.Printf("API Gateway '%s' listening on %s://%s", config.Name, scheme, gateway.Server.Addr)
 	log.Printf("Gateway public URL set to: %s", config.Server.URL)
 	log.Printf("Management API prefix: %s", config.Management.Prefix)
 
 	// Print OAuth callback URLs if configured
 	config.AuthenticationProviders.PrintOAuthCallbackURLs(config.Server.URL, config.Management.Prefix)
 
-	err = gateway.Server.ListenAndServe()
+	err = gateway.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("FATAL: Failed to start server: %v", err)
 	}
+	<-stopped
 
 	log.Println("API Gateway shut down gracefully.")
 }
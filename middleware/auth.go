@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/session"
 )
 
@@ -33,3 +34,16 @@ func (a *AuthMiddleware) AuthMiddlewareFunc(isStatic bool) Middleware {
 		})
 	}
 }
+
+// SessionFromRequest returns the session of the request, either the one an earlier
+// middleware put in the context or the one its cookie or bearer token points to.
+// It returns nil for anonymous requests.
+func (a *AuthMiddleware) SessionFromRequest(r *http.Request) *db.Session {
+	if s, ok := r.Context().Value(session.SessionKey).(*db.Session); ok && s != nil {
+		return s
+	}
+	if result := ValidateSessionFromRequest(r, a.SessionStore, a.TokenService); result.IsAuthenticated {
+		return result.Session
+	}
+	return nil
+}
@@ -3,15 +3,18 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/jmaister/taronja-gateway/cache"
 	"github.com/jmaister/taronja-gateway/config"
 )
 
 // HttpCacheMiddleware provides cache control middleware functionality
-type HttpCacheMiddleware struct{}
+type HttpCacheMiddleware struct {
+	responseCache *cache.Cache // shared response cache of the proxy routes, nil when not used
+}
 
 // NewHttpCacheMiddleware creates a new cache control middleware
-func NewHttpCacheMiddleware() *HttpCacheMiddleware {
-	return &HttpCacheMiddleware{}
+func NewHttpCacheMiddleware(responseCache *cache.Cache) *HttpCacheMiddleware {
+	return &HttpCacheMiddleware{responseCache: responseCache}
 }
 
 // CacheControlMiddlewareFunc creates a middleware function for cache control
@@ -26,3 +29,13 @@ func (c *HttpCacheMiddleware) CacheControlMiddlewareFunc(routeConfig config.Rout
 		})
 	}
 }
+
+// ResponseCacheEnabled reports whether responses of the route are stored in the shared cache
+func (c *HttpCacheMiddleware) ResponseCacheEnabled(routeConfig config.RouteConfig) bool {
+	return c.responseCache != nil && routeConfig.Cache != nil && !routeConfig.Static
+}
+
+// ResponseCacheMiddlewareFunc creates a middleware function that serves the route from the shared cache
+func (c *HttpCacheMiddleware) ResponseCacheMiddlewareFunc(routeConfig config.RouteConfig) Middleware {
+	return c.responseCache.Middleware(routeConfig)
+}
@@ -46,12 +46,30 @@ func BuildGlobalChain(
 	tokenService *auth.TokenService,
 	trafficMetricRepo db.TrafficMetricRepository,
 	rateLimiter *RateLimiter,
+	errorPages *ErrorPages,
 ) *ChainBuilder {
 	chain := NewChainBuilder()
 
-	// rate limiter should run first, even before analytics
+	// The client address is resolved once, for the rate limiter, analytics and upstreams
+	chain.Add(ClientIPMiddleware(session.NewClientIPResolver(gatewayConfig.Server.TrustedProxyPrefixes(), gatewayConfig.Server.GetForwardedHeader())))
+
+	// Error pages next, so they also cover the responses of the rate limiter
+	if errorPages != nil {
+		chain.Add(errorPages.GlobalMiddleware())
+	}
+
+	// rate limiter should run first, even before analytics; the path policies keyed by
+	// user or token look the session up
 	if rateLimiter != nil {
-		chain.Add(rateLimiter.Handler)
+		chain.Add(rateLimiter.Middleware(func(r *http.Request) *db.Session {
+			if sessionStore == nil {
+				return nil
+			}
+			if result := ValidateSessionFromRequest(r, sessionStore, tokenService); result.IsAuthenticated {
+				return result.Session
+			}
+			return nil
+		}))
 	} else if gatewayConfig.Management.RateLimiter.IsEnabled() {
 		chain.Add(RateLimiterMiddleware(gatewayConfig.Management.RateLimiter))
 	}
@@ -79,15 +97,21 @@ func BuildGlobalChain(
 
 // RouteChainBuilder builds middleware chains for individual routes
 type RouteChainBuilder struct {
-	authMiddleware  *AuthMiddleware
-	cacheMiddleware *HttpCacheMiddleware
+	authMiddleware        *AuthMiddleware
+	rateLimiter           *RateLimiter
+	cacheMiddleware       *HttpCacheMiddleware
+	compressionMiddleware *CompressionMiddleware
+	errorPages            *ErrorPages
 }
 
 // NewRouteChainBuilder creates a new route chain builder
-func NewRouteChainBuilder(authMiddleware *AuthMiddleware, cacheMiddleware *HttpCacheMiddleware) *RouteChainBuilder {
+func NewRouteChainBuilder(authMiddleware *AuthMiddleware, rateLimiter *RateLimiter, cacheMiddleware *HttpCacheMiddleware, compressionMiddleware *CompressionMiddleware, errorPages *ErrorPages) *RouteChainBuilder {
 	return &RouteChainBuilder{
-		authMiddleware:  authMiddleware,
-		cacheMiddleware: cacheMiddleware,
+		authMiddleware:        authMiddleware,
+		rateLimiter:           rateLimiter,
+		cacheMiddleware:       cacheMiddleware,
+		compressionMiddleware: compressionMiddleware,
+		errorPages:            errorPages,
 	}
 }
 
@@ -95,6 +119,21 @@ func NewRouteChainBuilder(authMiddleware *AuthMiddleware, cacheMiddleware *HttpC
 func (r *RouteChainBuilder) BuildRouteChain(handler http.HandlerFunc, routeConfig config.RouteConfig) http.HandlerFunc {
 	chain := NewChainBuilder()
 
+	// Error pages (if configured), outermost so they cover the timeout and authentication errors
+	if r.errorPages.Enabled(routeConfig) {
+		chain.Add(r.errorPages.ErrorPagesMiddlewareFunc(routeConfig))
+	}
+
+	// Request deadline (if the route has one), so it also bounds authentication
+	if timeout := routeConfig.Timeouts.Request(); timeout > 0 {
+		chain.Add(RequestTimeoutMiddleware(timeout))
+	}
+
+	// Client certificate check (if configured for this route), before the session
+	if routeConfig.ClientCert != nil {
+		chain.Add(ClientCertMiddleware(routeConfig))
+	}
+
 	// Authentication middleware (if enabled for this route)
 	if routeConfig.Authentication.Enabled {
 		// Redirect to login page for static routes and SPA proxy routes (browser-facing),
@@ -103,9 +142,35 @@ func (r *RouteChainBuilder) BuildRouteChain(handler http.HandlerFunc, routeConfi
 		chain.Add(r.authMiddleware.AuthMiddlewareFunc(shouldRedirect))
 	}
 
+	// Rate limit policies (if configured), after authentication so user keys see the session
+	if len(routeConfig.RateLimit) > 0 && r.rateLimiter != nil {
+		chain.Add(r.rateLimiter.PolicyMiddleware(routeConfig.RateLimit, r.authMiddleware.SessionFromRequest))
+	}
+
+	// Header changes (if configured), after authentication so templates see the session
+	if routeConfig.RequestHeaders != nil || routeConfig.ResponseHeaders != nil {
+		chain.Add(HeadersMiddleware(routeConfig, r.authMiddleware.SessionFromRequest))
+	}
+
+	// Response compression (if enabled), inside the header rules so they see the final
+	// headers, and outside the cache so it stores the uncompressed responses
+	if r.compressionMiddleware.Enabled(routeConfig) {
+		chain.Add(r.compressionMiddleware.CompressionMiddlewareFunc(routeConfig))
+	}
+
 	// Cache control middleware (always applied)
 	chain.Add(r.cacheMiddleware.CacheControlMiddlewareFunc(routeConfig))
 
+	// Shared response cache (if configured), inside the header rules so they also apply to stored responses
+	if r.cacheMiddleware.ResponseCacheEnabled(routeConfig) {
+		chain.Add(r.cacheMiddleware.ResponseCacheMiddlewareFunc(routeConfig))
+	}
+
+	// Request collapsing (if configured), inside the cache so only its misses are shared
+	if routeConfig.Collapse != nil && !routeConfig.Static {
+		chain.Add(CollapseMiddleware(routeConfig, r.authMiddleware.SessionFromRequest))
+	}
+
 	return chain.Build(handler).(http.HandlerFunc)
 }
 
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+)
+
+// Headers that carry the verified client certificate to the upstreams of routes with a
+// client certificate configuration. The ones sent by clients are always removed.
+const (
+	ClientCertSubjectHeader     = "X-Client-Cert-Subject"
+	ClientCertFingerprintHeader = "X-Client-Cert-Fingerprint"
+)
+
+// ClientCertProvider is the provider of the identities mapped from client certificates
+const ClientCertProvider = "clientCertificate"
+
+// VerifiedClientCertificate returns the client certificate of the request that the server
+// verified against the client CAs, or nil when there is none.
+func VerifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// CertificateFingerprint returns the hex SHA-256 of the DER encoded certificate
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientCertMiddleware rejects the requests without an allowed client certificate with 403
+// when the route requires one.
+func ClientCertMiddleware(routeConfig config.RouteConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert := VerifiedClientCertificate(r)
+			switch {
+			case cert == nil:
+				if routeConfig.ClientCert.Required {
+					http.Error(w, "Client certificate required", http.StatusForbidden)
+					return
+				}
+			case !routeConfig.ClientCert.AllowsSubject(cert.Subject.CommonName):
+				log.Printf("Route [%s]: client certificate '%s' not allowed", routeConfig.Name, cert.Subject.CommonName)
+				http.Error(w, "Client certificate not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientCertIdentity maps a verified client certificate to a gateway identity. The user
+// whose email is the first email address of the certificate, or whose username is its
+// common name, is the identity; otherwise the certificate itself is, with the user ID
+// "cert:<common name>". Certificate identities are never admins.
+func ClientCertIdentity(cert *x509.Certificate, userRepo db.UserRepository) *db.Session {
+	commonName := cert.Subject.CommonName
+	var email string
+	if len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	}
+	identity := &db.Session{
+		Token:           "cert:" + CertificateFingerprint(cert),
+		UserID:          "cert:" + commonName,
+		Username:        commonName,
+		Email:           email,
+		IsAuthenticated: true,
+		ValidUntil:      cert.NotAfter,
+		Provider:        ClientCertProvider,
+	}
+	if userRepo != nil && (commonName != "" || email != "") {
+		if user, err := userRepo.FindUserByIdOrUsername("", commonName, email); err == nil && user != nil {
+			identity.UserID = user.ID
+			identity.Username = user.Username
+			identity.Email = user.Email
+		}
+	}
+	return identity
+}
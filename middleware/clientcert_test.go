@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithClientCert(cert *x509.Certificate) *http.Request {
+	req := httptest.NewRequest("GET", "https://gateway.test/billing", nil)
+	req.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		req.TLS.PeerCertificates = []*x509.Certificate{cert}
+		req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return req
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	route := config.RouteConfig{Name: "Billing", ClientCert: &config.RouteClientCertConfig{Required: true, AllowedSubjects: []string{"billing"}}}
+	handler := ClientCertMiddleware(route)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	billing := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, Raw: []byte("billing")}
+	assert.Equal(t, http.StatusOK, serve(requestWithClientCert(billing)))
+	assert.Equal(t, http.StatusForbidden, serve(requestWithClientCert(&x509.Certificate{Subject: pkix.Name{CommonName: "reports"}})))
+	assert.Equal(t, http.StatusForbidden, serve(requestWithClientCert(nil)))
+	assert.Equal(t, http.StatusForbidden, serve(httptest.NewRequest("GET", "/billing", nil)), "plain HTTP has no certificate")
+
+	// Sent but not verified, as with a certificate of an unknown CA
+	unverified := requestWithClientCert(nil)
+	unverified.TLS.PeerCertificates = []*x509.Certificate{billing}
+	assert.Equal(t, http.StatusForbidden, serve(unverified))
+
+	route.ClientCert.Required = false
+	handler = ClientCertMiddleware(route)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(t, http.StatusOK, serve(requestWithClientCert(nil)), "optional certificates")
+}
+
+func TestClientCertIdentity(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour)
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing"},
+		EmailAddresses: []string{"billing@example.com"},
+		NotAfter:       notAfter,
+		Raw:            []byte("billing"),
+	}
+
+	identity := ClientCertIdentity(cert, nil)
+	assert.Equal(t, "cert:billing", identity.UserID)
+	assert.Equal(t, "billing", identity.Username)
+	assert.Equal(t, "billing@example.com", identity.Email)
+	assert.Equal(t, ClientCertProvider, identity.Provider)
+	assert.Equal(t, "cert:"+CertificateFingerprint(cert), identity.Token)
+	assert.True(t, identity.IsAuthenticated)
+	assert.False(t, identity.IsAdmin, "certificates never grant admin access")
+	assert.Equal(t, notAfter, identity.ValidUntil)
+	assert.Len(t, CertificateFingerprint(cert), 64)
+}
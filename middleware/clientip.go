@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// ClientIPMiddleware resolves the client address of every request behind the trusted
+// proxies, for session.GetClientIP. It runs before any middleware that uses the address.
+func ClientIPMiddleware(resolver *session.ClientIPResolver) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, resolver.WithClientIP(r))
+		})
+	}
+}
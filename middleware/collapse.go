@@ -0,0 +1,313 @@
+package middleware
+
+import (
+	"bufio"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/upstream"
+)
+
+// collapseKeyHeaders always take part in the collapse key, since they change the response
+// of the upstream: a waiting request must not get a 304 or a partial or compressed body it
+// did not ask for.
+var collapseKeyHeaders = []string{
+	"Accept-Encoding", "If-Match", "If-Modified-Since", "If-None-Match", "If-Range", "If-Unmodified-Since", "Range",
+}
+
+// CollapseMiddleware shares one upstream request between concurrent identical GET and HEAD
+// requests of a route. The first request is sent to the upstream; the ones that arrive
+// while it is in flight wait for its response and get a copy of it. A waiting request is
+// sent on its own when the response cannot be shared (too large, sets cookies, the
+// upstream failed, is private) or does not arrive within the maxWait of the route.
+// Requests of authenticated routes, and requests with credentials, only share responses
+// with requests of the same user or credentials.
+// lookupSession returns the session of a request, or nil for anonymous requests; it is
+// only called when the route collapses per user or requires authentication.
+func CollapseMiddleware(routeConfig config.RouteConfig, lookupSession func(*http.Request) *db.Session) Middleware {
+	c := &collapser{
+		headers:       slices.Clone(collapseKeyHeaders),
+		perUser:       routeConfig.Collapse.PerUser || routeConfig.Authentication.Enabled,
+		maxWait:       routeConfig.Collapse.MaxWait(),
+		maxBody:       routeConfig.Collapse.MaxBodyBytes(),
+		lookupSession: lookupSession,
+		calls:         make(map[string]*collapsedCall),
+	}
+	for _, name := range routeConfig.Collapse.Headers {
+		c.headers = append(c.headers, http.CanonicalHeaderKey(name))
+	}
+	slices.Sort(c.headers)
+	c.headers = slices.Compact(c.headers)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.serve(w, r, next)
+		})
+	}
+}
+
+type collapser struct {
+	headers       []string
+	perUser       bool
+	maxWait       time.Duration
+	maxBody       int64
+	lookupSession func(*http.Request) *db.Session
+
+	mu    sync.Mutex
+	calls map[string]*collapsedCall // in-flight upstream requests by key
+}
+
+// collapsedCall is an upstream request that other requests wait for. Its response fields
+// are set before done is closed and never change afterwards.
+type collapsedCall struct {
+	done    chan struct{}
+	release sync.Once
+
+	shared bool // the response can be copied to the waiting requests
+	status int
+	header http.Header
+	body   []byte
+	route  string
+	target string
+}
+
+func (c *collapser) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Upgrade") != "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	key := c.key(r)
+	c.mu.Lock()
+	call, inFlight := c.calls[key]
+	if !inFlight {
+		call = &collapsedCall{done: make(chan struct{})}
+		c.calls[key] = call
+	}
+	c.mu.Unlock()
+
+	if !inFlight {
+		c.lead(w, r, next, key, call)
+		return
+	}
+	if !c.wait(w, r, call) {
+		next.ServeHTTP(w, r)
+	}
+}
+
+// key identifies the requests that may share a response: same method and URL, same values
+// of the configured headers, same Authorization, Cookie and client certificate and, when
+// collapsing per user, same user. Upstreams may tell the clients apart by any of them.
+func (c *collapser) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.RequestURI())
+	for _, name := range c.headers {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	if auth := r.Header.Values("Authorization"); len(auth) > 0 {
+		b.WriteString("\x00Authorization:")
+		b.WriteString(strings.Join(auth, ","))
+	}
+	if cookies := r.Header.Values("Cookie"); len(cookies) > 0 {
+		b.WriteString("\x00Cookie:")
+		b.WriteString(strings.Join(cookies, "; "))
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		b.WriteString("\x00cert:")
+		b.WriteString(CertificateFingerprint(r.TLS.PeerCertificates[0]))
+	}
+	if c.perUser {
+		b.WriteString("\x00user:")
+		if c.lookupSession != nil {
+			if sess := c.lookupSession(r); sess != nil {
+				b.WriteString(sess.UserID)
+			}
+		}
+	}
+	return b.String()
+}
+
+// lead sends the request to the upstream and hands its response to the waiting requests.
+func (c *collapser) lead(w http.ResponseWriter, r *http.Request, next http.Handler, key string, call *collapsedCall) {
+	r, info := upstream.WithProxyInfo(r)
+	cw := &collapseWriter{ResponseWriter: w, header: make(http.Header), limit: c.maxBody}
+	cw.giveUp = func() { c.finish(key, call, info) }
+	// The waiters are released even when the handler panics, e.g. when the reverse proxy
+	// aborts a response that broke in the middle of the body
+	defer cw.giveUp()
+
+	next.ServeHTTP(cw, r)
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.shareable {
+		call.release.Do(func() {
+			call.shared = true
+			call.status = cw.status
+			call.header = cw.header
+			call.body = cw.body
+			c.close(key, call, info)
+		})
+	}
+}
+
+// finish releases the waiting requests without a response, so they are sent on their own.
+func (c *collapser) finish(key string, call *collapsedCall, info *upstream.ProxyInfo) {
+	call.release.Do(func() { c.close(key, call, info) })
+}
+
+func (c *collapser) close(key string, call *collapsedCall, info *upstream.ProxyInfo) {
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	call.route = info.Route
+	call.target = info.Target
+	close(call.done)
+}
+
+// wait copies the response of call to w and reports whether it did.
+func (c *collapser) wait(w http.ResponseWriter, r *http.Request, call *collapsedCall) bool {
+	timer := time.NewTimer(c.maxWait)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+	if !call.shared {
+		return false
+	}
+
+	if info := upstream.ProxyInfoFromContext(r.Context()); info != nil {
+		info.Route = call.route
+		info.Target = call.target
+		info.Coalesced = true
+	}
+	dst := w.Header()
+	for name, values := range call.header {
+		dst[name] = append(dst[name], values...)
+	}
+	w.WriteHeader(call.status)
+	if r.Method != http.MethodHead {
+		w.Write(call.body)
+	}
+	return true
+}
+
+// collapseWriter sends the upstream response to the client while keeping a copy of it
+// for the waiting requests. The handler writes the headers to a map of its own, so the
+// copy only has the headers of the upstream response.
+type collapseWriter struct {
+	http.ResponseWriter
+	header http.Header
+	limit  int64
+	giveUp func() // releases the waiting requests once the response cannot be shared
+
+	status    int
+	shareable bool
+	body      []byte
+}
+
+// Header returns the headers of the response; once they are sent it returns the headers
+// of the client response, where the handler adds the trailers.
+func (cw *collapseWriter) Header() http.Header {
+	if cw.status != 0 {
+		return cw.ResponseWriter.Header()
+	}
+	return cw.header
+}
+
+func (cw *collapseWriter) WriteHeader(code int) {
+	if cw.status != 0 {
+		return
+	}
+	dst := cw.ResponseWriter.Header()
+	if code < http.StatusOK {
+		// Informational responses go straight to the client, without the headers of the
+		// final response
+		saved := dst.Clone()
+		maps.Copy(dst, cw.header)
+		cw.ResponseWriter.WriteHeader(code)
+		clear(dst)
+		maps.Copy(dst, saved)
+		return
+	}
+
+	cw.status = code
+	cw.header = cw.header.Clone()
+	// Cookies and private responses belong to the client of the first request, and
+	// trailers are only known once the body is sent
+	cw.shareable = len(cw.header.Values("Set-Cookie")) == 0 && cw.header.Get("Trailer") == "" && !privateResponse(cw.header)
+	if !cw.shareable {
+		cw.giveUp()
+	}
+	for name, values := range cw.header {
+		dst[name] = append(dst[name], values...)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// privateResponse reports whether the Cache-Control of the response forbids sharing it,
+// with the private or no-store directives, like the shared cache does.
+func privateResponse(header http.Header) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(name, "private") || strings.EqualFold(name, "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (cw *collapseWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.shareable {
+		if int64(len(cw.body)+len(p)) > cw.limit {
+			cw.shareable = false
+			cw.body = nil
+			cw.giveUp()
+		} else {
+			cw.body = append(cw.body, p...)
+		}
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client, for streamed responses
+func (cw *collapseWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over for upgraded requests
+func (cw *collapseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (cw *collapseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,303 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/upstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingUpstream holds every response until release is closed and counts its calls.
+type blockingUpstream struct {
+	calls   atomic.Int32
+	release chan struct{}
+	respond func(w http.ResponseWriter, r *http.Request)
+}
+
+func newBlockingUpstream(respond func(w http.ResponseWriter, r *http.Request)) *blockingUpstream {
+	return &blockingUpstream{release: make(chan struct{}), respond: respond}
+}
+
+func (u *blockingUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.calls.Add(1)
+	if info := upstream.ProxyInfoFromContext(r.Context()); info != nil {
+		info.Route = "r"
+		info.Target = "http://backend"
+	}
+	<-u.release
+	u.respond(w, r)
+}
+
+// serveConcurrently sends the requests at the same time, lets the upstream answer once
+// they all reached the handler, and returns the responses and the ProxyInfo of each request.
+func serveConcurrently(t *testing.T, h http.Handler, u *blockingUpstream, reqs ...*http.Request) ([]*httptest.ResponseRecorder, []*upstream.ProxyInfo) {
+	t.Helper()
+	var arrived atomic.Int32
+	recs := make([]*httptest.ResponseRecorder, len(reqs))
+	infos := make([]*upstream.ProxyInfo, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		recs[i] = httptest.NewRecorder()
+		req, infos[i] = upstream.WithProxyInfo(req)
+		wg.Go(func() {
+			arrived.Add(1)
+			h.ServeHTTP(recs[i], req)
+		})
+	}
+	require.Eventually(t, func() bool { return arrived.Load() == int32(len(reqs)) }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(u.release)
+	wg.Wait()
+	return recs, infos
+}
+
+func collapseHandler(cc *config.CollapseConfig, lookupSession func(*http.Request) *db.Session, next http.Handler) http.Handler {
+	return CollapseMiddleware(config.RouteConfig{Name: "r", Collapse: cc}, lookupSession)(next)
+}
+
+func TestCollapseMiddleware_SharesResponse(t *testing.T) {
+	u := newBlockingUpstream(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"items":[]}`))
+	})
+	h := collapseHandler(&config.CollapseConfig{}, nil, u)
+
+	reqs := make([]*http.Request, 5)
+	for i := range reqs {
+		reqs[i] = httptest.NewRequest(http.MethodGet, "/items?page=1", nil)
+	}
+	recs, infos := serveConcurrently(t, h, u, reqs...)
+
+	assert.Equal(t, int32(1), u.calls.Load())
+	coalesced := 0
+	for i, rec := range recs {
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, `{"items":[]}`, rec.Body.String())
+		assert.Equal(t, []string{"application/json"}, rec.Header().Values("Content-Type"))
+		assert.Equal(t, "1", rec.Header().Get("X-Upstream"))
+		assert.Equal(t, "r", infos[i].Route)
+		if infos[i].Coalesced {
+			coalesced++
+		}
+	}
+	assert.Equal(t, 4, coalesced, "all requests but the one sent upstream are coalesced")
+}
+
+func TestCollapseMiddleware_Key(t *testing.T) {
+	tests := []struct {
+		name      string
+		cc        *config.CollapseConfig
+		auth      bool
+		session   func(*http.Request) *db.Session
+		second    func(r *http.Request)
+		wantCalls int32
+	}{
+		{
+			name:      "different query",
+			cc:        &config.CollapseConfig{},
+			second:    func(r *http.Request) { r.URL.RawQuery = "page=2"; r.RequestURI = "" },
+			wantCalls: 2,
+		},
+		{
+			name:      "configured header differs",
+			cc:        &config.CollapseConfig{Headers: []string{"accept-language"}},
+			second:    func(r *http.Request) { r.Header.Set("Accept-Language", "fr") },
+			wantCalls: 2,
+		},
+		{
+			name:      "other header differs",
+			cc:        &config.CollapseConfig{Headers: []string{"Accept-Language"}},
+			second:    func(r *http.Request) { r.Header.Set("X-Trace", "abc") },
+			wantCalls: 1,
+		},
+		{
+			name:      "conditional request",
+			cc:        &config.CollapseConfig{},
+			second:    func(r *http.Request) { r.Header.Set("If-None-Match", `"v1"`) },
+			wantCalls: 2,
+		},
+		{
+			name:      "accept-encoding differs",
+			cc:        &config.CollapseConfig{},
+			second:    func(r *http.Request) { r.Header.Set("Accept-Encoding", "gzip") },
+			wantCalls: 2,
+		},
+		{
+			name: "different users",
+			cc:   &config.CollapseConfig{PerUser: true},
+			session: func(r *http.Request) *db.Session {
+				return &db.Session{UserID: r.Header.Get("X-Test-User")}
+			},
+			second:    func(r *http.Request) { r.Header.Set("X-Test-User", "bob") },
+			wantCalls: 2,
+		},
+		{
+			name:      "anonymous requests shared without perUser",
+			cc:        &config.CollapseConfig{},
+			second:    func(r *http.Request) { r.Header.Set("X-Trace", "abc") },
+			wantCalls: 1,
+		},
+		{
+			name: "different users of an authenticated route",
+			cc:   &config.CollapseConfig{},
+			auth: true,
+			session: func(r *http.Request) *db.Session {
+				return &db.Session{UserID: r.Header.Get("X-Test-User")}
+			},
+			second:    func(r *http.Request) { r.Header.Set("X-Test-User", "bob") },
+			wantCalls: 2,
+		},
+		{
+			name: "same user of an authenticated route",
+			cc:   &config.CollapseConfig{},
+			auth: true,
+			session: func(r *http.Request) *db.Session {
+				return &db.Session{UserID: r.Header.Get("X-Test-User")}
+			},
+			second:    func(r *http.Request) {},
+			wantCalls: 1,
+		},
+		{
+			name:      "different credentials",
+			cc:        &config.CollapseConfig{},
+			second:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer bob") },
+			wantCalls: 2,
+		},
+		{
+			name:      "different cookies",
+			cc:        &config.CollapseConfig{},
+			second:    func(r *http.Request) { r.Header.Set("Cookie", "session=bob") },
+			wantCalls: 2,
+		},
+		{
+			name: "different client certificates",
+			cc:   &config.CollapseConfig{},
+			second: func(r *http.Request) {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("bob")}}}
+			},
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newBlockingUpstream(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+			route := config.RouteConfig{Name: "r", Collapse: tt.cc, Authentication: config.AuthenticationConfig{Enabled: tt.auth}}
+			h := CollapseMiddleware(route, tt.session)(u)
+			first := httptest.NewRequest(http.MethodGet, "/items?page=1", nil)
+			first.Header.Set("X-Test-User", "ana")
+			first.Header.Set("Authorization", "Bearer ana")
+			first.Header.Set("Cookie", "session=ana")
+			first.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("ana")}}}
+			second := httptest.NewRequest(http.MethodGet, "/items?page=1", nil)
+			second.Header.Set("X-Test-User", "ana")
+			second.Header.Set("Authorization", "Bearer ana")
+			second.Header.Set("Cookie", "session=ana")
+			second.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("ana")}}}
+			tt.second(second)
+			serveConcurrently(t, h, u, first, second)
+			assert.Equal(t, tt.wantCalls, u.calls.Load())
+		})
+	}
+}
+
+func TestCollapseMiddleware_FallsBack(t *testing.T) {
+	t.Run("unsafe methods", func(t *testing.T) {
+		u := newBlockingUpstream(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+		h := collapseHandler(&config.CollapseConfig{}, nil, u)
+		serveConcurrently(t, h, u, httptest.NewRequest(http.MethodPost, "/items", nil), httptest.NewRequest(http.MethodPost, "/items", nil))
+		assert.Equal(t, int32(2), u.calls.Load())
+	})
+
+	t.Run("responses with cookies", func(t *testing.T) {
+		u := newBlockingUpstream(func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "ana"})
+			w.Write([]byte("ok"))
+		})
+		h := collapseHandler(&config.CollapseConfig{}, nil, u)
+		_, infos := serveConcurrently(t, h, u, httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, int32(2), u.calls.Load())
+		assert.False(t, infos[0].Coalesced || infos[1].Coalesced)
+	})
+
+	t.Run("private responses", func(t *testing.T) {
+		for _, cacheControl := range []string{"private, max-age=60", "no-store", `max-age=0, Private="X-User"`} {
+			u := newBlockingUpstream(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", cacheControl)
+				w.Write([]byte("ok"))
+			})
+			h := collapseHandler(&config.CollapseConfig{}, nil, u)
+			_, infos := serveConcurrently(t, h, u, httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, int32(2), u.calls.Load(), cacheControl)
+			assert.False(t, infos[0].Coalesced || infos[1].Coalesced)
+		}
+	})
+
+	t.Run("large bodies", func(t *testing.T) {
+		u := newBlockingUpstream(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(strings.Repeat("x", 2048))) })
+		h := collapseHandler(&config.CollapseConfig{MaxBodyKB: 1}, nil, u)
+		recs, _ := serveConcurrently(t, h, u, httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, int32(2), u.calls.Load())
+		assert.Len(t, recs[0].Body.String(), 2048)
+		assert.Len(t, recs[1].Body.String(), 2048)
+	})
+
+	t.Run("aborted responses", func(t *testing.T) {
+		var aborted atomic.Bool
+		u := newBlockingUpstream(func(w http.ResponseWriter, r *http.Request) {
+			if aborted.CompareAndSwap(false, true) {
+				panic(http.ErrAbortHandler)
+			}
+			w.Write([]byte("ok"))
+		})
+		h := collapseHandler(&config.CollapseConfig{}, nil, u)
+		recovering := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() { recover() }()
+			h.ServeHTTP(w, r)
+		})
+		recs, _ := serveConcurrently(t, recovering, u, httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, int32(2), u.calls.Load())
+		assert.True(t, recs[0].Body.String() == "ok" || recs[1].Body.String() == "ok", "the waiting request is sent on its own")
+	})
+
+	t.Run("slow upstream", func(t *testing.T) {
+		var calls atomic.Int32
+		release := make(chan struct{})
+		slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				<-release
+			}
+			w.Write([]byte("ok"))
+		})
+		h := collapseHandler(&config.CollapseConfig{MaxWaitMs: 20}, nil, slow)
+
+		leader := make(chan struct{})
+		go func() {
+			defer close(leader)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, "ok", rec.Body.String())
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+		assert.Equal(t, int32(2), calls.Load(), "the waiting request gave up and was sent on its own")
+		close(release)
+		<-leader
+	})
+}
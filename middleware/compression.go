@@ -0,0 +1,338 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionMiddleware compresses the responses of the user routes
+type CompressionMiddleware struct {
+	global config.CompressionConfig
+}
+
+// NewCompressionMiddleware creates a new compression middleware from the global settings
+func NewCompressionMiddleware(global config.CompressionConfig) *CompressionMiddleware {
+	return &CompressionMiddleware{global: global}
+}
+
+// Settings returns the compression settings of a route, the route block over the global one
+func (c *CompressionMiddleware) Settings(routeConfig config.RouteConfig) config.CompressionConfig {
+	return c.global.ForRoute(routeConfig.Compression)
+}
+
+// Enabled reports whether the responses of the route are compressed
+func (c *CompressionMiddleware) Enabled(routeConfig config.RouteConfig) bool {
+	return c.Settings(routeConfig).Enabled
+}
+
+// CompressionMiddlewareFunc creates a middleware function that compresses the responses of
+// the route with the encoding negotiated from the Accept-Encoding header of the request.
+// Responses that are small, of another content type, already encoded or partial are sent
+// as they are.
+func (c *CompressionMiddleware) CompressionMiddlewareFunc(routeConfig config.RouteConfig) Middleware {
+	settings := c.Settings(routeConfig)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, settings: settings}
+			// HEAD responses have no body, they only get the Vary header of the GET response
+			if r.Method != http.MethodHead {
+				cw.encoding = NegotiateEncoding(r.Header.Get("Accept-Encoding"), settings.Algorithms)
+			}
+			next.ServeHTTP(cw, r)
+			cw.finish()
+		})
+	}
+}
+
+// NegotiateEncoding returns the offered encoding that the Accept-Encoding header prefers,
+// or "" when the response is sent as is. Encodings with the same weight are chosen in the
+// order they are offered.
+func NegotiateEncoding(acceptEncoding string, offered []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	wildcard := 0.0
+	for part := range strings.SplitSeq(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := acceptWeight(params)
+		switch name {
+		case "":
+		case "*":
+			wildcard = weight
+		case "x-gzip":
+			weights[config.CompressionGzip] = weight
+		default:
+			weights[name] = weight
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, encoding := range offered {
+		weight, listed := weights[encoding]
+		if !listed {
+			weight = wildcard
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	return best
+}
+
+// acceptWeight returns the q parameter of an element of an Accept header, 1 when it has none
+func acceptWeight(params string) float64 {
+	weight := 1.0
+	for param := range strings.SplitSeq(params, ";") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			weight = q
+		}
+	}
+	return weight
+}
+
+// encoder is the common interface of the gzip, brotli and zstd writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders keeps the writers of each encoding for reuse, since they allocate large tables
+var encoders = map[string]*sync.Pool{
+	config.CompressionGzip: {New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+	config.CompressionBrotli: {New: func() any {
+		// Level 4 is about as fast as gzip and still smaller; the higher levels are meant
+		// for precompressed files
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	config.CompressionZstd: {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+// compressWriter compresses the body of the response once it is known to be eligible. A
+// body of unknown length is held back until it reaches the minimum size, so small
+// responses are sent as they are; flushing starts the compression straight away.
+type compressWriter struct {
+	http.ResponseWriter
+	settings config.CompressionConfig
+	encoding string // negotiated encoding, "" when the client did not accept any
+
+	status  int     // status of the response, 0 until the handler sets it
+	sent    bool    // the headers were sent to the client
+	buf     []byte  // body held back until the minimum size is reached
+	holding bool    // the body is being held back
+	enc     encoder // compresses the body, nil when it is sent as is
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		// Informational responses go straight to the client
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+
+	if !cw.eligible() {
+		cw.sendHeader()
+		return
+	}
+	addVary(cw.Header(), "Accept-Encoding")
+	if cw.encoding == "" {
+		cw.sendHeader()
+		return
+	}
+	if length, err := strconv.ParseInt(cw.Header().Get("Content-Length"), 10, 64); err == nil {
+		if length < int64(cw.settings.MinSizeBytes) {
+			cw.sendHeader()
+		} else {
+			cw.startCompression()
+		}
+		return
+	}
+	cw.holding = true
+}
+
+// eligible reports whether the response may be compressed
+func (cw *compressWriter) eligible() bool {
+	switch cw.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	for _, directive := range h.Values("Cache-Control") {
+		if strings.Contains(strings.ToLower(directive), "no-transform") {
+			return false
+		}
+	}
+	return compressibleType(h.Get("Content-Type"), cw.settings.ContentTypes)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		// Sniff the content type like the server would, so it can be checked
+		if _, ok := cw.Header()["Content-Type"]; !ok && cw.Header().Get("Content-Encoding") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case cw.holding:
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.settings.MinSizeBytes {
+			if err := cw.compressBuffered(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case cw.enc != nil:
+		return cw.enc.Write(p)
+	default:
+		return cw.ResponseWriter.Write(p)
+	}
+}
+
+// Flush sends the data written so far to the client, compressed when the response is.
+// A response that is still held back is compressed from then on, since it is streamed.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.holding {
+		if err := cw.compressBuffered(); err != nil {
+			return
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over for upgraded requests
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish completes the response once the handler returns: a body that stayed below the
+// minimum size is sent as it is, and a compressed one is terminated.
+func (cw *compressWriter) finish() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case cw.holding:
+		cw.holding = false
+		cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buf)))
+		cw.sendHeader()
+		cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+	case cw.enc != nil:
+		cw.enc.Close()
+		cw.enc.Reset(io.Discard)
+		encoders[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+func (cw *compressWriter) sendHeader() {
+	if !cw.sent {
+		cw.sent = true
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+}
+
+// startCompression sends the headers of the compressed response and sets up the encoder
+func (cw *compressWriter) startCompression() {
+	h := cw.Header()
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", cw.encoding)
+	// The compressed body is not byte for byte the one the strong validator stands for
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.sendHeader()
+	cw.enc = encoders[cw.encoding].Get().(encoder)
+	cw.enc.Reset(cw.ResponseWriter)
+}
+
+// compressBuffered starts the compression of a held back body and writes it
+func (cw *compressWriter) compressBuffered() error {
+	cw.holding = false
+	cw.startCompression()
+	_, err := cw.enc.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// compressibleType reports whether the media type is in the allow-list. An entry ending
+// in /* allows all the subtypes of its type.
+func compressibleType(contentType string, allowed []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds a header name to the Vary header unless it is already listed
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for field := range strings.SplitSeq(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compressionHandler(rc *config.RouteCompressionConfig, next http.HandlerFunc) http.Handler {
+	cm := NewCompressionMiddleware(config.CompressionConfig{})
+	return cm.CompressionMiddlewareFunc(config.RouteConfig{Name: "r", Compression: rc})(next)
+}
+
+func compressionGet(t *testing.T, h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = gr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	default:
+		return string(body)
+	}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	offered := []string{"br", "zstd", "gzip"}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, br;q=0.5", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"ZSTD", "zstd"},
+		{"*", "br"},
+		{"*, br;q=0", "zstd"},
+		{"identity", ""},
+		{"deflate", ""},
+		{"gzip;q=0", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NegotiateEncoding(tt.accept, offered), "Accept-Encoding: %q", tt.accept)
+	}
+	assert.Equal(t, "gzip", NegotiateEncoding("br, gzip", []string{"gzip"}))
+}
+
+func TestCompressionMiddleware_Encodings(t *testing.T) {
+	body := strings.Repeat(`{"name":"taronja","kind":"gateway"},`, 100)
+	h := compressionHandler(&config.RouteCompressionConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Write([]byte(body))
+	})
+
+	for _, encoding := range []string{"gzip", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			rec := compressionGet(t, h, encoding)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, encoding, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Equal(t, `W/"v1"`, rec.Header().Get("ETag"), "strong validators are weakened")
+			assert.Empty(t, rec.Header().Get("Accept-Ranges"))
+			assert.Empty(t, rec.Header().Get("Content-Length"))
+			assert.Less(t, rec.Body.Len(), len(body))
+			assert.Equal(t, body, decompress(t, encoding, rec.Body.Bytes()))
+		})
+	}
+
+	t.Run("not accepted", func(t *testing.T) {
+		rec := compressionGet(t, h, "")
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		assert.Equal(t, `"v1"`, rec.Header().Get("ETag"))
+		assert.Equal(t, body, rec.Body.String())
+	})
+}
+
+func TestCompressionMiddleware_SkipsResponses(t *testing.T) {
+	large := strings.Repeat("taronja gateway ", 200)
+	tests := []struct {
+		name    string
+		rc      *config.RouteCompressionConfig
+		respond func(w http.ResponseWriter)
+		vary    bool
+	}{
+		{
+			name: "below minimum size",
+			rc:   &config.RouteCompressionConfig{},
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte("small"))
+			},
+			vary: true,
+		},
+		{
+			name: "known length below minimum size",
+			rc:   &config.RouteCompressionConfig{MinSizeBytes: 4096},
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+				w.Write([]byte(large))
+			},
+			vary: true,
+		},
+		{
+			name: "content type not allowed",
+			rc:   &config.RouteCompressionConfig{},
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte(large))
+			},
+		},
+		{
+			name: "content type not in the route list",
+			rc:   &config.RouteCompressionConfig{ContentTypes: []string{"application/json"}},
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte(large))
+			},
+		},
+		{
+			name: "already encoded",
+			rc:   &config.RouteCompressionConfig{},
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "deflate")
+				w.Write([]byte(large))
+			},
+		},
+		{
+			name: "no-transform",
+			rc:   &config.RouteCompressionConfig{},
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Cache-Control", "public, no-transform")
+				w.Write([]byte(large))
+			},
+		},
+		{
+			name: "partial content",
+			rc:   &config.RouteCompressionConfig{},
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Range", "bytes 0-3199/6400")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(large))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := compressionHandler(tt.rc, func(w http.ResponseWriter, r *http.Request) {
+				tt.respond(w)
+			})
+			rec := compressionGet(t, h, "gzip, br")
+			plain := httptest.NewRecorder()
+			tt.respond(plain)
+
+			assert.Equal(t, plain.Code, rec.Code)
+			assert.Equal(t, plain.Header().Get("Content-Encoding"), rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, plain.Body.String(), rec.Body.String())
+			if tt.vary {
+				assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			} else {
+				assert.Empty(t, rec.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCompressionMiddleware_SniffsContentType(t *testing.T) {
+	page := "<!DOCTYPE html><html><body>" + strings.Repeat("<p>taronja</p>", 100) + "</body></html>"
+	h := compressionHandler(&config.RouteCompressionConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	})
+	rec := compressionGet(t, h, "gzip")
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, page, decompress(t, "gzip", rec.Body.Bytes()))
+}
+
+func TestCompressionMiddleware_Flush(t *testing.T) {
+	events := make(chan string)
+	received := make(chan string)
+	h := compressionHandler(&config.RouteCompressionConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for event := range events {
+			w.Write([]byte(event))
+			w.(http.Flusher).Flush()
+		}
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	go func() {
+		events <- "data: one\n\n"
+	}()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	// Each flushed event can be read before the response ends
+	gr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := gr.Read(buf)
+			if n > 0 {
+				received <- string(buf[:n])
+			}
+			if err != nil {
+				close(received)
+				return
+			}
+		}
+	}()
+	assert.Equal(t, "data: one\n\n", <-received)
+	events <- "data: two\n\n"
+	assert.Equal(t, "data: two\n\n", <-received)
+	close(events)
+	for range received {
+	}
+}
+
+func TestCompressionMiddleware_Head(t *testing.T) {
+	body := strings.Repeat("taronja gateway ", 200)
+	h := compressionHandler(&config.RouteCompressionConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	})
+	req := httptest.NewRequest(http.MethodHead, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(len(body)), rec.Header().Get("Content-Length"))
+	assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+}
+
+func TestCompressionMiddleware_Enabled(t *testing.T) {
+	disabled := false
+	cm := NewCompressionMiddleware(config.CompressionConfig{})
+	assert.False(t, cm.Enabled(config.RouteConfig{}))
+	assert.True(t, cm.Enabled(config.RouteConfig{Compression: &config.RouteCompressionConfig{}}))
+
+	cm = NewCompressionMiddleware(config.CompressionConfig{Enabled: true})
+	assert.True(t, cm.Enabled(config.RouteConfig{}))
+	assert.False(t, cm.Enabled(config.RouteConfig{Compression: &config.RouteCompressionConfig{Enabled: &disabled}}))
+}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// ErrorPageData is the data available to the error page templates
+type ErrorPageData struct {
+	Status     int    // e.g. 404
+	StatusText string // e.g. Not Found
+	Method     string
+	Path       string
+	Route      string // name of the route, empty for errors outside the user routes
+	RequestID  string // X-Request-Id of the request, if any
+}
+
+// ErrorPages replaces the plain-text error responses sent to browsers with the HTML error
+// pages of the configuration. Responses with a body of another type, like the JSON errors
+// of an API or the HTML pages of an upstream, are sent as they are.
+type ErrorPages struct {
+	gatewayConfig *config.GatewayConfig
+	templates     map[string]*template.Template // parsed templates by file
+}
+
+// NewErrorPages parses the error page templates of the gateway and of all its routes
+func NewErrorPages(gatewayConfig *config.GatewayConfig) (*ErrorPages, error) {
+	e := &ErrorPages{gatewayConfig: gatewayConfig, templates: make(map[string]*template.Template)}
+	files := make([]string, 0, len(gatewayConfig.ErrorPages))
+	for _, file := range gatewayConfig.ErrorPages {
+		files = append(files, file)
+	}
+	for _, route := range gatewayConfig.Routes {
+		for _, file := range route.ErrorPages {
+			files = append(files, file)
+		}
+	}
+	for _, file := range files {
+		if _, ok := e.templates[file]; ok {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error page '%s': %w", file, err)
+		}
+		tmpl, err := template.New(filepath.Base(file)).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse error page '%s': %w", file, err)
+		}
+		e.templates[file] = tmpl
+	}
+	return e, nil
+}
+
+// Enabled reports whether the route has error pages, of its own or global
+func (e *ErrorPages) Enabled(routeConfig config.RouteConfig) bool {
+	return len(e.gatewayConfig.ErrorPages) > 0 || len(routeConfig.ErrorPages) > 0
+}
+
+// GlobalMiddleware renders the global error pages, for the errors sent before a route is
+// selected (rate limiting, unknown paths) and the management routes
+func (e *ErrorPages) GlobalMiddleware() Middleware {
+	return e.middleware(e.gatewayConfig.ErrorPages, "")
+}
+
+// ErrorPagesMiddlewareFunc renders the error pages of a route
+func (e *ErrorPages) ErrorPagesMiddlewareFunc(routeConfig config.RouteConfig) Middleware {
+	return e.middleware(e.gatewayConfig.ErrorPagesForRoute(&routeConfig), routeConfig.Name)
+}
+
+func (e *ErrorPages) middleware(pages map[string]string, route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(pages) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !acceptsHTML(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ew := &errorPageWriter{ResponseWriter: w, head: r.Method == http.MethodHead}
+			ew.render = func(status int) ([]byte, bool) {
+				return e.render(pages, status, r, route)
+			}
+			next.ServeHTTP(ew, r)
+		})
+	}
+}
+
+// render executes the template of the status, and reports whether there is one
+func (e *ErrorPages) render(pages map[string]string, status int, r *http.Request, route string) ([]byte, bool) {
+	file, ok := pages[strconv.Itoa(status)]
+	if !ok {
+		file, ok = pages[fmt.Sprintf("%dxx", status/100)]
+	}
+	tmpl := e.templates[file]
+	if !ok || tmpl == nil {
+		return nil, false
+	}
+
+	var page bytes.Buffer
+	err := tmpl.Execute(&page, ErrorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Route:      route,
+		RequestID:  r.Header.Get(RequestIDHeader),
+	})
+	if err != nil {
+		log.Printf("Error page '%s' failed for status %d: %v", file, status, err)
+		return nil, false
+	}
+	return page.Bytes(), true
+}
+
+// acceptsHTML reports whether the client asked for HTML, as browsers do
+func acceptsHTML(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for mediaRange := range strings.SplitSeq(value, ",") {
+			mediaType, params, _ := strings.Cut(mediaRange, ";")
+			if !strings.EqualFold(strings.TrimSpace(mediaType), "text/html") {
+				continue
+			}
+			return acceptWeight(params) > 0
+		}
+	}
+	return false
+}
+
+// errorPageWriter swaps the body of plain-text error responses for the error page. Other
+// responses are passed through untouched.
+type errorPageWriter struct {
+	http.ResponseWriter
+	render func(status int) ([]byte, bool)
+	head   bool // HEAD request, the page is not written
+
+	wroteHeader bool
+	replaced    bool // the body of the handler is dropped
+}
+
+func (ew *errorPageWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	if code < http.StatusOK {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	ew.wroteHeader = true
+
+	h := ew.Header()
+	if code >= http.StatusBadRequest && plainBody(h) {
+		if page, ok := ew.render(code); ok {
+			ew.replaced = true
+			for _, name := range []string{"Content-Encoding", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+				h.Del(name)
+			}
+			h.Set("Content-Type", "text/html; charset=utf-8")
+			h.Set("Content-Length", strconv.Itoa(len(page)))
+			ew.ResponseWriter.WriteHeader(code)
+			if !ew.head {
+				ew.ResponseWriter.Write(page)
+			}
+			return
+		}
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+// plainBody reports whether the response body is a plain-text message, like the errors of
+// http.Error, or has no declared type
+func plainBody(h http.Header) bool {
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/plain"
+}
+
+func (ew *errorPageWriter) Write(p []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.replaced {
+		return len(p), nil
+	}
+	return ew.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client, for streamed responses
+func (ew *errorPageWriter) Flush() {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over for upgraded requests
+func (ew *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(ew.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (ew *errorPageWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeErrorPage(t *testing.T, name, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o644))
+	return file
+}
+
+func TestErrorPages(t *testing.T) {
+	notFound := writeErrorPage(t, "404.html", "<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Path}} on {{.Route}}</p>")
+	serverError := writeErrorPage(t, "5xx.html", "<h1>Oops {{.Status}}</h1><p>{{.RequestID}}</p>")
+	routeNotFound := writeErrorPage(t, "route-404.html", "<h1>Not in the catalog</h1>")
+
+	gatewayConfig := &config.GatewayConfig{
+		ErrorPages: map[string]string{"404": notFound, "5xx": serverError},
+		Routes: []config.RouteConfig{
+			{Name: "Site"},
+			{Name: "Catalog", ErrorPages: map[string]string{"404": routeNotFound}},
+		},
+	}
+	pages, err := NewErrorPages(gatewayConfig)
+	require.NoError(t, err)
+	assert.True(t, pages.Enabled(gatewayConfig.Routes[0]))
+
+	serve := func(route config.RouteConfig, accept string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set(RequestIDHeader, "req-1")
+		rec := httptest.NewRecorder()
+		pages.ErrorPagesMiddlewareFunc(route)(handler).ServeHTTP(rec, req)
+		return rec
+	}
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	t.Run("plain-text errors", func(t *testing.T) {
+		rec := serve(gatewayConfig.Routes[0], browser, http.NotFound)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "<h1>404 Not Found</h1><p>/missing on Site</p>", rec.Body.String())
+	})
+
+	t.Run("status class", func(t *testing.T) {
+		rec := serve(gatewayConfig.Routes[0], browser, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		})
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Equal(t, "<h1>Oops 502</h1><p>req-1</p>", rec.Body.String())
+	})
+
+	t.Run("route pages over global ones", func(t *testing.T) {
+		rec := serve(gatewayConfig.Routes[1], browser, http.NotFound)
+		assert.Equal(t, "<h1>Not in the catalog</h1>", rec.Body.String())
+		rec = serve(gatewayConfig.Routes[1], browser, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		assert.Equal(t, "<h1>Oops 503</h1><p>req-1</p>", rec.Body.String())
+	})
+
+	t.Run("responses sent as they are", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			accept  string
+			handler http.HandlerFunc
+		}{
+			{"API clients", "application/json", http.NotFound},
+			{"HTML refused", "text/html;q=0, */*", http.NotFound},
+			{"no page for the status", browser, func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			}},
+			{"JSON errors", browser, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"not found"}`))
+			}},
+			{"HTML errors", browser, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("<h1>Upstream page</h1>"))
+			}},
+			{"successful responses", browser, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rec := serve(gatewayConfig.Routes[0], tt.accept, tt.handler)
+				plain := httptest.NewRecorder()
+				tt.handler(plain, httptest.NewRequest(http.MethodGet, "/missing", nil))
+				assert.Equal(t, plain.Code, rec.Code)
+				assert.Equal(t, plain.Body.String(), rec.Body.String())
+			})
+		}
+	})
+
+	t.Run("HEAD requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodHead, "/missing", nil)
+		req.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+		pages.GlobalMiddleware()(http.HandlerFunc(http.NotFound)).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Empty(t, rec.Body.String())
+	})
+}
+
+func TestNewErrorPages_InvalidTemplates(t *testing.T) {
+	_, err := NewErrorPages(&config.GatewayConfig{ErrorPages: map[string]string{"404": filepath.Join(t.TempDir(), "missing.html")}})
+	assert.Error(t, err)
+
+	broken := writeErrorPage(t, "broken.html", "<h1>{{.Status</h1>")
+	_, err = NewErrorPages(&config.GatewayConfig{Routes: []config.RouteConfig{{Name: "r", ErrorPages: map[string]string{"5xx": broken}}}})
+	assert.Error(t, err)
+
+	pages, err := NewErrorPages(&config.GatewayConfig{})
+	require.NoError(t, err)
+	assert.False(t, pages.Enabled(config.RouteConfig{Name: "r"}))
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/middleware/fingerprint"
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// RequestIDHeader is the header a client or an upstream proxy uses to pass a request ID.
+const RequestIDHeader = "X-Request-Id"
+
+// HeadersMiddleware applies the requestHeaders and responseHeaders blocks of a route.
+// lookupSession returns the session of a request, or nil for anonymous requests; it is
+// only called when a template uses a user value.
+func HeadersMiddleware(routeConfig config.RouteConfig, lookupSession func(*http.Request) *db.Session) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			values := &templateValues{r: r, route: routeConfig.Name, lookupSession: lookupSession}
+			if hc := routeConfig.RequestHeaders; hc != nil {
+				applyHeaders(r.Header, hc, values)
+			}
+			if hc := routeConfig.ResponseHeaders; hc != nil {
+				w = &headerWriter{ResponseWriter: w, apply: func(h http.Header) { applyHeaders(h, hc, values) }}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// applyHeaders removes, sets and adds headers, in that order.
+func applyHeaders(h http.Header, hc *config.HeadersConfig, values *templateValues) {
+	for _, name := range hc.Remove {
+		h.Del(name)
+	}
+	for name, tmpl := range hc.Set {
+		h.Set(name, values.expand(tmpl))
+	}
+	for name, tmpl := range hc.Add {
+		h.Add(name, values.expand(tmpl))
+	}
+}
+
+// templateValues resolves the values of header templates for one request. Values are
+// computed on first use, so the session lookup, the geolocation and the request ID only
+// cost something on routes that use them.
+type templateValues struct {
+	r             *http.Request
+	route         string
+	lookupSession func(*http.Request) *db.Session
+
+	session       *db.Session
+	sessionLoaded bool
+	requestID     string
+}
+
+func (v *templateValues) expand(tmpl string) string {
+	return config.TemplatePlaceholder.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		return v.value(placeholder[1 : len(placeholder)-1])
+	})
+}
+
+func (v *templateValues) value(name string) string {
+	switch name {
+	case "user.id", "user.email", "user.username", "user.admin":
+		s := v.userSession()
+		if s == nil {
+			return ""
+		}
+		switch name {
+		case "user.id":
+			return s.UserID
+		case "user.email":
+			return s.Email
+		case "user.username":
+			return s.Username
+		}
+		return strconv.FormatBool(s.IsAdmin)
+	case "client.ip":
+		return session.GetClientIP(v.r)
+	case "client.country":
+		geo, err := session.GetGeoDataFromIP(session.GetClientIP(v.r))
+		if err != nil {
+			return ""
+		}
+		return geo.CountryCode
+	case "client.ja4h":
+		if ja4h := fingerprint.GetJA4FromRequest(v.r); ja4h != "" {
+			return ja4h
+		}
+		return getJA4HCache().GetOrCalculate(v.r)
+	case "route":
+		return v.route
+	case "request.id":
+		if v.requestID == "" {
+			v.requestID = v.r.Header.Get(RequestIDHeader)
+		}
+		if v.requestID == "" {
+			v.requestID = newRequestID()
+		}
+		return v.requestID
+	}
+	return ""
+}
+
+func (v *templateValues) userSession() *db.Session {
+	if !v.sessionLoaded {
+		v.sessionLoaded = true
+		if v.lookupSession != nil {
+			v.session = v.lookupSession(v.r)
+		}
+	}
+	return v.session
+}
+
+// newRequestID returns a random 128-bit ID in hex.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// headerWriter applies the response header changes right before the headers are sent.
+type headerWriter struct {
+	http.ResponseWriter
+	apply   func(http.Header)
+	applied bool
+}
+
+func (hw *headerWriter) applyOnce() {
+	if !hw.applied {
+		hw.applied = true
+		hw.apply(hw.Header())
+	}
+}
+
+// WriteHeader applies the header changes to final responses; informational responses
+// (1xx) are sent unchanged.
+func (hw *headerWriter) WriteHeader(code int) {
+	if code >= http.StatusOK {
+		hw.applyOnce()
+	}
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerWriter) Write(p []byte) (int, error) {
+	hw.applyOnce()
+	return hw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client, for streamed responses
+func (hw *headerWriter) Flush() {
+	hw.applyOnce()
+	http.NewResponseController(hw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over for upgraded requests
+func (hw *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(hw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (hw *headerWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeadersMiddleware_RequestHeaders(t *testing.T) {
+	route := config.RouteConfig{
+		Name: "Orders",
+		RequestHeaders: &config.HeadersConfig{
+			Set:    map[string]string{"X-Route": "{route}", "X-User": "{user.id} admin={user.admin}"},
+			Add:    map[string]string{"X-Tag": "gateway"},
+			Remove: []string{"X-Debug"},
+		},
+	}
+	lookup := func(r *http.Request) *db.Session {
+		return &db.Session{UserID: "user-1", IsAdmin: true}
+	}
+
+	var received http.Header
+	handler := HeadersMiddleware(route, lookup)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Tag", "client")
+	req.Header.Set("X-Route", "spoofed")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "Orders", received.Get("X-Route"))
+	assert.Equal(t, "user-1 admin=true", received.Get("X-User"))
+	assert.Equal(t, []string{"client", "gateway"}, received.Values("X-Tag"))
+	assert.Empty(t, received.Get("X-Debug"))
+}
+
+func TestHeadersMiddleware_AnonymousUserValuesAreEmpty(t *testing.T) {
+	route := config.RouteConfig{RequestHeaders: &config.HeadersConfig{Set: map[string]string{"X-User": "{user.email}"}}}
+	handler := HeadersMiddleware(route, func(*http.Request) *db.Session { return nil })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, []string{""}, r.Header.Values("X-User"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestHeadersMiddleware_ResponseHeaders(t *testing.T) {
+	route := config.RouteConfig{
+		Name: "Static",
+		ResponseHeaders: &config.HeadersConfig{
+			Set:    map[string]string{"X-Frame-Options": "DENY", "X-Served-By": "{route}"},
+			Remove: []string{"Server"},
+		},
+	}
+	handler := HeadersMiddleware(route, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Write([]byte("ok"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"), "the route overrides the handler")
+	assert.Equal(t, "Static", rec.Header().Get("X-Served-By"))
+	assert.Empty(t, rec.Header().Get("Server"))
+}
+
+func TestHeadersMiddleware_RequestID(t *testing.T) {
+	route := config.RouteConfig{
+		RequestHeaders:  &config.HeadersConfig{Set: map[string]string{RequestIDHeader: "{request.id}"}},
+		ResponseHeaders: &config.HeadersConfig{Set: map[string]string{RequestIDHeader: "{request.id}"}},
+	}
+	var forwarded string
+	handler := HeadersMiddleware(route, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestIDHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Len(t, forwarded, 32, "a request ID is generated")
+	assert.Equal(t, forwarded, rec.Header().Get(RequestIDHeader), "the same ID is used for the request and the response")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "abc-123", forwarded, "the client request ID is kept")
+	assert.Equal(t, "abc-123", rec.Header().Get(RequestIDHeader))
+}
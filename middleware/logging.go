@@ -1,9 +1,15 @@
 package middleware
 
 import (
+	"bufio"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
+
+	"github.com/jmaister/taronja-gateway/session"
+	"github.com/jmaister/taronja-gateway/upstream"
 )
 
 // LoggingMiddleware logs information about each request
@@ -14,6 +20,9 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		// Create a response wrapper to capture the status code
 		rw := NewResponseWriter(w)
 
+		// Let the proxy handler report the upstream target it used
+		r, proxyInfo := upstream.WithProxyInfo(r)
+
 		// Call the next handler
 		next.ServeHTTP(rw, r)
 
@@ -24,13 +33,26 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		timestamp := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
 		responseTimeMs := float64(duration.Nanoseconds()) / 1000000.0
 
-		log.Printf("%s - %s \"%s %s\" %d %.2fms",
+		// Proxied requests also log the upstream target: ... -> http://backend:8080
+		upstreamSuffix := ""
+		if proxyInfo.Target != "" {
+			upstreamSuffix = " -> " + proxyInfo.Target
+		}
+		if proxyInfo.Attempts > 1 {
+			upstreamSuffix += fmt.Sprintf(" (%d attempts)", proxyInfo.Attempts)
+		}
+		if proxyInfo.Coalesced {
+			upstreamSuffix += " (coalesced)"
+		}
+
+		log.Printf("%s - %s \"%s %s\" %d %.2fms%s",
 			timestamp,
-			r.RemoteAddr,
+			session.GetClientIP(r),
 			r.Method,
 			r.URL.Path,
 			rw.Status(),
 			responseTimeMs,
+			upstreamSuffix,
 		)
 	})
 }
@@ -56,3 +78,22 @@ func (rw *responseWriter) WriteHeader(code int) {
 func (rw *responseWriter) Status() int {
 	return rw.statusCode
 }
+
+// Flush sends buffered data to the client, for streamed responses
+func (rw *responseWriter) Flush() {
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection for protocol upgrades such as WebSocket
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,388 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// ErrNoBlockList is returned by the block list methods of a limiter without a repository
+var ErrNoBlockList = errors.New("the rate limiter does not store its blocks")
+
+// ErrInvalidNetwork is returned when a block or allowlist entry is not an IP address or CIDR network
+var ErrInvalidNetwork = errors.New("invalid IP address or CIDR network")
+
+// ErrAlreadyAllowed is returned when a network is already in the allowlist
+var ErrAlreadyAllowed = errors.New("the network is already in the allowlist")
+
+// blockedNetwork is an IP address or network blocked by an administrator
+type blockedNetwork struct {
+	id     string
+	prefix netip.Prefix
+	until  time.Time // zero for a permanent block
+}
+
+// allowedNetwork is an IP address or network of the allowlist
+type allowedNetwork struct {
+	id     string
+	prefix netip.Prefix
+}
+
+// networkLists holds the allowlist and the manual blocks. It is never modified: changes
+// store a new copy, so requests read it without locking.
+type networkLists struct {
+	allowed []allowedNetwork
+	blocked []blockedNetwork
+}
+
+// allows reports whether the address is in the allowlist
+func (l *networkLists) allows(addr netip.Addr) bool {
+	for _, network := range l.allowed {
+		if network.prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocks reports whether a manual block applies to the address at now, and until when;
+// zero for a permanent block
+func (l *networkLists) blocks(addr netip.Addr, now time.Time) (time.Time, bool) {
+	var until time.Time
+	blocked := false
+	for _, network := range l.blocked {
+		if !network.prefix.Contains(addr) || (!network.until.IsZero() && !now.Before(network.until)) {
+			continue
+		}
+		if network.until.IsZero() {
+			return time.Time{}, true
+		}
+		if !blocked || network.until.After(until) {
+			until = network.until
+		}
+		blocked = true
+	}
+	return until, blocked
+}
+
+// UseBlockList stores the blocks of the limiter in the repository, and restores the blocks
+// and the allowlist stored there: the clients that were blocked stay blocked across restarts.
+// With a shared store, the allowlist and manual blocks of the other instances are read again
+// on every sync. Call it before the limiter serves requests, after UseSharedStore.
+func (rl *RateLimiter) UseBlockList(repo db.BlockListRepository) error {
+	now := time.Now()
+	lists, events, err := loadNetworks(repo, now)
+	if err != nil {
+		return err
+	}
+
+	rl.listMu.Lock()
+	defer rl.listMu.Unlock()
+	for _, event := range events {
+		if event.EndsAt.After(rl.store.blockedUntil(event.Network, now)) {
+			rl.store.block(event.Network, now, event.EndsAt.Sub(now))
+		}
+	}
+	rl.networks.Store(lists)
+	rl.blockList = repo
+	if store, ok := rl.store.(*databaseStore); ok {
+		store.afterSync(func(now time.Time) error {
+			return rl.reloadNetworks(repo, now)
+		})
+	}
+	return nil
+}
+
+// loadNetworks reads the allowlist and the manual blocks of the repository, and returns the
+// automatic blocks that are active, which are the blocks of an IP
+func loadNetworks(repo db.BlockListRepository, now time.Time) (*networkLists, []db.BlockEvent, error) {
+	events, _, err := repo.ListBlockEvents(db.BlockEventFilter{ActiveAt: &now}, 0, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load the active blocks: %w", err)
+	}
+	allowlist, err := repo.ListAllowlistEntries()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load the allowlist: %w", err)
+	}
+
+	lists := &networkLists{}
+	for _, entry := range allowlist {
+		prefix, err := parseNetwork(entry.Network)
+		if err != nil {
+			log.Printf("Rate limiter: skipping the allowlist entry %s: %v", entry.ID, err)
+			continue
+		}
+		lists.allowed = append(lists.allowed, allowedNetwork{id: entry.ID, prefix: prefix})
+	}
+	var automatic []db.BlockEvent
+	for _, event := range events {
+		// The automatic blocks are the ones of an IP; the manual ones may be networks
+		if event.Reason != db.BlockReasonManual && event.EndsAt != nil {
+			automatic = append(automatic, event)
+			continue
+		}
+		prefix, err := parseNetwork(event.Network)
+		if err != nil {
+			log.Printf("Rate limiter: skipping the block %s: %v", event.ID, err)
+			continue
+		}
+		lists.blocked = append(lists.blocked, blockedNetwork{id: event.ID, prefix: prefix, until: endOf(event)})
+	}
+	return lists, automatic, nil
+}
+
+// reloadNetworks reads the allowlist and the manual blocks again, with the changes of the
+// other instances. A change made here while reading is newer than what was read, so the
+// lists read are dropped and the next reload picks it up.
+func (rl *RateLimiter) reloadNetworks(repo db.BlockListRepository, now time.Time) error {
+	rl.listMu.Lock()
+	version := rl.listVersion
+	rl.listMu.Unlock()
+
+	lists, _, err := loadNetworks(repo, now)
+	if err != nil {
+		return err
+	}
+	rl.listMu.Lock()
+	defer rl.listMu.Unlock()
+	if rl.listVersion == version {
+		rl.networks.Store(lists)
+	}
+	return nil
+}
+
+// BlockNetwork blocks an IP address or CIDR network for the duration, or for good when the
+// duration is 0. The block is stored and applies at once, even without limits configured.
+func (rl *RateLimiter) BlockNetwork(network string, duration time.Duration, note, createdBy string) (*db.BlockEvent, error) {
+	if rl.blockList == nil {
+		return nil, ErrNoBlockList
+	}
+	prefix, err := parseNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	event := &db.BlockEvent{
+		Network:    formatNetwork(prefix),
+		Reason:     db.BlockReasonManual,
+		Note:       note,
+		CreatedBy:  createdBy,
+		StartedAt:  now,
+		ClientInfo: db.ClientInfo{IPAddress: formatNetwork(prefix)},
+	}
+	if duration > 0 {
+		until := now.Add(duration)
+		event.EndsAt = &until
+	}
+	if err := rl.blockList.CreateBlockEvent(event); err != nil {
+		return nil, err
+	}
+
+	rl.updateNetworks(func(lists *networkLists) {
+		lists.blocked = append(lists.blocked, blockedNetwork{id: event.ID, prefix: prefix, until: endOf(*event)})
+	})
+	return event, nil
+}
+
+// Unblock ends a block early, manual or automatic, and returns it. An automatically blocked
+// IP also starts counting its requests and errors again.
+func (rl *RateLimiter) Unblock(id, unblockedBy string) (*db.BlockEvent, error) {
+	if rl.blockList == nil {
+		return nil, ErrNoBlockList
+	}
+	if err := rl.blockList.EndBlockEvent(id, time.Now(), unblockedBy); err != nil {
+		return nil, err
+	}
+	event, err := rl.blockList.GetBlockEvent(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if event.Reason == db.BlockReasonManual {
+		rl.updateNetworks(func(lists *networkLists) {
+			lists.blocked = slices.DeleteFunc(lists.blocked, func(network blockedNetwork) bool {
+				return network.id == id
+			})
+		})
+	} else {
+		rl.store.reset(event.Network)
+	}
+	return event, nil
+}
+
+// BlockEvents returns a page of the stored blocks, newest first, and their total count
+func (rl *RateLimiter) BlockEvents(filter db.BlockEventFilter, limit, offset int) ([]db.BlockEvent, int64, error) {
+	if rl.blockList == nil {
+		return nil, 0, ErrNoBlockList
+	}
+	// the networks are stored in their canonical form
+	if prefix, err := parseNetwork(filter.Network); err == nil {
+		filter.Network = formatNetwork(prefix)
+	}
+	return rl.blockList.ListBlockEvents(filter, limit, offset)
+}
+
+// Allowlist returns the IP addresses and networks that are never limited
+func (rl *RateLimiter) Allowlist() ([]db.AllowlistEntry, error) {
+	if rl.blockList == nil {
+		return nil, ErrNoBlockList
+	}
+	return rl.blockList.ListAllowlistEntries()
+}
+
+// AllowNetwork adds an IP address or CIDR network to the allowlist. Its requests bypass every
+// check of the limiter, the manual blocks included, until it is removed.
+func (rl *RateLimiter) AllowNetwork(network, note, createdBy string) (*db.AllowlistEntry, error) {
+	if rl.blockList == nil {
+		return nil, ErrNoBlockList
+	}
+	prefix, err := parseNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	if current := rl.networks.Load(); current != nil && slices.ContainsFunc(current.allowed, func(network allowedNetwork) bool {
+		return network.prefix == prefix
+	}) {
+		return nil, ErrAlreadyAllowed
+	}
+	entry := &db.AllowlistEntry{Network: formatNetwork(prefix), Note: note, CreatedBy: createdBy}
+	if err := rl.blockList.CreateAllowlistEntry(entry); err != nil {
+		return nil, err
+	}
+
+	rl.updateNetworks(func(lists *networkLists) {
+		lists.allowed = append(lists.allowed, allowedNetwork{id: entry.ID, prefix: prefix})
+	})
+	return entry, nil
+}
+
+// RemoveAllowed removes an entry of the allowlist
+func (rl *RateLimiter) RemoveAllowed(id string) error {
+	if rl.blockList == nil {
+		return ErrNoBlockList
+	}
+	if err := rl.blockList.DeleteAllowlistEntry(id); err != nil {
+		return err
+	}
+
+	rl.updateNetworks(func(lists *networkLists) {
+		lists.allowed = slices.DeleteFunc(lists.allowed, func(network allowedNetwork) bool {
+			return network.id == id
+		})
+	})
+	return nil
+}
+
+// updateNetworks stores a changed copy of the allowlist and manual blocks
+func (rl *RateLimiter) updateNetworks(change func(lists *networkLists)) {
+	rl.listMu.Lock()
+	defer rl.listMu.Unlock()
+	lists := &networkLists{}
+	if current := rl.networks.Load(); current != nil {
+		lists.allowed = slices.Clone(current.allowed)
+		lists.blocked = slices.Clone(current.blocked)
+	}
+	change(lists)
+	rl.networks.Store(lists)
+	rl.listVersion++
+}
+
+// pruneBlocks removes the manual blocks that ended
+func (rl *RateLimiter) pruneBlocks(now time.Time) {
+	current := rl.networks.Load()
+	if current == nil || !slices.ContainsFunc(current.blocked, func(network blockedNetwork) bool {
+		return !network.until.IsZero() && !now.Before(network.until)
+	}) {
+		return
+	}
+	rl.updateNetworks(func(lists *networkLists) {
+		lists.blocked = slices.DeleteFunc(lists.blocked, func(network blockedNetwork) bool {
+			return !network.until.IsZero() && !now.Before(network.until)
+		})
+	})
+}
+
+// checkNetworks reports whether the client is in the allowlist, and whether a manual block
+// applies to it, until when
+func (rl *RateLimiter) checkNetworks(ip string, now time.Time) (allowed bool, until time.Time, blocked bool) {
+	lists := rl.networks.Load()
+	if lists == nil {
+		return false, time.Time{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, time.Time{}, false
+	}
+	if lists.allows(addr) {
+		return true, time.Time{}, false
+	}
+	until, blocked = lists.blocks(addr, now)
+	return false, until, blocked
+}
+
+// recordBlock stores the automatic block of the client of the request. The geolocation of
+// the client may take a while, so it is stored in the background; Close waits for it.
+func (rl *RateLimiter) recordBlock(r *http.Request, ip, reason string, startedAt, until time.Time) {
+	if rl.blockList == nil {
+		return
+	}
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	rl.pending.Add(1)
+	go func() {
+		defer rl.pending.Done()
+		event := &db.BlockEvent{
+			Network:    ip,
+			Reason:     reason,
+			Path:       req.URL.Path,
+			StartedAt:  startedAt,
+			EndsAt:     &until,
+			ClientInfo: *session.NewClientInfo(req),
+		}
+		if err := rl.blockList.CreateBlockEvent(event); err != nil {
+			log.Printf("Rate limiter: failed to store the block of %s: %v", ip, err)
+		}
+	}()
+}
+
+// parseNetwork parses an IP address, as the network of that address alone, or a CIDR network
+func parseNetwork(network string) (netip.Prefix, error) {
+	network = strings.TrimSpace(network)
+	if strings.Contains(network, "/") {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%w: %q", ErrInvalidNetwork, network)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(network)
+	if err != nil || addr.Zone() != "" {
+		return netip.Prefix{}, fmt.Errorf("%w: %q", ErrInvalidNetwork, network)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// formatNetwork returns the address of a single IP network, and the CIDR notation of the others
+func formatNetwork(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// endOf returns the end of a block, zero when it is permanent
+func endOf(event db.BlockEvent) time.Time {
+	if event.EndsAt == nil {
+		return time.Time{}
+	}
+	return *event.EndsAt
+}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newBlockListRepository(t *testing.T) db.BlockListRepository {
+	t.Helper()
+	db.SetupTestDB(fmt.Sprintf("%s_%d", t.Name(), time.Now().UnixNano()))
+	return db.NewBlockListRepositoryDB(db.GetConnection())
+}
+
+// serveFrom sends a request of the client address through the handler
+func serveFrom(handler http.Handler, remoteAddr, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("User-Agent", "scanner/1.0")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		network  string
+		expected string
+	}{
+		{"203.0.113.7", "203.0.113.7/32"},
+		{" 2001:db8::1 ", "2001:db8::1/128"},
+		{"::ffff:203.0.113.7", "203.0.113.7/32"},
+		{"198.51.100.77/24", "198.51.100.0/24"},
+		{"2001:db8::/32", "2001:db8::/32"},
+	}
+	for _, tt := range tests {
+		prefix, err := parseNetwork(tt.network)
+		require.NoError(t, err, tt.network)
+		assert.Equal(t, tt.expected, prefix.String(), tt.network)
+	}
+	for _, network := range []string{"", "example.com", "203.0.113.300", "203.0.113.0/33", "fe80::1%eth0"} {
+		_, err := parseNetwork(network)
+		assert.ErrorIs(t, err, ErrInvalidNetwork, network)
+	}
+	assert.Equal(t, "203.0.113.7", formatNetwork(netip.MustParsePrefix("203.0.113.7/32")))
+	assert.Equal(t, "198.51.100.0/24", formatNetwork(netip.MustParsePrefix("198.51.100.0/24")))
+}
+
+func TestRateLimiterRecordsBlocks(t *testing.T) {
+	repo := newBlockListRepository(t)
+	rl := NewRateLimiter(config.RateLimiterConfig{
+		RequestsPerMinute: 2,
+		BlockMinutes:      5,
+		VulnerabilityScan: config.VulnerabilityScanConfig{URLs: []string{"/.env"}, Max404: 1, BlockMinutes: 30},
+	})
+	require.NoError(t, rl.UseBlockList(repo))
+	handler := rl.Handler(http.NotFoundHandler())
+
+	assert.Equal(t, http.StatusNotFound, serveFrom(handler, "127.0.0.2:1234", "/orders").Code)
+	assert.Equal(t, http.StatusNotFound, serveFrom(handler, "127.0.0.2:1234", "/orders").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(handler, "127.0.0.2:1234", "/orders").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(handler, "127.0.0.2:1234", "/orders").Code, "blocked")
+	rl.Close()
+
+	events, total, err := rl.BlockEvents(db.BlockEventFilter{}, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total, "a block is recorded once")
+	event := events[0]
+	assert.Equal(t, "127.0.0.2", event.Network)
+	assert.Equal(t, db.BlockReasonRate, event.Reason)
+	assert.Equal(t, "/orders", event.Path)
+	assert.Equal(t, "scanner/1.0", event.UserAgent)
+	assert.Equal(t, "127.0.0.2", event.IPAddress)
+	require.NotNil(t, event.EndsAt)
+	assert.WithinDuration(t, event.StartedAt.Add(5*time.Minute), *event.EndsAt, time.Second)
+
+	// A new limiter, as after a restart, keeps the IP blocked
+	restarted := NewRateLimiter(rl.Config())
+	defer restarted.Close()
+	require.NoError(t, restarted.UseBlockList(repo))
+	handler = restarted.Handler(http.NotFoundHandler())
+	w := serveFrom(handler, "127.0.0.2:1234", "/orders")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Unblocking starts the IP over
+	unblocked, err := restarted.Unblock(event.ID, "admin")
+	require.NoError(t, err)
+	assert.NotNil(t, unblocked.UnblockedAt)
+	assert.Equal(t, http.StatusNotFound, serveFrom(handler, "127.0.0.2:1234", "/orders").Code)
+	_, err = restarted.Unblock(event.ID, "admin")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Scans are recorded with their reason
+	assert.Equal(t, http.StatusNotFound, serveFrom(handler, "127.0.0.3:1234", "/.env").Code)
+	assert.Equal(t, http.StatusNotFound, serveFrom(handler, "127.0.0.4:1234", "/.env").Code)
+	assert.Equal(t, http.StatusNotFound, serveFrom(handler, "127.0.0.3:1234", "/.env?v=2").Code)
+	restarted.Close()
+	events, _, err = restarted.BlockEvents(db.BlockEventFilter{Reason: db.BlockReasonScan}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "127.0.0.3", events[0].Network)
+	assert.Equal(t, "/.env", events[0].Path)
+}
+
+func TestRateLimiterManualBlocks(t *testing.T) {
+	// No limits: the manual blocks and the allowlist apply anyway
+	rl := NewRateLimiter(config.RateLimiterConfig{})
+	defer rl.Close()
+	_, err := rl.BlockNetwork("203.0.113.0/24", time.Hour, "", "")
+	assert.ErrorIs(t, err, ErrNoBlockList)
+
+	repo := newBlockListRepository(t)
+	require.NoError(t, rl.UseBlockList(repo))
+	handler := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	_, err = rl.BlockNetwork("203.0.113.0/33", time.Hour, "", "admin")
+	assert.ErrorIs(t, err, ErrInvalidNetwork)
+
+	network, err := rl.BlockNetwork("203.0.113.77/24", time.Hour, "credential stuffing", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.0/24", network.Network)
+	assert.Equal(t, db.BlockReasonManual, network.Reason)
+	assert.Equal(t, "admin", network.CreatedBy)
+	permanent, err := rl.BlockNetwork("2001:db8::1", 0, "", "admin")
+	require.NoError(t, err)
+	assert.Nil(t, permanent.EndsAt)
+
+	w := serveFrom(handler, "203.0.113.9:1234", "/")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+	w = serveFrom(handler, "[2001:db8::1]:1234", "/")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"), "permanent block")
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "198.51.100.1:1234", "/").Code)
+
+	// The allowlist wins over the blocks
+	allowed, err := rl.AllowNetwork("203.0.113.9", "office", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "203.0.113.9:1234", "/").Code)
+	assert.Equal(t, http.StatusForbidden, serveFrom(handler, "203.0.113.10:1234", "/").Code)
+	entries, err := rl.Allowlist()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "office", entries[0].Note)
+
+	require.NoError(t, rl.RemoveAllowed(allowed.ID))
+	assert.ErrorIs(t, rl.RemoveAllowed(allowed.ID), gorm.ErrRecordNotFound)
+	assert.Equal(t, http.StatusForbidden, serveFrom(handler, "203.0.113.9:1234", "/").Code)
+
+	_, err = rl.Unblock(network.ID, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "203.0.113.9:1234", "/").Code)
+
+	// The permanent block is restored by a new limiter
+	restarted := NewRateLimiter(config.RateLimiterConfig{})
+	defer restarted.Close()
+	require.NoError(t, restarted.UseBlockList(repo))
+	handler = restarted.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	assert.Equal(t, http.StatusForbidden, serveFrom(handler, "[2001:db8::1]:1234", "/").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(handler, "203.0.113.9:1234", "/").Code)
+
+	// Blocks that ended are pruned
+	restarted.pruneBlocks(time.Now().Add(2 * time.Hour))
+	assert.Len(t, restarted.networks.Load().blocked, 1, "the permanent block stays")
+}
+
+func TestAllowlistBypassesLimits(t *testing.T) {
+	rl := NewRateLimiter(config.RateLimiterConfig{
+		RequestsPerMinute: 1,
+		BlockMinutes:      5,
+		Policies: map[string]config.RateLimitPolicyConfig{
+			"login": {Requests: 1, PeriodSeconds: 60},
+		},
+	})
+	defer rl.Close()
+	require.NoError(t, rl.UseBlockList(newBlockListRepository(t)))
+	_, err := rl.AllowNetwork("192.0.2.0/24", "monitoring", "admin")
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	global := rl.Handler(ok)
+	route := rl.PolicyMiddleware([]string{"login"}, nil)(ok)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serveFrom(global, "192.0.2.10:1234", "/").Code)
+		assert.Equal(t, http.StatusOK, serveFrom(route, "192.0.2.10:1234", "/login").Code)
+	}
+	assert.Equal(t, http.StatusOK, serveFrom(route, "127.0.0.5:1234", "/login").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(route, "127.0.0.5:1234", "/login").Code)
+}
@@ -0,0 +1,550 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+)
+
+// Prefixes of the bucket and counter keys of the database store
+const (
+	bucketRequests = "rate|"   // requestsPerMinute of an IP
+	bucketPolicy   = "policy|" // a policy, by its name and what it counts by
+	counterErrors  = "errors|" // 401 and 404 responses of an IP
+	counterScans   = "scan|"   // 404s of the watched paths of an IP
+)
+
+// maxStoreKeyLength is the length of the key columns of the shared store tables
+const maxStoreKeyLength = 255
+
+// storeKey returns the key as it is stored. The keys longer than the key columns, like the
+// policy keys of long header values, are replaced by the SHA-256 of the key after its prefix.
+func storeKey(key string) string {
+	if len(key) <= maxStoreKeyLength {
+		return key
+	}
+	prefix, _, _ := strings.Cut(key, "|")
+	sum := sha256.Sum256([]byte(key))
+	return prefix + "|sha256:" + hex.EncodeToString(sum[:])
+}
+
+// slidingWindow counts the events of a period. The events are counted in fixed windows of the
+// period: the estimate of the last period is the count of the current window plus the part of
+// the previous one that the period still covers, assuming its events were even.
+type slidingWindow struct {
+	period time.Duration
+}
+
+// windows returns the start of the current window and of the previous one, in Unix seconds,
+// and the time elapsed in the current one
+func (w slidingWindow) windows(now time.Time) (current, previous int64, elapsed time.Duration) {
+	seconds := max(int64(w.period/time.Second), 1)
+	current = now.Unix() / seconds * seconds
+	return current, current - seconds, now.Sub(time.Unix(current, 0))
+}
+
+// expiresAt returns when the window starting at start no longer counts
+func (w slidingWindow) expiresAt(start int64) time.Time {
+	return time.Unix(start, 0).Add(2 * w.period)
+}
+
+// estimate returns the events in the period up to now
+func (w slidingWindow) estimate(previous, current int64, elapsed time.Duration) float64 {
+	return float64(previous)*(1-float64(elapsed)/float64(w.period)) + float64(current)
+}
+
+// counterKey is the counter of a key in a window
+type counterKey struct {
+	key   string
+	start int64
+}
+
+// windowCount is the count of a counter and when it expires
+type windowCount struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// databaseStore keeps the buckets, counters and blocks in the database, for the gateway
+// instances that share it. The requestsPerMinute limit and the policies are token buckets, as in
+// memory, with the TAT of every key in a row that all the instances move forward; the errors and
+// the 404s of watched paths are counted in windows (see slidingWindow). Every instance reads the
+// state of the others on every sync. Without batching, the requests are counted in the database
+// at once, so the limits are exact; with it they are counted locally and written on every sync,
+// so the requests do not wait for the database, and each instance sees the requests of the
+// others up to one sync late.
+//
+// Without batching, a request that cannot be counted is let through, or denied with failClosed.
+// The failures are logged once per sync, with their count.
+type databaseStore struct {
+	repo       db.RateLimitStoreRepository
+	batch      bool
+	failClosed bool
+	interval   time.Duration
+	failures   atomic.Int64 // requests and events not counted since the last sync
+
+	mu      sync.Mutex
+	shared  map[counterKey]windowCount  // counts of every instance at the last sync
+	pending map[counterKey]windowCount  // counts of this instance not written yet, in batch mode
+	writing map[counterKey]windowCount  // pending counts being written by the sync
+	tats    map[string]time.Time        // TATs of the buckets at the last sync, with the requests of this instance since
+	takes   map[string]db.RateLimitTake // requests of this instance not written yet, in batch mode
+	blocks  map[string]time.Time        // blocked IPs at the last sync, with the blocks since
+	changed map[string]bool             // IPs blocked or reset while a sync runs, nil otherwise
+	synced  func(now time.Time) error   // reads more shared state after every sync, nil for none
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newDatabaseStore reads the counters and blocks of the repository and starts the sync
+func newDatabaseStore(repo db.RateLimitStoreRepository, storage *config.RateLimitStorageConfig) (*databaseStore, error) {
+	s := &databaseStore{
+		repo:       repo,
+		batch:      storage != nil && storage.Batch,
+		failClosed: storage != nil && storage.FailClosed,
+		interval:   storage.SyncInterval(),
+		shared:     make(map[counterKey]windowCount),
+		pending:    make(map[counterKey]windowCount),
+		tats:       make(map[string]time.Time),
+		takes:      make(map[string]db.RateLimitTake),
+		blocks:     make(map[string]time.Time),
+		done:       make(chan struct{}),
+	}
+	if err := s.sync(time.Now()); err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.syncLoop()
+	return s, nil
+}
+
+// UseSharedStore keeps the counters and blocks of the limiter in the repository, shared by
+// every gateway instance that uses it, with the storage settings of the configuration. Call
+// it before the limiter serves requests, and before UseBlockList.
+func (rl *RateLimiter) UseSharedStore(repo db.RateLimitStoreRepository) error {
+	store, err := newDatabaseStore(repo, rl.Config().Storage)
+	if err != nil {
+		return fmt.Errorf("failed to read the shared rate limiter state: %w", err)
+	}
+	rl.store = store
+	return nil
+}
+
+// syncLoop syncs the store every interval, and once more when it is closed
+func (s *databaseStore) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			if err := s.sync(time.Now()); err != nil {
+				log.Printf("Rate limiter: failed to write the last counts: %v", err)
+			}
+			s.reportFailures()
+			return
+		case now := <-ticker.C:
+			if err := s.sync(now); err != nil {
+				log.Printf("Rate limiter: failed to sync the shared state: %v", err)
+			}
+			s.reportFailures()
+		}
+	}
+}
+
+// sync writes the pending requests and counts, and reads the buckets, counts and blocks of
+// every instance. The counts being written keep counting until the ones read replace them.
+func (s *databaseStore) sync(now time.Time) error {
+	s.mu.Lock()
+	writing, takes := s.pending, s.takes
+	s.writing, s.pending = writing, make(map[counterKey]windowCount)
+	s.takes = make(map[string]db.RateLimitTake)
+	s.changed = make(map[string]bool)
+	s.mu.Unlock()
+
+	counters := make([]db.RateLimitCounter, 0, len(writing))
+	for key, count := range writing {
+		counters = append(counters, db.RateLimitCounter{Key: key.key, WindowStart: key.start, Count: count.count, ExpiresAt: count.expiresAt})
+	}
+	err := s.repo.AddTakes(slices.Collect(maps.Values(takes)), now)
+	if err == nil {
+		takes = nil
+		err = s.repo.AddCounts(counters)
+	}
+	if err != nil {
+		s.mu.Lock()
+		for key, count := range writing {
+			s.pending[key] = addCount(s.pending[key], count.count, count.expiresAt)
+		}
+		for key, take := range takes {
+			s.addTake(key, take.Requests, take.Interval)
+		}
+		s.writing, s.changed = nil, nil
+		s.mu.Unlock()
+		return err
+	}
+	stored, err := s.repo.ListCounters(now)
+	var buckets []db.RateLimitBucket
+	if err == nil {
+		buckets, err = s.repo.ListBuckets(now)
+	}
+	var blocks []db.RateLimitBlock
+	if err == nil {
+		blocks, err = s.repo.ListBlocks(now)
+	}
+
+	s.mu.Lock()
+	changed := s.changed
+	s.changed = nil
+	if err != nil {
+		// written but not read: they count as shared until the next sync
+		for key, count := range writing {
+			s.shared[key] = addCount(s.shared[key], count.count, count.expiresAt)
+		}
+		s.writing = nil
+		s.mu.Unlock()
+		return err
+	}
+	s.shared = make(map[counterKey]windowCount, len(stored))
+	for _, counter := range stored {
+		s.shared[counterKey{key: counter.Key, start: counter.WindowStart}] = windowCount{count: counter.Count, expiresAt: counter.ExpiresAt}
+	}
+	s.writing = nil
+	tats := make(map[string]time.Time, len(buckets))
+	for _, bucket := range buckets {
+		tats[bucket.Key] = time.Unix(0, bucket.TAT)
+	}
+	// the requests taken while reading are not in the buckets read yet
+	for key, take := range s.takes {
+		tats[key] = later(tats[key], now).Add(time.Duration(take.Requests) * take.Interval)
+	}
+	s.tats = tats
+	fresh := make(map[string]time.Time, len(blocks))
+	for _, block := range blocks {
+		fresh[block.Key] = block.BlockedUntil
+	}
+	// what changed while reading is newer than what was read. The IPs reset may have had
+	// counts being written after their counters were removed.
+	var reset []string
+	for ip := range changed {
+		if until, ok := s.blocks[ip]; ok {
+			fresh[ip] = until
+			continue
+		}
+		delete(fresh, ip)
+		s.forget(ip)
+		reset = append(reset, ip)
+	}
+	s.blocks = fresh
+	synced := s.synced
+	s.mu.Unlock()
+
+	for _, ip := range reset {
+		if err := s.repo.DeleteBuckets(bucketRequests + ip); err != nil {
+			return err
+		}
+		if err := s.repo.DeleteCounters(counterKeys(ip)...); err != nil {
+			return err
+		}
+	}
+	if synced != nil {
+		return synced(now)
+	}
+	return nil
+}
+
+// later returns the later of two times
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// addTake adds n requests to the pending ones of a bucket. Hold mu.
+func (s *databaseStore) addTake(key string, n int64, interval time.Duration) {
+	s.takes[key] = db.RateLimitTake{Key: key, Requests: s.takes[key].Requests + n, Interval: interval}
+}
+
+// afterSync calls synced after every sync, to read more of the state the instances share
+func (s *databaseStore) afterSync(synced func(now time.Time) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = synced
+}
+
+// addCount adds n to a count
+func addCount(count windowCount, n int64, expiresAt time.Time) windowCount {
+	return windowCount{count: count.count + n, expiresAt: expiresAt}
+}
+
+// count returns the count of a counter, the pending requests included. Hold mu.
+func (s *databaseStore) count(key counterKey) int64 {
+	return s.shared[key].count + s.writing[key].count + s.pending[key].count
+}
+
+// take counts a request against the token bucket of the key
+func (s *databaseStore) take(key string, bucket tokenBucket, now time.Time) rateDecision {
+	key = storeKey(key)
+	if s.batch {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		tat, decision := bucket.take(s.tats[key], now)
+		if decision.allowed {
+			s.tats[key] = tat
+			s.addTake(key, 1, bucket.interval)
+		}
+		return decision
+	}
+
+	// the database decides which requests are within the limit when instances race, with one
+	// statement for an allowed request
+	tat, allowed, err := s.repo.TakeBucket(key, now, bucket.interval, bucket.burst)
+	if err != nil {
+		s.failed(key, err)
+		if s.failClosed {
+			return rateDecision{failed: true, retryAfter: s.interval}
+		}
+		return rateDecision{allowed: true}
+	}
+	s.mu.Lock()
+	s.tats[key] = tat
+	s.mu.Unlock()
+	return bucket.decision(tat, now, allowed)
+}
+
+// add counts an event of the key and returns the events in the window up to now
+func (s *databaseStore) add(key string, w slidingWindow, now time.Time) int {
+	// without a window the events never block
+	if w.period <= 0 {
+		return 0
+	}
+	key = storeKey(key)
+	current, previous, elapsed := w.windows(now)
+	if s.batch {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.pending[counterKey{key, current}] = addCount(s.pending[counterKey{key, current}], 1, w.expiresAt(current))
+		return int(w.estimate(s.count(counterKey{key, previous}), s.count(counterKey{key, current}), elapsed))
+	}
+
+	// the previous window is over, so its count as of the last sync is the one of every instance
+	count, err := s.repo.IncrementCounter(key, current, 1, w.expiresAt(current))
+	if err != nil {
+		s.failed(key, err)
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(w.estimate(s.shared[counterKey{key, previous}].count, count, elapsed))
+}
+
+// failed counts a request or event the database could not count, and logs the first one
+// since the last sync
+func (s *databaseStore) failed(key string, err error) {
+	if s.failures.Add(1) == 1 {
+		log.Printf("Rate limiter: failed to count a request of %s in the shared state: %v", key, err)
+	}
+}
+
+// reportFailures logs how many requests and events were not counted since the last report
+func (s *databaseStore) reportFailures() {
+	if n := s.failures.Swap(0); n > 0 {
+		action := "allowed"
+		if s.failClosed {
+			action = "denied"
+		}
+		log.Printf("Rate limiter: %d requests or events could not be counted in the shared state, the requests were %s", n, action)
+	}
+}
+
+func (s *databaseStore) takeRequest(ip string, cfg config.RateLimiterConfig, now time.Time) rateDecision {
+	return s.take(bucketRequests+ip, newTokenBucket(cfg.RequestsPerMinute, time.Minute, cfg.GlobalBurst()), now)
+}
+
+func (s *databaseStore) takePolicy(key string, policy config.RateLimitPolicyConfig, now time.Time) rateDecision {
+	return s.take(bucketPolicy+key, newTokenBucket(policy.Requests, policy.Period(), policy.BurstSize()), now)
+}
+
+func (s *databaseStore) addError(ip string, cfg config.RateLimiterConfig, now time.Time) int {
+	return s.add(counterErrors+ip, slidingWindow{period: time.Duration(cfg.BlockMinutes) * time.Minute}, now)
+}
+
+func (s *databaseStore) addScan(ip string, cfg config.RateLimiterConfig, now time.Time) int {
+	return s.add(counterScans+ip, slidingWindow{period: time.Duration(cfg.VulnerabilityScan.BlockMinutes) * time.Minute}, now)
+}
+
+// block stores the block at once, in batch mode too: blocks are rare, and the other instances
+// apply them on their next sync
+func (s *databaseStore) block(ip string, now time.Time, duration time.Duration) (time.Time, bool) {
+	if duration <= 0 {
+		return now, false
+	}
+	until := now.Add(duration)
+	s.mu.Lock()
+	started := !now.Before(s.blocks[ip])
+	s.blocks[ip] = until
+	if s.changed != nil {
+		s.changed[ip] = true
+	}
+	s.mu.Unlock()
+
+	if err := s.repo.SetBlock(ip, until); err != nil {
+		log.Printf("Rate limiter: failed to share the block of %s: %v", ip, err)
+	}
+	return until, started
+}
+
+func (s *databaseStore) blockedUntil(ip string, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blocks[ip]
+}
+
+func (s *databaseStore) reset(ip string) {
+	s.mu.Lock()
+	delete(s.blocks, ip)
+	s.forget(ip)
+	if s.changed != nil {
+		s.changed[ip] = true
+	}
+	s.mu.Unlock()
+
+	if err := s.repo.DeleteBlock(ip); err != nil {
+		log.Printf("Rate limiter: failed to remove the shared block of %s: %v", ip, err)
+	}
+	if err := s.repo.DeleteBuckets(bucketRequests + ip); err != nil {
+		log.Printf("Rate limiter: failed to remove the shared bucket of %s: %v", ip, err)
+	}
+	if err := s.repo.DeleteCounters(counterKeys(ip)...); err != nil {
+		log.Printf("Rate limiter: failed to remove the shared counters of %s: %v", ip, err)
+	}
+}
+
+// forget removes the bucket and the counts of the IP that this instance knows of. Hold mu.
+func (s *databaseStore) forget(ip string) {
+	delete(s.tats, bucketRequests+ip)
+	delete(s.takes, bucketRequests+ip)
+	keys := counterKeys(ip)
+	for _, counts := range []map[counterKey]windowCount{s.shared, s.pending} {
+		for key := range counts {
+			if slices.Contains(keys, key.key) {
+				delete(counts, key)
+			}
+		}
+	}
+}
+
+// counterKeys returns the keys of the counters of an IP
+func counterKeys(ip string) []string {
+	return []string{counterErrors + ip, counterScans + ip}
+}
+
+// reconfigure keeps the shared buckets and counters as they are: every instance reloads its
+// configuration, and rescaling the buckets once per instance would hold the requests back
+// several times over
+func (s *databaseStore) reconfigure(old, cfg config.RateLimiterConfig, now time.Time) {}
+
+// cleanup removes the expired counters and blocks, from the database too
+func (s *databaseStore) cleanup(cfg config.RateLimiterConfig, now time.Time) {
+	s.mu.Lock()
+	for key, count := range s.shared {
+		if !now.Before(count.expiresAt) {
+			delete(s.shared, key)
+		}
+	}
+	// full buckets are the same as no bucket
+	for key, tat := range s.tats {
+		if !tat.After(now) {
+			delete(s.tats, key)
+		}
+	}
+	for ip, until := range s.blocks {
+		if !now.Before(until) {
+			delete(s.blocks, ip)
+		}
+	}
+	s.mu.Unlock()
+
+	if err := s.repo.DeleteExpired(now); err != nil {
+		log.Printf("Rate limiter: failed to remove the expired shared counters: %v", err)
+	}
+}
+
+// stats reports the IPs of every instance as of the last sync
+func (s *databaseStore) stats(cfg config.RateLimiterConfig, now time.Time) []RateLimiterStat {
+	bucket := newTokenBucket(max(cfg.RequestsPerMinute, 1), time.Minute, cfg.GlobalBurst())
+	windows := map[string]slidingWindow{
+		counterErrors: {period: time.Duration(cfg.BlockMinutes) * time.Minute},
+		counterScans:  {period: time.Duration(cfg.VulnerabilityScan.BlockMinutes) * time.Minute},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byIP := make(map[string]*RateLimiterStat)
+	stat := func(ip string) *RateLimiterStat {
+		if byIP[ip] == nil {
+			byIP[ip] = &RateLimiterStat{IP: ip}
+		}
+		return byIP[ip]
+	}
+	counted := make(map[string]bool)
+	for _, counts := range []map[counterKey]windowCount{s.shared, s.writing, s.pending} {
+		for key := range counts {
+			if counted[key.key] {
+				continue
+			}
+			counted[key.key] = true
+			prefix, ip, found := strings.Cut(key.key, "|")
+			w, ok := windows[prefix+"|"]
+			if !found || !ok || w.period <= 0 {
+				continue
+			}
+			current, previous, elapsed := w.windows(now)
+			events := int(math.Ceil(w.estimate(s.count(counterKey{key.key, previous}), s.count(counterKey{key.key, current}), elapsed)))
+			if events == 0 {
+				continue
+			}
+			switch prefix + "|" {
+			case counterErrors:
+				stat(ip).Errors = events
+			case counterScans:
+				stat(ip).Scan404 = events
+			}
+		}
+	}
+	for key, tat := range s.tats {
+		if ip, ok := strings.CutPrefix(key, bucketRequests); ok && tat.After(now) {
+			stat(ip).Requests = bucket.used(tat, now)
+		}
+	}
+	for ip, until := range s.blocks {
+		if now.Before(until) {
+			stat(ip).BlockedUntil = until
+		}
+	}
+
+	stats := make([]RateLimiterStat, 0, len(byIP))
+	for _, st := range byIP {
+		stats = append(stats, *st)
+	}
+	return stats
+}
+
+// close stops the sync after writing the pending counts
+func (s *databaseStore) close() {
+	close(s.done)
+	s.wg.Wait()
+}
@@ -0,0 +1,321 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSharedLimiters creates two limiters sharing a database, as two gateway instances behind
+// a load balancer. They only sync when the test does.
+func newSharedLimiters(t *testing.T, cfg config.RateLimiterConfig) (*RateLimiter, *RateLimiter, db.RateLimitStoreRepository) {
+	t.Helper()
+	db.SetupTestDB(fmt.Sprintf("%s_%d", t.Name(), time.Now().UnixNano()))
+	repo := db.NewRateLimitStoreRepositoryDB(db.GetConnection())
+	if cfg.Storage == nil {
+		cfg.Storage = &config.RateLimitStorageConfig{Type: config.RateLimitStorageDatabase}
+	}
+	cfg.Storage.SyncSeconds = 3600
+	var limiters []*RateLimiter
+	for range 2 {
+		rl := NewRateLimiter(cfg)
+		t.Cleanup(rl.Close)
+		require.NoError(t, rl.UseSharedStore(repo))
+		limiters = append(limiters, rl)
+	}
+	return limiters[0], limiters[1], repo
+}
+
+// syncStore syncs the shared store of the limiter
+func syncStore(t *testing.T, rl *RateLimiter) {
+	t.Helper()
+	require.NoError(t, rl.store.(*databaseStore).sync(time.Now()))
+}
+
+func TestSlidingWindow(t *testing.T) {
+	w := slidingWindow{period: time.Minute}
+	current, previous, elapsed := w.windows(time.Unix(150, 0))
+	assert.Equal(t, int64(120), current)
+	assert.Equal(t, int64(60), previous)
+	assert.Equal(t, 30*time.Second, elapsed)
+	assert.Equal(t, time.Unix(240, 0), w.expiresAt(current))
+
+	// half of the previous window is still in the last minute
+	assert.InDelta(t, 7.0, w.estimate(8, 3, elapsed), 0.001)
+	assert.InDelta(t, 3.0, w.estimate(0, 3, elapsed), 0.001)
+}
+
+func TestDatabaseStoreSharesLimits(t *testing.T) {
+	a, b, repo := newSharedLimiters(t, config.RateLimiterConfig{
+		RequestsPerMinute: 3,
+		BlockMinutes:      5,
+		Policies: map[string]config.RateLimitPolicyConfig{
+			"login": {Requests: 1, PeriodSeconds: 60},
+		},
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	first, second := a.Handler(ok), b.Handler(ok)
+
+	// The requests of both instances count against the same limit
+	assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(second, "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.7:1234", "/").Code)
+	w := serveFrom(second, "203.0.113.7:1234", "/")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "300", w.Header().Get("Retry-After"), "blocked")
+
+	// The block applies on the other instance after its sync
+	assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.8:1234", "/").Code)
+	blocks, err := repo.ListBlocks(time.Now())
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	assert.Equal(t, "203.0.113.7", blocks[0].Key)
+	syncStore(t, a)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(first, "203.0.113.7:1234", "/").Code)
+
+	stats := a.Stats()
+	require.Len(t, stats, 2)
+	for _, stat := range stats {
+		if stat.IP == "203.0.113.7" {
+			assert.Equal(t, 3, stat.Requests)
+			assert.True(t, stat.BlockedUntil.After(time.Now()))
+		} else {
+			assert.Equal(t, 1, stat.Requests)
+		}
+	}
+
+	// Policies are shared too
+	login := b.PolicyMiddleware([]string{"login"}, nil)(ok)
+	assert.Equal(t, http.StatusOK, serveFrom(a.PolicyMiddleware([]string{"login"}, nil)(ok), "198.51.100.1:1234", "/login").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(login, "198.51.100.1:1234", "/login").Code)
+
+	// Resetting an IP removes its block and counters for every instance
+	b.store.reset("203.0.113.7")
+	syncStore(t, a)
+	assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(second, "203.0.113.7:1234", "/").Code)
+}
+
+func TestDatabaseStoreBurst(t *testing.T) {
+	a, b, _ := newSharedLimiters(t, config.RateLimiterConfig{
+		RequestsPerMinute: 60,
+		Burst:             2,
+		Policies: map[string]config.RateLimitPolicyConfig{
+			"login": {Requests: 1, PeriodSeconds: 60, Burst: 3},
+		},
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// A burst of 2 across the instances, then one request per second
+	assert.Equal(t, http.StatusOK, serveFrom(a.Handler(ok), "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(b.Handler(ok), "203.0.113.7:1234", "/").Code)
+	w := serveFrom(a.Handler(ok), "203.0.113.7:1234", "/")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// The burst of a policy is shared too, with its RateLimit headers
+	login := func(rl *RateLimiter) *httptest.ResponseRecorder {
+		return serveFrom(rl.PolicyMiddleware([]string{"login"}, nil)(ok), "198.51.100.1:1234", "/login")
+	}
+	w = login(a)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, http.StatusOK, login(b).Code)
+	w = login(a)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get(RateLimitRemainingHeader))
+	w = login(b)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestDatabaseStoreSharesNetworks(t *testing.T) {
+	a, b, _ := newSharedLimiters(t, config.RateLimiterConfig{RequestsPerMinute: 1, BlockMinutes: 5})
+	blockList := db.NewBlockListRepositoryDB(db.GetConnection())
+	require.NoError(t, a.UseBlockList(blockList))
+	require.NoError(t, b.UseBlockList(blockList))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	first, second := a.Handler(ok), b.Handler(ok)
+
+	// A manual block applies on the other instance after its sync
+	event, err := a.BlockNetwork("198.51.100.0/24", 0, "abuse", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, serveFrom(first, "198.51.100.5:1234", "/").Code)
+	syncStore(t, b)
+	assert.Equal(t, http.StatusForbidden, serveFrom(second, "198.51.100.6:1234", "/").Code)
+
+	// And so does its end
+	_, err = b.Unblock(event.ID, "admin")
+	require.NoError(t, err)
+	syncStore(t, a)
+	assert.Equal(t, http.StatusOK, serveFrom(first, "198.51.100.7:1234", "/").Code)
+
+	// The allowlist too
+	entry, err := b.AllowNetwork("203.0.113.0/24", "office", "admin")
+	require.NoError(t, err)
+	syncStore(t, a)
+	for range 3 {
+		assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.7:1234", "/").Code)
+	}
+	require.NoError(t, b.RemoveAllowed(entry.ID))
+	syncStore(t, a)
+	assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(first, "203.0.113.7:1234", "/").Code, "limited again")
+}
+
+func TestDatabaseStoreBatch(t *testing.T) {
+	storage := &config.RateLimitStorageConfig{Type: config.RateLimitStorageDatabase, Batch: true}
+	a, b, repo := newSharedLimiters(t, config.RateLimiterConfig{
+		RequestsPerMinute: 4,
+		MaxErrors:         1,
+		BlockMinutes:      5,
+		Storage:           storage,
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	first, second := a.Handler(ok), b.Handler(ok)
+
+	// The requests are counted locally until the sync
+	assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(first, "203.0.113.7:1234", "/").Code)
+	counters, err := repo.ListCounters(time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, counters)
+
+	syncStore(t, a)
+	syncStore(t, b)
+	assert.Equal(t, 2, sharedRequests(t, repo, "rate|203.0.113.7", 15*time.Second))
+	assert.Equal(t, http.StatusOK, serveFrom(second, "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(second, "203.0.113.7:1234", "/").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(second, "203.0.113.7:1234", "/").Code)
+
+	// The errors of both instances add up, and the block is shared at once
+	notFound := http.NotFoundHandler()
+	assert.Equal(t, http.StatusNotFound, serveFrom(a.Handler(notFound), "198.51.100.1:1234", "/missing").Code)
+	syncStore(t, a)
+	syncStore(t, b)
+	assert.Equal(t, http.StatusNotFound, serveFrom(b.Handler(notFound), "198.51.100.1:1234", "/missing").Code)
+	syncStore(t, a)
+	assert.Equal(t, http.StatusTooManyRequests, serveFrom(first, "198.51.100.1:1234", "/").Code)
+
+	// Closing writes the pending counts
+	assert.Equal(t, http.StatusOK, serveFrom(first, "192.0.2.1:1234", "/").Code)
+	a.Close()
+	assert.Equal(t, 1, sharedRequests(t, repo, "rate|192.0.2.1", 15*time.Second))
+}
+
+func TestDatabaseStoreLongKeys(t *testing.T) {
+	a, b, repo := newSharedLimiters(t, config.RateLimiterConfig{
+		Policies: map[string]config.RateLimitPolicyConfig{
+			"api": {Requests: 1, Key: "header:X-Api-Key"},
+		},
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(rl *RateLimiter, apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		req.Header.Set("X-Api-Key", apiKey)
+		w := httptest.NewRecorder()
+		rl.PolicyMiddleware([]string{"api"}, nil)(ok).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Header values longer than the key column are stored by their hash, and still shared
+	long := strings.Repeat("k", 1000)
+	assert.Equal(t, http.StatusOK, serve(a, long+"1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(b, long+"1"))
+	assert.Equal(t, http.StatusOK, serve(b, long+"2"), "values differing after the column length")
+
+	buckets, err := repo.ListBuckets(time.Now())
+	require.NoError(t, err)
+	require.Len(t, buckets, 2)
+	for _, bucket := range buckets {
+		assert.LessOrEqual(t, len(bucket.Key), maxStoreKeyLength)
+		assert.True(t, strings.HasPrefix(bucket.Key, bucketPolicy+"sha256:"), bucket.Key)
+	}
+	assert.Equal(t, "policy|api|header:short", storeKey("policy|api|header:short"))
+}
+
+// sharedRequests returns the requests the bucket of the key holds back in the repository,
+// refilled one per interval
+func sharedRequests(t *testing.T, repo db.RateLimitStoreRepository, key string, interval time.Duration) int {
+	t.Helper()
+	buckets, err := repo.ListBuckets(time.Now())
+	require.NoError(t, err)
+	for _, bucket := range buckets {
+		if bucket.Key == key {
+			return newTokenBucket(1, interval, 1).used(time.Unix(0, bucket.TAT), time.Now())
+		}
+	}
+	return 0
+}
+
+// failingStoreRepository fails the requests on the request path while down is set
+type failingStoreRepository struct {
+	db.RateLimitStoreRepository
+	down atomic.Bool
+}
+
+func (r *failingStoreRepository) TakeBucket(key string, now time.Time, interval time.Duration, burst int) (time.Time, bool, error) {
+	if r.down.Load() {
+		return time.Time{}, false, errors.New("database is down")
+	}
+	return r.RateLimitStoreRepository.TakeBucket(key, now, interval, burst)
+}
+
+func (r *failingStoreRepository) IncrementCounter(key string, windowStart, n int64, expiresAt time.Time) (int64, error) {
+	if r.down.Load() {
+		return 0, errors.New("database is down")
+	}
+	return r.RateLimitStoreRepository.IncrementCounter(key, windowStart, n, expiresAt)
+}
+
+func TestDatabaseStoreFailures(t *testing.T) {
+	for _, failClosed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("failClosed=%v", failClosed), func(t *testing.T) {
+			db.SetupTestDB(fmt.Sprintf("%s_%d", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano()))
+			repo := &failingStoreRepository{RateLimitStoreRepository: db.NewRateLimitStoreRepositoryDB(db.GetConnection())}
+			rl := NewRateLimiter(config.RateLimiterConfig{
+				RequestsPerMinute: 2,
+				MaxErrors:         1,
+				BlockMinutes:      5,
+				Storage:           &config.RateLimitStorageConfig{Type: config.RateLimitStorageDatabase, SyncSeconds: 30, FailClosed: failClosed},
+			})
+			t.Cleanup(rl.Close)
+			require.NoError(t, rl.UseSharedStore(repo))
+			store := rl.store.(*databaseStore)
+			handler := rl.Handler(http.NotFoundHandler())
+
+			repo.down.Store(true)
+			for range 3 {
+				w := serveFrom(handler, "203.0.113.7:1234", "/missing")
+				if failClosed {
+					assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+					assert.Equal(t, "30", w.Header().Get("Retry-After"))
+				} else {
+					assert.Equal(t, http.StatusNotFound, w.Code, "the requests and errors are not counted")
+				}
+			}
+			if failClosed {
+				assert.Equal(t, int64(3), store.failures.Load())
+			} else {
+				assert.Equal(t, int64(6), store.failures.Load(), "the requests and their errors")
+			}
+			store.reportFailures()
+			assert.Zero(t, store.failures.Load())
+
+			// The client was never blocked for the failures of the database
+			repo.down.Store(false)
+			assert.Equal(t, http.StatusNotFound, serveFrom(handler, "203.0.113.7:1234", "/missing").Code)
+		})
+	}
+}
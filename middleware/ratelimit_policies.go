@@ -0,0 +1,252 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/middleware/fingerprint"
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// Response headers of the rate limit policies, from the IETF draft "RateLimit header
+// fields for HTTP".
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RateLimitPolicyHeader    = "RateLimit-Policy"
+)
+
+// tokenBucket is a token bucket evaluated with GCRA (generic cell rate algorithm). Instead
+// of counting tokens it keeps the theoretical arrival time (TAT) of the next request, which
+// every request moves forward by the interval: one time value per key, whatever the rate.
+type tokenBucket struct {
+	interval time.Duration // time to refill one request
+	burst    int           // requests the bucket holds
+}
+
+// newTokenBucket creates a bucket of burst requests refilled at requests per period
+func newTokenBucket(requests int, period time.Duration, burst int) tokenBucket {
+	return tokenBucket{interval: period / time.Duration(requests), burst: burst}
+}
+
+// rateDecision is the result of a request against a bucket
+type rateDecision struct {
+	allowed    bool
+	remaining  int           // requests still allowed at once
+	retryAfter time.Duration // until the next request is allowed, when denied
+	reset      time.Duration // until the bucket is full again
+	failed     bool          // the store could not count the request and denied it
+}
+
+// take counts a request made at now against the TAT, and returns the new TAT. A denied
+// request does not move it.
+func (b tokenBucket) take(tat, now time.Time) (time.Time, rateDecision) {
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(b.interval)
+	if now.Before(next.Add(-time.Duration(b.burst) * b.interval)) {
+		return tat, b.decision(tat, now, false)
+	}
+	return next, b.decision(next, now, true)
+}
+
+// decision describes a request made at now, given the TAT after it: moved forward by the
+// request when it was allowed, unchanged when it was denied
+func (b tokenBucket) decision(tat, now time.Time, allowed bool) rateDecision {
+	if !allowed {
+		allowAt := tat.Add(b.interval - time.Duration(b.burst)*b.interval)
+		return rateDecision{retryAfter: allowAt.Sub(now), reset: tat.Sub(now)}
+	}
+	allowAt := tat.Add(-time.Duration(b.burst) * b.interval)
+	return rateDecision{
+		allowed:   true,
+		remaining: int(now.Sub(allowAt) / b.interval),
+		reset:     tat.Sub(now),
+	}
+}
+
+// used returns the requests the bucket is holding back at now
+func (b tokenBucket) used(tat, now time.Time) int {
+	if !tat.After(now) {
+		return 0
+	}
+	return int(math.Ceil(float64(tat.Sub(now)) / float64(b.interval)))
+}
+
+// rescale converts the TAT of a bucket to another rate, keeping the requests it holds back
+func rescale(tat, now time.Time, from, to tokenBucket) time.Time {
+	if !tat.After(now) || from.interval == to.interval {
+		return tat
+	}
+	return now.Add(time.Duration(float64(tat.Sub(now)) * float64(to.interval) / float64(from.interval)))
+}
+
+// policyBucket is the state of a policy for one key
+type policyBucket struct {
+	mu  sync.Mutex
+	tat time.Time
+}
+
+// policyDecision is the decision of a named policy, for the response headers
+type policyDecision struct {
+	rateDecision
+	policy config.RateLimitPolicyConfig
+}
+
+// stricter reports whether d leaves the client less room than other
+func (d policyDecision) stricter(other policyDecision) bool {
+	if d.allowed != other.allowed {
+		return !d.allowed
+	}
+	if !d.allowed {
+		return d.retryAfter > other.retryAfter
+	}
+	return d.remaining < other.remaining
+}
+
+// Middleware limits the requests by client IP, as Handler does, and by the policies that
+// list the path of the request. lookupSession returns the session of a request for the
+// policies keyed by user or token; without it they count by IP.
+func (rl *RateLimiter) Middleware(lookupSession func(*http.Request) *db.Session) Middleware {
+	return func(next http.Handler) http.Handler {
+		return rl.handler(next, lookupSession)
+	}
+}
+
+// PolicyMiddleware limits the requests of a route with the named policies. The policies are
+// read from the configuration on every request, so a reload applies them at once. The
+// allowlist is not limited.
+func (rl *RateLimiter) PolicyMiddleware(names []string, lookupSession func(*http.Request) *db.Session) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, _, _ := rl.checkNetworks(session.GetClientIP(r), time.Now())
+			if !allowed && !rl.applyPolicies(w, r, names, lookupSession) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// pathPolicies returns the names of the policies whose paths match the request path
+func pathPolicies(cfg config.RateLimiterConfig, path string) []string {
+	var names []string
+	for name, policy := range cfg.Policies {
+		for _, pattern := range policy.Paths {
+			if matched, _ := doublestar.Match(pattern, path); matched {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// applyPolicies counts the request against the named policies and sets the RateLimit headers
+// of the strictest one. When a policy has no requests left it answers 429 Too Many Requests
+// and reports false.
+func (rl *RateLimiter) applyPolicies(w http.ResponseWriter, r *http.Request, names []string, lookupSession func(*http.Request) *db.Session) bool {
+	cfg := rl.Config()
+	now := time.Now()
+	var strictest *policyDecision
+	for _, name := range names {
+		policy, ok := cfg.Policies[name]
+		if !ok {
+			continue
+		}
+		decision := rl.store.takePolicy(name+"|"+rateLimitKey(r, policy, lookupSession), policy, now)
+		current := policyDecision{rateDecision: decision, policy: policy}
+		if strictest == nil || current.stricter(*strictest) {
+			strictest = &current
+		}
+	}
+	if strictest == nil {
+		return true
+	}
+
+	if strictest.failed {
+		unavailable(w, strictest.retryAfter)
+		return false
+	}
+	setRateLimitHeaders(w.Header(), *strictest)
+	if !strictest.allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(strictest.retryAfter)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Rate limit exceeded"))
+		return false
+	}
+	return true
+}
+
+// setRateLimitHeaders describes the decision in the RateLimit headers, unless an outer
+// policy already left the client with fewer requests
+func setRateLimitHeaders(h http.Header, d policyDecision) {
+	if current, err := strconv.Atoi(h.Get(RateLimitRemainingHeader)); err == nil && current <= d.remaining {
+		return
+	}
+	burst := d.policy.BurstSize()
+	h.Set(RateLimitLimitHeader, strconv.Itoa(burst))
+	h.Set(RateLimitRemainingHeader, strconv.Itoa(d.remaining))
+	h.Set(RateLimitResetHeader, strconv.Itoa(ceilSeconds(d.reset)))
+	h.Set(RateLimitPolicyHeader, fmt.Sprintf("%d;w=%d;burst=%d", d.policy.Requests, int(d.policy.Period().Seconds()), burst))
+}
+
+// rateLimitKey returns what the policy counts the request by. Requests without the value
+// of the key, such as anonymous requests of a user policy, count by IP.
+func rateLimitKey(r *http.Request, policy config.RateLimitPolicyConfig, lookupSession func(*http.Request) *db.Session) string {
+	switch policy.KeyType() {
+	case config.RateLimitKeyUser:
+		if s := requestSession(r, lookupSession); s != nil && s.UserID != "" {
+			return "user:" + s.UserID
+		}
+	case config.RateLimitKeyToken:
+		if s := requestSession(r, lookupSession); s != nil && s.CreatedFrom == session.TokenAuthCreatedFrom {
+			return "token:" + s.Token
+		}
+	case config.RateLimitKeyJA4H:
+		if ja4h := fingerprint.GetJA4FromRequest(r); ja4h != "" {
+			return "ja4h:" + ja4h
+		}
+		return "ja4h:" + getJA4HCache().GetOrCalculate(r)
+	case config.RateLimitKeyHeader:
+		if value := r.Header.Get(policy.HeaderName()); value != "" {
+			return "header:" + value
+		}
+	}
+	return "ip:" + session.GetClientIP(r)
+}
+
+// requestSession returns the session an earlier middleware stored, or the one lookupSession finds
+func requestSession(r *http.Request, lookupSession func(*http.Request) *db.Session) *db.Session {
+	if s, ok := r.Context().Value(session.SessionKey).(*db.Session); ok && s != nil {
+		return s
+	}
+	if lookupSession != nil {
+		return lookupSession(r)
+	}
+	return nil
+}
+
+// ceilSeconds rounds a duration up to whole seconds, for the headers
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// unavailable answers a request the limiter could not count, when its store fails closed.
+// The client is not blocked: the failure is not its doing.
+func unavailable(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte("Rate limiter unavailable"))
+}
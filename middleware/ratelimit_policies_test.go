@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
+	"github.com/jmaister/taronja-gateway/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(1, time.Second, 3)
+	now := time.Now()
+	var tat time.Time
+
+	for i, remaining := range []int{2, 1, 0} {
+		var decision rateDecision
+		tat, decision = bucket.take(tat, now)
+		require.True(t, decision.allowed, "request %d", i)
+		assert.Equal(t, remaining, decision.remaining)
+	}
+	assert.Equal(t, 3*time.Second, tat.Sub(now), "full bucket after 3 seconds")
+	assert.Equal(t, 3, bucket.used(tat, now))
+
+	tat, decision := bucket.take(tat, now)
+	assert.False(t, decision.allowed)
+	assert.Equal(t, time.Second, decision.retryAfter)
+	assert.Equal(t, 3*time.Second, decision.reset)
+
+	// One request is refilled per second
+	now = now.Add(time.Second)
+	tat, decision = bucket.take(tat, now)
+	assert.True(t, decision.allowed)
+	assert.Equal(t, 0, decision.remaining)
+	_, decision = bucket.take(tat, now)
+	assert.False(t, decision.allowed)
+
+	assert.Equal(t, 0, bucket.used(tat, now.Add(time.Minute)))
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	rl := NewRateLimiter(config.RateLimiterConfig{Policies: map[string]config.RateLimitPolicyConfig{
+		"search": {Requests: 2, PeriodSeconds: 1},
+		"daily":  {Requests: 1000, PeriodSeconds: 86400, Burst: 10},
+	}})
+	defer rl.Close()
+	handler := rl.PolicyMiddleware([]string{"daily", "search"}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/search", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("192.0.2.1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(RateLimitLimitHeader), "the strictest policy")
+	assert.Equal(t, "1", rec.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "1", rec.Header().Get(RateLimitResetHeader))
+	assert.Equal(t, "2;w=1;burst=2", rec.Header().Get(RateLimitPolicyHeader))
+
+	assert.Equal(t, http.StatusOK, serve("192.0.2.1").Code)
+	rec = serve("192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "Rate limit exceeded", rec.Body.String())
+
+	assert.Equal(t, http.StatusOK, serve("192.0.2.2").Code, "each IP has its own bucket")
+
+	// One bucket per policy and key
+	for _, key := range []string{"search|ip:192.0.2.1", "daily|ip:192.0.2.1"} {
+		_, ok := rl.buckets.Load(key)
+		assert.True(t, ok, key)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	request := func() *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		return req
+	}
+	user := &db.Session{UserID: "user-1", Token: "cookie-token"}
+	token := &db.Session{UserID: "user-1", Token: "token-id", CreatedFrom: session.TokenAuthCreatedFrom}
+	lookup := func(s *db.Session) func(*http.Request) *db.Session {
+		return func(*http.Request) *db.Session { return s }
+	}
+
+	assert.Equal(t, "ip:192.0.2.1", rateLimitKey(request(), config.RateLimitPolicyConfig{}, nil))
+	assert.Equal(t, "user:user-1", rateLimitKey(request(), config.RateLimitPolicyConfig{Key: "user"}, lookup(user)))
+	assert.Equal(t, "ip:192.0.2.1", rateLimitKey(request(), config.RateLimitPolicyConfig{Key: "user"}, lookup(nil)), "anonymous")
+	assert.Equal(t, "token:token-id", rateLimitKey(request(), config.RateLimitPolicyConfig{Key: "token"}, lookup(token)))
+	assert.Equal(t, "ip:192.0.2.1", rateLimitKey(request(), config.RateLimitPolicyConfig{Key: "token"}, lookup(user)), "cookie sessions have no token")
+
+	// The session of the context comes first
+	req := request().WithContext(context.WithValue(context.Background(), session.SessionKey, token))
+	assert.Equal(t, "user:user-1", rateLimitKey(req, config.RateLimitPolicyConfig{Key: "user"}, nil))
+
+	req = request()
+	req.Header.Set("X-Api-Key", "key-1")
+	assert.Equal(t, "header:key-1", rateLimitKey(req, config.RateLimitPolicyConfig{Key: "header:x-api-key"}, nil))
+	assert.Equal(t, "ip:192.0.2.1", rateLimitKey(request(), config.RateLimitPolicyConfig{Key: "header:X-Api-Key"}, nil))
+
+	req = request()
+	req.Header.Set("User-Agent", "curl/8.0")
+	ja4h := rateLimitKey(req, config.RateLimitPolicyConfig{Key: "ja4h"}, nil)
+	assert.Regexp(t, "^ja4h:ge11", ja4h)
+}
+
+func TestRateLimiterPathPolicies(t *testing.T) {
+	rl := NewRateLimiter(config.RateLimiterConfig{
+		RequestsPerMinute: 100,
+		BlockMinutes:      1,
+		Policies: map[string]config.RateLimitPolicyConfig{
+			"login": {Requests: 1, Key: "user", Paths: []string{"/_/login", "/_/auth/**"}},
+		},
+	})
+	defer rl.Close()
+	handler := rl.Middleware(func(*http.Request) *db.Session { return nil })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+		return rec
+	}
+
+	rec := serve("/_/login")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get(RateLimitRemainingHeader))
+	rec = serve("/_/auth/basic/login")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "the paths share the bucket")
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	rec = serve("/orders")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "97", rec.Header().Get(RateLimitRemainingHeader), "no policy, the requestsPerMinute limit")
+	assert.Equal(t, "100;w=60;burst=100", rec.Header().Get(RateLimitPolicyHeader))
+}
+
+func TestSetRateLimitHeaders(t *testing.T) {
+	h := http.Header{}
+	loose := policyDecision{rateDecision: rateDecision{allowed: true, remaining: 9}, policy: config.RateLimitPolicyConfig{Requests: 10}}
+	strict := policyDecision{rateDecision: rateDecision{allowed: true, remaining: 1}, policy: config.RateLimitPolicyConfig{Requests: 2}}
+
+	setRateLimitHeaders(h, strict)
+	setRateLimitHeaders(h, loose)
+	assert.Equal(t, "1", h.Get(RateLimitRemainingHeader), "an inner policy does not hide a stricter outer one")
+	assert.Equal(t, "2;w=60;burst=2", h.Get(RateLimitPolicyHeader))
+
+	assert.True(t, strict.stricter(loose))
+	denied := policyDecision{rateDecision: rateDecision{retryAfter: time.Second}}
+	assert.True(t, denied.stricter(strict))
+}
+
+func TestRateLimiterReconfigureRescales(t *testing.T) {
+	policies := func(requests int) map[string]config.RateLimitPolicyConfig {
+		return map[string]config.RateLimitPolicyConfig{"search": {Requests: requests}}
+	}
+	rl := NewRateLimiter(config.RateLimiterConfig{Policies: policies(60)})
+	defer rl.Close()
+	handler := rl.PolicyMiddleware([]string{"search"}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+	for range 3 {
+		require.Equal(t, http.StatusOK, serve())
+	}
+
+	// The 3 requests used count against the new burst of 3
+	rl.Reconfigure(config.RateLimiterConfig{Policies: policies(3)})
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+}
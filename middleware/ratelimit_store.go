@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// limiterStore keeps the counters and blocks of a RateLimiter: in the memory of the limiter,
+// or in the database shared by the gateway instances (see databaseStore).
+type limiterStore interface {
+	// takeRequest counts a request of the IP against requestsPerMinute
+	takeRequest(ip string, cfg config.RateLimiterConfig, now time.Time) rateDecision
+	// takePolicy counts a request against the policy, keyed by the policy name and what it counts by
+	takePolicy(key string, policy config.RateLimitPolicyConfig, now time.Time) rateDecision
+	// addError counts a 401 or 404 response of the IP and returns its errors in the block window
+	addError(ip string, cfg config.RateLimiterConfig, now time.Time) int
+	// addScan counts a 404 of a watched path and returns the ones of the IP in the scan window
+	addScan(ip string, cfg config.RateLimiterConfig, now time.Time) int
+	// block blocks the IP for the duration from now and returns the end of the block. It reports
+	// whether a new block started, so a client is not counted as blocked twice.
+	block(ip string, now time.Time, duration time.Duration) (time.Time, bool)
+	// blockedUntil returns the end of the block of the IP, in the past when it is not blocked
+	blockedUntil(ip string, now time.Time) time.Time
+	// reset forgets the block and the counters of the IP
+	reset(ip string)
+	// reconfigure adapts the counters to the limits of a new configuration
+	reconfigure(old, cfg config.RateLimiterConfig, now time.Time)
+	// cleanup removes the counters and blocks that no longer apply
+	cleanup(cfg config.RateLimiterConfig, now time.Time)
+	// stats returns the state of every IP
+	stats(cfg config.RateLimiterConfig, now time.Time) []RateLimiterStat
+	// close stops the background work of the store
+	close()
+}
+
+// memoryStore keeps the counters and blocks of a limiter in memory, for a single instance
+type memoryStore struct {
+	entries sync.Map // map[string]*rateEntry
+	buckets sync.Map // map[string]*policyBucket, by policy and key
+}
+
+// rateEntry stores the state for a single IP address.
+type rateEntry struct {
+	mu           sync.Mutex
+	tat          time.Time   // theoretical arrival time of the requestsPerMinute bucket
+	errors       []time.Time // timestamps of 401/404 responses in the block window
+	scan404      []time.Time // timestamps of 404s for watched vulnerability paths
+	blockedUntil time.Time   // if in the future, requests should be rejected
+}
+
+// getEntry retrieves or creates the rateEntry for the given IP.
+func (m *memoryStore) getEntry(ip string) *rateEntry {
+	if v, ok := m.entries.Load(ip); ok {
+		return v.(*rateEntry)
+	}
+	e := &rateEntry{}
+	actual, _ := m.entries.LoadOrStore(ip, e)
+	return actual.(*rateEntry)
+}
+
+// getBucket retrieves or creates the state of a policy key
+func (m *memoryStore) getBucket(key string) *policyBucket {
+	if v, ok := m.buckets.Load(key); ok {
+		return v.(*policyBucket)
+	}
+	actual, _ := m.buckets.LoadOrStore(key, &policyBucket{})
+	return actual.(*policyBucket)
+}
+
+func (m *memoryStore) takeRequest(ip string, cfg config.RateLimiterConfig, now time.Time) rateDecision {
+	bucket := newTokenBucket(cfg.RequestsPerMinute, time.Minute, cfg.GlobalBurst())
+	entry := m.getEntry(ip)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	var decision rateDecision
+	entry.tat, decision = bucket.take(entry.tat, now)
+	return decision
+}
+
+func (m *memoryStore) takePolicy(key string, policy config.RateLimitPolicyConfig, now time.Time) rateDecision {
+	bucket := newTokenBucket(policy.Requests, policy.Period(), policy.BurstSize())
+	state := m.getBucket(key)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	var decision rateDecision
+	state.tat, decision = bucket.take(state.tat, now)
+	return decision
+}
+
+func (m *memoryStore) addError(ip string, cfg config.RateLimiterConfig, now time.Time) int {
+	entry := m.getEntry(ip)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.errors = append(entry.errors, now)
+	entry.trim(now, cfg)
+	return len(entry.errors)
+}
+
+func (m *memoryStore) addScan(ip string, cfg config.RateLimiterConfig, now time.Time) int {
+	entry := m.getEntry(ip)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.scan404 = append(entry.scan404, now)
+	entry.trim(now, cfg)
+	return len(entry.scan404)
+}
+
+func (m *memoryStore) block(ip string, now time.Time, duration time.Duration) (time.Time, bool) {
+	entry := m.getEntry(ip)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	started := entry.block(now, duration)
+	return entry.blockedUntil, started
+}
+
+func (m *memoryStore) blockedUntil(ip string, now time.Time) time.Time {
+	v, ok := m.entries.Load(ip)
+	if !ok {
+		return time.Time{}
+	}
+	entry := v.(*rateEntry)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.blockedUntil
+}
+
+func (m *memoryStore) reset(ip string) {
+	m.entries.Delete(ip)
+}
+
+// reconfigure keeps the requests a bucket holds back when its rate changes
+func (m *memoryStore) reconfigure(old, cfg config.RateLimiterConfig, now time.Time) {
+	if old.RequestsPerMinute > 0 && cfg.RequestsPerMinute > 0 {
+		from := newTokenBucket(old.RequestsPerMinute, time.Minute, old.GlobalBurst())
+		to := newTokenBucket(cfg.RequestsPerMinute, time.Minute, cfg.GlobalBurst())
+		m.entries.Range(func(key, val interface{}) bool {
+			entry := val.(*rateEntry)
+			entry.mu.Lock()
+			entry.tat = rescale(entry.tat, now, from, to)
+			entry.mu.Unlock()
+			return true
+		})
+	}
+	m.buckets.Range(func(key, val interface{}) bool {
+		name, _, _ := strings.Cut(key.(string), "|")
+		before, ok := old.Policies[name]
+		after, found := cfg.Policies[name]
+		if !ok || !found {
+			return true
+		}
+		bucket := val.(*policyBucket)
+		bucket.mu.Lock()
+		bucket.tat = rescale(bucket.tat, now,
+			newTokenBucket(before.Requests, before.Period(), before.BurstSize()),
+			newTokenBucket(after.Requests, after.Period(), after.BurstSize()))
+		bucket.mu.Unlock()
+		return true
+	})
+}
+
+func (m *memoryStore) cleanup(cfg config.RateLimiterConfig, now time.Time) {
+	m.entries.Range(func(key, val interface{}) bool {
+		entry := val.(*rateEntry)
+		entry.mu.Lock()
+		entry.trim(now, cfg)
+		if entry.blockedUntil.Before(now) && entry.tat.Before(now) && len(entry.errors) == 0 && len(entry.scan404) == 0 {
+			m.entries.Delete(key)
+		}
+		entry.mu.Unlock()
+		return true
+	})
+	// full buckets are the same as no bucket
+	m.buckets.Range(func(key, val interface{}) bool {
+		bucket := val.(*policyBucket)
+		bucket.mu.Lock()
+		if bucket.tat.Before(now) {
+			m.buckets.Delete(key)
+		}
+		bucket.mu.Unlock()
+		return true
+	})
+}
+
+func (m *memoryStore) stats(cfg config.RateLimiterConfig, now time.Time) []RateLimiterStat {
+	var stats []RateLimiterStat
+	bucket := newTokenBucket(max(cfg.RequestsPerMinute, 1), time.Minute, cfg.GlobalBurst())
+	m.entries.Range(func(key, val interface{}) bool {
+		ip := key.(string)
+		e := val.(*rateEntry)
+		e.mu.Lock()
+		stats = append(stats, RateLimiterStat{
+			IP:           ip,
+			Requests:     bucket.used(e.tat, now),
+			Errors:       len(e.errors),
+			Scan404:      len(e.scan404),
+			BlockedUntil: e.blockedUntil,
+		})
+		e.mu.Unlock()
+		return true
+	})
+	return stats
+}
+
+func (m *memoryStore) close() {}
+
+// block blocks the IP for the duration from now. It reports whether a new block started,
+// so requests that finish while the IP is blocked are not counted as blocks again.
+func (e *rateEntry) block(now time.Time, duration time.Duration) bool {
+	started := !now.Before(e.blockedUntil) && duration > 0
+	e.blockedUntil = now.Add(duration)
+	return started
+}
+
+// trim removes outdated timestamps from the entry.
+func (e *rateEntry) trim(now time.Time, cfg config.RateLimiterConfig) {
+	// prune errors older than block window
+	if cfg.BlockMinutes > 0 {
+		cutoffErr := now.Add(-time.Duration(cfg.BlockMinutes) * time.Minute)
+		j := 0
+		for ; j < len(e.errors); j++ {
+			if e.errors[j].After(cutoffErr) {
+				break
+			}
+		}
+		if j > 0 {
+			e.errors = e.errors[j:]
+		}
+	}
+
+	// prune vulnerability scan timestamps
+	if cfg.VulnerabilityScan.BlockMinutes > 0 {
+		cutoffScan := now.Add(-time.Duration(cfg.VulnerabilityScan.BlockMinutes) * time.Minute)
+		k := 0
+		for ; k < len(e.scan404); k++ {
+			if e.scan404[k].After(cutoffScan) {
+				break
+			}
+		}
+		if k > 0 {
+			e.scan404 = e.scan404[k:]
+		}
+	}
+}
@@ -1,32 +1,36 @@
 package middleware
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/session"
 )
 
-// RateLimiter implements an in‑memory rate limiter keyed by client IP.
-// It's safe for concurrent use and maintains its own cleanup goroutine.
+// RateLimiter implements a rate limiter keyed by client IP, and the named policies of the
+// routes. Its counters and blocks are kept in memory, or shared by the gateway instances with
+// UseSharedStore. It's safe for concurrent use and maintains its own cleanup goroutine.
 type RateLimiter struct {
-	cfg             config.RateLimiterConfig
-	entries         sync.Map // map[string]*rateEntry
+	cfg             atomic.Pointer[config.RateLimiterConfig] // replaced when the configuration is reloaded
+	memoryStore                                              // counters and blocks when they are not shared
+	store           limiterStore                             // the memory store or a shared one
+	networks        atomic.Pointer[networkLists]             // allowlist and manual blocks, nil without a block list
+	listMu          sync.Mutex                               // one change of networks at a time
+	listVersion     int                                      // changes of networks made by this instance, guarded by listMu
+	blockList       db.BlockListRepository                   // stores the blocks, nil when they are not stored
+	pending         sync.WaitGroup                           // blocks being stored
 	cleanupInterval time.Duration
-}
-
-// rateEntry stores the state for a single IP address.
-type rateEntry struct {
-	mu           sync.Mutex
-	requests     []time.Time // timestamps of all requests in the last minute
-	errors       []time.Time // timestamps of 401/404 responses in the block window
-	scan404      []time.Time // timestamps of 404s for watched vulnerability paths
-	blockedUntil time.Time   // if in the future, requests should be rejected
+	done            chan struct{} // closed by Close to stop the cleanup goroutine
+	closeOnce       sync.Once
 }
 
 // RateLimiterMiddleware creates a middleware function configured with the
@@ -45,30 +49,70 @@ func NewRateLimiter(cfg config.RateLimiterConfig) *RateLimiter {
 		interval = time.Duration(cfg.BlockMinutes) * time.Minute
 	}
 	rl := &RateLimiter{
-		cfg:             cfg,
 		cleanupInterval: interval,
+		done:            make(chan struct{}),
 	}
+	rl.cfg.Store(&cfg)
+	rl.store = &rl.memoryStore
 	go rl.cleanupLoop()
 	return rl
 }
 
-// Handler is the middleware implementation.
+// Reconfigure replaces the limits of a running limiter, keeping the state of every IP, so
+// blocked clients stay blocked across configuration reloads. The requests a bucket holds
+// back are kept when its rate changes.
+func (rl *RateLimiter) Reconfigure(cfg config.RateLimiterConfig) {
+	old := rl.cfg.Swap(&cfg)
+	rl.store.reconfigure(*old, cfg, time.Now())
+}
+
+// Close stops the cleanup goroutine, writes the counts of a shared store and waits for the
+// blocks being stored. The limiter keeps enforcing its limits, but the entries of idle IPs
+// are no longer removed. It is safe to call more than once.
+func (rl *RateLimiter) Close() {
+	rl.closeOnce.Do(func() {
+		close(rl.done)
+		rl.store.close()
+	})
+	rl.pending.Wait()
+}
+
+// Handler is the middleware implementation. The policies keyed by user or token count by IP,
+// see Middleware.
 func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
-	// if no limits are configured simply pass through
-	if !rl.cfg.IsEnabled() {
-		return next
-	}
+	return rl.handler(next, nil)
+}
 
+func (rl *RateLimiter) handler(next http.Handler, lookupSession func(*http.Request) *db.Session) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ip := session.GetClientIP(r)
 		now := time.Now()
-		entry := rl.getEntry(ip)
 
-		entry.mu.Lock()
+		// the allowlist bypasses every check, the manual blocks apply even without limits
+		allowed, until, blocked := rl.checkNetworks(ip, now)
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if blocked {
+			if !until.IsZero() {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", ceilSeconds(until.Sub(now))))
+			}
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Access blocked"))
+			return
+		}
+
+		// if no limits are configured simply pass through
+		cfg := rl.Config()
+		if !cfg.IsEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// check existing block
-		if now.Before(entry.blockedUntil) {
-			retry := int(entry.blockedUntil.Sub(now).Seconds())
-			entry.mu.Unlock()
+		if blockedUntil := rl.store.blockedUntil(ip, now); now.Before(blockedUntil) {
+			retry := ceilSeconds(blockedUntil.Sub(now))
 			header := w.Header()
 			header.Set("Retry-After", fmt.Sprintf("%d", retry))
 			w.WriteHeader(http.StatusTooManyRequests)
@@ -76,23 +120,39 @@ func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
 			return
 		}
 
-		// record the request timestamp and prune old records
-		entry.requests = append(entry.requests, now)
-		entry.trim(now, rl.cfg)
-
 		// enforce request rate limit
-		if rl.cfg.RequestsPerMinute > 0 && len(entry.requests) > rl.cfg.RequestsPerMinute {
-			// block the IP
-			entry.blockedUntil = now.Add(time.Duration(rl.cfg.BlockMinutes) * time.Minute)
-			retry := int(entry.blockedUntil.Sub(now).Seconds())
-			entry.mu.Unlock()
+		decision := rateDecision{allowed: true}
+		if cfg.RequestsPerMinute > 0 {
+			decision = rl.store.takeRequest(ip, cfg, now)
+		}
+		if decision.failed {
+			unavailable(w, decision.retryAfter)
+			return
+		}
+		if cfg.RequestsPerMinute > 0 {
+			setRateLimitHeaders(w.Header(), policyDecision{rateDecision: decision, policy: globalPolicy(cfg)})
+		}
+		if !decision.allowed {
+			// block the IP, or wait for the bucket to refill without blockMinutes
+			retry := ceilSeconds(decision.retryAfter)
+			if cfg.BlockMinutes > 0 {
+				blockedUntil, started := rl.store.block(ip, now, time.Duration(cfg.BlockMinutes)*time.Minute)
+				retry = ceilSeconds(blockedUntil.Sub(now))
+				if started {
+					rl.recordBlock(r, ip, db.BlockReasonRate, now, blockedUntil)
+				}
+			}
 			header := w.Header()
 			header.Set("Retry-After", fmt.Sprintf("%d", retry))
 			w.WriteHeader(http.StatusTooManyRequests)
 			w.Write([]byte("Rate limit exceeded"))
 			return
 		}
-		entry.mu.Unlock()
+
+		// policies that limit paths on every route, like the login page
+		if names := pathPolicies(cfg, r.URL.Path); len(names) > 0 && !rl.applyPolicies(w, r, names, lookupSession) {
+			return
+		}
 
 		// wrap response to capture status
 		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
@@ -100,31 +160,25 @@ func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
 
 		// after response, update error counts if necessary
 		if rw.status == http.StatusNotFound || rw.status == http.StatusUnauthorized {
-			entry := rl.getEntry(ip)
 			now := time.Now()
-			entry.mu.Lock()
-			entry.errors = append(entry.errors, now)
-			entry.trim(now, rl.cfg)
-			if rl.cfg.MaxErrors > 0 && len(entry.errors) > rl.cfg.MaxErrors {
-				entry.blockedUntil = now.Add(time.Duration(rl.cfg.BlockMinutes) * time.Minute)
+			if errors := rl.store.addError(ip, cfg, now); cfg.MaxErrors > 0 && errors > cfg.MaxErrors {
+				if blockedUntil, started := rl.store.block(ip, now, time.Duration(cfg.BlockMinutes)*time.Minute); started {
+					rl.recordBlock(r, ip, db.BlockReasonErrors, now, blockedUntil)
+				}
 			}
-			entry.mu.Unlock()
 		}
 
 		// vulnerability scan paths: count only 404s on configured urls
-		if rw.status == http.StatusNotFound && len(rl.cfg.VulnerabilityScan.URLs) > 0 {
-			for _, pattern := range rl.cfg.VulnerabilityScan.URLs {
+		if rw.status == http.StatusNotFound && len(cfg.VulnerabilityScan.URLs) > 0 {
+			for _, pattern := range cfg.VulnerabilityScan.URLs {
 				matched := matchesVulnerabilityScanPath(pattern, r.URL.Path)
 				if matched {
-					entry := rl.getEntry(ip)
 					now := time.Now()
-					entry.mu.Lock()
-					entry.scan404 = append(entry.scan404, now)
-					entry.trim(now, rl.cfg)
-					if rl.cfg.VulnerabilityScan.Max404 > 0 && len(entry.scan404) > rl.cfg.VulnerabilityScan.Max404 {
-						entry.blockedUntil = now.Add(time.Duration(rl.cfg.VulnerabilityScan.BlockMinutes) * time.Minute)
+					if scans := rl.store.addScan(ip, cfg, now); cfg.VulnerabilityScan.Max404 > 0 && scans > cfg.VulnerabilityScan.Max404 {
+						if blockedUntil, started := rl.store.block(ip, now, time.Duration(cfg.VulnerabilityScan.BlockMinutes)*time.Minute); started {
+							rl.recordBlock(r, ip, db.BlockReasonScan, now, blockedUntil)
+						}
 					}
-					entry.mu.Unlock()
 					break
 				}
 			}
@@ -132,6 +186,11 @@ func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
 	})
 }
 
+// globalPolicy describes the requestsPerMinute limit as a policy, for the RateLimit headers
+func globalPolicy(cfg config.RateLimiterConfig) config.RateLimitPolicyConfig {
+	return config.RateLimitPolicyConfig{Requests: cfg.RequestsPerMinute, PeriodSeconds: 60, Burst: cfg.GlobalBurst()}
+}
+
 func matchesVulnerabilityScanPath(pattern string, requestPath string) bool {
 	// Normalize separators so matching works consistently on all platforms.
 	pattern = strings.ReplaceAll(pattern, "\\", "/")
@@ -171,110 +230,57 @@ func (r *statusRecorder) WriteHeader(code int) {
 	r.ResponseWriter.WriteHeader(code)
 }
 
-// getEntry retrieves or creates the rateEntry for the given IP.
-func (rl *RateLimiter) getEntry(ip string) *rateEntry {
-	if v, ok := rl.entries.Load(ip); ok {
-		return v.(*rateEntry)
-	}
-	e := &rateEntry{}
-	actual, _ := rl.entries.LoadOrStore(ip, e)
-	return actual.(*rateEntry)
+func (r *statusRecorder) Flush() {
+	http.NewResponseController(r.ResponseWriter).Flush()
 }
 
-// trim removes outdated timestamps from the entry.
-func (e *rateEntry) trim(now time.Time, cfg config.RateLimiterConfig) {
-	// prune requests older than one minute
-	cutoff := now.Add(-1 * time.Minute)
-	i := 0
-	for ; i < len(e.requests); i++ {
-		if e.requests[i].After(cutoff) {
-			break
-		}
-	}
-	if i > 0 {
-		e.requests = e.requests[i:]
-	}
-
-	// prune errors older than block window
-	if cfg.BlockMinutes > 0 {
-		cutoffErr := now.Add(-time.Duration(cfg.BlockMinutes) * time.Minute)
-		j := 0
-		for ; j < len(e.errors); j++ {
-			if e.errors[j].After(cutoffErr) {
-				break
-			}
-		}
-		if j > 0 {
-			e.errors = e.errors[j:]
-		}
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
 	}
+	return conn, brw, err
+}
 
-	// prune vulnerability scan timestamps
-	if cfg.VulnerabilityScan.BlockMinutes > 0 {
-		cutoffScan := now.Add(-time.Duration(cfg.VulnerabilityScan.BlockMinutes) * time.Minute)
-		k := 0
-		for ; k < len(e.scan404); k++ {
-			if e.scan404[k].After(cutoffScan) {
-				break
-			}
-		}
-		if k > 0 {
-			e.scan404 = e.scan404[k:]
-		}
-	}
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
 
 // RateLimiterStat is a snapshot of a single IP's rate limiter state.
 type RateLimiterStat struct {
 	IP           string    `json:"ip"`
-	Requests     int       `json:"requests"`
+	Requests     int       `json:"requests"` // requests held back by requestsPerMinute
 	Errors       int       `json:"errors"`
 	Scan404      int       `json:"scan404"`
 	BlockedUntil time.Time `json:"blockedUntil"`
 }
 
-// Stats returns a copy of the current entries suitable for reporting.
+// Stats returns a copy of the current entries suitable for reporting. With a shared store
+// they include the IPs of the other instances as of the last sync.
 func (rl *RateLimiter) Stats() []RateLimiterStat {
-	var stats []RateLimiterStat
-	rl.entries.Range(func(key, val interface{}) bool {
-		ip := key.(string)
-		e := val.(*rateEntry)
-		e.mu.Lock()
-		stats = append(stats, RateLimiterStat{
-			IP:           ip,
-			Requests:     len(e.requests),
-			Errors:       len(e.errors),
-			Scan404:      len(e.scan404),
-			BlockedUntil: e.blockedUntil,
-		})
-		e.mu.Unlock()
-		return true
-	})
-	return stats
+	return rl.store.stats(rl.Config(), time.Now())
 }
 
 // Config returns a snapshot of the limiter's configuration.
 // A copy is returned to avoid callers mutating internal state.
 func (rl *RateLimiter) Config() config.RateLimiterConfig {
-	// cfg is a value type so copying is cheap, and it is immutable after
-	// limiter creation which means we can safely return it directly.
-	return rl.cfg
+	// cfg is a value type so copying is cheap; Reconfigure swaps the
+	// pointer instead of changing the stored value.
+	return *rl.cfg.Load()
 }
 
 // cleanupLoop periodically removes stale entries from the map.
 func (rl *RateLimiter) cleanupLoop() {
 	ticker := time.NewTicker(rl.cleanupInterval)
 	defer ticker.Stop()
-	for now := range ticker.C {
-		rl.entries.Range(func(key, val interface{}) bool {
-			entry := val.(*rateEntry)
-			entry.mu.Lock()
-			entry.trim(now, rl.cfg)
-			if entry.blockedUntil.Before(now) && len(entry.requests) == 0 && len(entry.errors) == 0 && len(entry.scan404) == 0 {
-				rl.entries.Delete(key)
-			}
-			entry.mu.Unlock()
-			return true
-		})
+	for {
+		var now time.Time
+		select {
+		case <-rl.done:
+			return
+		case now = <-ticker.C:
+		}
+		rl.store.cleanup(rl.Config(), now)
+		rl.pruneBlocks(now)
 	}
 }
@@ -31,14 +31,18 @@ func TestRateLimiter_RequestLimit(t *testing.T) {
 	w := httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get(RateLimitLimitHeader))
+	assert.Equal(t, "1", w.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "30", w.Header().Get(RateLimitResetHeader))
+	assert.Equal(t, "2;w=60;burst=2", w.Header().Get(RateLimitPolicyHeader))
 	entry := rl.getEntry("1.2.3.4")
-	t.Logf("after first request: requests=%d errors=%d blockedUntil=%v", len(entry.requests), len(entry.errors), entry.blockedUntil)
+	t.Logf("after first request: tat=%v errors=%d blockedUntil=%v", entry.tat, len(entry.errors), entry.blockedUntil)
 
 	w = httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 	// log again
 	entry = rl.getEntry("1.2.3.4")
-	t.Logf("after second request: requests=%d errors=%d blockedUntil=%v", len(entry.requests), len(entry.errors), entry.blockedUntil)
+	t.Logf("after second request: tat=%v errors=%d blockedUntil=%v", entry.tat, len(entry.errors), entry.blockedUntil)
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	// third request exceeds limit and returns 429
@@ -46,16 +50,24 @@ func TestRateLimiter_RequestLimit(t *testing.T) {
 	handler.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusTooManyRequests, w.Code)
 	assert.Equal(t, "Rate limit exceeded", w.Body.String())
+	assert.Equal(t, "0", w.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "60", w.Header().Get("Retry-After"), "the block of one minute, rounded up")
+
+	// blocked requests are told the time left, rounded up
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
 
 	// entry should be marked blocked
 	entry = rl.getEntry("1.2.3.4")
 	assert.True(t, time.Now().Before(entry.blockedUntil))
 
-	// manually expire the block and clear historical timestamps so a new
+	// manually expire the block and refill the bucket so a new
 	// request will not immediately re‑trigger the limit.
 	entry.mu.Lock()
 	entry.blockedUntil = time.Now().Add(-time.Minute)
-	entry.requests = nil
+	entry.tat = time.Time{}
 	entry.errors = nil
 	entry.mu.Unlock()
 
@@ -251,12 +263,7 @@ func TestRateLimiter_Trim(t *testing.T) {
 	entry := &rateEntry{}
 	now := time.Now()
 
-	// Add requests, errors, and scan404s that should be trimmed and some that should remain
-	// Requests (1 minute cutoff)
-	entry.requests = []time.Time{
-		now.Add(-2 * time.Minute),  // should be trimmed
-		now.Add(-30 * time.Second), // should remain
-	}
+	// Add errors and scan404s that should be trimmed and some that should remain
 	// Errors (BlockMinutes cutoff = 1 minute)
 	entry.errors = []time.Time{
 		now.Add(-2 * time.Minute),  // should be trimmed
@@ -270,9 +277,6 @@ func TestRateLimiter_Trim(t *testing.T) {
 
 	entry.trim(now, cfg)
 
-	assert.Len(t, entry.requests, 1)
-	assert.True(t, entry.requests[0].After(now.Add(-1*time.Minute)))
-
 	assert.Len(t, entry.errors, 1)
 	assert.True(t, entry.errors[0].After(now.Add(-1*time.Minute)))
 
@@ -280,6 +284,36 @@ func TestRateLimiter_Trim(t *testing.T) {
 	assert.True(t, entry.scan404[0].After(now.Add(-1*time.Minute)))
 }
 
+func TestRateLimiter_Close(t *testing.T) {
+	rl := NewRateLimiter(config.RateLimiterConfig{RequestsPerMinute: 1, BlockMinutes: 1})
+	rl.Close()
+	rl.Close() // closing twice is fine
+
+	// The cleanup goroutine returns once the limiter is closed
+	stopped := make(chan struct{})
+	go func() {
+		rl.cleanupLoop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("cleanup loop did not stop")
+	}
+
+	// The limits still apply
+	handler := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.RemoteAddr = "1.2.3.4:1234"
+	codes := []int{}
+	for range 2 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
 // Test the various matching scenarios for vulnerability scan paths
 func TestMatchesVulnerabilityScanPath(t *testing.T) {
 	tests := []struct {
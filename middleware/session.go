@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/jmaister/taronja-gateway/api"
@@ -100,7 +101,8 @@ func SessionMiddleware(next http.HandlerFunc, sessionStore session.SessionStore,
 		result := ValidateSessionFromRequest(r, sessionStore, tokenService)
 
 		if !result.IsAuthenticated {
-			if isStatic {
+			// WebSocket handshakes cannot follow a redirect to the login page
+			if isStatic && !isUpgradeRequest(r) {
 				// Redirect to login page with the original URL as the redirect parameter
 				originalURL := r.URL.RequestURI()
 				redirectURL := managementPrefix + "/login?redirect=" + url.QueryEscape(originalURL)
@@ -148,6 +150,21 @@ func SessionMiddleware(next http.HandlerFunc, sessionStore session.SessionStore,
 	}
 }
 
+// isUpgradeRequest reports whether r asks to switch protocols, e.g. a WebSocket handshake.
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // SessionMiddlewareFunc creates an api.MiddlewareFunc from the existing SessionMiddleware.
 // This allows SessionMiddleware to be used with OpenAPI generated handlers that expect api.MiddlewareFunc.
 func SessionMiddlewareFunc(sessionStore session.SessionStore, tokenService session.TokenService, isStatic bool, managementPrefix string, adminRequired bool) api.MiddlewareFunc {
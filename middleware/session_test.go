@@ -378,6 +378,45 @@ func TestSessionMiddleware(t *testing.T) {
 		assert.Equal(t, expectedRedirect, w.Header().Get("Location"))
 	})
 
+	t.Run("no session for WebSocket handshake on static route - returns 401", func(t *testing.T) {
+		handlerCalled := false
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerCalled = true
+		})
+
+		middleware := SessionMiddleware(nextHandler, store, tokenService, true, managementPrefix, false)
+
+		req := httptest.NewRequest("GET", "/app/socket", nil)
+		req.Header.Set("Connection", "keep-alive, Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.False(t, handlerCalled)
+	})
+
+	t.Run("valid session cookie authenticates WebSocket handshake", func(t *testing.T) {
+		user := createTestUser()
+		_, cookie := createValidSessionAndCookie(t, store, user)
+
+		handlerCalled := false
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerCalled = true
+		})
+
+		middleware := SessionMiddleware(nextHandler, store, tokenService, true, managementPrefix, false)
+
+		req := httptest.NewRequest("GET", "/app/socket", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.AddCookie(cookie)
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.True(t, handlerCalled)
+	})
+
 	t.Run("no session for API request - returns 401", func(t *testing.T) {
 		handlerCalled := false
 		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
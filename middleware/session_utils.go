@@ -35,7 +35,7 @@ func ValidateSessionFromRequest(r *http.Request, sessionStore session.SessionSto
 		} else {
 			// Log failed token authentication for security monitoring
 			log.Printf("Failed bearer token authentication from %s for %s %s",
-				r.RemoteAddr, r.Method, r.URL.Path)
+				session.GetClientIP(r), r.Method, r.URL.Path)
 		}
 	}
 
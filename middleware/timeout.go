@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// deadlineGrace is the extra time the connection gets to write a 504 once the request
+// deadline has passed.
+const deadlineGrace = time.Second
+
+// RequestTimeoutMiddleware bounds the total time of a request. The deadline is set on the
+// request context, so upstream calls are cancelled when it passes, and replaces the server
+// read and write timeouts on the connection through http.ResponseController.
+func RequestTimeoutMiddleware(timeout time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.Now().Add(timeout)
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("Could not set read deadline for %s: %v", r.URL.Path, err)
+			}
+			if err := rc.SetWriteDeadline(deadline.Add(deadlineGrace)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("Could not set write deadline for %s: %v", r.URL.Path, err)
+			}
+			// The server only resets the write deadline when it has a write timeout of its own
+			defer rc.SetWriteDeadline(time.Time{})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTimeoutMiddleware_SetsContextDeadline(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	handler := RequestTimeoutMiddleware(2 * time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+	}))
+
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	require.True(t, hasDeadline)
+	assert.WithinDuration(t, start.Add(2*time.Second), deadline, 100*time.Millisecond)
+}
+
+func TestRequestTimeoutMiddleware_ExtendsServerWriteTimeout(t *testing.T) {
+	slowBody := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("start "))
+		http.NewResponseController(w).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("end"))
+	}
+
+	mux := http.NewServeMux()
+	// Wrapped writers must let the controller reach the connection
+	mux.Handle("/generous", LoggingMiddleware(RequestTimeoutMiddleware(5*time.Second)(http.HandlerFunc(slowBody))))
+	mux.HandleFunc("/strict", slowBody)
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/generous")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "start end", string(body))
+
+	resp, err = http.Get(server.URL + "/strict")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	assert.Error(t, err, "the server write timeout still applies to routes without a request timeout")
+}
@@ -1,14 +1,19 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"regexp"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmaister/taronja-gateway/db"
 	"github.com/jmaister/taronja-gateway/session"
+	"github.com/jmaister/taronja-gateway/upstream"
 )
 
 // responseWriterWithStats wraps http.ResponseWriter to capture response details
@@ -62,6 +67,43 @@ func (rw *responseWriterWithStats) Body() string {
 	return rw.body.String()
 }
 
+// Flush sends buffered data to the client, for streamed responses
+func (rw *responseWriterWithStats) Flush() {
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection for protocol upgrades such as WebSocket
+func (rw *responseWriterWithStats) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach the connection
+func (rw *responseWriterWithStats) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// pendingMetrics counts the metrics still being stored, so they can be flushed on shutdown
+var pendingMetrics atomic.Int64
+
+// FlushTrafficMetrics waits until the metrics of the finished requests are stored, or ctx
+// is done. Call it once no more requests are served, before the database is closed.
+func FlushTrafficMetrics(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for pendingMetrics.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 // TrafficMetricMiddleware creates middleware for collecting request statistics
 func TrafficMetricMiddleware(statsRepo db.TrafficMetricRepository) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -71,6 +113,9 @@ func TrafficMetricMiddleware(statsRepo db.TrafficMetricRepository) func(http.Han
 			// Wrap the response writer to capture statistics
 			resp := NewResponseWriterWithStats(w)
 
+			// Let the proxy handler report the route and upstream target it used
+			req, proxyInfo := upstream.WithProxyInfo(req)
+
 			// Call the next handler
 			next.ServeHTTP(resp, req)
 
@@ -104,9 +149,20 @@ func TrafficMetricMiddleware(statsRepo db.TrafficMetricRepository) func(http.Han
 			stat.Error = errorMsg
 			stat.UserID = userID
 			stat.SessionID = sessionID
+			stat.RouteName = proxyInfo.Route
+			stat.Upstream = proxyInfo.Target
+			stat.CircuitState = proxyInfo.CircuitState
+			stat.CircuitTransition = proxyInfo.CircuitTransition
+			stat.Attempts = proxyInfo.Attempts
+			stat.StreamType = proxyInfo.Stream
+			stat.StreamBytesIn = proxyInfo.StreamBytesIn
+			stat.StreamBytesOut = proxyInfo.StreamBytesOut
+			stat.Coalesced = proxyInfo.Coalesced
 
 			// Store the statistic (async to avoid blocking the response)
+			pendingMetrics.Add(1)
 			go func() {
+				defer pendingMetrics.Add(-1)
 				if err := statsRepo.Create(stat); err != nil {
 					log.Printf("Failed to store request statistic: %v", err)
 				}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -58,24 +59,39 @@ func TestDetermineDeviceType(t *testing.T) {
 }
 
 func TestGetClientIP(t *testing.T) {
-	t.Run("extracts IP from X-Forwarded-For header", func(t *testing.T) {
+	resolver := session.NewClientIPResolver([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+	}, "")
+
+	t.Run("extracts IP from X-Forwarded-For header of a trusted proxy", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/test", nil)
 		req.Header.Set("X-Forwarded-For", "203.0.113.1, 192.168.1.1")
 		req.RemoteAddr = "10.0.0.1:12345"
 
-		ip := session.GetClientIP(req)
+		ip := session.GetClientIP(resolver.WithClientIP(req))
 		assert.Equal(t, "203.0.113.1", ip)
 	})
 
-	t.Run("extracts IP from X-Real-IP header", func(t *testing.T) {
+	t.Run("extracts IP from X-Real-IP header of a trusted proxy", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/test", nil)
 		req.Header.Set("X-Real-IP", "203.0.113.2")
 		req.RemoteAddr = "10.0.0.1:12345"
 
-		ip := session.GetClientIP(req)
+		resolver := session.NewClientIPResolver([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "X-Real-IP")
+		ip := session.GetClientIP(resolver.WithClientIP(req))
 		assert.Equal(t, "203.0.113.2", ip)
 	})
 
+	t.Run("ignores the headers of untrusted clients", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		req.RemoteAddr = "198.51.100.7:12345"
+
+		assert.Equal(t, "198.51.100.7", session.GetClientIP(resolver.WithClientIP(req)))
+		assert.Equal(t, "198.51.100.7", session.GetClientIP(req), "not resolved, no proxy is trusted")
+	})
+
 	t.Run("falls back to RemoteAddr", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/test", nil)
 		req.RemoteAddr = "192.168.1.1:12345"
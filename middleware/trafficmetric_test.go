@@ -341,3 +341,32 @@ func TestTrafficMetricMiddleware(t *testing.T) {
 		assert.NotEmpty(t, stat.OSVersion)      // Should have a version
 	})
 }
+
+// slowTrafficRepo stores the metrics after a delay, like a busy database
+type slowTrafficRepo struct {
+	db.TrafficMetricRepository
+	delay time.Duration
+}
+
+func (r *slowTrafficRepo) Create(stat *db.TrafficMetric) error {
+	time.Sleep(r.delay)
+	return r.TrafficMetricRepository.Create(stat)
+}
+
+func TestFlushTrafficMetrics(t *testing.T) {
+	statsRepo := setupTestTrafficRepo(t)
+	handler := TrafficMetricMiddleware(&slowTrafficRepo{TrafficMetricRepository: statsRepo, delay: 50 * time.Millisecond})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/flush", nil))
+
+	// The context ends before the metric is stored
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, FlushTrafficMetrics(ctx), context.DeadlineExceeded)
+
+	require.NoError(t, FlushTrafficMetrics(context.Background()))
+	stats, err := statsRepo.FindByPath("/api/flush", 10)
+	require.NoError(t, err)
+	assert.Len(t, stats, 1, "the metric is stored once flushed")
+}
@@ -150,7 +150,9 @@ func ValidateRateLimiterMiddleware(deps *deps.Dependencies, config *config.Gatew
 	if rl.MaxErrors < 0 {
 		return &ValidationError{Middleware: "rate_limiter", Message: "maxErrors cannot be negative"}
 	}
-	if rl.BlockMinutes <= 0 {
+	// the policies answer 429 until their bucket refills, without blocking
+	policiesOnly := rl.RequestsPerMinute == 0 && rl.MaxErrors == 0 && rl.VulnerabilityScan.Max404 == 0 && len(rl.VulnerabilityScan.URLs) == 0
+	if rl.BlockMinutes <= 0 && !policiesOnly {
 		return &ValidationError{Middleware: "rate_limiter", Message: "blockMinutes must be positive when rate limiting is enabled"}
 	}
 
@@ -168,6 +170,10 @@ func ValidateRateLimiterMiddleware(deps *deps.Dependencies, config *config.Gatew
 		}
 	}
 
+	if rl.IsShared() && deps.RateLimitStoreRepo == nil {
+		return &ValidationError{Middleware: "rate_limiter", Message: "storage type database requires the rate limit store repository"}
+	}
+
 	return nil
 }
 
@@ -215,18 +221,18 @@ func ValidateRouteConfiguration(deps *deps.Dependencies, config *config.GatewayC
 	for _, route := range config.Routes {
 		// Validate static routes
 		if route.Static {
-			if route.ToFile == "" && route.To == "" && route.ToFolder == "" {
+			if route.ToFile == "" && route.To == "" && route.ToFolder == "" && route.ToArchive == "" {
 				return &ValidationError{
 					Middleware: "static",
-					Message:    fmt.Sprintf("static route '%s' must have either ToFile, ToFolder, or To configured", route.Name),
+					Message:    fmt.Sprintf("static route '%s' must have either ToFile, ToFolder, ToArchive, or To configured", route.Name),
 				}
 			}
 		} else {
 			// Validate proxy routes
-			if route.To == "" {
+			if len(route.UpstreamTargets()) == 0 {
 				return &ValidationError{
 					Middleware: "proxy",
-					Message:    fmt.Sprintf("proxy route '%s' must have To URL configured", route.Name),
+					Message:    fmt.Sprintf("proxy route '%s' must have To URL or Targets configured", route.Name),
 				}
 			}
 		}
@@ -305,13 +311,15 @@ func LogMiddlewareStatus(config *config.GatewayConfig) {
 
 	// Rate limiter
 	if config.Management.RateLimiter.IsEnabled() {
-		log.Printf("✓ Rate Limiter: ENABLED (rpm=%d, maxErrors=%d, blockMinutes=%d, scanMax404=%d, scanURLs=%d, scanBlockMinutes=%d)",
+		log.Printf("✓ Rate Limiter: ENABLED (rpm=%d, burst=%d, maxErrors=%d, blockMinutes=%d, scanMax404=%d, scanURLs=%d, scanBlockMinutes=%d, policies=%d)",
 			config.Management.RateLimiter.RequestsPerMinute,
+			config.Management.RateLimiter.GlobalBurst(),
 			config.Management.RateLimiter.MaxErrors,
 			config.Management.RateLimiter.BlockMinutes,
 			config.Management.RateLimiter.VulnerabilityScan.Max404,
 			len(config.Management.RateLimiter.VulnerabilityScan.URLs),
-			config.Management.RateLimiter.VulnerabilityScan.BlockMinutes)
+			config.Management.RateLimiter.VulnerabilityScan.BlockMinutes,
+			len(config.Management.RateLimiter.Policies))
 	} else {
 		log.Printf("✗ Rate Limiter: DISABLED")
 	}
@@ -2,9 +2,7 @@ package session
 
 import (
 	"log"
-	"net"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/jmaister/taronja-gateway/db"
@@ -12,53 +10,6 @@ import (
 	"github.com/ua-parser/uap-go/uaparser"
 )
 
-// stripPort removes the port from an IP address if present
-func stripPort(ip string) string {
-	host, _, err := net.SplitHostPort(ip)
-	if err != nil {
-		// If SplitHostPort fails, it might be just an IP without port
-		return ip
-	}
-	return host
-}
-
-// GetClientIP extracts the real client IP address from the request
-func GetClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (from load balancers/proxies)
-	xForwardedFor := r.Header.Get("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		ips := strings.Split(xForwardedFor, ",")
-		if len(ips) > 0 {
-			return stripPort(strings.TrimSpace(ips[0]))
-		}
-	}
-
-	// Check X-Real-IP header (from reverse proxies)
-	xRealIP := r.Header.Get("X-Real-IP")
-	if xRealIP != "" {
-		return stripPort(xRealIP)
-	}
-
-	// Check X-Client-IP header
-	xClientIP := r.Header.Get("X-Client-IP")
-	if xClientIP != "" {
-		return stripPort(xClientIP)
-	}
-
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-
-	// Remove port if present using net.SplitHostPort
-	host, _, err := net.SplitHostPort(ip)
-	if err != nil {
-		// If SplitHostPort fails, it might be just an IP without port
-		return ip
-	}
-
-	return host
-}
-
 // NewClientInfo creates a ClientInfo instance from an HTTP request and geolocation data
 func NewClientInfo(req *http.Request) *db.ClientInfo {
 	parser := uaparser.NewFromSaved()
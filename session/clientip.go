@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientAddressKey stores the clientAddress of a request resolved by a ClientIPResolver
+const clientAddressKey contextKey = "clientAddress"
+
+// clientAddress is the client of a request and the proxies it went through
+type clientAddress struct {
+	ip           string   // address of the client, empty when unknown
+	forwardedFor []string // client and trusted proxies before the peer, left to right
+	trustedPeer  bool     // the peer is a trusted proxy
+}
+
+// ClientIPResolver finds the address of the client of a request. Only the forwarding
+// header that the trusted proxies set (X-Forwarded-For, Forwarded, X-Real-IP or
+// X-Client-IP) is read, and only when the peer is a trusted proxy, right to left: the
+// client is the first address that is not a trusted proxy, so the addresses that clients
+// add themselves are never used. The other headers are passed through by many proxies
+// as the client sent them, so they are ignored.
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// NewClientIPResolver creates a resolver that trusts the header of the proxies in the
+// prefixes, X-Forwarded-For when empty. Without prefixes the peer of the connection is
+// the client.
+func NewClientIPResolver(trustedProxies []netip.Prefix, header string) *ClientIPResolver {
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	return &ClientIPResolver{trusted: trustedProxies, header: http.CanonicalHeaderKey(header)}
+}
+
+// isTrusted reports whether the address is a trusted proxy
+func (res *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithClientIP returns the request with its client address resolved, for GetClientIP and
+// GetForwardedFor. The headers are read once, before any middleware can change them.
+func (res *ClientIPResolver) WithClientIP(r *http.Request) *http.Request {
+	address := res.resolve(r)
+	return r.WithContext(context.WithValue(r.Context(), clientAddressKey, address))
+}
+
+func (res *ClientIPResolver) resolve(r *http.Request) *clientAddress {
+	peer, ok := parseNode(r.RemoteAddr)
+	if !ok {
+		return &clientAddress{}
+	}
+	address := &clientAddress{ip: peer.String()}
+	if !res.isTrusted(peer) {
+		return address
+	}
+	address.trustedPeer = true
+
+	var hops []string
+	switch values := r.Header.Values(res.header); res.header {
+	case "Forwarded":
+		hops = forwardedFor(values)
+	case "X-Forwarded-For":
+		for _, value := range values {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	default: // a single address, the last one set
+		if len(values) > 0 {
+			hops = values[len(values)-1:]
+		}
+	}
+
+	// The rightmost address was added by the peer, the one before by the proxy before it,
+	// and so on while they are trusted. An entry that is not an address ends the chain.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseNode(strings.TrimSpace(hops[i]))
+		if !ok {
+			break
+		}
+		address.ip = hop.String()
+		address.forwardedFor = append([]string{address.ip}, address.forwardedFor...)
+		if !res.isTrusted(hop) {
+			break
+		}
+	}
+	return address
+}
+
+// forwardedFor returns the "for" parameters of the elements of the RFC 7239 Forwarded
+// headers, in order. Elements without one get an empty entry, which ends the chain.
+func forwardedFor(values []string) []string {
+	var nodes []string
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			node := ""
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					node = strings.Trim(value, `"`)
+					break
+				}
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// splitQuoted splits s on sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++ // escaped character of a quoted string
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseNode parses an address with an optional port: "192.0.2.1", "192.0.2.1:4711",
+// "2001:db8::1" or "[2001:db8::1]:4711". Names, "unknown" and obfuscated identifiers
+// such as "_hidden" are not addresses.
+func parseNode(node string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	addr, err := netip.ParseAddr(node)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// GetClientIP returns the address of the client of the request, as resolved by the
+// ClientIPResolver of the gateway. Requests that were not resolved trust no proxy: the
+// peer of the connection is the client. The result is always an IP address, or empty.
+func GetClientIP(r *http.Request) string {
+	if address, ok := r.Context().Value(clientAddressKey).(*clientAddress); ok {
+		return address.ip
+	}
+	if peer, ok := parseNode(r.RemoteAddr); ok {
+		return peer.String()
+	}
+	return ""
+}
+
+// GetForwardedFor returns the X-Forwarded-For value to send upstream without the peer of
+// the connection, which the proxy adds: the client and the trusted proxies it went
+// through. It is empty when the peer is the client.
+func GetForwardedFor(r *http.Request) string {
+	if address, ok := r.Context().Value(clientAddressKey).(*clientAddress); ok {
+		return strings.Join(address.forwardedFor, ", ")
+	}
+	return ""
+}
+
+// IsFromTrustedProxy reports whether the peer of the request is a trusted proxy, whose
+// forwarding headers may be passed on
+func IsFromTrustedProxy(r *http.Request) bool {
+	address, ok := r.Context().Value(clientAddressKey).(*clientAddress)
+	return ok && address.trustedPeer
+}
@@ -0,0 +1,190 @@
+package session
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		header       string // header of the trusted proxies, X-Forwarded-For when empty
+		headers      map[string]string
+		expectedIP   string
+		forwardedFor string
+		trustedPeer  bool
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "198.51.100.7:4711",
+			expectedIP: "198.51.100.7",
+		},
+		{
+			name:       "spoofed X-Forwarded-For from an untrusted client",
+			remoteAddr: "198.51.100.7:4711",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"},
+			expectedIP: "198.51.100.7",
+		},
+		{
+			name:         "trusted proxy",
+			remoteAddr:   "10.0.0.1:4711",
+			headers:      map[string]string{"X-Forwarded-For": "203.0.113.1"},
+			expectedIP:   "203.0.113.1",
+			forwardedFor: "203.0.113.1",
+			trustedPeer:  true,
+		},
+		{
+			name:         "address prepended by the client is skipped",
+			remoteAddr:   "10.0.0.1:4711",
+			headers:      map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.1, 10.0.0.2"},
+			expectedIP:   "203.0.113.1",
+			forwardedFor: "203.0.113.1, 10.0.0.2",
+			trustedPeer:  true,
+		},
+		{
+			name:         "only trusted proxies",
+			remoteAddr:   "10.0.0.1:4711",
+			headers:      map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			expectedIP:   "10.0.0.3",
+			forwardedFor: "10.0.0.3, 10.0.0.2",
+			trustedPeer:  true,
+		},
+		{
+			name:         "invalid entry ends the chain",
+			remoteAddr:   "10.0.0.1:4711",
+			headers:      map[string]string{"X-Forwarded-For": "203.0.113.1, ${jndi:ldap://x}, 10.0.0.2"},
+			expectedIP:   "10.0.0.2",
+			forwardedFor: "10.0.0.2",
+			trustedPeer:  true,
+		},
+		{
+			name:        "trusted proxy without headers",
+			remoteAddr:  "10.0.0.1:4711",
+			expectedIP:  "10.0.0.1",
+			trustedPeer: true,
+		},
+		{
+			name:         "X-Real-IP",
+			remoteAddr:   "10.0.0.1:4711",
+			header:       "X-Real-IP",
+			headers:      map[string]string{"X-Real-IP": "203.0.113.2"},
+			expectedIP:   "203.0.113.2",
+			forwardedFor: "203.0.113.2",
+			trustedPeer:  true,
+		},
+		{
+			name:         "X-Client-IP",
+			remoteAddr:   "10.0.0.1:4711",
+			header:       "X-Client-IP",
+			headers:      map[string]string{"X-Client-IP": "203.0.113.3"},
+			expectedIP:   "203.0.113.3",
+			forwardedFor: "203.0.113.3",
+			trustedPeer:  true,
+		},
+		{
+			name:         "Forwarded with quoted IPv6 and port",
+			remoteAddr:   "10.0.0.1:4711",
+			header:       "Forwarded",
+			headers:      map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https, For=10.0.0.2;by=10.0.0.1`},
+			expectedIP:   "2001:db8:cafe::17",
+			forwardedFor: "2001:db8:cafe::17, 10.0.0.2",
+			trustedPeer:  true,
+		},
+		{
+			name:         "Forwarded sent by the client is ignored",
+			remoteAddr:   "10.0.0.1:4711",
+			headers:      map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "203.0.113.1"},
+			expectedIP:   "203.0.113.1",
+			forwardedFor: "203.0.113.1",
+			trustedPeer:  true,
+		},
+		{
+			name:         "X-Forwarded-For sent by the client is ignored",
+			remoteAddr:   "10.0.0.1:4711",
+			header:       "Forwarded",
+			headers:      map[string]string{"Forwarded": "for=203.0.113.4", "X-Forwarded-For": "1.2.3.4"},
+			expectedIP:   "203.0.113.4",
+			forwardedFor: "203.0.113.4",
+			trustedPeer:  true,
+		},
+		{
+			name:        "X-Real-IP sent by the client is ignored",
+			remoteAddr:  "10.0.0.1:4711",
+			headers:     map[string]string{"X-Real-IP": "1.2.3.4"},
+			expectedIP:  "10.0.0.1",
+			trustedPeer: true,
+		},
+		{
+			name:        "Forwarded unknown and obfuscated identifiers",
+			remoteAddr:  "10.0.0.1:4711",
+			header:      "Forwarded",
+			headers:     map[string]string{"Forwarded": `for=203.0.113.1, for=unknown, for="_hidden"`},
+			expectedIP:  "10.0.0.1",
+			trustedPeer: true,
+		},
+		{
+			name:        "Forwarded element without for",
+			remoteAddr:  "10.0.0.1:4711",
+			header:      "Forwarded",
+			headers:     map[string]string{"Forwarded": `for=203.0.113.1, proto="a,b";host=example.com`},
+			expectedIP:  "10.0.0.1",
+			trustedPeer: true,
+		},
+		{
+			name:         "IPv6 trusted proxy",
+			remoteAddr:   "[2001:db8:ffff::1]:4711",
+			headers:      map[string]string{"X-Forwarded-For": "203.0.113.5"},
+			expectedIP:   "203.0.113.5",
+			forwardedFor: "203.0.113.5",
+			trustedPeer:  true,
+		},
+		{
+			name:         "IPv4-mapped addresses",
+			remoteAddr:   "[::ffff:10.0.0.1]:4711",
+			headers:      map[string]string{"X-Forwarded-For": "::ffff:203.0.113.6"},
+			expectedIP:   "203.0.113.6",
+			forwardedFor: "203.0.113.6",
+			trustedPeer:  true,
+		},
+		{
+			name:       "invalid remote address",
+			remoteAddr: "pipe",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.1"},
+			expectedIP: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			req = NewClientIPResolver(trusted, tt.header).WithClientIP(req)
+
+			assert.Equal(t, tt.expectedIP, GetClientIP(req))
+			assert.Equal(t, tt.forwardedFor, GetForwardedFor(req))
+			assert.Equal(t, tt.trustedPeer, IsFromTrustedProxy(req))
+		})
+	}
+}
+
+func TestGetClientIPWithoutResolver(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "[2001:db8::1]:4711"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+
+	assert.Equal(t, "2001:db8::1", GetClientIP(req))
+	assert.Empty(t, GetForwardedFor(req))
+	assert.False(t, IsFromTrustedProxy(req))
+}
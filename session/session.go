@@ -19,6 +19,10 @@ const SessionKey contextKey = "session"
 const TokenLength = 32
 const SessionCookieName = "tg_session_token"
 
+// TokenAuthCreatedFrom is the CreatedFrom of the sessions of API token requests, whose
+// Token is the ID of the API token.
+const TokenAuthCreatedFrom = "token_auth"
+
 // Passing data headers
 const UserIdHeader = "X-User-Id"
 const UserDataHeader = "X-User-Data"
@@ -111,7 +115,7 @@ func (s *SessionStoreDB) ValidateTokenAuth(r *http.Request, tokenService TokenSe
 		IsAdmin:         user.Provider == db.AdminProvider,
 		Provider:        user.Provider,
 		SessionName:     tokenData.Name,
-		CreatedFrom:     "token_auth",
+		CreatedFrom:     TokenAuthCreatedFrom,
 		LastActivity:    time.Now(),
 	}
 
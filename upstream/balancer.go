@@ -0,0 +1,201 @@
+package upstream
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/jmaister/taronja-gateway/session"
+)
+
+// Balancer picks one target out of a list of candidates for a request.
+// Candidates are never empty and only contain targets that may receive traffic.
+type Balancer interface {
+	Pick(candidates []*Target, r *http.Request) *Target
+}
+
+// NewBalancer creates the balancer for the configured strategy.
+// The full target list is needed by strategies that keep per-target state.
+func NewBalancer(cfg config.LoadBalancingConfig, targets []*Target) Balancer {
+	switch cfg.Strategy {
+	case config.StrategyWeighted:
+		return &weightedBalancer{current: make(map[*Target]int, len(targets))}
+	case config.StrategyLeastConnections:
+		return &leastConnectionsBalancer{}
+	case config.StrategyRandomTwoChoices:
+		return &randomTwoChoicesBalancer{}
+	case config.StrategyConsistentHash:
+		return newConsistentHashBalancer(cfg, targets)
+	default:
+		return &roundRobinBalancer{}
+	}
+}
+
+// --- Round robin ---
+
+// roundRobinBalancer cycles through the candidates in order.
+type roundRobinBalancer struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobinBalancer) Pick(candidates []*Target, r *http.Request) *Target {
+	n := b.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// --- Weighted ---
+
+// weightedBalancer implements the smooth weighted round-robin algorithm used by nginx,
+// which spreads picks of heavy targets evenly instead of sending them in bursts.
+type weightedBalancer struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+func (b *weightedBalancer) Pick(candidates []*Target, r *http.Request) *Target {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *Target
+	total := 0
+	for _, t := range candidates {
+		b.current[t] += t.Weight
+		total += t.Weight
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+// --- Least connections ---
+
+// leastConnectionsBalancer picks the candidate with the fewest in-flight requests.
+// Ties are broken in round-robin order so idle targets share the load.
+type leastConnectionsBalancer struct {
+	next atomic.Uint64
+}
+
+func (b *leastConnectionsBalancer) Pick(candidates []*Target, r *http.Request) *Target {
+	start := int((b.next.Add(1) - 1) % uint64(len(candidates)))
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		t := candidates[(start+i)%len(candidates)]
+		if t.ActiveRequests() < best.ActiveRequests() {
+			best = t
+		}
+	}
+	return best
+}
+
+// --- Random two choices ---
+
+// randomTwoChoicesBalancer samples two random candidates and keeps the less loaded one.
+type randomTwoChoicesBalancer struct{}
+
+func (b *randomTwoChoicesBalancer) Pick(candidates []*Target, r *http.Request) *Target {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, c := candidates[i], candidates[j]
+	if c.ActiveRequests() < a.ActiveRequests() {
+		return c
+	}
+	return a
+}
+
+// --- Consistent hash ---
+
+// virtualNodesPerWeight is the number of ring points created per unit of target weight.
+const virtualNodesPerWeight = 100
+
+type ringPoint struct {
+	hash   uint64
+	target *Target
+}
+
+// consistentHashBalancer maps a request key (client IP, header or cookie) onto a hash
+// ring so the same key keeps reaching the same target while the target set is stable.
+// When the owning target is not a candidate, the next target on the ring is used.
+type consistentHashBalancer struct {
+	hashOn  string
+	hashKey string
+	ring    []ringPoint
+	rr      roundRobinBalancer
+}
+
+func newConsistentHashBalancer(cfg config.LoadBalancingConfig, targets []*Target) *consistentHashBalancer {
+	b := &consistentHashBalancer{hashOn: cfg.HashOn, hashKey: cfg.HashKey}
+	for _, t := range targets {
+		for i := 0; i < t.Weight*virtualNodesPerWeight; i++ {
+			b.ring = append(b.ring, ringPoint{hash: hashString(t.String() + "#" + strconv.Itoa(i)), target: t})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	return b
+}
+
+func (b *consistentHashBalancer) Pick(candidates []*Target, r *http.Request) *Target {
+	key := b.requestKey(r)
+	if key == "" || len(b.ring) == 0 {
+		return b.rr.Pick(candidates, r)
+	}
+
+	allowed := make(map[*Target]bool, len(candidates))
+	for _, t := range candidates {
+		allowed[t] = true
+	}
+
+	h := hashString(key)
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+	for i := 0; i < len(b.ring); i++ {
+		p := b.ring[(start+i)%len(b.ring)]
+		if allowed[p.target] {
+			return p.target
+		}
+	}
+	return b.rr.Pick(candidates, r)
+}
+
+// requestKey extracts the value that is hashed onto the ring.
+// Requests without the configured header or cookie fall back to the client IP.
+func (b *consistentHashBalancer) requestKey(r *http.Request) string {
+	switch b.hashOn {
+	case "header":
+		if v := r.Header.Get(b.hashKey); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := r.Cookie(b.hashKey); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return session.GetClientIP(r)
+}
+
+// hashString returns a well-mixed 64-bit hash of s.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+	x := binary.BigEndian.Uint64(sum)
+	// splitmix64 finalizer: FNV alone clusters badly for keys that only differ in a suffix
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,173 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, lb config.LoadBalancingConfig, targets ...config.UpstreamTarget) *Pool {
+	t.Helper()
+	pool, err := NewPool(config.RouteConfig{Name: "test", Targets: targets, LoadBalancing: lb})
+	require.NoError(t, err)
+	return pool
+}
+
+func pickCounts(t *testing.T, pool *Pool, n int, newReq func(i int) *http.Request) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		target, err := pool.Next(newReq(i))
+		require.NoError(t, err)
+		counts[target.String()]++
+	}
+	return counts
+}
+
+func plainRequest(int) *http.Request {
+	return httptest.NewRequest("GET", "/", nil)
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	pool := newTestPool(t, config.LoadBalancingConfig{},
+		config.UpstreamTarget{URL: "http://a:1"},
+		config.UpstreamTarget{URL: "http://b:1"},
+		config.UpstreamTarget{URL: "http://c:1"},
+	)
+	assert.Equal(t, config.StrategyRoundRobin, pool.Strategy)
+
+	var order []string
+	for i := 0; i < 6; i++ {
+		target, err := pool.Next(plainRequest(i))
+		require.NoError(t, err)
+		order = append(order, target.URL.Host)
+	}
+	assert.Equal(t, []string{"a:1", "b:1", "c:1", "a:1", "b:1", "c:1"}, order)
+}
+
+func TestWeightedBalancer(t *testing.T) {
+	pool := newTestPool(t, config.LoadBalancingConfig{Strategy: config.StrategyWeighted},
+		config.UpstreamTarget{URL: "http://a:1", Weight: 3},
+		config.UpstreamTarget{URL: "http://b:1", Weight: 1},
+	)
+
+	counts := pickCounts(t, pool, 400, plainRequest)
+	assert.Equal(t, 300, counts["http://a:1"])
+	assert.Equal(t, 100, counts["http://b:1"])
+}
+
+func TestLeastConnectionsBalancer(t *testing.T) {
+	pool := newTestPool(t, config.LoadBalancingConfig{Strategy: config.StrategyLeastConnections},
+		config.UpstreamTarget{URL: "http://a:1"},
+		config.UpstreamTarget{URL: "http://b:1"},
+	)
+	busy := pool.Targets()[0]
+	busy.acquire()
+	busy.acquire()
+	defer busy.release()
+	defer busy.release()
+
+	counts := pickCounts(t, pool, 10, plainRequest)
+	assert.Equal(t, 10, counts["http://b:1"])
+}
+
+func TestRandomTwoChoicesBalancer(t *testing.T) {
+	pool := newTestPool(t, config.LoadBalancingConfig{Strategy: config.StrategyRandomTwoChoices},
+		config.UpstreamTarget{URL: "http://a:1"},
+		config.UpstreamTarget{URL: "http://b:1"},
+	)
+	// With two candidates both are always sampled, so the idle one wins
+	pool.Targets()[1].acquire()
+	defer pool.Targets()[1].release()
+
+	counts := pickCounts(t, pool, 20, plainRequest)
+	assert.Equal(t, 20, counts["http://a:1"])
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	t.Run("same client IP reaches the same target", func(t *testing.T) {
+		pool := newTestPool(t, config.LoadBalancingConfig{Strategy: config.StrategyConsistentHash, HashOn: "ip"},
+			config.UpstreamTarget{URL: "http://a:1"},
+			config.UpstreamTarget{URL: "http://b:1"},
+			config.UpstreamTarget{URL: "http://c:1"},
+		)
+		newReq := func(int) *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "203.0.113.7:5555"
+			return req
+		}
+		counts := pickCounts(t, pool, 20, newReq)
+		assert.Len(t, counts, 1)
+	})
+
+	t.Run("different header values spread over targets", func(t *testing.T) {
+		pool := newTestPool(t, config.LoadBalancingConfig{Strategy: config.StrategyConsistentHash, HashOn: "header", HashKey: "X-Tenant"},
+			config.UpstreamTarget{URL: "http://a:1"},
+			config.UpstreamTarget{URL: "http://b:1"},
+		)
+		newReq := func(i int) *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Tenant", "tenant-"+string(rune('a'+i%26)))
+			return req
+		}
+		counts := pickCounts(t, pool, 26, newReq)
+		assert.Len(t, counts, 2)
+	})
+
+	t.Run("cookie key moves to another target when its owner is down", func(t *testing.T) {
+		pool := newTestPool(t, config.LoadBalancingConfig{Strategy: config.StrategyConsistentHash, HashOn: "cookie", HashKey: "sid"},
+			config.UpstreamTarget{URL: "http://a:1"},
+			config.UpstreamTarget{URL: "http://b:1"},
+		)
+		newReq := func(int) *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "sid", Value: "session-42"})
+			return req
+		}
+		owner, err := pool.Next(newReq(0))
+		require.NoError(t, err)
+
+		owner.SetHealthy(false)
+		other, err := pool.Next(newReq(0))
+		require.NoError(t, err)
+		assert.NotEqual(t, owner, other)
+
+		owner.SetHealthy(true)
+		again, err := pool.Next(newReq(0))
+		require.NoError(t, err)
+		assert.Equal(t, owner, again)
+	})
+}
+
+func TestPoolSkipsUnhealthyAndExcludedTargets(t *testing.T) {
+	pool := newTestPool(t, config.LoadBalancingConfig{},
+		config.UpstreamTarget{URL: "http://a:1"},
+		config.UpstreamTarget{URL: "http://b:1"},
+	)
+	a, b := pool.Targets()[0], pool.Targets()[1]
+
+	assert.True(t, a.SetHealthy(false))
+	assert.False(t, a.SetHealthy(false), "state did not change")
+	assert.Equal(t, 1, pool.HealthyCount())
+
+	counts := pickCounts(t, pool, 5, plainRequest)
+	assert.Equal(t, 5, counts["http://b:1"])
+
+	_, err := pool.Next(plainRequest(0), b)
+	assert.ErrorIs(t, err, ErrNoHealthyTarget)
+}
+
+func TestNewPoolFromSingleTo(t *testing.T) {
+	pool, err := NewPool(config.RouteConfig{Name: "single", To: "http://backend:8080/base"})
+	require.NoError(t, err)
+	require.Len(t, pool.Targets(), 1)
+	assert.Equal(t, 1, pool.Targets()[0].Weight)
+	assert.Equal(t, "/base", pool.Targets()[0].URL.Path)
+
+	_, err = NewPool(config.RouteConfig{Name: "bad", Targets: []config.UpstreamTarget{{URL: "backend:8080"}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,226 @@
+package upstream
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// ErrCircuitOpen is returned when the circuit breaker of a route rejects a request.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// breakerBuckets is the number of slices the failure ratio window is split into.
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// CircuitBreaker protects an upstream pool from being hammered while it is failing.
+//
+// Closed: requests flow and outcomes are counted. When consecutive failures or the
+// failure ratio in the window reach the configured limits, the breaker opens.
+// Open: requests are rejected with ErrCircuitOpen until the open duration elapses.
+// Half-open: a limited number of probe requests go through; if all succeed the
+// breaker closes, and the first failure opens it again.
+type CircuitBreaker struct {
+	name string
+	cfg  config.CircuitBreakerConfig
+	now  func() time.Time
+
+	mu                  sync.Mutex
+	state               string
+	openedAt            time.Time
+	consecutiveFailures int
+	buckets             [breakerBuckets]breakerBucket
+	probesInFlight      int
+	probeSuccesses      int
+	transitions         int
+	lastTransition      time.Time
+}
+
+// CircuitBreakerStats is a point-in-time view of a circuit breaker.
+type CircuitBreakerStats struct {
+	Route               string
+	State               string
+	ConsecutiveFailures int
+	Requests            int // requests in the failure ratio window
+	Failures            int // failures in the failure ratio window
+	OpenedAt            time.Time
+	RetryAt             time.Time // when an open breaker lets probe requests through
+	Transitions         int
+	LastTransition      time.Time
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for the named route.
+func NewCircuitBreaker(name string, cfg config.CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{name: name, cfg: cfg, now: time.Now, state: CircuitClosed}
+}
+
+// Allow reports whether a request may be sent upstream. It returns the state the
+// request was admitted in and the transition it caused, if any (e.g. "open->half-open").
+// A rejected request gets ErrCircuitOpen. Every admitted request must be followed by a
+// call to Record with its outcome.
+func (cb *CircuitBreaker) Allow() (state string, transition string, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen {
+		if cb.now().Sub(cb.openedAt) < cb.cfg.OpenDuration() {
+			return CircuitOpen, "", ErrCircuitOpen
+		}
+		transition = cb.setState(CircuitHalfOpen)
+	}
+	if cb.state == CircuitHalfOpen {
+		if cb.probesInFlight+cb.probeSuccesses >= cb.cfg.ProbeRequests() {
+			return CircuitHalfOpen, transition, ErrCircuitOpen
+		}
+		cb.probesInFlight++
+	}
+	return cb.state, transition, nil
+}
+
+// Record reports the outcome of a request admitted by Allow in the given state.
+// It returns the transition caused by the outcome, if any.
+func (cb *CircuitBreaker) Record(admittedIn string, success bool) (transition string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if admittedIn == CircuitHalfOpen {
+		if cb.state != CircuitHalfOpen {
+			return ""
+		}
+		cb.probesInFlight--
+		if !success {
+			return cb.trip()
+		}
+		cb.probeSuccesses++
+		if cb.probeSuccesses >= cb.cfg.ProbeRequests() {
+			return cb.setState(CircuitClosed)
+		}
+		return ""
+	}
+
+	if cb.state != CircuitClosed {
+		// Late outcome of a request sent before the breaker opened
+		return ""
+	}
+	bucket := cb.currentBucket()
+	bucket.requests++
+	if success {
+		cb.consecutiveFailures = 0
+		return ""
+	}
+	bucket.failures++
+	cb.consecutiveFailures++
+
+	if cb.cfg.ConsecutiveFailures > 0 && cb.consecutiveFailures >= cb.cfg.ConsecutiveFailures {
+		return cb.trip()
+	}
+	if cb.cfg.FailureRatio > 0 {
+		requests, failures := cb.windowCounts()
+		if requests >= cb.cfg.MinimumRequests() && float64(failures)/float64(requests) >= cb.cfg.FailureRatio {
+			return cb.trip()
+		}
+	}
+	return ""
+}
+
+// Abandon releases a request admitted by Allow whose outcome says nothing about the
+// upstream, such as a request cancelled by the client.
+func (cb *CircuitBreaker) Abandon(admittedIn string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if admittedIn == CircuitHalfOpen && cb.state == CircuitHalfOpen {
+		cb.probesInFlight--
+	}
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Stats returns a snapshot of the breaker.
+func (cb *CircuitBreaker) Stats() CircuitBreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	requests, failures := cb.windowCounts()
+	stats := CircuitBreakerStats{
+		Route:               cb.name,
+		State:               cb.state,
+		ConsecutiveFailures: cb.consecutiveFailures,
+		Requests:            requests,
+		Failures:            failures,
+		Transitions:         cb.transitions,
+		LastTransition:      cb.lastTransition,
+	}
+	if cb.state != CircuitClosed {
+		stats.OpenedAt = cb.openedAt
+	}
+	if cb.state == CircuitOpen {
+		stats.RetryAt = cb.openedAt.Add(cb.cfg.OpenDuration())
+	}
+	return stats
+}
+
+// trip opens the breaker. Must be called with mu held.
+func (cb *CircuitBreaker) trip() string {
+	cb.openedAt = cb.now()
+	return cb.setState(CircuitOpen)
+}
+
+// setState moves the breaker to a new state, resets the counters of the new state and
+// returns the transition as "from->to". Must be called with mu held.
+func (cb *CircuitBreaker) setState(state string) string {
+	transition := cb.state + "->" + state
+	cb.state = state
+	cb.transitions++
+	cb.lastTransition = cb.now()
+	cb.probesInFlight = 0
+	cb.probeSuccesses = 0
+	if state == CircuitClosed {
+		cb.consecutiveFailures = 0
+		cb.buckets = [breakerBuckets]breakerBucket{}
+	}
+	log.Printf("Circuit breaker [%s]: %s", cb.name, transition)
+	return transition
+}
+
+// currentBucket returns the bucket for the current time, recycling expired ones.
+// Must be called with mu held.
+func (cb *CircuitBreaker) currentBucket() *breakerBucket {
+	size := cb.cfg.Window() / breakerBuckets
+	start := cb.now().Truncate(size)
+	bucket := &cb.buckets[(start.UnixNano()/int64(size))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// windowCounts sums the buckets that are still inside the window. Must be called with mu held.
+func (cb *CircuitBreaker) windowCounts() (requests, failures int) {
+	cutoff := cb.now().Add(-cb.cfg.Window())
+	for _, b := range cb.buckets {
+		if b.start.After(cutoff) {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
@@ -0,0 +1,117 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBreaker returns a breaker driven by a manual clock.
+func newTestBreaker(cfg config.CircuitBreakerConfig) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cb := NewCircuitBreaker("test", cfg)
+	cb.now = func() time.Time { return now }
+	return cb, &now
+}
+
+func callBreaker(t *testing.T, cb *CircuitBreaker, success bool) string {
+	t.Helper()
+	state, _, err := cb.Allow()
+	require.NoError(t, err)
+	return cb.Record(state, success)
+}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	cb, now := newTestBreaker(config.CircuitBreakerConfig{ConsecutiveFailures: 3, OpenSeconds: 10})
+
+	callBreaker(t, cb, false)
+	callBreaker(t, cb, false)
+	callBreaker(t, cb, true) // resets the streak
+	callBreaker(t, cb, false)
+	callBreaker(t, cb, false)
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.Equal(t, "closed->open", callBreaker(t, cb, false))
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	_, _, err := cb.Allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, now.Add(10*time.Second), cb.Stats().RetryAt)
+
+	*now = now.Add(10 * time.Second)
+	state, transition, err := cb.Allow()
+	require.NoError(t, err)
+	assert.Equal(t, CircuitHalfOpen, state)
+	assert.Equal(t, "open->half-open", transition)
+
+	// Only one probe at a time by default
+	_, _, err = cb.Allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	assert.Equal(t, "half-open->closed", cb.Record(state, true))
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.Equal(t, 3, cb.Stats().Transitions)
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb, now := newTestBreaker(config.CircuitBreakerConfig{ConsecutiveFailures: 1, OpenSeconds: 5, HalfOpenRequests: 2})
+
+	callBreaker(t, cb, false)
+	*now = now.Add(5 * time.Second)
+
+	first, _, err := cb.Allow()
+	require.NoError(t, err)
+	second, _, err := cb.Allow()
+	require.NoError(t, err)
+	_, _, err = cb.Allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen, "only two probes are allowed")
+
+	assert.Equal(t, "", cb.Record(first, true))
+	assert.Equal(t, "half-open->open", cb.Record(second, false))
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreakerAbandonFreesProbe(t *testing.T) {
+	cb, now := newTestBreaker(config.CircuitBreakerConfig{ConsecutiveFailures: 1, OpenSeconds: 5})
+
+	callBreaker(t, cb, false)
+	*now = now.Add(5 * time.Second)
+
+	state, _, err := cb.Allow()
+	require.NoError(t, err)
+	cb.Abandon(state)
+
+	state, _, err = cb.Allow()
+	require.NoError(t, err, "an abandoned probe must not block the next one")
+	cb.Record(state, true)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestCircuitBreakerFailureRatio(t *testing.T) {
+	cb, now := newTestBreaker(config.CircuitBreakerConfig{FailureRatio: 0.5, MinRequests: 10, WindowSeconds: 10})
+
+	// 4 failures out of 9 requests: below the minimum request count
+	for i := 0; i < 5; i++ {
+		callBreaker(t, cb, true)
+	}
+	for i := 0; i < 4; i++ {
+		callBreaker(t, cb, false)
+	}
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	// Old requests leave the window
+	*now = now.Add(11 * time.Second)
+	stats := cb.Stats()
+	assert.Equal(t, 0, stats.Requests)
+
+	for i := 0; i < 5; i++ {
+		callBreaker(t, cb, true)
+	}
+	for i := 0; i < 4; i++ {
+		callBreaker(t, cb, false)
+	}
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.Equal(t, "closed->open", callBreaker(t, cb, false), "5 failures out of 10 requests reaches the ratio")
+}
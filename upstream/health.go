@@ -0,0 +1,180 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// HealthChecker keeps the health state of the targets of a pool up to date.
+// Active checks probe every target on a fixed interval; passive checks count
+// consecutive failures of proxied requests reported through Observe.
+type HealthChecker struct {
+	pool   *Pool
+	cfg    config.HealthCheckConfig
+	client *http.Client
+}
+
+func newHealthChecker(pool *Pool, cfg config.HealthCheckConfig) *HealthChecker {
+	return &HealthChecker{
+		pool: pool,
+		cfg:  cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout(),
+			// Probes must see the real status code of the target
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Active reports whether the checker probes targets periodically.
+func (hc *HealthChecker) Active() bool {
+	return hc.cfg.Path != ""
+}
+
+// Passive reports whether proxied requests are used to mark targets unhealthy.
+func (hc *HealthChecker) Passive() bool {
+	return hc.cfg.Passive.MaxFailures > 0
+}
+
+// Run probes all targets until ctx is cancelled. It returns immediately when
+// active checks are not configured.
+func (hc *HealthChecker) Run(ctx context.Context) {
+	if !hc.Active() {
+		return
+	}
+	ticker := time.NewTicker(hc.cfg.Interval())
+	defer ticker.Stop()
+	for {
+		hc.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes every target concurrently and waits for all of them.
+func (hc *HealthChecker) probeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range hc.pool.targets {
+		wg.Add(1)
+		go func(t *Target) {
+			defer wg.Done()
+			hc.recordProbe(t, hc.probe(ctx, t))
+		}(t)
+	}
+	wg.Wait()
+}
+
+// probe sends one health check request to the target.
+func (hc *HealthChecker) probe(ctx context.Context, t *Target) error {
+	probeURL := t.URL.Scheme + "://" + t.URL.Host + hc.cfg.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "taronja-gateway-health-check")
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if !hc.cfg.IsExpectedStatus(resp.StatusCode) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordProbe updates the target counters with a probe result and flips its
+// state once the configured threshold is reached.
+func (hc *HealthChecker) recordProbe(t *Target, probeErr error) {
+	healthyThreshold, unhealthyThreshold := hc.cfg.Thresholds()
+
+	t.mu.Lock()
+	t.lastCheck = time.Now()
+	var markHealthy, markUnhealthy bool
+	if probeErr == nil {
+		t.successes++
+		t.failures = 0
+		t.lastError = ""
+		markHealthy = t.successes >= healthyThreshold
+	} else {
+		t.failures++
+		t.successes = 0
+		t.lastError = probeErr.Error()
+		markUnhealthy = t.failures >= unhealthyThreshold
+	}
+	t.mu.Unlock()
+
+	switch {
+	case markHealthy:
+		if t.SetHealthy(true) {
+			t.mu.Lock()
+			t.passiveFailures = 0
+			t.mu.Unlock()
+			log.Printf("Upstream [%s]: target %s is healthy again", hc.pool.Name, t)
+		}
+	case markUnhealthy:
+		if t.SetHealthy(false) {
+			log.Printf("Upstream [%s]: target %s marked unhealthy: %v", hc.pool.Name, t, probeErr)
+		}
+	}
+}
+
+// Observe records the outcome of a proxied request for passive health checking.
+// Responses with a 5xx status and transport errors count as failures.
+func (hc *HealthChecker) Observe(t *Target, status int, err error) {
+	maxFailures := hc.cfg.Passive.MaxFailures
+	if maxFailures <= 0 {
+		return
+	}
+
+	failed := err != nil || status >= 500
+	t.mu.Lock()
+	if !failed {
+		t.passiveFailures = 0
+		t.mu.Unlock()
+		return
+	}
+	t.passiveFailures++
+	if err != nil {
+		t.lastError = err.Error()
+	} else {
+		t.lastError = fmt.Sprintf("upstream responded with status %d", status)
+	}
+	reached := t.passiveFailures >= maxFailures
+	lastError := t.lastError
+	t.mu.Unlock()
+
+	if !reached || !t.SetHealthy(false) {
+		return
+	}
+	log.Printf("Upstream [%s]: target %s marked unhealthy after %d consecutive failures: %s", hc.pool.Name, t, maxFailures, lastError)
+
+	// With active checks the probes bring the target back; otherwise retry it after the cooldown
+	if hc.Active() {
+		t.mu.Lock()
+		t.successes = 0
+		t.mu.Unlock()
+		return
+	}
+	time.AfterFunc(hc.cfg.Passive.Cooldown(), func() {
+		t.mu.Lock()
+		t.passiveFailures = 0
+		t.mu.Unlock()
+		if t.SetHealthy(true) {
+			log.Printf("Upstream [%s]: target %s back in rotation after cooldown", hc.pool.Name, t)
+		}
+	})
+}
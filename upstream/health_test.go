@@ -0,0 +1,134 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveHealthCheck(t *testing.T) {
+	var failing atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/healthz", r.URL.Path)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+
+	pool, err := NewPool(config.RouteConfig{
+		Name: "probed",
+		To:   backend.URL + "/api",
+		HealthCheck: &config.HealthCheckConfig{
+			Path:               "/healthz",
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+		},
+	})
+	require.NoError(t, err)
+	checker := pool.HealthChecker()
+	require.NotNil(t, checker)
+	assert.True(t, checker.Active())
+	assert.False(t, checker.Passive())
+	target := pool.Targets()[0]
+
+	failing.Store(true)
+	checker.probeAll(context.Background())
+	assert.True(t, target.Healthy(), "one failure is below the threshold")
+	checker.probeAll(context.Background())
+	assert.False(t, target.Healthy())
+	assert.Equal(t, StatusUnhealthy, pool.Status())
+	health := target.Health()
+	assert.Equal(t, 2, health.ConsecutiveFailures)
+	assert.Contains(t, health.LastError, "503")
+	assert.False(t, health.LastCheck.IsZero())
+
+	failing.Store(false)
+	checker.probeAll(context.Background())
+	assert.False(t, target.Healthy(), "one success is below the threshold")
+	checker.probeAll(context.Background())
+	assert.True(t, target.Healthy())
+	assert.Empty(t, target.Health().LastError)
+}
+
+func TestActiveHealthCheckExpectedStatus(t *testing.T) {
+	hc := &config.HealthCheckConfig{}
+	assert.True(t, hc.IsExpectedStatus(http.StatusOK))
+	assert.True(t, hc.IsExpectedStatus(http.StatusNoContent))
+	assert.False(t, hc.IsExpectedStatus(http.StatusFound))
+
+	hc.ExpectedStatus = http.StatusFound
+	assert.True(t, hc.IsExpectedStatus(http.StatusFound))
+	assert.False(t, hc.IsExpectedStatus(http.StatusOK))
+}
+
+func TestPassiveHealthCheck(t *testing.T) {
+	pool, err := NewPool(config.RouteConfig{
+		Name:    "passive",
+		Targets: []config.UpstreamTarget{{URL: "http://a:1"}, {URL: "http://b:1"}},
+		HealthCheck: &config.HealthCheckConfig{
+			Passive: config.PassiveHealthCheckConfig{MaxFailures: 3, CooldownSeconds: 1},
+		},
+	})
+	require.NoError(t, err)
+	checker := pool.HealthChecker()
+	assert.False(t, checker.Active())
+	assert.True(t, checker.Passive())
+	target := pool.Targets()[0]
+
+	checker.Observe(target, http.StatusBadGateway, nil)
+	checker.Observe(target, 0, errors.New("connection refused"))
+	checker.Observe(target, http.StatusOK, nil)
+	assert.True(t, target.Healthy(), "a success resets the failure count")
+
+	checker.Observe(target, http.StatusInternalServerError, nil)
+	checker.Observe(target, http.StatusInternalServerError, nil)
+	checker.Observe(target, 0, errors.New("connection refused"))
+	assert.False(t, target.Healthy())
+	assert.Equal(t, StatusDegraded, pool.Status())
+	assert.Equal(t, "connection refused", target.Health().LastError)
+
+	// Without active probes the target comes back after the cooldown
+	assert.Eventually(t, target.Healthy, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestTransportReportsToPassiveHealthCheck(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	pool, err := NewPool(config.RouteConfig{
+		Name: "passive",
+		To:   backend.URL,
+		HealthCheck: &config.HealthCheckConfig{
+			Passive: config.PassiveHealthCheckConfig{MaxFailures: 2, CooldownSeconds: 60},
+		},
+	})
+	require.NoError(t, err)
+	transport := &Transport{Pool: pool}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "http://gateway/", nil)
+		req.RequestURI = ""
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.False(t, pool.Targets()[0].Healthy())
+
+	req := httptest.NewRequest("GET", "http://gateway/", nil)
+	req.RequestURI = ""
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, ErrNoHealthyTarget)
+}
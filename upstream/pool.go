@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// ErrNoHealthyTarget is returned when every target of a pool is unhealthy or excluded.
+var ErrNoHealthyTarget = errors.New("no healthy upstream target available")
+
+// Pool is the set of upstream targets of one proxy route together with its balancer.
+type Pool struct {
+	Name     string
+	Strategy string
+	targets  []*Target
+	balancer Balancer
+	health   *HealthChecker  // nil when the route has no health check configured
+	breaker  *CircuitBreaker // nil when the route has no circuit breaker configured
+}
+
+// Pool status values reported by Status.
+const (
+	StatusHealthy   = "healthy"   // every target is healthy
+	StatusDegraded  = "degraded"  // some targets are unhealthy but traffic can still be served
+	StatusUnhealthy = "unhealthy" // no target can receive traffic
+)
+
+// NewPool creates the pool for a proxy route from its configuration.
+func NewPool(routeConfig config.RouteConfig) (*Pool, error) {
+	cfgTargets := routeConfig.UpstreamTargets()
+	if len(cfgTargets) == 0 {
+		return nil, fmt.Errorf("route '%s' has no upstream targets", routeConfig.Name)
+	}
+
+	targets := make([]*Target, 0, len(cfgTargets))
+	for _, ct := range cfgTargets {
+		t, err := NewTarget(ct)
+		if err != nil {
+			return nil, fmt.Errorf("route '%s': %w", routeConfig.Name, err)
+		}
+		targets = append(targets, t)
+	}
+
+	strategy := routeConfig.LoadBalancing.Strategy
+	if strategy == "" {
+		strategy = config.StrategyRoundRobin
+	}
+
+	pool := &Pool{
+		Name:     routeConfig.Name,
+		Strategy: strategy,
+		targets:  targets,
+		balancer: NewBalancer(routeConfig.LoadBalancing, targets),
+	}
+	if routeConfig.HealthCheck != nil {
+		pool.health = newHealthChecker(pool, *routeConfig.HealthCheck)
+	}
+	if routeConfig.CircuitBreaker != nil {
+		pool.breaker = NewCircuitBreaker(routeConfig.Name, *routeConfig.CircuitBreaker)
+	}
+	return pool, nil
+}
+
+// Targets returns all targets of the pool, healthy or not.
+func (p *Pool) Targets() []*Target {
+	return p.targets
+}
+
+// Next picks the target for a request among the healthy targets that are not excluded.
+func (p *Pool) Next(r *http.Request, exclude ...*Target) (*Target, error) {
+	candidates := make([]*Target, 0, len(p.targets))
+	for _, t := range p.targets {
+		if !t.Healthy() || containsTarget(exclude, t) {
+			continue
+		}
+		candidates = append(candidates, t)
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoHealthyTarget
+	}
+	return p.balancer.Pick(candidates, r), nil
+}
+
+// HealthyCount returns the number of targets currently able to receive traffic.
+func (p *Pool) HealthyCount() int {
+	n := 0
+	for _, t := range p.targets {
+		if t.Healthy() {
+			n++
+		}
+	}
+	return n
+}
+
+// HealthChecker returns the health checker of the pool, or nil if health checks are not configured.
+func (p *Pool) HealthChecker() *HealthChecker {
+	return p.health
+}
+
+// Breaker returns the circuit breaker of the pool, or nil if none is configured.
+func (p *Pool) Breaker() *CircuitBreaker {
+	return p.breaker
+}
+
+// Health returns a snapshot of the health of every target.
+func (p *Pool) Health() []TargetHealth {
+	health := make([]TargetHealth, 0, len(p.targets))
+	for _, t := range p.targets {
+		health = append(health, t.Health())
+	}
+	return health
+}
+
+// Status summarizes the health of the pool as StatusHealthy, StatusDegraded or StatusUnhealthy.
+func (p *Pool) Status() string {
+	switch healthy := p.HealthyCount(); {
+	case healthy == len(p.targets):
+		return StatusHealthy
+	case healthy == 0:
+		return StatusUnhealthy
+	default:
+		return StatusDegraded
+	}
+}
+
+func containsTarget(list []*Target, t *Target) bool {
+	for _, x := range list {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
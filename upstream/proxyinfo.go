@@ -0,0 +1,43 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is a custom type for context keys to avoid collisions.
+type contextKey string
+
+// proxyInfoKey is the key used to store the ProxyInfo in the request context.
+const proxyInfoKey contextKey = "proxy_info"
+
+// ProxyInfo carries the outcome of proxying a request (route, chosen target, breaker state) from the
+// proxy handler back to the outer middlewares, such as logging and traffic metrics.
+// The outermost middleware creates it; inner handlers fill it in.
+type ProxyInfo struct {
+	Route             string // Name of the route that handled the request
+	Target            string // Upstream target URL the request was sent to
+	CircuitState      string // State of the route circuit breaker when the request was handled, if any
+	CircuitTransition string // Breaker transition caused by the request (e.g. "closed->open"), if any
+	Attempts          int    // Number of upstream attempts, including retries
+	Stream            string // Stream type when the response was streamed: "websocket", "sse" or "chunked"
+	StreamBytesIn     int64  // Bytes received from the client over an upgraded connection
+	StreamBytesOut    int64  // Bytes sent to the client over an upgraded connection
+	Coalesced         bool   // The response was copied from an identical request in flight instead of sent upstream
+}
+
+// WithProxyInfo returns a request carrying a ProxyInfo, reusing the one already in the
+// context when an outer middleware created it.
+func WithProxyInfo(r *http.Request) (*http.Request, *ProxyInfo) {
+	if info := ProxyInfoFromContext(r.Context()); info != nil {
+		return r, info
+	}
+	info := &ProxyInfo{}
+	return r.WithContext(context.WithValue(r.Context(), proxyInfoKey, info)), info
+}
+
+// ProxyInfoFromContext returns the ProxyInfo stored in ctx, or nil.
+func ProxyInfoFromContext(ctx context.Context) *ProxyInfo {
+	info, _ := ctx.Value(proxyInfoKey).(*ProxyInfo)
+	return info
+}
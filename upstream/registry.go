@@ -0,0 +1,44 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+)
+
+// Registry keeps the upstream pools of all proxy routes so their state can be
+// reported by the management API, and owns the background health checks.
+type Registry struct {
+	mu     sync.RWMutex
+	pools  []*Pool
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Registry{ctx: ctx, cancel: cancel}
+}
+
+// Add registers a pool and starts its active health checks, if any.
+func (r *Registry) Add(pool *Pool) {
+	r.mu.Lock()
+	r.pools = append(r.pools, pool)
+	r.mu.Unlock()
+
+	if pool.health != nil && pool.health.Active() {
+		go pool.health.Run(r.ctx)
+	}
+}
+
+// Pools returns the registered pools in registration order.
+func (r *Registry) Pools() []*Pool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Pool(nil), r.pools...)
+}
+
+// Close stops all background health checks.
+func (r *Registry) Close() {
+	r.cancel()
+}
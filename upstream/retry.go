@@ -0,0 +1,145 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// ErrAttemptTimeout is returned when an upstream attempt does not get response headers within
+// the per-try or response-header timeout of its route.
+var ErrAttemptTimeout = errors.New("timeout awaiting upstream response headers")
+
+// attemptOptions holds what every attempt of a request needs.
+type attemptOptions struct {
+	body       []byte        // buffered request body, replayed on every attempt
+	replayBody bool          // whether body replaces the request body
+	timeout    time.Duration // time to wait for the response headers, 0 = no limit
+}
+
+// prepareAttempts returns how many times req may be sent and buffers its body when it
+// may be sent more than once. Bodies over the limit are left streaming and sent once.
+func (t *Transport) prepareAttempts(req *http.Request) (int, attemptOptions, error) {
+	opts := attemptOptions{timeout: t.ResponseHeaderTimeout}
+	if t.Retry == nil {
+		return 1, opts, nil
+	}
+	if perTry := t.Retry.PerTryTimeout(); perTry > 0 && (opts.timeout == 0 || perTry < opts.timeout) {
+		opts.timeout = perTry
+	}
+	maxAttempts := t.Retry.MaxAttempts()
+	if maxAttempts <= 1 || !t.Retry.IsRetryableMethod(req.Method) {
+		return 1, opts, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return maxAttempts, opts, nil
+	}
+
+	limit := t.Retry.BodyLimit()
+	body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return 0, opts, fmt.Errorf("reading request body: %w", err)
+	}
+	if int64(len(body)) > limit {
+		// Too large to keep in memory: send what was read followed by the rest of the stream
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return 1, opts, nil
+	}
+	req.Body.Close()
+	opts.body, opts.replayBody = body, true
+	return maxAttempts, opts, nil
+}
+
+// shouldRetry reports whether the outcome of an attempt calls for another one.
+func (t *Transport) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		kind := retryErrorKind(err)
+		return kind != "" && t.Retry.RetriesOn(kind)
+	}
+	return t.Retry.IsRetryableStatus(resp.StatusCode)
+}
+
+// nextTarget picks the target of a retry, preferring one that has not been tried yet.
+// Pools with a single healthy target retry on it.
+func (t *Transport) nextTarget(req *http.Request, tried []*Target) (*Target, error) {
+	target, err := t.Pool.Next(req, tried...)
+	if errors.Is(err, ErrNoHealthyTarget) {
+		return t.Pool.Next(req)
+	}
+	return target, err
+}
+
+// backoff returns the delay before the given retry: exponential from the base delay,
+// capped at the maximum, with the upper half randomized so retries do not synchronize.
+func (t *Transport) backoff(retry int) time.Duration {
+	base, limit := t.Retry.Backoff()
+	delay := limit
+	if shift := retry - 1; shift < 32 && base<<shift > 0 && base<<shift < limit {
+		delay = base << shift
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryErrorKind classifies a transport error as one of the config.RetryOn* kinds,
+// or "" when it should never be retried.
+func retryErrorKind(err error) string {
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrAttemptTimeout):
+		return config.RetryOnTimeout
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return config.RetryOnConnectFailure
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return config.RetryOnReset
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return config.RetryOnTimeout
+	}
+	return ""
+}
+
+// describeOutcome renders the result of a failed attempt for logging.
+func describeOutcome(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", resp.StatusCode)
+}
+
+// discardResponse drains and closes the response of an attempt that will be retried.
+func discardResponse(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+// sleepContext waits for d and reports whether ctx is still alive afterwards.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,232 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRetryTransport(t *testing.T, retry *config.RetryConfig, urls ...string) *Transport {
+	t.Helper()
+	targets := make([]config.UpstreamTarget, 0, len(urls))
+	for _, u := range urls {
+		targets = append(targets, config.UpstreamTarget{URL: u})
+	}
+	pool, err := NewPool(config.RouteConfig{Name: "retry", Targets: targets})
+	require.NoError(t, err)
+	return &Transport{Pool: pool, Retry: retry}
+}
+
+func sendThrough(t *testing.T, transport *Transport, method, body string) (*http.Response, *ProxyInfo, error) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, "http://gateway/items", reader)
+	req.RequestURI = ""
+	req, info := WithProxyInfo(req)
+	resp, err := transport.RoundTrip(req)
+	return resp, info, err
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestRetryMovesToAnotherTarget(t *testing.T) {
+	var failingHits atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingHits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 3, BackoffMs: 1}, failing.URL, healthy.URL)
+
+	resp, info, err := sendThrough(t, transport, http.MethodGet, "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", readBody(t, resp))
+	assert.Equal(t, int32(1), failingHits.Load())
+	assert.Equal(t, 2, info.Attempts)
+	assert.Equal(t, healthy.URL, info.Target)
+	assert.Equal(t, int64(0), transport.Pool.Targets()[0].ActiveRequests(), "the discarded attempt must release its target")
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer backend.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 3, BackoffMs: 1}, backend.URL)
+
+	resp, info, err := sendThrough(t, transport, http.MethodGet, "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode, "the last outcome is returned")
+	readBody(t, resp)
+	assert.Equal(t, int32(3), hits.Load(), "a single target is retried")
+	assert.Equal(t, 3, info.Attempts)
+}
+
+func TestRetrySkipsNonRetryableMethods(t *testing.T) {
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 3, BackoffMs: 1}, backend.URL)
+
+	resp, info, err := sendThrough(t, transport, http.MethodPost, "payload")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, int32(1), hits.Load())
+	assert.Equal(t, 1, info.Attempts)
+}
+
+func TestRetryReplaysBufferedBody(t *testing.T) {
+	var bodies []string
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if hits.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("stored"))
+	}))
+	defer backend.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 2, BackoffMs: 1}, backend.URL)
+
+	resp, _, err := sendThrough(t, transport, http.MethodPut, "payload")
+	require.NoError(t, err)
+	assert.Equal(t, "stored", readBody(t, resp))
+	assert.Equal(t, []string{"payload", "payload"}, bodies)
+}
+
+func TestRetryDoesNotBufferLargeBodies(t *testing.T) {
+	var hits atomic.Int32
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 3, BackoffMs: 1, MaxBodyBytes: 4}, backend.URL)
+
+	resp, _, err := sendThrough(t, transport, http.MethodPut, "too large")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, int32(1), hits.Load())
+	assert.Equal(t, "too large", received, "the body is streamed unchanged")
+}
+
+func TestRetryOnConnectionReset(t *testing.T) {
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 2, BackoffMs: 1}, backend.URL)
+
+	resp, info, err := sendThrough(t, transport, http.MethodGet, "")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readBody(t, resp))
+	assert.Equal(t, 2, info.Attempts)
+}
+
+func TestRetryPerTryTimeout(t *testing.T) {
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte("fast"))
+	}))
+	defer backend.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 2, BackoffMs: 1, PerTryTimeoutMs: 50}, backend.URL)
+
+	start := time.Now()
+	resp, info, err := sendThrough(t, transport, http.MethodGet, "")
+	require.NoError(t, err)
+	assert.Equal(t, "fast", readBody(t, resp))
+	assert.Equal(t, 2, info.Attempts)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetryPerTryTimeoutWithoutRetries(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+
+	transport := newRetryTransport(t, &config.RetryConfig{Attempts: 1, PerTryTimeoutMs: 50}, backend.URL)
+
+	_, _, err := sendThrough(t, transport, http.MethodGet, "")
+	assert.ErrorIs(t, err, ErrAttemptTimeout)
+}
+
+func TestRetryErrorKind(t *testing.T) {
+	// A closed server gives a real connection refused error
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	_, dialErr := http.DefaultTransport.RoundTrip(httptest.NewRequest(http.MethodGet, closed.URL, nil))
+	require.Error(t, dialErr)
+
+	assert.Equal(t, config.RetryOnConnectFailure, retryErrorKind(dialErr))
+	assert.Equal(t, config.RetryOnTimeout, retryErrorKind(ErrAttemptTimeout))
+	assert.Equal(t, config.RetryOnReset, retryErrorKind(io.ErrUnexpectedEOF))
+	assert.Equal(t, "", retryErrorKind(ErrCircuitOpen))
+}
+
+func TestRetryBackoffIsBoundedAndJittered(t *testing.T) {
+	transport := &Transport{Retry: &config.RetryConfig{BackoffMs: 100, MaxBackoffMs: 400}}
+
+	for i := 0; i < 20; i++ {
+		first := transport.backoff(1)
+		assert.GreaterOrEqual(t, first, 50*time.Millisecond)
+		assert.LessOrEqual(t, first, 100*time.Millisecond)
+
+		late := transport.backoff(10)
+		assert.GreaterOrEqual(t, late, 200*time.Millisecond)
+		assert.LessOrEqual(t, late, 400*time.Millisecond)
+	}
+}
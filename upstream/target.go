@@ -0,0 +1,112 @@
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// Target is a single backend server behind a proxy route.
+// It is safe for concurrent use.
+type Target struct {
+	URL    *url.URL
+	Weight int
+
+	down   atomic.Bool  // true when the target has been marked unhealthy
+	active atomic.Int64 // number of in-flight requests
+
+	mu               sync.Mutex // guards the health check bookkeeping below
+	successes        int        // consecutive successful probes
+	failures         int        // consecutive failed probes
+	passiveFailures  int        // consecutive failed proxied requests
+	lastCheck        time.Time
+	lastError        string
+	lastStatusChange time.Time
+}
+
+// TargetHealth is a point-in-time view of the health of a target.
+type TargetHealth struct {
+	URL                 string
+	Healthy             bool
+	ActiveRequests      int64
+	ConsecutiveFailures int // failed probes, or failed proxied requests for passive-only checks
+	LastCheck           time.Time
+	LastError           string
+	LastStatusChange    time.Time
+}
+
+// NewTarget parses a configured upstream target.
+func NewTarget(cfg config.UpstreamTarget) (*Target, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL '%s': %w", cfg.URL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid target URL '%s': scheme and host are required", cfg.URL)
+	}
+	weight := cfg.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Target{URL: u, Weight: weight}, nil
+}
+
+// String returns the target URL.
+func (t *Target) String() string {
+	return t.URL.String()
+}
+
+// Healthy reports whether the target can receive traffic.
+func (t *Target) Healthy() bool {
+	return !t.down.Load()
+}
+
+// SetHealthy marks the target as healthy or unhealthy.
+// It returns true when the state actually changed.
+func (t *Target) SetHealthy(healthy bool) bool {
+	changed := t.down.Swap(!healthy) == healthy
+	if changed {
+		t.mu.Lock()
+		t.lastStatusChange = time.Now()
+		t.mu.Unlock()
+	}
+	return changed
+}
+
+// Health returns a snapshot of the target health state.
+func (t *Target) Health() TargetHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	failures := t.failures
+	if t.passiveFailures > failures {
+		failures = t.passiveFailures
+	}
+	return TargetHealth{
+		URL:                 t.String(),
+		Healthy:             t.Healthy(),
+		ActiveRequests:      t.ActiveRequests(),
+		ConsecutiveFailures: failures,
+		LastCheck:           t.lastCheck,
+		LastError:           t.lastError,
+		LastStatusChange:    t.lastStatusChange,
+	}
+}
+
+// ActiveRequests returns the number of requests currently in flight to the target.
+func (t *Target) ActiveRequests() int64 {
+	return t.active.Load()
+}
+
+// acquire records the start of a request to the target.
+func (t *Target) acquire() {
+	t.active.Add(1)
+}
+
+// release records the end of a request to the target.
+func (t *Target) release() {
+	t.active.Add(-1)
+}
@@ -0,0 +1,253 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmaister/taronja-gateway/config"
+)
+
+// Transport is an http.RoundTripper that sends every request to a target picked from a Pool.
+// It is meant to be used as the Transport of an httputil.ReverseProxy whose Director only
+// deals with target-independent changes (forwarded headers and so on).
+type Transport struct {
+	Pool  *Pool
+	Base  http.RoundTripper   // Transport used for the actual upstream call. Default: http.DefaultTransport
+	Retry *config.RetryConfig // Retry policy of the route. nil = every request is sent once.
+
+	// ResponseHeaderTimeout bounds the wait for the response headers of every attempt. 0 = no limit.
+	ResponseHeaderTimeout time.Duration
+
+	// Rewrite points the outgoing request at the chosen target (URL, Host header).
+	// It receives a fresh copy of the request for every target. Default: DirectTo.
+	Rewrite func(req *http.Request, target *Target)
+}
+
+// RoundTrip picks a target and forwards the request to it. Failed attempts of retryable
+// requests are sent again, to another target when the pool has one.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	maxAttempts, opts, err := t.prepareAttempts(req)
+	if err != nil {
+		return nil, err
+	}
+
+	admittedIn, err := t.allow(req)
+	if err != nil {
+		return nil, err
+	}
+	target, err := t.Pool.Next(req)
+	if err != nil {
+		t.abandon(admittedIn)
+		return nil, err
+	}
+
+	info := ProxyInfoFromContext(req.Context())
+	var tried []*Target
+	for attempt := 1; ; attempt++ {
+		tried = append(tried, target)
+		if info != nil {
+			info.Attempts = attempt
+		}
+		resp, err := t.roundTripTarget(req, target, admittedIn, opts)
+		if attempt >= maxAttempts || !t.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		// Keep the failed outcome until the next attempt is certain to happen
+		if !sleepContext(req.Context(), t.backoff(attempt)) {
+			return resp, err
+		}
+		next, nextErr := t.nextTarget(req, tried)
+		if nextErr != nil {
+			return resp, err
+		}
+		nextAdmittedIn, allowErr := t.allow(req)
+		if allowErr != nil {
+			return resp, err
+		}
+		log.Printf("Upstream [%s]: retrying %s %s on %s (attempt %d of %d) after %s",
+			t.Pool.Name, req.Method, req.URL.Path, next, attempt+1, maxAttempts, describeOutcome(resp, err))
+		discardResponse(resp)
+		target, admittedIn = next, nextAdmittedIn
+	}
+}
+
+// allow asks the circuit breaker of the pool, if any, to let the request through.
+// It returns the breaker state the request was admitted in.
+func (t *Transport) allow(req *http.Request) (string, error) {
+	if t.Pool.breaker == nil {
+		return "", nil
+	}
+	state, transition, err := t.Pool.breaker.Allow()
+	if info := ProxyInfoFromContext(req.Context()); info != nil {
+		info.CircuitState = state
+		if transition != "" {
+			info.CircuitTransition = transition
+		}
+	}
+	return state, err
+}
+
+// abandon releases a breaker admission that never reached an upstream.
+func (t *Transport) abandon(admittedIn string) {
+	if t.Pool.breaker != nil {
+		t.Pool.breaker.Abandon(admittedIn)
+	}
+}
+
+// roundTripTarget sends a copy of req to the given target as one attempt.
+func (t *Transport) roundTripTarget(req *http.Request, target *Target, admittedIn string, opts attemptOptions) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	out := req.Clone(ctx)
+	if opts.replayBody {
+		out.Body = io.NopCloser(bytes.NewReader(opts.body))
+		out.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(opts.body)), nil
+		}
+	}
+	if t.Rewrite != nil {
+		t.Rewrite(out, target)
+	} else {
+		DirectTo(out, target)
+	}
+
+	if info := ProxyInfoFromContext(req.Context()); info != nil {
+		info.Target = target.String()
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// The per-try timeout only covers the wait for the response headers
+	var timer *time.Timer
+	if opts.timeout > 0 {
+		timer = time.AfterFunc(opts.timeout, cancel)
+	}
+
+	target.acquire()
+	resp, err := base.RoundTrip(out)
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+			resp = nil
+		}
+		err = ErrAttemptTimeout
+	}
+	t.observe(req, target, admittedIn, resp, err)
+	if err != nil {
+		target.release()
+		cancel()
+		return nil, err
+	}
+	resp.Body = releaseOnClose(resp.Body, func() {
+		target.release()
+		cancel()
+	})
+	return resp, nil
+}
+
+// observe reports the outcome of an upstream call to the passive health checker and
+// the circuit breaker. Requests cancelled by the client say nothing about the target.
+func (t *Transport) observe(req *http.Request, target *Target, admittedIn string, resp *http.Response, err error) {
+	if req.Context().Err() != nil {
+		t.abandon(admittedIn)
+		return
+	}
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	if t.Pool.health != nil {
+		t.Pool.health.Observe(target, status, err)
+	}
+	if t.Pool.breaker != nil {
+		success := err == nil && status < 500
+		if transition := t.Pool.breaker.Record(admittedIn, success); transition != "" {
+			if info := ProxyInfoFromContext(req.Context()); info != nil {
+				info.CircuitTransition = transition
+			}
+		}
+	}
+}
+
+// NewBaseTransport returns a copy of http.DefaultTransport with its own connection pool
+// and the given connect timeout. A zero timeout keeps the default.
+func NewBaseTransport(dialTimeout time.Duration) *http.Transport {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if dialTimeout > 0 {
+		dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+		base.DialContext = dialer.DialContext
+	}
+	return base
+}
+
+// DirectTo points req at target, joining the target base path with the request path
+// and merging the target query string, like httputil.NewSingleHostReverseProxy does.
+func DirectTo(req *http.Request, target *Target) {
+	req.URL.Scheme = target.URL.Scheme
+	req.URL.Host = target.URL.Host
+	req.URL.Path = JoinPath(target.URL.Path, req.URL.Path)
+	req.URL.RawPath = req.URL.EscapedPath()
+	if target.URL.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.URL.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.URL.RawQuery + "&" + req.URL.RawQuery
+	}
+	req.Host = target.URL.Host
+}
+
+// JoinPath joins a target base path and a request path with exactly one slash between them.
+func JoinPath(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		if b == "" {
+			return a
+		}
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// releaseOnClose wraps a response body so release runs exactly once when it is closed.
+// Bodies of upgraded connections (101 Switching Protocols) stay writable.
+func releaseOnClose(body io.ReadCloser, release func()) io.ReadCloser {
+	rb := releaseBody{ReadCloser: body, release: release, once: &sync.Once{}}
+	if rw, ok := body.(io.ReadWriteCloser); ok {
+		return &releaseReadWriteBody{releaseBody: rb, w: rw}
+	}
+	return &rb
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    *sync.Once
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+type releaseReadWriteBody struct {
+	releaseBody
+	w io.Writer
+}
+
+func (b *releaseReadWriteBody) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
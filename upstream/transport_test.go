@@ -0,0 +1,53 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmaister/taronja-gateway/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		a, b, expected string
+	}{
+		{"", "/api", "/api"},
+		{"/", "/api", "/api"},
+		{"/base", "/api", "/base/api"},
+		{"/base/", "/api", "/base/api"},
+		{"/base", "api", "/base/api"},
+		{"/base", "", "/base"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, JoinPath(tt.a, tt.b), "JoinPath(%q, %q)", tt.a, tt.b)
+	}
+}
+
+func TestTransportRecordsTargetAndReleasesIt(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + "?" + r.URL.RawQuery))
+	}))
+	defer backend.Close()
+
+	pool, err := NewPool(config.RouteConfig{Name: "test", To: backend.URL + "/base?fixed=1"})
+	require.NoError(t, err)
+	transport := &Transport{Pool: pool}
+
+	req := httptest.NewRequest("GET", "http://gateway/items?page=2", nil)
+	req.RequestURI = ""
+	req, info := WithProxyInfo(req)
+
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pool.Targets()[0].ActiveRequests())
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "/base/items?fixed=1&page=2", string(body))
+	assert.Equal(t, int64(0), pool.Targets()[0].ActiveRequests())
+	assert.Equal(t, backend.URL+"/base?fixed=1", info.Target)
+}
@@ -1,8 +1,8 @@
 import { useState } from 'react';
 import { Card, CardContent, CardHeader } from '../components/ui/Card';
 import { Badge } from '../components/ui/Badge';
-import { useRateLimiterStats } from '../services/services';
-import type { RateLimiterStat } from '../apiclient/types.gen';
+import { useCircuitBreakerStats, useRateLimiterStats } from '../services/services';
+import type { CircuitBreakerStat, RateLimiterStat } from '../apiclient/types.gen';
 
 function formatBlockedUntil(ts: string): { label: string; isBlocked: boolean } {
     const d = new Date(ts);
@@ -34,14 +34,41 @@ function StatRow({ stat }: { stat: RateLimiterStat }) {
     );
 }
 
+function breakerVariant(state: CircuitBreakerStat['state']): 'success' | 'warning' | 'danger' {
+    if (state === 'open') return 'danger';
+    if (state === 'half-open') return 'warning';
+    return 'success';
+}
+
+function BreakerRow({ stat }: { stat: CircuitBreakerStat }) {
+    return (
+        <tr className="border-b border-border last:border-0 hover:bg-muted/30 transition-colors">
+            <td className="px-4 py-3 text-sm">{stat.route}</td>
+            <td className="px-4 py-3 text-center">
+                <Badge variant={breakerVariant(stat.state)}>{stat.state}</Badge>
+            </td>
+            <td className="px-4 py-3 text-center text-sm">{stat.failures} / {stat.requests}</td>
+            <td className="px-4 py-3 text-center text-sm">{stat.consecutiveFailures}</td>
+            <td className="px-4 py-3 text-center text-sm">{stat.transitions}</td>
+            <td className="px-4 py-3 text-center text-sm">
+                {stat.retryAt ? new Date(stat.retryAt).toLocaleTimeString() : '—'}
+            </td>
+        </tr>
+    );
+}
+
 export function RateLimiterStatsPage() {
     const [autoRefresh, setAutoRefresh] = useState(true);
     const { data: stats, isLoading, error, refetch, dataUpdatedAt } = useRateLimiterStats();
+    const { data: breakers, refetch: refetchBreakers } = useCircuitBreakerStats();
 
     // Auto-refresh: re-fetch every 10 seconds when enabled
     useState(() => {
         if (!autoRefresh) return;
-        const id = setInterval(() => refetch(), 10_000);
+        const id = setInterval(() => {
+            refetch();
+            refetchBreakers();
+        }, 10_000);
         return () => clearInterval(id);
     });
 
@@ -69,7 +96,10 @@ export function RateLimiterStatsPage() {
                         Auto-refresh
                     </label>
                     <button
-                        onClick={() => refetch()}
+                        onClick={() => {
+                            refetch();
+                            refetchBreakers();
+                        }}
                         className="rounded-lg border border-border px-3 py-1.5 text-sm font-medium hover:bg-muted/70 transition-colors"
                     >
                         Refresh
@@ -152,6 +182,36 @@ export function RateLimiterStatsPage() {
                     )}
                 </CardContent>
             </Card>
+
+            {/* Circuit Breakers */}
+            {breakers && breakers.length > 0 && (
+                <Card>
+                    <CardHeader>
+                        <h3 className="text-base font-semibold">Circuit Breakers</h3>
+                    </CardHeader>
+                    <CardContent className="p-0">
+                        <div className="overflow-x-auto">
+                            <table className="w-full text-left">
+                                <thead>
+                                    <tr className="border-b border-border bg-muted/40">
+                                        <th className="px-4 py-3 text-xs font-semibold uppercase tracking-wide text-muted-fg">Route</th>
+                                        <th className="px-4 py-3 text-center text-xs font-semibold uppercase tracking-wide text-muted-fg">State</th>
+                                        <th className="px-4 py-3 text-center text-xs font-semibold uppercase tracking-wide text-muted-fg">Failures / Requests</th>
+                                        <th className="px-4 py-3 text-center text-xs font-semibold uppercase tracking-wide text-muted-fg">Consecutive Failures</th>
+                                        <th className="px-4 py-3 text-center text-xs font-semibold uppercase tracking-wide text-muted-fg">Transitions</th>
+                                        <th className="px-4 py-3 text-center text-xs font-semibold uppercase tracking-wide text-muted-fg">Retry At</th>
+                                    </tr>
+                                </thead>
+                                <tbody>
+                                    {breakers.map(stat => (
+                                        <BreakerRow key={stat.route} stat={stat} />
+                                    ))}
+                                </tbody>
+                            </table>
+                        </div>
+                    </CardContent>
+                </Card>
+            )}
         </div>
     );
 }
@@ -5,6 +5,7 @@ import {
   getAvailableCounters,
   getRateLimiterStats,
   getRateLimiterConfig,
+  getCircuitBreakerStats,
 } from '@/apiclient/sdk.gen';
 import type {
   CounterHistoryResponse,
@@ -13,6 +14,7 @@ import type {
   AvailableCountersResponse,
   RateLimiterStats,
   RateLimiterConfigResponse,
+  CircuitBreakerStats,
 } from '@/apiclient/types.gen';
 
 
@@ -75,6 +77,7 @@ export const queryKeys = {
   token: (tokenId: string) => ['tokens', tokenId] as const,
   rateLimiterStats: () => ['rateLimiterStats'] as const,
   rateLimiterConfig: () => ['rateLimiterConfig'] as const,
+  circuitBreakerStats: () => ['circuitBreakerStats'] as const,
 } as const;
 
 // Users hooks
@@ -278,6 +281,18 @@ export function useRateLimiterStats() {
   });
 }
 
+export function useCircuitBreakerStats() {
+  return useQuery<CircuitBreakerStats, Error>({
+    queryKey: queryKeys.circuitBreakerStats(),
+    queryFn: async () => {
+      const response = await getCircuitBreakerStats({ client: customApiClient });
+      return handleResponse<CircuitBreakerStats>(response);
+    },
+    staleTime: 10_000, // refresh every 10 seconds
+    gcTime: 60_000,
+  });
+}
+
 export function useRateLimiterConfig() {
   return useQuery<RateLimiterConfigResponse, Error>({
     queryKey: queryKeys.rateLimiterConfig(),